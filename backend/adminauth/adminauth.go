@@ -0,0 +1,202 @@
+// Package adminauth manages the accounts of the admin dashboard and API: bcrypt password
+// hashes, roles and login session tokens. Usernames are locked out after repeated wrong
+// passwords with a lockout.Limiter keyed by NormalizeUsername.
+package adminauth
+
+import (
+	"backend/db"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles of admin accounts, from the least to the most privileged. Each role can do
+// everything the roles before it can.
+const (
+	RoleViewer    = "viewer"     // Sees sessions, vouchers and settings.
+	RoleFrontDesk = "front-desk" // Also revokes and extends guests, generates vouchers and reads the passphrase.
+	RoleAdmin     = "admin"      // Also manages admin accounts and changes the passphrase.
+)
+
+// roleRanks orders the roles by privilege.
+var roleRanks = map[string]int{RoleViewer: 1, RoleFrontDesk: 2, RoleAdmin: 3}
+
+// MinPasswordLength is the minimum length of admin account passwords.
+const MinPasswordLength = 10
+
+// bcryptCost is the bcrypt work factor of stored password hashes.
+const bcryptCost = 12
+
+// Errors returned when creating or changing accounts.
+var (
+	ErrInvalidUsername = errors.New("usernames must be 1 to 64 characters of letters, digits, '.', '-', '_' or '@'")
+	ErrInvalidRole     = fmt.Errorf("role must be one of %s, %s or %s", RoleViewer, RoleFrontDesk, RoleAdmin)
+	ErrWeakPassword    = fmt.Errorf("passwords must be at least %d characters long", MinPasswordLength)
+	ErrWrongPassword   = errors.New("invalid username or password")
+)
+
+// dummyHash is compared against when a username does not exist, so that unknown and
+// known usernames take the same time to reject.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("unused password"), bcryptCost)
+
+// ValidRole reports whether role is one of the Role* constants.
+func ValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// Allows reports whether an account with the given role may use an endpoint requiring
+// the required role.
+func Allows(role, required string) bool {
+	return ValidRole(role) && roleRanks[role] >= roleRanks[required]
+}
+
+// NormalizeUsername returns the stored form of a username. Usernames are case-insensitive.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// CreateAccount validates and stores a new admin account.
+//
+// Parameters:
+// - store: Database holding the accounts.
+// - username: Login name; stored in lowercase.
+// - password: Clear-text password; only its bcrypt hash is stored.
+// - role: One of the Role* constants.
+//
+// Returns ErrInvalidUsername, ErrInvalidRole, ErrWeakPassword, db.ErrAdminExists or a database error.
+func CreateAccount(store db.Storage, username, password, role string) (*db.Admin, error) {
+	username = NormalizeUsername(username)
+	if !validUsername(username) {
+		return nil, ErrInvalidUsername
+	}
+	if !ValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	admin := db.Admin{Username: username, PasswordHash: hash, Role: role, CreatedAt: time.Now().UTC()}
+	if err := store.CreateAdmin(admin); err != nil {
+		return nil, err
+	}
+	return &admin, nil
+}
+
+// SetPassword replaces the password of an account and signs it out everywhere.
+//
+// Returns ErrWeakPassword, db.ErrNoAdmin or a database error.
+func SetPassword(store db.Storage, username, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	username = NormalizeUsername(username)
+	if err := store.SetAdminPassword(username, hash); err != nil {
+		return err
+	}
+	return store.DeleteAdminSessions(username)
+}
+
+// Bootstrap creates an admin account if no account with the admin role exists yet. It
+// does nothing if username is empty.
+//
+// Returns an error wrapping db.ErrAdminExists if the account already exists, e.g. because
+// another portal sharing the database created it at the same time.
+func Bootstrap(store db.Storage, username, password string) error {
+	if username == "" {
+		return nil
+	}
+	count, err := store.CountAdmins(RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	if _, err := CreateAccount(store, username, password, RoleAdmin); err != nil {
+		return fmt.Errorf("failed to create bootstrap admin %q: %w", username, err)
+	}
+	log.Printf("Created bootstrap admin account %q", NormalizeUsername(username))
+	return nil
+}
+
+// HashPassword checks the length of a password and returns its bcrypt hash.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+	// bcrypt ignores everything after 72 bytes; longer passwords are pre-hashed so every
+	// character counts
+	hash, err := bcrypt.GenerateFromPassword(passwordBytes(password), bcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
+
+// Authenticate checks a username and password.
+//
+// Returns the account, or ErrWrongPassword if the username does not exist or the
+// password is wrong.
+func Authenticate(store db.Storage, username, password string) (*db.Admin, error) {
+	admin, err := store.GetAdmin(NormalizeUsername(username))
+	if errors.Is(err, db.ErrNoAdmin) {
+		bcrypt.CompareHashAndPassword(dummyHash, passwordBytes(password))
+		return nil, ErrWrongPassword
+	} else if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(admin.PasswordHash), passwordBytes(password)) != nil {
+		return nil, ErrWrongPassword
+	}
+	return admin, nil
+}
+
+// NewSessionToken generates a random login session token. Only the returned hash is
+// stored, so a leaked database does not reveal usable tokens.
+func NewSessionToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate session token: %v", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the stored form of a login session token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// passwordBytes returns the bytes passed to bcrypt for a password.
+func passwordBytes(password string) []byte {
+	if len(password) <= 72 {
+		return []byte(password)
+	}
+	sum := sha256.Sum256([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// validUsername reports whether a normalized username is acceptable.
+func validUsername(username string) bool {
+	if username == "" || len(username) > 64 {
+		return false
+	}
+	for _, c := range username {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune(".-_@", c)) {
+			return false
+		}
+	}
+	return true
+}
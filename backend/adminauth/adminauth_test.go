@@ -0,0 +1,120 @@
+package adminauth
+
+import (
+	"backend/db"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// newStore opens an empty SQLite database.
+func newStore(t *testing.T) db.Storage {
+	t.Helper()
+	store, err := db.Open(db.DriverSQLite, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRoles(t *testing.T) {
+	tests := []struct {
+		role, required string
+		want           bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleFrontDesk, false},
+		{RoleFrontDesk, RoleViewer, true},
+		{RoleFrontDesk, RoleAdmin, false},
+		{RoleAdmin, RoleFrontDesk, true},
+		{RoleAdmin, RoleAdmin, true},
+		{"", RoleViewer, false},
+		{"root", RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := Allows(tt.role, tt.required); got != tt.want {
+			t.Errorf("Allows(%q, %q): got %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+	if ValidRole("Admin") || !ValidRole(RoleAdmin) {
+		t.Error("ValidRole: roles are case-sensitive")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	store := newStore(t)
+	long := strings.Repeat("x", 72)
+	if _, err := CreateAccount(store, " Ada ", "correct horse", RoleFrontDesk); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateAccount(store, "bob", long+"1", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	admin, err := Authenticate(store, "ADA", "correct horse")
+	if err != nil || admin.Username != "ada" || admin.Role != RoleFrontDesk {
+		t.Errorf("right password: got %+v, %v", admin, err)
+	}
+
+	tests := []struct {
+		name               string
+		username, password string
+	}{
+		{"wrong password", "ada", "correct horse!"},
+		{"unknown username", "eve", "correct horse"},
+		{"past the 72 bytes bcrypt reads", "bob", long + "2"},
+	}
+	for _, tt := range tests {
+		if _, err := Authenticate(store, tt.username, tt.password); !errors.Is(err, ErrWrongPassword) {
+			t.Errorf("%s: got %v, want ErrWrongPassword", tt.name, err)
+		}
+	}
+	if _, err := Authenticate(store, "bob", long+"1"); err != nil {
+		t.Errorf("long password: got %v", err)
+	}
+}
+
+func TestCreateAccountValidation(t *testing.T) {
+	store := newStore(t)
+	tests := []struct {
+		username, password, role string
+		want                     error
+	}{
+		{"ada smith", "correct horse", RoleViewer, ErrInvalidUsername},
+		{"", "correct horse", RoleViewer, ErrInvalidUsername},
+		{"ada", "correct horse", "root", ErrInvalidRole},
+		{"ada", "short", RoleViewer, ErrWeakPassword},
+	}
+	for _, tt := range tests {
+		if _, err := CreateAccount(store, tt.username, tt.password, tt.role); !errors.Is(err, tt.want) {
+			t.Errorf("CreateAccount(%q, %q, %q): got %v, want %v", tt.username, tt.password, tt.role, err, tt.want)
+		}
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	store := newStore(t)
+	if err := Bootstrap(store, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := Bootstrap(store, "root", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Bootstrap(store, "other", "correct horse"); err != nil {
+		t.Fatalf("with an admin: got %v", err)
+	}
+	if count, _ := store.CountAdmins(""); count != 1 {
+		t.Errorf("got %d accounts, want the first bootstrap only", count)
+	}
+
+	// The username is taken by an account of another role, as when another portal
+	// created the account between the count and the insert
+	store = newStore(t)
+	if _, err := CreateAccount(store, "root", "correct horse", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if err := Bootstrap(store, "root", "correct horse"); !errors.Is(err, db.ErrAdminExists) {
+		t.Errorf("taken username: got %v, want db.ErrAdminExists", err)
+	}
+}
@@ -1,3 +1,6 @@
+// Package authorization provides a long-lived client for the UniFi controller API.
+// The client logs in once, keeps the session cookies and CSRF token between calls,
+// and transparently logs in again when the controller expires the session.
 package authorization
 
 import (
@@ -6,146 +9,415 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 )
 
-// AuthorizeGuestProcess orchestrates the process of logging into the UniFi
-// controller and authorizing a guest.
+// Client is a reusable, session-caching client for a single UniFi controller site.
 //
-// It first calls the login function to obtain the session cookies and CSRF token,
-// and then uses that information to call the authorizeGuest function.
+// A Client owns one http.Client whose cookie jar holds the controller session, and
+// remembers the CSRF token returned by the controller. It is safe for concurrent use
+// and is intended to be created once at startup and shared by all request handlers.
+type Client struct {
+	controllerURL string // Base URL of the UniFi controller.
+	site          string // Site to which guests are authorized.
+	username      string // Username used for logging in.
+	password      string // Password used for logging in.
+
+	httpClient *http.Client // HTTP client holding the session cookie jar.
+
+	mu        sync.Mutex // Protects the session state below.
+	flavor    Flavor     // Controller flavor, resolved from FlavorAuto on first login.
+	csrfToken string     // CSRF token of the current session.
+	session   int        // Incremented on every successful login.
+}
+
+// NewClient creates a Client for the given controller and site.
+//
+// No request is sent to the controller until the first API call, at which point
+// the client logs in and caches the session for subsequent calls.
 //
 // Parameters:
 //   - controllerURL: The base URL of the UniFi controller.
-//   - site: The site to which the guest should be authorized.
+//   - site: The site to which guests should be authorized.
 //   - username: The username used for logging in.
 //   - password: The password used for logging in.
-//   - clientMAC: The MAC address of the client to be authorized.
-//   - apMAC: The MAC address of the access point to which the client is connected.
-//   - duration: The duration (in minutes) for which the guest will be authorized.
 //   - disableTLS: A flag indicating whether to skip TLS verification (for insecure connections).
+//   - flavor: The kind of controller, or FlavorAuto to detect it on first login.
 //
 // Returns:
-//   - error: An error if any of the steps fail, otherwise nil.
-func AuthorizeGuestProcess(controllerURL, site, username, password, clientMAC, apMAC string, duration int, disableTLS bool) error {
-	// Login to the router and retrieve session cookies and CSRF token
-	cookies, csrfToken, err := login(controllerURL, username, password, disableTLS)
-	if err != nil {
-		return err
-	}
+//   - *Client: The configured client.
+func NewClient(controllerURL, site, username, password string, disableTLS bool, flavor Flavor) *Client {
+	// cookiejar.New only fails when given a PublicSuffixList that errors, which we don't pass
+	jar, _ := cookiejar.New(nil)
 
-	// Authorize the guest using the session cookies and CSRF token
-	err = authorizeGuest(controllerURL, site, clientMAC, apMAC, duration, cookies, csrfToken)
-	if err != nil {
-		return err
+	return &Client{
+		controllerURL: strings.TrimRight(controllerURL, "/"),
+		site:          site,
+		username:      username,
+		password:      password,
+		flavor:        flavor,
+		httpClient: &http.Client{
+			Jar:     jar,
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: disableTLS,
+				},
+			},
+		},
 	}
+}
 
-	return nil
+// Site returns the site to which the client authorizes guests.
+func (c *Client) Site() string {
+	return c.site
+}
+
+// Limits holds the optional bandwidth and data quota restrictions applied to a guest.
+// A zero value leaves the corresponding restriction unset on the controller.
+type Limits struct {
+	Up    int // Upload limit in kbps.
+	Down  int // Download limit in kbps.
+	Bytes int // Data quota in MB.
+}
+
+// AuthorizeGuestProcess authorizes a guest on the UniFi controller.
+//
+// It reuses the cached controller session, logging in first if no session exists
+// yet or if the controller rejects the current one.
+//
+// Parameters:
+//   - clientMAC: The MAC address of the client to be authorized.
+//   - apMAC: The MAC address of the access point to which the client is connected.
+//   - duration: The duration (in minutes) for which the guest will be authorized.
+//   - limits: The bandwidth and data quota restrictions for the guest.
+//
+// Returns:
+//   - error: An error if any of the steps fail, otherwise nil.
+func (c *Client) AuthorizeGuestProcess(clientMAC, apMAC string, duration int, limits Limits) error {
+	return c.authorizeGuest(clientMAC, apMAC, duration, limits)
 }
 
 // login handles the login process to the UniFi controller.
 //
-// It sends a POST request with the provided username and password to the
-// controller's login endpoint and retrieves the session cookies and CSRF token.
+// It sends a POST request with the configured username and password to the
+// controller's login endpoint. The session cookies are stored in the client's
+// cookie jar and the CSRF token is remembered for subsequent requests. If the
+// controller flavor is not known yet, it is detected before logging in.
 //
 // Parameters:
-//   - controllerURL: The base URL of the UniFi controller.c
-//   - username: The username used for logging in.
-//   - password: The password used for logging in.
-//   - disableTLS: A flag indicating whether to skip TLS verification (for insecure connections).
+//   - session: The session counter observed by the caller before its request failed.
+//     If another goroutine has already logged in since then, no new login is made.
 //
 // Returns:
-//   - []*http.Cookie: The session cookies returned by the login request.
-//   - string: The CSRF token needed for subsequent requests.
 //   - error: An error if the login fails, otherwise nil.
-func login(controllerURL, username, password string, disableTLS bool) ([]*http.Cookie, string, error) {
-	loginURL := fmt.Sprintf("%s/api/auth/login", controllerURL)
-	loginPayload := map[string]string{
-		"username": username,
-		"password": password,
+func (c *Client) login(session int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another request already refreshed the session while we were waiting
+	if c.session != session {
+		return nil
 	}
-	loginData, _ := json.Marshal(loginPayload)
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: disableTLS,
-			},
-		},
+	if c.flavor == FlavorAuto {
+		flavor, err := c.detectFlavor()
+		if err != nil {
+			return err
+		}
+		log.Printf("Detected UniFi controller type: %s", flavor)
+		c.flavor = flavor
 	}
 
+	loginURL := c.controllerURL + c.flavor.loginPath()
+	loginPayload := map[string]string{
+		"username": c.username,
+		"password": c.password,
+	}
+	loginData, _ := json.Marshal(loginPayload)
+
 	req, err := http.NewRequest(http.MethodPost, loginURL, bytes.NewBuffer(loginData))
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create login request: %v", err)
+		return fmt.Errorf("failed to create login request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to login to UniFi: %v", err)
+		return &Error{Message: fmt.Sprintf("failed to login to UniFi: %v", err)}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, "", fmt.Errorf("login failed: %s", string(body))
+		_, message := controllerResult(body)
+		return &Error{StatusCode: resp.StatusCode, Message: "login failed: " + message}
+	}
+
+	c.csrfToken = resp.Header.Get("x-csrf-token")
+	if c.csrfToken == "" {
+		// Legacy controllers hand out the token as a cookie instead of a header
+		c.csrfToken = c.cookie("csrf_token")
+	}
+	c.session++
+	return nil
+}
+
+// do sends an authenticated JSON request to the controller and returns the response body.
+//
+// If no session exists yet the client logs in first. If the controller answers with
+// 401 Unauthorized or 403 Forbidden, the client logs in again and retries the request once.
+//
+// Parameters:
+//   - method: The HTTP method of the request.
+//   - path: The path of the Network API endpoint, relative to the site (e.g. `cmd/stamgr`).
+//   - payload: The value to encode as the JSON request body, or nil for no body.
+//
+// Returns:
+//   - []byte: The body of a successful (200 OK) response.
+//   - error: An *Error if the request fails or the controller rejects it, otherwise nil.
+func (c *Client) do(method, path string, payload interface{}) ([]byte, error) {
+	var data []byte
+	if payload != nil {
+		data, _ = json.Marshal(payload)
 	}
 
-	cookies := resp.Cookies()
-	csrfToken := resp.Header.Get("x-csrf-token")
+	retried := false
+	for {
+		c.mu.Lock()
+		session, csrfToken, flavor := c.session, c.csrfToken, c.flavor
+		c.mu.Unlock()
+
+		if session == 0 {
+			if err := c.login(session); err != nil {
+				return nil, err
+			}
+			continue
+		}
 
-	return cookies, csrfToken, nil
+		apiURL := fmt.Sprintf("%s%s/api/s/%s/%s", c.controllerURL, flavor.apiPrefix(), c.site, path)
+		req, err := http.NewRequest(method, apiURL, bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if csrfToken != "" {
+			req.Header.Set("x-csrf-token", csrfToken)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, &Error{Message: fmt.Sprintf("failed to reach UniFi: %v", err)}
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		// The session expired or was revoked on the controller, so log in again and retry once
+		if (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) && !retried {
+			retried = true
+			if err := c.login(session); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		ok, message := controllerResult(body)
+		if resp.StatusCode != http.StatusOK || !ok {
+			return nil, &Error{StatusCode: resp.StatusCode, Message: "request failed: " + message}
+		}
+
+		// UniFi OS rotates the CSRF token on some responses
+		if updated := resp.Header.Get("x-updated-csrf-token"); updated != "" {
+			c.mu.Lock()
+			if c.session == session {
+				c.csrfToken = updated
+			}
+			c.mu.Unlock()
+		}
+
+		return body, nil
+	}
 }
 
 // authorizeGuest sends a request to authorize a guest on the UniFi controller.
 //
 // It sends a POST request to the controller's `stamgr` endpoint with the provided
-// guest information and session details (cookies and CSRF token).
+// guest information, using the client's cached session.
 //
 // Parameters:
-//   - controllerURL: The base URL of the UniFi controller.
-//   - site: The site to which the guest should be authorized.
 //   - clientMAC: The MAC address of the client to be authorized.
 //   - apMAC: The MAC address of the access point to which the client is connected.
 //   - duration: The duration (in minutes) for which the guest will be authorized.
-//   - cookies: The session cookies obtained from a successful login request.
-//   - csrfToken: The CSRF token required for authorization.
+//   - limits: The bandwidth and data quota restrictions for the guest.
 //
 // Returns:
 //   - error: An error if the authorization fails, otherwise nil.
-func authorizeGuest(controllerURL, site, clientMAC, apMAC string, duration int, cookies []*http.Cookie, csrfToken string) error {
-	authURL := fmt.Sprintf("%s/proxy/network/api/s/%s/cmd/stamgr", controllerURL, site)
+func (c *Client) authorizeGuest(clientMAC, apMAC string, duration int, limits Limits) error {
 	authPayload := map[string]interface{}{
 		"cmd":     "authorize-guest",
 		"mac":     clientMAC,
 		"minutes": duration,
 		"ap_mac":  apMAC,
 	}
-	authData, _ := json.Marshal(authPayload)
+	if limits.Up > 0 {
+		authPayload["up"] = limits.Up
+	}
+	if limits.Down > 0 {
+		authPayload["down"] = limits.Down
+	}
+	if limits.Bytes > 0 {
+		authPayload["bytes"] = limits.Bytes
+	}
+
+	if _, err := c.do(http.MethodPost, "cmd/stamgr", authPayload); err != nil {
+		return fmt.Errorf("authorization failed: %w", err)
+	}
+	return nil
+}
 
-	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodPost, authURL, bytes.NewBuffer(authData))
+// cookie returns the value of the named cookie the controller has set for the client,
+// or an empty string if no such cookie exists.
+func (c *Client) cookie(name string) string {
+	u, err := url.Parse(c.controllerURL)
 	if err != nil {
-		return fmt.Errorf("failed to create auth request: %v", err)
+		return ""
+	}
+	for _, cookie := range c.httpClient.Jar.Cookies(u) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// Guest describes a guest authorization as reported by the controller's `stat/guest` endpoint.
+type Guest struct {
+	ID      string `json:"_id"`      // Controller identifier of the guest authorization.
+	MAC     string `json:"mac"`      // MAC address of the guest client.
+	APMAC   string `json:"ap_mac"`   // MAC address of the access point the guest was authorized on.
+	Start   int64  `json:"start"`    // Start of the authorization as a Unix timestamp.
+	End     int64  `json:"end"`      // End of the authorization as a Unix timestamp.
+	Minutes int    `json:"duration"` // Authorized duration in minutes.
+	Expired bool   `json:"expired"`  // Whether the authorization has expired or been revoked.
+}
+
+// UnauthorizeGuest revokes the authorization of a guest before it expires.
+//
+// Parameters:
+//   - clientMAC: The MAC address of the client to be unauthorized.
+//
+// Returns:
+//   - error: An error if the request fails, otherwise nil.
+func (c *Client) UnauthorizeGuest(clientMAC string) error {
+	payload := map[string]interface{}{
+		"cmd": "unauthorize-guest",
+		"mac": clientMAC,
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	// Attach session cookies and CSRF token to the request
-	for _, cookie := range cookies {
-		req.AddCookie(cookie)
+	if _, err := c.do(http.MethodPost, "cmd/stamgr", payload); err != nil {
+		return fmt.Errorf("unauthorization failed: %w", err)
 	}
-	req.Header.Add("x-csrf-token", csrfToken)
+	return nil
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to authorize guest: %v", err)
+// KickClient disconnects a client from the network. The client is free to
+// reconnect, and its guest authorization (if any) is left untouched.
+//
+// Parameters:
+//   - clientMAC: The MAC address of the client to be disconnected.
+//
+// Returns:
+//   - error: An error if the request fails, otherwise nil.
+func (c *Client) KickClient(clientMAC string) error {
+	payload := map[string]interface{}{
+		"cmd": "kick-sta",
+		"mac": clientMAC,
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("authorization failed: %s", string(body))
+	if _, err := c.do(http.MethodPost, "cmd/stamgr", payload); err != nil {
+		return fmt.Errorf("kick failed: %w", err)
+	}
+	return nil
+}
+
+// ExtendGuest extends the validity of an existing guest authorization.
+// The controller extends the authorization by its original duration.
+//
+// Parameters:
+//   - guestID: The controller identifier of the guest authorization (see Guest.ID).
+//
+// Returns:
+//   - error: An error if the request fails, otherwise nil.
+func (c *Client) ExtendGuest(guestID string) error {
+	payload := map[string]interface{}{
+		"cmd": "extend",
+		"_id": guestID,
+	}
+
+	if _, err := c.do(http.MethodPost, "cmd/stamgr", payload); err != nil {
+		return fmt.Errorf("extension failed: %w", err)
 	}
-	fmt.Println("Auth Sent")
 	return nil
 }
+
+// Guest looks up the most recent, still valid guest authorization of a client.
+//
+// Parameters:
+//   - clientMAC: The MAC address of the guest client.
+//
+// Returns:
+//   - *Guest: The guest authorization, or nil if the client has no valid authorization.
+//   - error: An error if the request fails, otherwise nil.
+func (c *Client) Guest(clientMAC string) (*Guest, error) {
+	guests, err := c.Guests()
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *Guest
+	for i, guest := range guests {
+		if !strings.EqualFold(guest.MAC, clientMAC) {
+			continue
+		}
+		if latest == nil || guest.Start > latest.Start {
+			latest = &guests[i]
+		}
+	}
+	return latest, nil
+}
+
+// Guests lists the still valid guest authorizations of the site. A client may appear
+// more than once if it was authorized again before its earlier authorization ran out.
+//
+// Returns:
+//   - []Guest: The valid guest authorizations.
+//   - error: An error if the request fails, otherwise nil.
+func (c *Client) Guests() ([]Guest, error) {
+	body, err := c.do(http.MethodGet, "stat/guest", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list guests: %w", err)
+	}
+
+	var result struct {
+		Data []Guest `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode guest list: %v", err)
+	}
+
+	guests := []Guest{}
+	for _, guest := range result.Data {
+		if !guest.Expired {
+			guests = append(guests, guest)
+		}
+	}
+	return guests, nil
+}
@@ -0,0 +1,379 @@
+package authorization
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// apiRequest is a Network API request received by the fake controller.
+type apiRequest struct {
+	Method    string                 // HTTP method.
+	Path      string                 // Path relative to the site, e.g. "cmd/stamgr".
+	CSRFToken string                 // Value of the x-csrf-token header.
+	Payload   map[string]interface{} // Decoded JSON body, if any.
+}
+
+// fakeController is a UniFi controller that hands out a new session cookie and CSRF
+// token on every login and rejects API requests that do not carry the current ones.
+type fakeController struct {
+	mu         sync.Mutex
+	legacy     bool          // Serve the legacy Network Application instead of UniFi OS.
+	loginDelay time.Duration // Time each login takes, so concurrent logins overlap.
+	rejectWith int           // Status of rejected API requests (401 if 0).
+	rejectAll  bool          // Reject every API request, even with the current session.
+	rotate     bool          // Hand out a new CSRF token in x-updated-csrf-token on every API response.
+
+	logins    int          // Number of successful logins.
+	rejected  int          // Number of rejected API requests.
+	session   string       // Value of the current session cookie.
+	csrfToken string       // CSRF token API requests must carry.
+	requests  []apiRequest // API requests accepted so far.
+
+	// respond, if set, writes the answer to an accepted API request instead of an empty
+	// successful one.
+	respond func(w http.ResponseWriter, request apiRequest)
+}
+
+// newFakeController starts a fake controller and returns a client for its "default" site.
+func newFakeController(t *testing.T, controller *fakeController, flavor Flavor) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(controller.serve))
+	t.Cleanup(server.Close)
+	return NewClient(server.URL, "default", "admin", "secret", false, flavor)
+}
+
+// serve answers the detection probe, the login and the Network API.
+func (c *fakeController) serve(w http.ResponseWriter, r *http.Request) {
+	prefix, loginPath, sessionCookie := "/proxy/network", "/api/auth/login", "TOKEN"
+	if c.legacy {
+		prefix, loginPath, sessionCookie = "", "/api/login", "unifises"
+	}
+
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodGet:
+		if c.legacy {
+			http.Redirect(w, r, "/manage", http.StatusFound)
+		}
+	case r.URL.Path == "/manage" && c.legacy:
+		w.Write([]byte("<html>UniFi Network</html>"))
+	case r.URL.Path == loginPath && r.Method == http.MethodPost:
+		c.login(w, r, sessionCookie)
+	case strings.HasPrefix(r.URL.Path, prefix+"/api/s/default/"):
+		c.api(w, r, strings.TrimPrefix(r.URL.Path, prefix+"/api/s/default/"), sessionCookie)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// login checks the credentials and starts a new session.
+func (c *fakeController) login(w http.ResponseWriter, r *http.Request, sessionCookie string) {
+	time.Sleep(c.loginDelay)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var credentials map[string]string
+	json.NewDecoder(r.Body).Decode(&credentials)
+	if credentials["username"] != "admin" || credentials["password"] != "secret" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"meta":{"rc":"error","msg":"api.err.Invalid"},"data":[]}`))
+		return
+	}
+
+	c.logins++
+	c.session = fmt.Sprintf("session-%d", c.logins)
+	c.csrfToken = fmt.Sprintf("csrf-%d", c.logins)
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: c.session, Path: "/"})
+	if c.legacy {
+		// Legacy controllers hand out the token as a cookie
+		http.SetCookie(w, &http.Cookie{Name: "csrf_token", Value: c.csrfToken, Path: "/"})
+	} else {
+		w.Header().Set("x-csrf-token", c.csrfToken)
+	}
+	w.Write([]byte(`{"meta":{"rc":"ok"},"data":[]}`))
+}
+
+// api checks the session of a Network API request and answers it.
+func (c *fakeController) api(w http.ResponseWriter, r *http.Request, path, sessionCookie string) {
+	c.mu.Lock()
+	cookie, _ := r.Cookie(sessionCookie)
+	if c.rejectAll || cookie == nil || cookie.Value != c.session || r.Header.Get("x-csrf-token") != c.csrfToken {
+		c.rejected++
+		c.mu.Unlock()
+		status := c.rejectWith
+		if status == 0 {
+			status = http.StatusUnauthorized
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"meta":{"rc":"error","msg":"api.err.LoginRequired"},"data":[]}`))
+		return
+	}
+
+	request := apiRequest{Method: r.Method, Path: path, CSRFToken: r.Header.Get("x-csrf-token")}
+	json.NewDecoder(r.Body).Decode(&request.Payload)
+	c.requests = append(c.requests, request)
+	if c.rotate {
+		c.csrfToken = fmt.Sprintf("csrf-%d-%d", c.logins, len(c.requests))
+		w.Header().Set("x-updated-csrf-token", c.csrfToken)
+	}
+	respond := c.respond
+	c.mu.Unlock()
+
+	if respond != nil {
+		respond(w, request)
+		return
+	}
+	w.Write([]byte(`{"meta":{"rc":"ok"},"data":[]}`))
+}
+
+// expire ends the current session, as the controller does after a while.
+func (c *fakeController) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = ""
+}
+
+// counts returns the number of logins, rejected and accepted API requests so far.
+func (c *fakeController) counts() (int, int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.logins, c.rejected, len(c.requests)
+}
+
+// received returns the API requests accepted so far.
+func (c *fakeController) received() []apiRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]apiRequest{}, c.requests...)
+}
+
+// authorize authorizes a guest for an hour.
+func authorize(client *Client) error {
+	return client.AuthorizeGuestProcess("aa:bb:cc:dd:ee:01", "11:22:33:44:55:66", 60, Limits{})
+}
+
+func TestSessionIsReused(t *testing.T) {
+	controller := &fakeController{}
+	client := newFakeController(t, controller, FlavorUniFiOS)
+
+	for i := 0; i < 3; i++ {
+		if err := authorize(client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if logins, rejected, accepted := controller.counts(); logins != 1 || rejected != 0 || accepted != 3 {
+		t.Errorf("got %d logins, %d rejected and %d accepted requests, want 1, 0 and 3", logins, rejected, accepted)
+	}
+}
+
+func TestLoginAgainOnRejectedSession(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			controller := &fakeController{rejectWith: status}
+			client := newFakeController(t, controller, FlavorUniFiOS)
+
+			if err := authorize(client); err != nil {
+				t.Fatal(err)
+			}
+			controller.expire()
+			if err := authorize(client); err != nil {
+				t.Fatalf("after the session expired: %v", err)
+			}
+
+			if logins, rejected, accepted := controller.counts(); logins != 2 || rejected != 1 || accepted != 2 {
+				t.Errorf("got %d logins, %d rejected and %d accepted requests, want 2, 1 and 2", logins, rejected, accepted)
+			}
+			if requests := controller.received(); requests[1].CSRFToken != "csrf-2" {
+				t.Errorf("retried request: got CSRF token %q, want the new session's", requests[1].CSRFToken)
+			}
+		})
+	}
+}
+
+func TestLoginAgainOnlyOnce(t *testing.T) {
+	controller := &fakeController{rejectAll: true}
+	client := newFakeController(t, controller, FlavorUniFiOS)
+
+	err := authorize(client)
+	var controllerErr *Error
+	if !errors.As(err, &controllerErr) || controllerErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got %v, want a 401 controller error", err)
+	}
+	if logins, rejected, _ := controller.counts(); logins != 2 || rejected != 2 {
+		t.Errorf("got %d logins and %d rejected requests, want 2 and 2", logins, rejected)
+	}
+}
+
+func TestConcurrentLoginsAreMerged(t *testing.T) {
+	controller := &fakeController{loginDelay: 20 * time.Millisecond}
+	client := newFakeController(t, controller, FlavorUniFiOS)
+
+	run := func() {
+		t.Helper()
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := authorize(client); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	run()
+	if logins, _, accepted := controller.counts(); logins != 1 || accepted != 10 {
+		t.Errorf("first requests: got %d logins and %d accepted requests, want 1 and 10", logins, accepted)
+	}
+
+	// Requests rejected with the same expired session log in once between them
+	controller.expire()
+	run()
+	if logins, _, accepted := controller.counts(); logins != 2 || accepted != 20 {
+		t.Errorf("after expiry: got %d logins and %d accepted requests, want 2 and 20", logins, accepted)
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		controller *fakeController
+		flavor     Flavor
+		want       []string // CSRF tokens of three consecutive requests.
+	}{
+		{"UniFi OS header", &fakeController{}, FlavorUniFiOS, []string{"csrf-1", "csrf-1", "csrf-1"}},
+		{"legacy cookie", &fakeController{legacy: true}, FlavorLegacy, []string{"csrf-1", "csrf-1", "csrf-1"}},
+		{"rotated token", &fakeController{rotate: true}, FlavorUniFiOS, []string{"csrf-1", "csrf-1-1", "csrf-1-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeController(t, tt.controller, tt.flavor)
+			for i := range tt.want {
+				if err := authorize(client); err != nil {
+					t.Fatalf("request %d: %v", i+1, err)
+				}
+			}
+
+			got := []string{}
+			for _, request := range tt.controller.received() {
+				got = append(got, request.CSRFToken)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got tokens %v, want %v", got, tt.want)
+			}
+			if logins, _, _ := tt.controller.counts(); logins != 1 {
+				t.Errorf("got %d logins, want 1", logins)
+			}
+		})
+	}
+}
+
+func TestGuestCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(client *Client) error
+		want map[string]interface{}
+	}{
+		{"unauthorize", func(client *Client) error { return client.UnauthorizeGuest("aa:bb:cc:dd:ee:01") },
+			map[string]interface{}{"cmd": "unauthorize-guest", "mac": "aa:bb:cc:dd:ee:01"}},
+		{"kick", func(client *Client) error { return client.KickClient("aa:bb:cc:dd:ee:01") },
+			map[string]interface{}{"cmd": "kick-sta", "mac": "aa:bb:cc:dd:ee:01"}},
+		{"extend", func(client *Client) error { return client.ExtendGuest("64b0c0ffee") },
+			map[string]interface{}{"cmd": "extend", "_id": "64b0c0ffee"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakeController{}
+			client := newFakeController(t, controller, FlavorUniFiOS)
+			if err := tt.run(client); err != nil {
+				t.Fatal(err)
+			}
+
+			requests := controller.received()
+			if len(requests) != 1 || requests[0].Method != http.MethodPost || requests[0].Path != "cmd/stamgr" {
+				t.Fatalf("got requests %+v", requests)
+			}
+			if got, want := fmt.Sprint(requests[0].Payload), fmt.Sprint(tt.want); got != want {
+				t.Errorf("got payload %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestGuests(t *testing.T) {
+	controller := &fakeController{respond: func(w http.ResponseWriter, request apiRequest) {
+		w.Write([]byte(`{"meta":{"rc":"ok"},"data":[
+			{"_id":"g1","mac":"aa:bb:cc:dd:ee:01","start":1000,"end":4600,"duration":60,"expired":false},
+			{"_id":"g2","mac":"AA:BB:CC:DD:EE:01","start":2000,"end":5600,"duration":60,"expired":false},
+			{"_id":"g3","mac":"aa:bb:cc:dd:ee:01","start":3000,"end":6600,"duration":60,"expired":true},
+			{"_id":"g4","mac":"aa:bb:cc:dd:ee:02","start":1000,"end":4600,"duration":60,"expired":false}]}`))
+	}}
+	client := newFakeController(t, controller, FlavorUniFiOS)
+
+	guests, err := client.Guests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(guests) != 3 || guests[0].ID != "g1" || guests[1].ID != "g2" || guests[2].ID != "g4" {
+		t.Errorf("got guests %+v, want the unexpired ones", guests)
+	}
+	if requests := controller.received(); requests[0].Method != http.MethodGet || requests[0].Path != "stat/guest" {
+		t.Errorf("got request %+v", requests[0])
+	}
+
+	tests := []struct {
+		mac  string
+		want string
+	}{
+		{"aa:bb:cc:dd:ee:01", "g2"}, // The latest unexpired authorization, whatever the case of the MAC
+		{"aa:bb:cc:dd:ee:02", "g4"},
+		{"aa:bb:cc:dd:ee:03", ""},
+	}
+	for _, tt := range tests {
+		guest, err := client.Guest(tt.mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if guest != nil {
+			got = guest.ID
+		}
+		if got != tt.want {
+			t.Errorf("guest %s: got %q, want %q", tt.mac, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorizePayload(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		want   string
+	}{
+		{"no limits", Limits{}, "map[ap_mac:11:22:33:44:55:66 cmd:authorize-guest mac:aa:bb:cc:dd:ee:01 minutes:60]"},
+		{"all limits", Limits{Up: 512, Down: 2048, Bytes: 1024},
+			"map[ap_mac:11:22:33:44:55:66 bytes:1024 cmd:authorize-guest down:2048 mac:aa:bb:cc:dd:ee:01 minutes:60 up:512]"},
+		{"download only", Limits{Down: 2048}, "map[ap_mac:11:22:33:44:55:66 cmd:authorize-guest down:2048 mac:aa:bb:cc:dd:ee:01 minutes:60]"},
+		{"quota only", Limits{Bytes: 100}, "map[ap_mac:11:22:33:44:55:66 bytes:100 cmd:authorize-guest mac:aa:bb:cc:dd:ee:01 minutes:60]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakeController{}
+			client := newFakeController(t, controller, FlavorUniFiOS)
+			if err := client.AuthorizeGuestProcess("aa:bb:cc:dd:ee:01", "11:22:33:44:55:66", 60, tt.limits); err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(controller.received()[0].Payload); got != tt.want {
+				t.Errorf("got payload %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
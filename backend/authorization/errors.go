@@ -0,0 +1,54 @@
+package authorization
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error describes a failed request to the UniFi controller.
+type Error struct {
+	StatusCode int    // HTTP status code returned by the controller, or 0 if it could not be reached.
+	Message    string // Error reported by the controller, or the transport error.
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.StatusCode == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// Retryable reports whether the same request may succeed if it is sent again later,
+// i.e. the controller could not be reached, was overloaded, or failed internally.
+// Rejections of the request itself (bad credentials, unknown client, ...) are not retryable.
+func (e *Error) Retryable() bool {
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// IsRetryable reports whether err is, or wraps, a retryable controller Error.
+func IsRetryable(err error) bool {
+	var controllerErr *Error
+	return errors.As(err, &controllerErr) && controllerErr.Retryable()
+}
+
+// controllerResult extracts the result code and message from the `meta` object
+// that the Network API includes in every JSON response.
+//
+// Returns:
+//   - bool: False if the controller reported an error in an otherwise successful response.
+//   - string: The controller's error message, or the raw body if it is not a Network API response.
+func controllerResult(body []byte) (bool, string) {
+	var result struct {
+		Meta struct {
+			RC  string `json:"rc"`
+			Msg string `json:"msg"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || result.Meta.RC == "" {
+		return true, string(body)
+	}
+	return result.Meta.RC == "ok", result.Meta.Msg
+}
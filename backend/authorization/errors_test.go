@@ -0,0 +1,107 @@
+package authorization
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{0, true}, // The controller could not be reached
+		{http.StatusOK, false},
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusNotFound, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		err := &Error{StatusCode: tt.status, Message: "request failed"}
+		if got := err.Retryable(); got != tt.want {
+			t.Errorf("status %d: got retryable %v, want %v", tt.status, got, tt.want)
+		}
+		if got := IsRetryable(fmt.Errorf("authorization failed: %w", err)); got != tt.want {
+			t.Errorf("status %d, wrapped: got retryable %v, want %v", tt.status, got, tt.want)
+		}
+	}
+	if IsRetryable(errors.New("not a controller error")) {
+		t.Error("other error: got retryable")
+	}
+}
+
+func TestControllerResult(t *testing.T) {
+	tests := []struct {
+		body        string
+		wantOK      bool
+		wantMessage string
+	}{
+		{`{"meta":{"rc":"ok"},"data":[]}`, true, ""},
+		{`{"meta":{"rc":"error","msg":"api.err.UnknownStation"},"data":[]}`, false, "api.err.UnknownStation"},
+		{`{"data":[]}`, true, `{"data":[]}`},
+		{`<html>Bad Gateway</html>`, true, `<html>Bad Gateway</html>`},
+	}
+	for _, tt := range tests {
+		ok, message := controllerResult([]byte(tt.body))
+		if ok != tt.wantOK || message != tt.wantMessage {
+			t.Errorf("%s: got %v, %q, want %v, %q", tt.body, ok, message, tt.wantOK, tt.wantMessage)
+		}
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		wantStatus    int
+		wantRetryable bool
+	}{
+		{"error result on 200", http.StatusOK, `{"meta":{"rc":"error","msg":"api.err.InvalidPayload"},"data":[]}`, http.StatusOK, false},
+		{"bad request", http.StatusBadRequest, `{"meta":{"rc":"error","msg":"api.err.InvalidPayload"},"data":[]}`, http.StatusBadRequest, false},
+		{"rate limited", http.StatusTooManyRequests, `Too Many Requests`, http.StatusTooManyRequests, true},
+		{"internal error", http.StatusInternalServerError, `{"meta":{"rc":"error","msg":"api.err.Internal"},"data":[]}`, http.StatusInternalServerError, true},
+		{"bad gateway", http.StatusBadGateway, `<html>Bad Gateway</html>`, http.StatusBadGateway, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakeController{respond: func(w http.ResponseWriter, request apiRequest) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}}
+			client := newFakeController(t, controller, FlavorUniFiOS)
+
+			err := authorize(client)
+			var controllerErr *Error
+			if !errors.As(err, &controllerErr) {
+				t.Fatalf("got %v, want a controller error", err)
+			}
+			if controllerErr.StatusCode != tt.wantStatus || IsRetryable(err) != tt.wantRetryable {
+				t.Errorf("got %v (retryable %v), want status %d, retryable %v", err, IsRetryable(err), tt.wantStatus, tt.wantRetryable)
+			}
+		})
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	controller := &fakeController{}
+	client := newFakeController(t, controller, FlavorUniFiOS)
+	client.password = "wrong"
+
+	err := authorize(client)
+	var controllerErr *Error
+	if !errors.As(err, &controllerErr) || controllerErr.StatusCode != http.StatusBadRequest || IsRetryable(err) {
+		t.Errorf("wrong password: got %v, want a 400 error that is not retryable", err)
+	}
+
+	unreachable := NewClient("http://127.0.0.1:1", "default", "admin", "secret", false, FlavorUniFiOS)
+	if err := authorize(unreachable); !errors.As(err, &controllerErr) || controllerErr.StatusCode != 0 || !IsRetryable(err) {
+		t.Errorf("unreachable controller: got %v, want a retryable error without status", err)
+	}
+}
@@ -0,0 +1,84 @@
+package authorization
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Flavor identifies the kind of UniFi controller the client talks to.
+//
+// UniFi OS consoles (UDM, UCG, Cloud Key Gen2+) expose the Network application behind
+// a `/proxy/network` prefix and log in through `/api/auth/login`. The legacy self-hosted
+// Network Application serves the API at the root, logs in through `/api/login` and keeps
+// its session in a `unifises` cookie.
+type Flavor string
+
+const (
+	FlavorAuto    Flavor = "auto"    // Detect the controller kind on first login.
+	FlavorUniFiOS Flavor = "unifios" // UniFi OS console.
+	FlavorLegacy  Flavor = "legacy"  // Self-hosted Network Application.
+)
+
+// ParseFlavor converts a configuration value into a Flavor.
+// An empty value selects FlavorAuto. Matching is case-insensitive.
+//
+// Returns:
+//   - Flavor: The parsed controller flavor.
+//   - error: An error if the value does not name a known flavor.
+func ParseFlavor(value string) (Flavor, error) {
+	switch Flavor(strings.ToLower(strings.TrimSpace(value))) {
+	case "", FlavorAuto:
+		return FlavorAuto, nil
+	case FlavorUniFiOS:
+		return FlavorUniFiOS, nil
+	case FlavorLegacy:
+		return FlavorLegacy, nil
+	}
+	return "", fmt.Errorf("unknown controller type %q (expected auto, unifios or legacy)", value)
+}
+
+// loginPath returns the path of the login endpoint for the flavor.
+func (f Flavor) loginPath() string {
+	if f == FlavorLegacy {
+		return "/api/login"
+	}
+	return "/api/auth/login"
+}
+
+// apiPrefix returns the path prefix under which the Network API is served for the flavor.
+func (f Flavor) apiPrefix() string {
+	if f == FlavorLegacy {
+		return ""
+	}
+	return "/proxy/network"
+}
+
+// detectFlavor determines whether the controller runs UniFi OS or the legacy Network Application.
+//
+// UniFi OS answers a plain GET of the base URL with 200 OK, while the legacy controller
+// redirects to its `/manage` UI. Redirects are therefore not followed during detection.
+//
+// Returns:
+//   - Flavor: FlavorUniFiOS or FlavorLegacy.
+//   - error: An error if the controller cannot be reached.
+func (c *Client) detectFlavor() (Flavor, error) {
+	probe := &http.Client{
+		Transport: c.httpClient.Transport,
+		Timeout:   c.httpClient.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := probe.Get(c.controllerURL)
+	if err != nil {
+		return "", &Error{Message: fmt.Sprintf("failed to detect controller type: %v", err)}
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return FlavorUniFiOS, nil
+	}
+	return FlavorLegacy, nil
+}
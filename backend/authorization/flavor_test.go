@@ -0,0 +1,92 @@
+package authorization
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseFlavor(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Flavor
+		wantErr bool
+	}{
+		{"", FlavorAuto, false},
+		{"auto", FlavorAuto, false},
+		{" UniFiOS ", FlavorUniFiOS, false},
+		{"LEGACY", FlavorLegacy, false},
+		{"udm", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFlavor(tt.value)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseFlavor(%q): got %q, %v", tt.value, got, err)
+		}
+	}
+}
+
+func TestFlavorPaths(t *testing.T) {
+	tests := []struct {
+		flavor    Flavor
+		loginPath string
+		apiPrefix string
+	}{
+		{FlavorUniFiOS, "/api/auth/login", "/proxy/network"},
+		{FlavorLegacy, "/api/login", ""},
+	}
+	for _, tt := range tests {
+		if got := tt.flavor.loginPath(); got != tt.loginPath {
+			t.Errorf("%s login path: got %q, want %q", tt.flavor, got, tt.loginPath)
+		}
+		if got := tt.flavor.apiPrefix(); got != tt.apiPrefix {
+			t.Errorf("%s API prefix: got %q, want %q", tt.flavor, got, tt.apiPrefix)
+		}
+	}
+}
+
+func TestControllerFlavors(t *testing.T) {
+	tests := []struct {
+		name   string
+		legacy bool
+		flavor Flavor
+		want   Flavor
+	}{
+		{"UniFi OS", false, FlavorUniFiOS, FlavorUniFiOS},
+		{"legacy", true, FlavorLegacy, FlavorLegacy},
+		{"UniFi OS detected", false, FlavorAuto, FlavorUniFiOS},
+		// The legacy controller redirects to a page that answers 200 OK, so detection
+		// only works if the redirect is not followed
+		{"legacy detected from redirect", true, FlavorAuto, FlavorLegacy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakeController{legacy: tt.legacy}
+			client := newFakeController(t, controller, tt.flavor)
+
+			// The fake only answers the login and API paths of its own kind
+			if err := authorize(client); err != nil {
+				t.Fatal(err)
+			}
+			if client.flavor != tt.want {
+				t.Errorf("got flavor %s, want %s", client.flavor, tt.want)
+			}
+			if requests := controller.received(); len(requests) != 1 || requests[0].Path != "cmd/stamgr" {
+				t.Errorf("got requests %+v", requests)
+			}
+		})
+	}
+}
+
+func TestDetectFlavorUnreachable(t *testing.T) {
+	server := httptest.NewServer(nil)
+	server.Close()
+	client := NewClient(server.URL, "default", "admin", "secret", false, FlavorAuto)
+
+	err := authorize(client)
+	if !IsRetryable(err) {
+		t.Errorf("got %v, want a retryable error", err)
+	}
+	if client.flavor != FlavorAuto {
+		t.Errorf("got flavor %s, want detection to be retried", client.flavor)
+	}
+}
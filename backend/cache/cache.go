@@ -3,6 +3,7 @@
 package cache
 
 import (
+	"backend/db"
 	"log"
 	"sync"
 	"time"
@@ -13,9 +14,20 @@ import (
 // LoginCache represents a cache entry for a login attempt.
 // It contains the login details, the AP (Access Point) used, and the timestamp when the login was added.
 type LoginCache struct {
-	ID        string    // Unique identifier for the login entry.
-	AP        string    // Access point (AP) associated with the login.
-	Timestamp time.Time // Timestamp when the login entry was added.
+	ID        string      // Unique identifier for the login entry.
+	AP        string      // Access point (AP) associated with the login.
+	Timestamp time.Time   // Timestamp when the login entry was added.
+	OAuth     *OAuthState // Pending OpenID Connect sign-in started from this entry, if any.
+}
+
+// OAuthState holds the secrets of an OpenID Connect sign-in started from a login entry.
+// They are kept server-side and bound to the entry, so a callback can only complete the
+// login that started it.
+type OAuthState struct {
+	State    string      // Random value of the `state` parameter.
+	Nonce    string      // Random value the ID token must carry in its `nonce` claim.
+	Verifier string      // PKCE code verifier.
+	Consent  *db.Consent // Consent given when the sign-in started, recorded once it succeeds, or nil.
 }
 
 var (
@@ -71,6 +83,24 @@ func GetRecord(cacheID string) *LoginCache {
 	return nil
 }
 
+// SetOAuthState attaches the secrets of an OpenID Connect sign-in to a login entry,
+// replacing any earlier sign-in, or detaches them if state is nil. It returns false if
+// the entry was not found.
+//
+// This function locks the cache during the operation to ensure thread-safety.
+func SetOAuthState(cacheID string, state *OAuthState) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	entry, exists := loginMap[cacheID]
+	if !exists {
+		return false
+	}
+	entry.OAuth = state
+	loginMap[cacheID] = entry
+	return true
+}
+
 // PurgeCacheEvery periodically purges cache entries older than a threshold.
 // The interval specifies how frequently the cache should be purged (e.g., every 30 seconds).
 //
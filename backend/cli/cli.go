@@ -0,0 +1,307 @@
+// Package cli implements the maintenance commands of the application binary, run as
+// `app <command> ...` next to the server (e.g. with `podman exec`). Commands use the
+// same .env file and database as the server.
+package cli
+
+import (
+	"backend/adminauth"
+	"backend/config"
+	"backend/db"
+	"backend/export"
+	"backend/retention"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// usage describes the available commands.
+const usage = `Usage:
+  app                                  Start the portal server.
+  app admin list                       List the admin accounts.
+  app admin create <username> [role]   Create an admin account (role: viewer, front-desk or admin; default: admin).
+  app admin passwd <username>          Reset the password of an admin account.
+  app admin role <username> <role>     Change the role of an admin account.
+  app admin delete <username>          Delete an admin account.
+  app retention report                 Show what the retention policy would purge now, without changing anything.
+  app retention purge                  Purge the guest data past the retention period now.
+  app subject export <email-or-mac>    Print every record about a guest as JSON (GDPR right of access).
+  app subject erase <email-or-mac> [mode]
+                                       Erase every record about a guest (mode: delete or anonymize; default: delete).
+  app audit verify                     Check the hash chain of the audit log.
+  app sessions export [flags]          Write guest sessions to standard output, oldest first. Flags:
+                                         -format csv|json|ndjson (default csv)
+                                         -since, -until <YYYY-MM-DD or RFC3339 time>  (until is exclusive)
+                                         -site <UniFi site>, -ap <access point MAC address>
+                                         -marketing  (only guests who agreed to receive marketing email)
+
+Passwords are read from the ADMIN_PASSWORD environment variable, or from standard input.
+`
+
+// Run executes the command given by the command-line arguments (without the program name).
+//
+// Returns an error if the command is unknown or fails; the usage is printed for unknown commands.
+func Run(args []string, stdin io.Reader, stdout io.Writer) error {
+	// The server logs a warning about a missing .env file; commands work without one as well
+	godotenv.Load()
+
+	var run func(store db.Storage) error
+	switch {
+	case len(args) >= 2 && args[0] == "admin":
+		run = func(store db.Storage) error { return runAdmin(store, args[1], args[2:], stdin, stdout) }
+	case len(args) == 2 && args[0] == "retention" && (args[1] == "report" || args[1] == "purge"):
+		policy, err := config.Retention()
+		if err != nil {
+			return err
+		}
+		run = func(store db.Storage) error { return runRetention(store, policy, args[1] == "report", stdout) }
+	case len(args) >= 3 && args[0] == "subject":
+		run = func(store db.Storage) error { return runSubject(store, args[1], args[2], args[3:], stdout) }
+	case len(args) >= 2 && args[0] == "sessions" && args[1] == "export":
+		format, filter, err := parseExportFlags(args[2:], stdout)
+		if err != nil {
+			return err
+		}
+		run = func(store db.Storage) error {
+			_, err := export.Sessions(store, filter, format, db.ActorCLI, stdout)
+			return err
+		}
+	case len(args) == 2 && args[0] == "audit" && args[1] == "verify":
+		run = func(store db.Storage) error { return runAuditVerify(store, stdout) }
+	default:
+		fmt.Fprint(stdout, usage)
+		return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+	}
+
+	driver, source, err := config.Database()
+	if err != nil {
+		return err
+	}
+	store, err := db.Open(driver, source)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return run(store)
+}
+
+// runAdmin executes an `admin` subcommand.
+func runAdmin(store db.Storage, command string, args []string, stdin io.Reader, stdout io.Writer) error {
+	switch {
+	case command == "list" && len(args) == 0:
+		admins, err := store.ListAdmins()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "USERNAME\tROLE\tCREATED\tLAST LOGIN")
+		for _, admin := range admins {
+			lastLogin := "never"
+			if admin.LastLoginAt != nil {
+				lastLogin = admin.LastLoginAt.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", admin.Username, admin.Role, admin.CreatedAt.Local().Format(time.DateTime), lastLogin)
+		}
+		return tw.Flush()
+
+	case command == "create" && (len(args) == 1 || len(args) == 2):
+		role := adminauth.RoleAdmin
+		if len(args) == 2 {
+			role = args[1]
+		}
+		if !adminauth.ValidRole(role) {
+			return adminauth.ErrInvalidRole
+		}
+		password, err := readPassword(stdin, stdout)
+		if err != nil {
+			return err
+		}
+		admin, err := adminauth.CreateAccount(store, args[0], password, role)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Created %s account %q.\n", admin.Role, admin.Username)
+		return nil
+
+	case command == "passwd" && len(args) == 1:
+		if _, err := store.GetAdmin(adminauth.NormalizeUsername(args[0])); err != nil {
+			return err
+		}
+		password, err := readPassword(stdin, stdout)
+		if err != nil {
+			return err
+		}
+		if err := adminauth.SetPassword(store, args[0], password); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Password of %q changed; its login sessions have ended.\n", adminauth.NormalizeUsername(args[0]))
+		return nil
+
+	case command == "role" && len(args) == 2:
+		if !adminauth.ValidRole(args[1]) {
+			return adminauth.ErrInvalidRole
+		}
+		if err := store.SetAdminRole(adminauth.NormalizeUsername(args[0]), args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%q is now %s.\n", adminauth.NormalizeUsername(args[0]), args[1])
+		return nil
+
+	case command == "delete" && len(args) == 1:
+		if err := store.DeleteAdmin(adminauth.NormalizeUsername(args[0])); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Deleted %q.\n", adminauth.NormalizeUsername(args[0]))
+		return nil
+	}
+
+	fmt.Fprint(stdout, usage)
+	return fmt.Errorf("invalid admin command")
+}
+
+// runRetention executes `retention report` (dryRun) or `retention purge` and prints the
+// rows affected per table.
+func runRetention(store db.Storage, policy retention.Policy, dryRun bool, stdout io.Writer) error {
+	report, err := retention.Purge(store, policy, db.ActorCLI, dryRun)
+	if err != nil {
+		return err
+	}
+
+	action := "Purged"
+	if dryRun {
+		action = "Would purge"
+	}
+	fmt.Fprintf(stdout, "%s data older than %s (%d days, %s mode):\n", action, report.Cutoff.Local().Format(time.DateTime), policy.Days, policy.Mode)
+	return printRecordCounts(stdout, report.RecordCounts)
+}
+
+// runSubject executes `subject export <email-or-mac>` or `subject erase <email-or-mac> [mode]`.
+// The argument is taken as a MAC address if it parses as one, and as an email address otherwise.
+func runSubject(store db.Storage, command string, identifier string, args []string, stdout io.Writer) error {
+	subject := db.Subject{Email: identifier}
+	if hw, err := net.ParseMAC(identifier); err == nil {
+		subject = db.Subject{MAC: strings.ToLower(hw.String())}
+	}
+
+	switch {
+	case command == "export" && len(args) == 0:
+		data, err := store.ExportSubject(subject, db.ActorCLI, "")
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(data)
+
+	case command == "erase" && len(args) <= 1:
+		mode := retention.ModeDelete
+		if len(args) == 1 {
+			mode = args[0]
+		}
+		if mode != retention.ModeDelete && mode != retention.ModeAnonymize {
+			return fmt.Errorf("mode must be %s or %s", retention.ModeDelete, retention.ModeAnonymize)
+		}
+		report, err := store.EraseSubject(subject, mode == retention.ModeAnonymize, db.ActorCLI, "")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Erased the records of %s (%s mode):\n", identifier, mode)
+		return printRecordCounts(stdout, report.RecordCounts)
+	}
+
+	fmt.Fprint(stdout, usage)
+	return fmt.Errorf("invalid subject command")
+}
+
+// runAuditVerify executes `audit verify`. It returns an error if the chain is broken, so
+// the command can be used in monitoring scripts.
+func runAuditVerify(store db.Storage, stdout io.Writer) error {
+	result, err := store.VerifyAuditLog()
+	if err != nil {
+		return err
+	}
+	if !result.Valid {
+		return fmt.Errorf("audit log is broken at entry %d (%d entries checked)", result.BrokenAt, result.Entries)
+	}
+	fmt.Fprintf(stdout, "Audit log intact: %d entries, head %s\n", result.Entries, result.Head)
+	return nil
+}
+
+// parseExportFlags reads the flags of `sessions export`.
+func parseExportFlags(args []string, stdout io.Writer) (string, db.SessionFilter, error) {
+	var filter db.SessionFilter
+	flags := flag.NewFlagSet("sessions export", flag.ContinueOnError)
+	flags.SetOutput(stdout)
+	format := flags.String("format", export.FormatCSV, "csv, json or ndjson")
+	flags.Func("since", "only sessions created at or after this date or time", timeFlag(&filter.Since))
+	flags.Func("until", "only sessions created before this date or time", timeFlag(&filter.Until))
+	flags.StringVar(&filter.Site, "site", "", "only sessions on this UniFi site")
+	flags.BoolVar(&filter.Marketing, "marketing", false, "only sessions whose guest agreed to receive marketing email")
+	flags.Func("ap", "only sessions on this access point", func(value string) error {
+		hw, err := net.ParseMAC(value)
+		if err != nil {
+			return errors.New("not a MAC address")
+		}
+		filter.AP = strings.ToLower(hw.String())
+		return nil
+	})
+	if err := flags.Parse(args); err != nil {
+		return "", filter, err
+	}
+	if flags.NArg() > 0 {
+		return "", filter, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	if !export.ValidFormat(*format) {
+		return "", filter, fmt.Errorf("format must be %s, %s or %s", export.FormatCSV, export.FormatJSON, export.FormatNDJSON)
+	}
+	return *format, filter, nil
+}
+
+// timeFlag returns a flag parser that reads an RFC3339 time or a YYYY-MM-DD date (midnight UTC) into target.
+func timeFlag(target *time.Time) func(string) error {
+	return func(value string) error {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", value); err != nil {
+				return errors.New("not an RFC3339 time or a YYYY-MM-DD date")
+			}
+		}
+		*target = t
+		return nil
+	}
+}
+
+// printRecordCounts prints the rows counted per table.
+func printRecordCounts(stdout io.Writer, counts db.RecordCounts) error {
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "  sessions\t%d\n", counts.Sessions)
+	fmt.Fprintf(tw, "  failed logins\t%d\n", counts.FailedAttempts)
+	fmt.Fprintf(tw, "  email verifications\t%d\n", counts.Verifications)
+	fmt.Fprintf(tw, "  sponsor requests\t%d\n", counts.SponsorRequests)
+	fmt.Fprintf(tw, "  RADIUS accounting sessions\t%d\n", counts.AccountingSessions)
+	fmt.Fprintf(tw, "  consents\t%d\n", counts.Consents)
+	return tw.Flush()
+}
+
+// readPassword returns the password in ADMIN_PASSWORD, or reads one line from stdin.
+func readPassword(stdin io.Reader, stdout io.Writer) (string, error) {
+	if password := os.Getenv("ADMIN_PASSWORD"); password != "" {
+		return password, nil
+	}
+
+	fmt.Fprint(stdout, "Password: ")
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
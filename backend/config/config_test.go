@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestAdminCookieSecureDefault(t *testing.T) {
+	tests := []struct {
+		name      string
+		portalURL string
+		secure    string
+		want      bool
+	}{
+		{"plain HTTP by default", "", "", false},
+		{"HTTP portal", "http://portal.example.com", "", false},
+		{"HTTPS portal", "https://portal.example.com", "", true},
+		{"HTTPS portal in upper case", "HTTPS://portal.example.com", "", true},
+		{"explicitly secure", "http://portal.example.com", "true", true},
+		{"explicitly insecure", "https://portal.example.com", "false", false},
+		{"invalid value", "https://portal.example.com", "maybe", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ADMIN_COOKIE_SECURE", tt.secure)
+			cfg := Config{PortalURL: tt.portalURL}
+			if err := loadAdminConfig(&cfg); err != nil {
+				t.Fatal(err)
+			}
+			if cfg.AdminCookieSecure != tt.want {
+				t.Errorf("got %v, want %v", cfg.AdminCookieSecure, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AccountingSession represents a RADIUS-authorized guest session in the
+// `radius_accounting` table. Sessions are kept until an Accounting-Stop was sent for
+// them, so stops still go out after a restart.
+type AccountingSession struct {
+	SessionID string     `json:"sessionId"`           // Acct-Session-Id sent to the RADIUS server.
+	CacheID   string     `json:"cacheId"`             // Cache identifier of the login.
+	ID        string     `json:"mac"`                 // User or device identifier (client MAC address).
+	AP        string     `json:"ap"`                  // Access point identifier (AP MAC address).
+	Username  string     `json:"username"`            // RADIUS user name.
+	Class     []byte     `json:"-"`                   // Class attribute from the Access-Accept.
+	StartedAt time.Time  `json:"startedAt"`           // Time the guest was authorized.
+	ExpiresAt time.Time  `json:"expiresAt"`           // Time the authorization runs out.
+	StoppedAt *time.Time `json:"stoppedAt,omitempty"` // Time the Accounting-Stop was sent, if it has been.
+}
+
+// WriteAccountingStart records a started RADIUS session.
+func (s *sqlStore) WriteAccountingStart(session AccountingSession) error {
+	_, err := s.exec(`INSERT INTO radius_accounting
+		(session_id, cache_id, id, ap, username, class, started_at, expires_at, stopped_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULL)`,
+		session.SessionID, session.CacheID, session.ID, session.AP, session.Username, session.Class,
+		session.StartedAt.UTC().Format(time.RFC3339), session.ExpiresAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to record accounting session: %v", err)
+	}
+	return nil
+}
+
+// ExpiredAccountingSessions returns the sessions without an Accounting-Stop whose
+// authorization ran out before now.
+func (s *sqlStore) ExpiredAccountingSessions(now time.Time) ([]AccountingSession, error) {
+	return s.queryAccountingSessions(`stopped_at IS NULL AND expires_at <= ?`, now.UTC().Format(time.RFC3339))
+}
+
+// OpenAccountingSessions returns the sessions of a client without an Accounting-Stop.
+func (s *sqlStore) OpenAccountingSessions(id string) ([]AccountingSession, error) {
+	return s.queryAccountingSessions(`stopped_at IS NULL AND id = ?`, id)
+}
+
+// ExtendAccountingSessions moves the expiry of a client's open sessions, for when an
+// administrator extended the guest's authorization.
+func (s *sqlStore) ExtendAccountingSessions(id string, expiresAt time.Time) error {
+	_, err := s.exec(`UPDATE radius_accounting SET expires_at = ? WHERE stopped_at IS NULL AND id = ?`,
+		expiresAt.UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return fmt.Errorf("failed to extend accounting sessions: %v", err)
+	}
+	return nil
+}
+
+// MarkAccountingStopped records that the Accounting-Stop of a session was sent.
+func (s *sqlStore) MarkAccountingStopped(sessionID string) error {
+	_, err := s.exec(`UPDATE radius_accounting SET stopped_at = ? WHERE session_id = ?`,
+		time.Now().UTC().Format(time.RFC3339), sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update accounting session: %v", err)
+	}
+	return nil
+}
+
+// queryAccountingSessions returns the accounting sessions matching a WHERE clause.
+func (s *sqlStore) queryAccountingSessions(where string, args ...interface{}) ([]AccountingSession, error) {
+	rows, err := s.query(`SELECT session_id, cache_id, id, ap, username, class, started_at, expires_at, stopped_at
+		FROM radius_accounting WHERE `+where+` ORDER BY started_at`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list accounting sessions: %v", err)
+	}
+	defer rows.Close()
+
+	var sessions []AccountingSession
+	for rows.Next() {
+		var s AccountingSession
+		var startedAt, expiresAt string
+		var stoppedAt sql.NullString
+		if err := rows.Scan(&s.SessionID, &s.CacheID, &s.ID, &s.AP, &s.Username, &s.Class, &startedAt, &expiresAt, &stoppedAt); err != nil {
+			return nil, fmt.Errorf("failed to read accounting session: %v", err)
+		}
+		s.StartedAt, _ = time.Parse(time.RFC3339, startedAt)
+		s.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt)
+		if stoppedAt.Valid {
+			if t, err := time.Parse(time.RFC3339, stoppedAt.String); err == nil {
+				s.StoppedAt = &t
+			}
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
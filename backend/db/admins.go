@@ -0,0 +1,187 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned by the admin account functions.
+var (
+	ErrNoAdmin        = errors.New("no such admin account")
+	ErrAdminExists    = errors.New("an admin account with this username already exists")
+	ErrNoAdminSession = errors.New("admin session is unknown or expired")
+)
+
+// Admin represents an account of the admin dashboard in the `admin_users` table.
+type Admin struct {
+	Username     string     `json:"username"`              // Login name (lowercase).
+	PasswordHash string     `json:"-"`                     // bcrypt hash of the password.
+	Role         string     `json:"role"`                  // One of the adminauth.Role* constants.
+	CreatedAt    time.Time  `json:"createdAt"`             // Time the account was created.
+	LastLoginAt  *time.Time `json:"lastLoginAt,omitempty"` // Time of the last successful login, if any.
+}
+
+// CreateAdmin inserts an admin account.
+//
+// Returns ErrAdminExists if the username is taken.
+func (s *sqlStore) CreateAdmin(admin Admin) error {
+	_, err := s.exec(`INSERT INTO admin_users (username, password_hash, role, created_at) VALUES (?, ?, ?, ?)`,
+		admin.Username, admin.PasswordHash, admin.Role, time.Now().UTC().Format(time.RFC3339))
+	if err != nil && s.dialect.uniqueViolation(err) {
+		return ErrAdminExists
+	} else if err != nil {
+		return fmt.Errorf("failed to create admin account: %v", err)
+	}
+	return nil
+}
+
+// GetAdmin returns an admin account.
+//
+// Returns ErrNoAdmin if the account does not exist.
+func (s *sqlStore) GetAdmin(username string) (*Admin, error) {
+	return scanAdmin(s.queryRow(`SELECT username, password_hash, role, created_at, last_login_at
+		FROM admin_users WHERE username = ?`, username))
+}
+
+// ListAdmins returns all admin accounts ordered by username.
+func (s *sqlStore) ListAdmins() ([]Admin, error) {
+	rows, err := s.query(`SELECT username, password_hash, role, created_at, last_login_at FROM admin_users ORDER BY username`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list admin accounts: %v", err)
+	}
+	defer rows.Close()
+
+	admins := []Admin{}
+	for rows.Next() {
+		admin, err := scanAdmin(rows)
+		if err != nil {
+			return nil, err
+		}
+		admins = append(admins, *admin)
+	}
+	return admins, rows.Err()
+}
+
+// CountAdmins returns the number of admin accounts with a role, or of all accounts if
+// role is empty.
+func (s *sqlStore) CountAdmins(role string) (int, error) {
+	var count int
+	if err := s.queryRow(`SELECT COUNT(*) FROM admin_users WHERE ? = '' OR role = ?`, role, role).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count admin accounts: %v", err)
+	}
+	return count, nil
+}
+
+// SetAdminPassword replaces the password hash of an admin account.
+//
+// Returns ErrNoAdmin if the account does not exist.
+func (s *sqlStore) SetAdminPassword(username, passwordHash string) error {
+	return s.updateAdmin(`UPDATE admin_users SET password_hash = ? WHERE username = ?`, passwordHash, username)
+}
+
+// SetAdminRole changes the role of an admin account.
+//
+// Returns ErrNoAdmin if the account does not exist.
+func (s *sqlStore) SetAdminRole(username, role string) error {
+	return s.updateAdmin(`UPDATE admin_users SET role = ? WHERE username = ?`, role, username)
+}
+
+// RecordAdminLogin stamps the `last_login_at` column of an admin account.
+func (s *sqlStore) RecordAdminLogin(username string) error {
+	return s.updateAdmin(`UPDATE admin_users SET last_login_at = ? WHERE username = ?`, time.Now().UTC().Format(time.RFC3339), username)
+}
+
+// DeleteAdmin removes an admin account and signs it out everywhere.
+//
+// Returns ErrNoAdmin if the account does not exist.
+func (s *sqlStore) DeleteAdmin(username string) error {
+	if err := s.updateAdmin(`DELETE FROM admin_users WHERE username = ?`, username); err != nil {
+		return err
+	}
+	return s.DeleteAdminSessions(username)
+}
+
+// updateAdmin runs a statement affecting a single admin account.
+func (s *sqlStore) updateAdmin(query string, args ...interface{}) error {
+	result, err := s.exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update admin account: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNoAdmin
+	}
+	return nil
+}
+
+// CreateAdminSession records a login session of an admin account.
+//
+// Parameters:
+// - tokenHash: Hash of the session token stored in the admin's cookie.
+// - username: Account the session belongs to.
+// - expiresAt: Time after which the session is no longer accepted.
+func (s *sqlStore) CreateAdminSession(tokenHash, username string, expiresAt time.Time) error {
+	// Expired sessions are cleaned up whenever someone logs in
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := s.exec(`DELETE FROM admin_sessions WHERE expires_at <= ?`, now); err != nil {
+		return fmt.Errorf("failed to purge admin sessions: %v", err)
+	}
+
+	_, err := s.exec(`INSERT INTO admin_sessions (token_hash, username, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		tokenHash, username, now, expiresAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to create admin session: %v", err)
+	}
+	return nil
+}
+
+// GetAdminSession returns the account of an unexpired login session.
+//
+// Returns ErrNoAdminSession if the session does not exist or has expired.
+func (s *sqlStore) GetAdminSession(tokenHash string) (*Admin, error) {
+	admin, err := scanAdmin(s.queryRow(`SELECT u.username, u.password_hash, u.role, u.created_at, u.last_login_at
+		FROM admin_sessions s JOIN admin_users u ON u.username = s.username
+		WHERE s.token_hash = ? AND s.expires_at > ?`, tokenHash, time.Now().UTC().Format(time.RFC3339)))
+	if errors.Is(err, ErrNoAdmin) {
+		return nil, ErrNoAdminSession
+	}
+	return admin, err
+}
+
+// DeleteAdminSession ends a login session.
+func (s *sqlStore) DeleteAdminSession(tokenHash string) error {
+	if _, err := s.exec(`DELETE FROM admin_sessions WHERE token_hash = ?`, tokenHash); err != nil {
+		return fmt.Errorf("failed to delete admin session: %v", err)
+	}
+	return nil
+}
+
+// DeleteAdminSessions ends all login sessions of an admin account.
+func (s *sqlStore) DeleteAdminSessions(username string) error {
+	if _, err := s.exec(`DELETE FROM admin_sessions WHERE username = ?`, username); err != nil {
+		return fmt.Errorf("failed to delete admin sessions: %v", err)
+	}
+	return nil
+}
+
+// scanAdmin reads a row of admin_users into an Admin.
+func scanAdmin(row interface{ Scan(...interface{}) error }) (*Admin, error) {
+	var a Admin
+	var createdAt string
+	var lastLoginAt sql.NullString
+	err := row.Scan(&a.Username, &a.PasswordHash, &a.Role, &createdAt, &lastLoginAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoAdmin
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read admin account: %v", err)
+	}
+
+	a.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	if lastLoginAt.Valid {
+		if t, err := time.Parse(time.RFC3339, lastLoginAt.String); err == nil {
+			a.LastLoginAt = &t
+		}
+	}
+	return &a, nil
+}
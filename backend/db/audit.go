@@ -0,0 +1,188 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Actors of audit entries that are not admin accounts.
+const (
+	ActorSystem = "system" // Scheduled jobs of the server.
+	ActorCLI    = "cli"    // Maintenance commands (see package cli).
+)
+
+// Audit actions stored in the `action` column of `audit_log`.
+const (
+	AuditRetentionPurge = "retention.purge" // Personal data past the retention period was deleted or anonymised.
+	AuditSubjectAccess  = "subject.access"  // The records of a data subject were exported.
+	AuditSubjectErasure = "subject.erasure" // The records of a data subject were deleted or anonymised.
+	AuditSessionExport  = "sessions.export" // Guest sessions were exported (see package export).
+)
+
+// AuditEntry represents a row of the `audit_log` table, which records administrative
+// operations on guest data.
+//
+// Entries form a hash chain: each entry's Hash covers its content and the Hash of the
+// entry before it, so editing, removing or reordering entries breaks the chain from that
+// point on (see VerifyAuditLog).
+type AuditEntry struct {
+	ID        int64           `json:"id"`        // Position of the entry in the log.
+	CreatedAt time.Time       `json:"createdAt"` // Time of the operation.
+	Actor     string          `json:"actor"`     // Admin username, ActorSystem or ActorCLI.
+	Action    string          `json:"action"`    // One of the Audit* actions.
+	Details   json.RawMessage `json:"details"`   // JSON description of the operation (e.g. a PurgeReport).
+	PrevHash  string          `json:"prevHash"`  // Hash of the previous entry (empty for the first entry).
+	Hash      string          `json:"hash"`      // Hash of this entry.
+}
+
+// AuditVerification is the result of VerifyAuditLog.
+type AuditVerification struct {
+	Entries  int    `json:"entries"`            // Number of entries checked.
+	Valid    bool   `json:"valid"`              // Whether the whole chain is intact.
+	BrokenAt int64  `json:"brokenAt,omitempty"` // ID of the first entry that does not match the chain.
+	Head     string `json:"head"`               // Hash of the last entry, to compare with a copy kept elsewhere.
+}
+
+// WriteAuditEntry appends an entry to the audit log.
+//
+// Parameters:
+// - actor: Admin username, ActorSystem or ActorCLI.
+// - action: One of the Audit* actions.
+// - details: Description of the operation, stored as JSON.
+//
+// Returns an error if the details cannot be encoded or the entry cannot be inserted.
+func (s *sqlStore) WriteAuditEntry(actor string, action string, details interface{}) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.writeAuditEntry(tx, actor, action, details); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to write audit entry: %v", err)
+	}
+	return nil
+}
+
+// writeAuditEntry is WriteAuditEntry inside a transaction, so an operation and its audit
+// entry are committed together. The table is locked while the entry is chained to the
+// last one, so concurrent writers cannot fork the chain.
+func (s *sqlStore) writeAuditEntry(tx *sql.Tx, actor string, action string, details interface{}) error {
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit details: %v", err)
+	}
+	if err := s.dialect.lock(tx, "audit_log"); err != nil {
+		return err
+	}
+
+	var prevHash string
+	err = tx.QueryRow(`SELECT COALESCE(hash, '') FROM audit_log ORDER BY entry_id DESC LIMIT 1`).Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+
+	entry := AuditEntry{
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Actor:     actor,
+		Action:    action,
+		Details:   encoded,
+		PrevHash:  prevHash,
+	}
+	_, err = tx.Exec(s.dialect.rebind(`INSERT INTO audit_log (created_at, actor, action, details, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?)`),
+		entry.CreatedAt.Format(time.RFC3339), actor, action, string(encoded), prevHash, entry.chainHash())
+	if err != nil {
+		return fmt.Errorf("failed to write audit entry: %v", err)
+	}
+	return nil
+}
+
+// ListAuditEntries returns a page of the audit log, newest first.
+//
+// Returns:
+// - []AuditEntry: The entries of the requested page.
+// - int: The number of entries in the log.
+// - error: An error if the database cannot be queried.
+func (s *sqlStore) ListAuditEntries(limit int, offset int) ([]AuditEntry, int, error) {
+	var total int
+	if err := s.queryRow(`SELECT COUNT(*) FROM audit_log`).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit entries: %v", err)
+	}
+
+	entries := []AuditEntry{}
+	err := s.scanAuditEntries(func(entry AuditEntry) bool {
+		entries = append(entries, entry)
+		return true
+	}, ` ORDER BY entry_id DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// VerifyAuditLog recomputes the hash chain of the audit log from the first entry and
+// reports the first entry that does not match.
+//
+// Removing entries from the end of the log cannot be detected from the log alone;
+// compare the returned Head with a copy kept outside the database for that.
+func (s *sqlStore) VerifyAuditLog() (*AuditVerification, error) {
+	result := &AuditVerification{Valid: true}
+	err := s.scanAuditEntries(func(entry AuditEntry) bool {
+		result.Entries++
+		if entry.PrevHash != result.Head || entry.Hash != entry.chainHash() {
+			result.Valid = false
+			result.BrokenAt = entry.ID
+			return false
+		}
+		result.Head = entry.Hash
+		return true
+	}, ` ORDER BY entry_id`)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// scanAuditEntries passes the audit entries selected by a query suffix to visit, until
+// visit returns false.
+func (s *sqlStore) scanAuditEntries(visit func(AuditEntry) bool, suffix string, args ...interface{}) error {
+	rows, err := s.query(`SELECT entry_id, created_at, actor, action, COALESCE(details, ''),
+		COALESCE(prev_hash, ''), COALESCE(hash, '') FROM audit_log`+suffix, args...)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+		var createdAt, details string
+		if err := rows.Scan(&entry.ID, &createdAt, &entry.Actor, &entry.Action, &details, &entry.PrevHash, &entry.Hash); err != nil {
+			return fmt.Errorf("failed to read audit entry: %v", err)
+		}
+		entry.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		entry.Details = json.RawMessage(details)
+		if !visit(entry) {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+// chainHash computes the hash of an entry from its content and the previous hash. The
+// fields are separated by NUL bytes, which none of them contains.
+func (e AuditEntry) chainHash() string {
+	sum := sha256.New()
+	for _, field := range []string{e.PrevHash, e.CreatedAt.UTC().Format(time.RFC3339), e.Actor, e.Action, string(e.Details)} {
+		sum.Write([]byte(field))
+		sum.Write([]byte{0})
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		if result, err := s.VerifyAuditLog(); err != nil || !result.Valid || result.Entries != 0 || result.Head != "" {
+			t.Errorf("empty log: got %+v, %v", result, err)
+		}
+
+		for i, actor := range []string{"admin", ActorSystem, ActorCLI} {
+			if err := s.WriteAuditEntry(actor, AuditRetentionPurge, map[string]int{"sessions": i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		entries, total, err := s.ListAuditEntries(2, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 3 || len(entries) != 2 || entries[0].Actor != ActorCLI || entries[1].Actor != ActorSystem {
+			t.Fatalf("first page: got %+v (total %d)", entries, total)
+		}
+		var details map[string]int
+		if err := json.Unmarshal(entries[0].Details, &details); err != nil || details["sessions"] != 2 {
+			t.Errorf("details: got %s, %v", entries[0].Details, err)
+		}
+		if entries[0].PrevHash != entries[1].Hash {
+			t.Errorf("entries are not chained: %q after %q", entries[0].PrevHash, entries[1].Hash)
+		}
+		if entries, _, err := s.ListAuditEntries(2, 2); err != nil || len(entries) != 1 || entries[0].Actor != "admin" {
+			t.Errorf("last page: got %+v, %v", entries, err)
+		}
+
+		result, err := s.VerifyAuditLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Valid || result.Entries != 3 || result.Head != entries[0].Hash {
+			t.Errorf("intact log: got %+v, want head %s", result, entries[0].Hash)
+		}
+
+		// Editing an entry breaks the chain from that entry on
+		if _, err := s.exec(`UPDATE audit_log SET actor = ? WHERE entry_id = ?`, "mallory", entries[1].ID); err != nil {
+			t.Fatal(err)
+		}
+		result, err = s.VerifyAuditLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Valid || result.BrokenAt != entries[1].ID {
+			t.Errorf("tampered log: got %+v, want broken at %d", result, entries[1].ID)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrNoConsent is returned when no consent was recorded for a cache ID.
+var ErrNoConsent = errors.New("no consent recorded")
+
+// Consent represents the consent a guest gave when logging in, in the `consents` table.
+// There is at most one consent per cache ID, the key of the login's row in `user_sessions`.
+// It is recorded once the guest gets access; until then, the email and sponsor modes keep
+// it with the pending verification or request.
+type Consent struct {
+	CacheID      string    `json:"cacheId"`                // Cache identifier of the login.
+	ID           string    `json:"mac"`                    // User or device identifier (client MAC address).
+	AP           string    `json:"ap"`                     // Access point identifier (AP MAC address).
+	IP           string    `json:"ip"`                     // IP address the consent was sent from.
+	Email        string    `json:"email,omitempty"`        // Email address entered with the consent, if any.
+	TermsVersion string    `json:"termsVersion,omitempty"` // Version of the terms of service accepted, if terms are configured.
+	Marketing    bool      `json:"marketing"`              // Whether the guest agreed to receive marketing email.
+	AcceptedAt   time.Time `json:"acceptedAt"`             // Time the consent was given.
+}
+
+// encodeConsent returns the value of a `consent` column holding the consent of a pending
+// login: its JSON object, or NULL if there is none.
+func encodeConsent(c *Consent) (interface{}, error) {
+	if c == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode consent: %v", err)
+	}
+	return string(encoded), nil
+}
+
+// decodeConsent reads a `consent` column. A NULL or unreadable column yields nil.
+func decodeConsent(column sql.NullString) *Consent {
+	if !column.Valid || column.String == "" {
+		return nil
+	}
+	var c Consent
+	if err := json.Unmarshal([]byte(column.String), &c); err != nil {
+		log.Printf("Ignoring unreadable consent: %v", err)
+		return nil
+	}
+	return &c
+}
+
+// WriteConsent stores the consent of a login, replacing any earlier one for the same cache
+// ID (e.g. when the guest retries after a failed login).
+func (s *sqlStore) WriteConsent(c Consent) error {
+	// marketing is an INTEGER column; PostgreSQL does not convert booleans to integers
+	marketing := 0
+	if c.Marketing {
+		marketing = 1
+	}
+	_, err := s.exec(`INSERT INTO consents (cache_id, id, ap, ip, email, terms_version, marketing, accepted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (cache_id) DO UPDATE SET id = excluded.id, ap = excluded.ap, ip = excluded.ip, email = excluded.email,
+			terms_version = excluded.terms_version, marketing = excluded.marketing, accepted_at = excluded.accepted_at`,
+		c.CacheID, c.ID, c.AP, c.IP, c.Email, c.TermsVersion, marketing, c.AcceptedAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to store consent: %v", err)
+	}
+	return nil
+}
+
+// consentColumns lists the `consents` columns read by scanConsent, in order.
+const consentColumns = `cache_id, COALESCE(id, ''), COALESCE(ap, ''), COALESCE(ip, ''), COALESCE(email, ''),
+	COALESCE(terms_version, ''), marketing, accepted_at`
+
+// GetConsent returns the consent of a cache ID, or ErrNoConsent.
+func (s *sqlStore) GetConsent(cacheId string) (*Consent, error) {
+	c, err := scanConsent(s.queryRow(`SELECT `+consentColumns+` FROM consents WHERE cache_id = ?`, cacheId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoConsent
+	}
+	return c, err
+}
+
+// scanConsent reads a row of consentColumns into a Consent.
+func scanConsent(row interface{ Scan(...interface{}) error }) (*Consent, error) {
+	var c Consent
+	var acceptedAt string
+	err := row.Scan(&c.CacheID, &c.ID, &c.AP, &c.IP, &c.Email, &c.TermsVersion, &c.Marketing, &acceptedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read consent: %v", err)
+	}
+	c.AcceptedAt, _ = time.Parse(time.RFC3339, acceptedAt)
+	return &c, nil
+}
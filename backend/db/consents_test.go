@@ -0,0 +1,51 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConsents(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		now := time.Now().UTC().Truncate(time.Second)
+		consent := Consent{CacheID: "s1", ID: "aa:aa:aa:aa:aa:01", AP: "ap-1", IP: "10.0.0.1", Email: "ada@example.com",
+			TermsVersion: "v1", Marketing: false, AcceptedAt: now.Add(-time.Minute)}
+		if err := s.WriteConsent(consent); err != nil {
+			t.Fatal(err)
+		}
+
+		// A retried login replaces the consent
+		consent.Marketing, consent.TermsVersion, consent.AcceptedAt = true, "v2", now
+		if err := s.WriteConsent(consent); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetConsent("s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != consent {
+			t.Errorf("got %+v, want %+v", got, consent)
+		}
+		if _, err := s.GetConsent("s2"); !errors.Is(err, ErrNoConsent) {
+			t.Errorf("missing consent: got %v, want ErrNoConsent", err)
+		}
+
+		// Only sessions with a marketing consent match the Marketing filter
+		for _, session := range []Session{
+			{CacheID: "s1", ID: "aa:aa:aa:aa:aa:01", Email: "ada@example.com", Duration: 60},
+			{CacheID: "s2", ID: "aa:aa:aa:aa:aa:02", Email: "bob@example.com", Duration: 60},
+		} {
+			if err := s.WriteSession(session); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.WriteConsent(Consent{CacheID: "s2", ID: "aa:aa:aa:aa:aa:02", AcceptedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+		sessions, total, err := s.ListSessions(SessionFilter{Marketing: true})
+		if err != nil || total != 1 || cacheIDs(sessions) != "s1" {
+			t.Errorf("marketing filter: got %q (total %d), %v", cacheIDs(sessions), total, err)
+		}
+	})
+}
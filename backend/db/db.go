@@ -1,94 +1,226 @@
 // Package db provides functionality for database interactions, specifically
-// for managing user session records in a SQLite database.
+// for managing user session records in a SQLite or PostgreSQL database.
 package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"time"
 )
 
-// WriteToDb inserts a user session record into the SQLite database. If the database or its
-// table does not exist, they will be created automatically.
+// Session statuses stored in the `status` column of `user_sessions`.
+const (
+	StatusAuthorized = "authorized" // The guest was authorized on the controller.
+	StatusExtended   = "extended"   // The authorization was extended by an administrator.
+	StatusRevoked    = "revoked"    // The authorization was revoked by an administrator.
+	StatusKicked     = "kicked"     // The client was disconnected by an administrator; its authorization keeps running.
+)
+
+// Session end reasons stored in the `end_reason` column of `user_sessions`. A session
+// without an end reason has not ended, although it may be past its expiry until the
+// session reconciler has run (see EndExpiredSessions).
+const (
+	EndExpired  = "expired"  // The authorization ran out.
+	EndRevoked  = "revoked"  // The authorization was revoked, by an administrator or on the controller.
+	EndReplaced = "replaced" // The client logged in again while the authorization was running.
+)
+
+// ErrNoSession is returned when an update targets a client without any recorded session.
+var ErrNoSession = errors.New("no session recorded for client")
+
+// FieldValues holds the answers of a guest to the custom form fields (see FORM_FIELDS) by
+// field name: strings, or true for ticked checkboxes. They are stored as a JSON object in
+// the `fields` column of the tables of logins.
+type FieldValues map[string]interface{}
+
+// encodeFields returns the value of a `fields` column: the JSON object of the answers, or
+// NULL if there are none.
+func encodeFields(values FieldValues) (interface{}, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode form fields: %v", err)
+	}
+	return string(encoded), nil
+}
+
+// decodeFields reads a `fields` column. A NULL or unreadable column yields nil.
+func decodeFields(column sql.NullString) FieldValues {
+	if !column.Valid || column.String == "" {
+		return nil
+	}
+	var values FieldValues
+	if err := json.Unmarshal([]byte(column.String), &values); err != nil {
+		log.Printf("Ignoring unreadable form fields: %v", err)
+		return nil
+	}
+	return values
+}
+
+// Session represents a guest session record in the `user_sessions` table.
+type Session struct {
+	CacheID  string // Unique identifier for the cached session.
+	ID       string // User or device identifier (client MAC address).
+	AP       string // Access point identifier (AP MAC address).
+	Site     string // UniFi site the guest was authorized on.
+	Name     string // Name of the user or device owner.
+	Email    string // Email address of the user.
+	Duration int    // Session duration in minutes.
+	Voucher  string // Voucher code redeemed for the session, if any.
+	Sponsor  string // Email address of the host who approved the session, if any.
+
+	Fields FieldValues // Answers to the custom form fields, if any.
+
+	IdentityIssuer  string // Issuer of the verified identity (OpenID Connect sign-in only).
+	IdentitySubject string // Subject of the verified identity at the issuer (OpenID Connect sign-in only).
+	EmailVerified   bool   // Whether the identity provider verified the email address.
+}
+
+// WriteSession inserts a guest session record into the `user_sessions` table.
 //
 // Parameters:
-// - cacheId: Unique identifier for the cached session.
-// - id: User or device identifier associated with the session.
-// - ap: Access point identifier for the session.
-// - name: Name of the user or device owner.
-// - email: Email address of the user.
-// - duration: Session duration in minutes.
-//
-// Environment Variables:
-//   - DB_PATH: The file path where the SQLite database is stored. If the directory does not exist,
-//     it will be created.
+// - session: The session to record.
 //
 // Behavior:
-// - Creates a directory for the database if it does not already exist.
-// - Opens (or creates) a SQLite database named `unifi-guest-portal.db` in the specified `DB_PATH`.
-// - Ensures a `user_sessions` table exists with the following schema:
-//   - cache_id (TEXT PRIMARY KEY): Unique session identifier.
-//   - id (TEXT): User or device identifier.
-//   - ap (TEXT): Access point identifier.
-//   - name (TEXT): User or device owner's name.
-//   - email (TEXT): User's email address.
-//   - duration (INTEGER): Session duration in minutes.
-//   - created_at (TEXT): Timestamp when the record was created in RFC3339 format.
-//
-// - Inserts a new record into the `user_sessions` table with the provided parameters.
+//   - Stores the expiry of the session, Duration minutes from now.
+//   - Ends the earlier sessions of the same client that have not ended: those past their
+//     expiry as expired, the others as replaced by this one.
 //
-// Errors:
-// - Logs and terminates the application if the database cannot be opened or the table cannot be created.
-// - Logs a warning if data insertion fails.
+// Returns an error if the record cannot be inserted. The guest is already authorized on
+// the controller at this point, so callers log the error rather than failing the login.
 //
 // Example:
 // ```go
-// err := os.Setenv("DB_PATH", "/path/to/db")
-//
-//	if err != nil {
-//	    log.Fatalf("Failed to set DB_PATH: %v", err)
-//	}
-//
-// db.WriteToDb("cache123", "id456", "ap789", "John Doe", "john@example.com", 120)
+// err := store.WriteSession(db.Session{CacheID: "cache123", ID: "id456", AP: "ap789", Name: "John Doe", Email: "john@example.com", Duration: 120})
 // ```
-func WriteToDb(cacheId string, id string, ap string, name string, email string, duration int) {
-	// Open (or create) the SQLite database
-	err := os.MkdirAll(os.Getenv("DB_PATH"), os.ModePerm)
+func (s *sqlStore) WriteSession(session Session) error {
+	now := time.Now()
+	currentTime := now.Format(time.RFC3339)
+	endedAt := now.UTC().Format(time.RFC3339)
+	expiresAt := now.Add(time.Duration(session.Duration) * time.Minute).UTC().Format(time.RFC3339)
+	fields, err := encodeFields(session.Fields)
 	if err != nil {
-		log.Fatalf("Failed to create directory: %v", err)
+		return err
 	}
-	db, err := sql.Open("sqlite3", fmt.Sprintf("%s/unifi-guest-portal.db", os.Getenv("DB_PATH")))
+
+	tx, err := s.db.Begin()
 	if err != nil {
-		log.Fatalf("Failed to open database: %v", err)
-	}
-	defer db.Close()
-
-	// Ensure the table exists
-	createTableQuery := `
-	CREATE TABLE IF NOT EXISTS user_sessions (
-	    cache_id TEXT PRIMARY KEY,
-		id TEXT,
-		ap TEXT,
-		name TEXT,
-		email TEXT,
-		duration INTEGER,
-		created_at TEXT
-	);`
-	if _, err := db.Exec(createTableQuery); err != nil {
-		log.Fatalf("Failed to create table: %v", err)
+		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
+	defer tx.Rollback()
 
-	currentTime := time.Now().Format(time.RFC3339)
+	// End the client's earlier sessions
+	expired := s.dialect.timestamp("expires_at") + ` <= ` + s.dialect.timestamp("?")
+	_, err = tx.Exec(s.dialect.rebind(`UPDATE user_sessions
+		SET ended_at = CASE WHEN `+expired+` THEN expires_at ELSE ? END,
+			end_reason = CASE WHEN `+expired+` THEN ? ELSE ? END, updated_at = ?
+		WHERE id = ? AND ended_at IS NULL`),
+		endedAt, endedAt, endedAt, EndExpired, EndReplaced, currentTime, session.ID)
+	if err != nil {
+		return fmt.Errorf("failed to end earlier sessions: %v", err)
+	}
 
 	// Insert the data
-	insertQuery := `INSERT INTO user_sessions (cache_id, id, ap, name, email, duration, created_at) 
-					VALUES (?, ?, ?, ?, ?, ?, ?)`
-	_, err = db.Exec(insertQuery, cacheId, id, ap, name, email, duration, currentTime)
+	insertQuery := `INSERT INTO user_sessions (cache_id, id, ap, name, email, duration, created_at, status, updated_at, voucher, sponsor,
+					identity_issuer, identity_subject, email_verified, expires_at, site, fields) 
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	// email_verified is an INTEGER column; PostgreSQL does not convert booleans to integers
+	emailVerified := 0
+	if session.EmailVerified {
+		emailVerified = 1
+	}
+	_, err = tx.Exec(s.dialect.rebind(insertQuery), session.CacheID, session.ID, session.AP, session.Name, session.Email, session.Duration,
+		currentTime, StatusAuthorized, currentTime, session.Voucher, session.Sponsor,
+		session.IdentityIssuer, session.IdentitySubject, emailVerified, expiresAt, session.Site, fields)
 	if err != nil {
-		log.Printf("Failed to insert data: %v", err)
-	} else {
-		log.Println("Data inserted successfully")
+		return fmt.Errorf("failed to record session: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record session: %v", err)
+	}
+	return nil
+}
+
+// WriteFailedAttempt records a guest login that could not be authorized on the UniFi
+// controller. Failed attempts are kept in the `login_failures` table, separate from the
+// sessions in `user_sessions`, so a guest can retry with the same cache ID.
+//
+// Parameters:
+// - cacheId: Unique identifier for the cached session.
+// - id: User or device identifier associated with the attempt.
+// - ap: Access point identifier for the attempt.
+// - name: Name of the user or device owner.
+// - email: Email address of the user.
+// - code: Machine-readable error code returned to the guest.
+// - message: Error message describing the failure.
+//
+// Errors are logged rather than returned, as recording the failure must not change the
+// response sent to the guest.
+func (s *sqlStore) WriteFailedAttempt(cacheId string, id string, ap string, name string, email string, code string, message string) {
+	insertQuery := `INSERT INTO login_failures (cache_id, id, ap, name, email, code, message, created_at)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.exec(insertQuery, cacheId, id, ap, name, email, code, message, time.Now().Format(time.RFC3339))
+	if err != nil {
+		log.Printf("Failed to record failed attempt: %v", err)
+	}
+}
+
+// UpdateSessionStatus records a change of state (revocation, kick, ...) on the most recent
+// session of a client on a site. A revocation also ends the session.
+//
+// Parameters:
+// - id: User or device identifier (client MAC address) of the session.
+// - site: UniFi site the client is authorized on.
+// - status: New session status, one of the Status* constants.
+//
+// Returns ErrNoSession if no session has been recorded for the client.
+func (s *sqlStore) UpdateSessionStatus(id string, site string, status string) error {
+	set, args := statusUpdate(status)
+	return s.updateLatestSession(id, site, set, args...)
+}
+
+// ExtendSession records that the most recent session of a client on a site has been extended.
+// The session is reopened if the reconciler already ended it, as the controller reported
+// the authorization as running.
+//
+// Parameters:
+// - id: User or device identifier (client MAC address) of the session.
+// - site: UniFi site the client is authorized on.
+// - duration: New total session duration in minutes.
+// - expiresAt: New expiry of the authorization.
+//
+// Returns ErrNoSession if no session has been recorded for the client.
+func (s *sqlStore) ExtendSession(id string, site string, duration int, expiresAt time.Time) error {
+	return s.updateLatestSession(id, site, extensionUpdate, StatusExtended, duration, expiresAt.UTC().Format(time.RFC3339))
+}
+
+// updateLatestSession applies an update to the most recent session of a client on a site
+// and stamps its `updated_at` column. Sessions recorded before sites were tracked have no
+// site and match any.
+//
+// Parameters:
+// - id: User or device identifier (client MAC address) of the session.
+// - site: UniFi site the client is authorized on.
+// - set: The assignments of the SET clause, using `?` placeholders.
+// - args: The values for the placeholders in set.
+func (s *sqlStore) updateLatestSession(id string, site string, set string, args ...interface{}) error {
+	query := fmt.Sprintf(`UPDATE user_sessions SET %s, updated_at = ?
+		WHERE cache_id = (SELECT cache_id FROM user_sessions WHERE id = ? AND COALESCE(site, '') IN (?, '')
+			ORDER BY %s DESC LIMIT 1)`, set, s.dialect.timestamp("created_at"))
+	args = append(args, time.Now().Format(time.RFC3339), id, site)
+
+	result, err := s.exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNoSession
 	}
+	return nil
 }
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect holds the SQL that differs between the supported databases. Queries are written
+// for SQLite with `?` placeholders and adapted through the dialect where needed.
+//
+// All timestamps are stored as RFC3339 text in both databases, so rows read the same way
+// everywhere; the dialect converts them when they are compared or grouped.
+type dialect struct {
+	name string
+
+	// numbered reports whether placeholders are numbered ($1, $2, ...) instead of `?`.
+	numbered bool
+
+	// timestamp converts a text expression holding an RFC3339 time (with any offset)
+	// into a comparable timestamp.
+	timestamp func(expr string) string
+
+	// plusMinutes adds an integer expression of minutes to a timestamp expression.
+	plusMinutes func(timestamp, minutes string) string
+
+	// rfc3339 formats a timestamp expression as RFC3339 text in UTC, the form the
+	// application writes.
+	rfc3339 func(timestamp string) string
+
+	// day returns the UTC date (YYYY-MM-DD) of a text expression holding an RFC3339 time.
+	day func(expr string) string
+
+	// now is the current time as a timestamp.
+	now string
+
+	// columnExists counts the columns named by the second parameter in the table named by
+	// the first parameter.
+	columnExists string
+
+	// lockTable returns the statement locking a table against concurrent writers until
+	// the end of the transaction, e.g. so that portals sharing a database do not apply the
+	// same migration twice. Empty if transactions already hold the write lock.
+	lockTable func(table string) string
+
+	// ddl adapts a CREATE TABLE statement written for SQLite.
+	ddl func(statement string) string
+
+	// uniqueViolation reports whether an error is a violated UNIQUE or PRIMARY KEY constraint.
+	uniqueViolation func(err error) bool
+}
+
+// sqliteDialect is the dialect of SQLite, the default database.
+var sqliteDialect = &dialect{
+	name:      DriverSQLite,
+	timestamp: func(expr string) string { return fmt.Sprintf("datetime(%s)", expr) },
+	plusMinutes: func(timestamp, minutes string) string {
+		return fmt.Sprintf("datetime(%s, '+' || %s || ' minutes')", timestamp, minutes)
+	},
+	rfc3339: func(timestamp string) string {
+		return fmt.Sprintf("strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', %s)", timestamp)
+	},
+	day:          func(expr string) string { return fmt.Sprintf("date(%s)", expr) },
+	now:          "datetime('now')",
+	columnExists: `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`,
+	lockTable:    func(table string) string { return "" },
+	ddl:          func(statement string) string { return statement },
+	uniqueViolation: func(err error) bool {
+		var sqliteErr sqlite3.Error
+		return errors.As(err, &sqliteErr) &&
+			(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+	},
+}
+
+// postgresDialect is the dialect of PostgreSQL.
+var postgresDialect = &dialect{
+	name:      DriverPostgres,
+	numbered:  true,
+	timestamp: func(expr string) string { return fmt.Sprintf("(%s)::timestamptz", expr) },
+	plusMinutes: func(timestamp, minutes string) string {
+		return fmt.Sprintf("(%s + (%s) * interval '1 minute')", timestamp, minutes)
+	},
+	rfc3339: func(timestamp string) string {
+		return fmt.Sprintf(`to_char((%s) AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`, timestamp)
+	},
+	day: func(expr string) string {
+		return fmt.Sprintf("to_char((%s)::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD')", expr)
+	},
+	now: "now()",
+	columnExists: `SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`,
+	lockTable: func(table string) string {
+		return fmt.Sprintf("LOCK TABLE %s IN EXCLUSIVE MODE", table)
+	},
+	ddl: strings.NewReplacer(
+		"INTEGER PRIMARY KEY AUTOINCREMENT", "BIGSERIAL PRIMARY KEY",
+		" BLOB", " BYTEA",
+	).Replace,
+	uniqueViolation: func(err error) bool {
+		var pqErr *pq.Error
+		return errors.As(err, &pqErr) && pqErr.Code == "23505"
+	},
+}
+
+// lock locks a table against concurrent writers until the end of a transaction, where
+// the database needs it (see lockTable).
+func (d *dialect) lock(tx *sql.Tx, table string) error {
+	if statement := d.lockTable(table); statement != "" {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to lock %s table: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// rebind rewrites the `?` placeholders of a query for the dialect. Question marks inside
+// quoted string literals are left alone.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
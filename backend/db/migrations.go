@@ -0,0 +1,444 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration is a step in the evolution of the database schema. Migrations are applied in
+// order, each in its own transaction, and recorded in the `schema_version` table so each
+// runs exactly once per database. The same migrations run on every driver; statements
+// are written for SQLite and adapted through the dialect.
+type migration struct {
+	version     int                                // Position of the migration; versions are consecutive from 1.
+	description string                             // Summary logged when the migration is applied.
+	up          func(tx *sql.Tx, d *dialect) error // Brings the schema of the previous version to this version.
+}
+
+// migrations lists every schema change, oldest first. Append new migrations at the end
+// and never edit one that has been released: databases that already applied it will not
+// run it again.
+var migrations = []migration{
+	{1, "initial schema", migrateInitialSchema},
+	{2, "session lifecycle", migrateSessionLifecycle},
+	{3, "retention and audit log", migrateRetention},
+	{4, "audit log hash chain", migrateAuditChain},
+	{5, "session site", migrateSessionSite},
+	{6, "consents", migrateConsents},
+	{7, "custom form fields", migrateFormFields},
+	{8, "settings", migrateSettings},
+	{9, "email grace", migrateEmailGrace},
+	{10, "pending consents", migratePendingConsents},
+}
+
+// migrate applies the migrations a database has not seen yet.
+//
+// Returns an error if a migration fails, in which case its changes are rolled back, or
+// if the database was migrated by a newer release than this one.
+func migrate(db *sql.DB, d *dialect) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT,
+		applied_at TEXT
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_version table: %v", err)
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than this release supports (%d)", current, latest)
+	}
+
+	for _, m := range migrations[current:] {
+		applied, err := applyMigration(db, d, m)
+		if err != nil {
+			return err
+		}
+		if applied {
+			log.Printf("Applied database migration %d: %s", m.version, m.description)
+		}
+	}
+	return nil
+}
+
+// applyMigration applies a migration unless another portal sharing the database applied
+// it since migrate read the schema version.
+//
+// Returns whether the migration was applied, or an error if it failed.
+func applyMigration(db *sql.DB, d *dialect, m migration) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to start migration %d: %v", m.version, err)
+	}
+	defer tx.Rollback()
+
+	if err := d.lock(tx, "schema_version"); err != nil {
+		return false, err
+	}
+	var current int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&current); err != nil {
+		return false, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if current >= m.version {
+		return false, nil
+	}
+
+	if err := m.up(tx, d); err != nil {
+		return false, fmt.Errorf("migration %d (%s) failed: %v", m.version, m.description, err)
+	}
+	_, err = tx.Exec(d.rebind(`INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)`),
+		m.version, m.description, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return false, fmt.Errorf("failed to record migration %d: %v", m.version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit migration %d: %v", m.version, err)
+	}
+	return true, nil
+}
+
+// execAll runs schema statements in order, adapted to the dialect.
+func execAll(tx *sql.Tx, d *dialect, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := tx.Exec(d.ddl(statement)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateInitialSchema creates the tables of the releases before versioned migrations.
+//
+// Those releases created tables with `CREATE TABLE IF NOT EXISTS` and added the newer
+// `user_sessions` columns with `ALTER TABLE` when missing, so their databases may be at
+// any point in between. This migration does the same and is safe to run on all of them.
+//
+// Tables:
+// - user_sessions: Guest sessions (see Session and SessionRecord).
+// - login_failures: Guest logins that could not be authorized.
+// - vouchers: Printed access codes (see Voucher).
+// - email_verifications: One-time codes of the email mode (see EmailVerification).
+// - sponsor_requests: Host approvals of the sponsor mode (see SponsorRequest).
+// - radius_accounting: RADIUS sessions awaiting an Accounting-Stop (see AccountingSession).
+// - admin_users, admin_sessions: Admin dashboard accounts and their login sessions (see Admin).
+func migrateInitialSchema(tx *sql.Tx, d *dialect) error {
+	err := execAll(tx, d,
+		`
+	CREATE TABLE IF NOT EXISTS user_sessions (
+	    cache_id TEXT PRIMARY KEY,
+		id TEXT,
+		ap TEXT,
+		name TEXT,
+		email TEXT,
+		duration INTEGER,
+		created_at TEXT
+	);`,
+		`
+	CREATE TABLE IF NOT EXISTS login_failures (
+		attempt_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		cache_id TEXT,
+		id TEXT,
+		ap TEXT,
+		name TEXT,
+		email TEXT,
+		code TEXT,
+		message TEXT,
+		created_at TEXT
+	);`,
+		`
+	CREATE TABLE IF NOT EXISTS vouchers (
+		code TEXT PRIMARY KEY,
+		batch TEXT,
+		duration INTEGER,
+		max_uses INTEGER,
+		uses INTEGER NOT NULL DEFAULT 0,
+		quota INTEGER,
+		expires_at TEXT,
+		note TEXT,
+		created_at TEXT
+	);`,
+		`
+	CREATE TABLE IF NOT EXISTS email_verifications (
+		cache_id TEXT PRIMARY KEY,
+		id TEXT,
+		ap TEXT,
+		name TEXT,
+		email TEXT,
+		code_hash TEXT,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		created_at TEXT,
+		expires_at TEXT,
+		verified_at TEXT
+	);`,
+		`
+	CREATE TABLE IF NOT EXISTS sponsor_requests (
+		cache_id TEXT PRIMARY KEY,
+		id TEXT,
+		ap TEXT,
+		name TEXT,
+		email TEXT,
+		sponsor TEXT,
+		status TEXT,
+		created_at TEXT,
+		expires_at TEXT,
+		decided_at TEXT
+	);`,
+		`
+	CREATE TABLE IF NOT EXISTS radius_accounting (
+		session_id TEXT PRIMARY KEY,
+		cache_id TEXT,
+		id TEXT,
+		ap TEXT,
+		username TEXT,
+		class BLOB,
+		started_at TEXT,
+		expires_at TEXT,
+		stopped_at TEXT
+	);`,
+		`
+	CREATE TABLE IF NOT EXISTS admin_users (
+		username TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TEXT,
+		last_login_at TEXT
+	);`,
+		`
+	CREATE TABLE IF NOT EXISTS admin_sessions (
+		token_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		created_at TEXT,
+		expires_at TEXT
+	);`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create table: %v", err)
+	}
+
+	// Columns the older releases added after user_sessions was first created, in order
+	addedColumns := []struct{ name, definition string }{
+		{"status", fmt.Sprintf("TEXT NOT NULL DEFAULT '%s'", StatusAuthorized)},
+		{"updated_at", "TEXT"},
+		{"voucher", "TEXT"},
+		{"sponsor", "TEXT"},
+		{"identity_issuer", "TEXT"},
+		{"identity_subject", "TEXT"},
+		{"email_verified", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, column := range addedColumns {
+		var count int
+		err := tx.QueryRow(d.rebind(d.columnExists), "user_sessions", column.name).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to inspect table: %v", err)
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE user_sessions ADD COLUMN %s %s`, column.name, column.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s: %v", column.name, err)
+		}
+	}
+	return nil
+}
+
+// migrateSessionLifecycle adds the expiry and end of guest sessions to `user_sessions`.
+//
+// Columns:
+// - expires_at: Time the authorization runs out, backfilled from created_at and duration.
+// - ended_at: Time the session ended, or NULL while it may still be running.
+// - end_reason: Why the session ended (one of the End* constants).
+//
+// Revoked sessions are backfilled as ended at their last update. Sessions past their
+// expiry are left to the session reconciler, which ends them on its first run.
+func migrateSessionLifecycle(tx *sql.Tx, d *dialect) error {
+	for _, column := range []string{"expires_at", "ended_at", "end_reason"} {
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE user_sessions ADD COLUMN %s TEXT`, column)); err != nil {
+			return fmt.Errorf("failed to add column %s: %v", column, err)
+		}
+	}
+
+	_, err := tx.Exec(`UPDATE user_sessions SET expires_at = ` +
+		d.rfc3339(d.plusMinutes(d.timestamp("created_at"), "COALESCE(duration, 0)")))
+	if err != nil {
+		return fmt.Errorf("failed to backfill session expiry: %v", err)
+	}
+	_, err = tx.Exec(d.rebind(`UPDATE user_sessions SET ended_at = `+
+		d.rfc3339(d.timestamp("COALESCE(updated_at, created_at)"))+`, end_reason = ? WHERE status = ?`),
+		EndRevoked, StatusRevoked)
+	if err != nil {
+		return fmt.Errorf("failed to backfill revoked sessions: %v", err)
+	}
+	return nil
+}
+
+// migrateRetention prepares the purge of personal data (see PurgePersonalData).
+//
+// Tables:
+// - audit_log: Record of administrative operations on guest data (see WriteAuditEntry).
+//
+// Columns:
+// - anonymized_at (user_sessions, login_failures): Time the personal data was anonymised, or NULL.
+func migrateRetention(tx *sql.Tx, d *dialect) error {
+	err := execAll(tx, d, `
+	CREATE TABLE audit_log (
+		entry_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		details TEXT
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create table: %v", err)
+	}
+
+	for _, table := range []string{"user_sessions", "login_failures"} {
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN anonymized_at TEXT`, table)); err != nil {
+			return fmt.Errorf("failed to add column %s.anonymized_at: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// migrateAuditChain adds the hash chain of the audit log (see AuditEntry) and chains the
+// entries written before it.
+//
+// Columns:
+// - prev_hash, hash (audit_log): Hash of the previous entry and of the entry itself.
+func migrateAuditChain(tx *sql.Tx, d *dialect) error {
+	for _, column := range []string{"prev_hash", "hash"} {
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE audit_log ADD COLUMN %s TEXT`, column)); err != nil {
+			return fmt.Errorf("failed to add column %s: %v", column, err)
+		}
+	}
+
+	rows, err := tx.Query(`SELECT entry_id, created_at, actor, action, COALESCE(details, '') FROM audit_log ORDER BY entry_id`)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var createdAt, details string
+		if err := rows.Scan(&entry.ID, &createdAt, &entry.Actor, &entry.Action, &details); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read audit entry: %v", err)
+		}
+		entry.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		entry.Details = json.RawMessage(details)
+		entries = append(entries, entry)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+
+	prevHash := ""
+	for _, entry := range entries {
+		entry.PrevHash = prevHash
+		prevHash = entry.chainHash()
+		_, err := tx.Exec(d.rebind(`UPDATE audit_log SET prev_hash = ?, hash = ? WHERE entry_id = ?`), entry.PrevHash, prevHash, entry.ID)
+		if err != nil {
+			return fmt.Errorf("failed to chain audit entry %d: %v", entry.ID, err)
+		}
+	}
+	return nil
+}
+
+// migrateSessionSite records the UniFi site of guest sessions, so portals sharing a
+// database can tell their sessions apart.
+//
+// Columns:
+// - site (user_sessions): UniFi site the guest was authorized on; empty for sessions recorded before.
+func migrateSessionSite(tx *sql.Tx, d *dialect) error {
+	if _, err := tx.Exec(`ALTER TABLE user_sessions ADD COLUMN site TEXT`); err != nil {
+		return fmt.Errorf("failed to add column site: %v", err)
+	}
+	return nil
+}
+
+// migrateConsents records the terms of service and marketing consent of guest logins.
+//
+// Tables:
+// - consents: Consent given with each login, keyed by its cache ID like `user_sessions` (see Consent).
+func migrateConsents(tx *sql.Tx, d *dialect) error {
+	err := execAll(tx, d, `
+	CREATE TABLE consents (
+		cache_id TEXT PRIMARY KEY,
+		id TEXT,
+		ap TEXT,
+		ip TEXT,
+		email TEXT,
+		terms_version TEXT,
+		marketing INTEGER NOT NULL DEFAULT 0,
+		accepted_at TEXT NOT NULL,
+		anonymized_at TEXT
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create table: %v", err)
+	}
+	return nil
+}
+
+// migrateFormFields stores the answers to the custom form fields of a login, as a JSON
+// object, with the session and with the steps that precede it in the email and sponsor
+// modes.
+func migrateFormFields(tx *sql.Tx, d *dialect) error {
+	for _, table := range []string{"user_sessions", "email_verifications", "sponsor_requests"} {
+		if _, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN fields TEXT`); err != nil {
+			return fmt.Errorf("failed to add column fields to %s: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// migrateSettings stores the settings changed at runtime, so they survive a restart.
+//
+// Tables:
+// - settings: Values by name (see GetSetting).
+func migrateSettings(tx *sql.Tx, d *dialect) error {
+	err := execAll(tx, d, `
+	CREATE TABLE settings (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create table: %v", err)
+	}
+	return nil
+}
+
+// migrateEmailGrace records the grace authorization of email verifications, so a guest
+// gets one while a code is pending, however often they ask for a new code.
+//
+// Columns:
+// - grace_at (email_verifications): Time the grace authorization was granted, or NULL.
+func migrateEmailGrace(tx *sql.Tx, d *dialect) error {
+	if _, err := tx.Exec(`ALTER TABLE email_verifications ADD COLUMN grace_at TEXT`); err != nil {
+		return fmt.Errorf("failed to add column grace_at: %v", err)
+	}
+	return nil
+}
+
+// migratePendingConsents stores the consent given with a login that only gets access once
+// a code is verified or a host approves, so it is recorded in `consents` at that point.
+//
+// Columns:
+// - consent (email_verifications, sponsor_requests): JSON object of the Consent to record, or NULL.
+func migratePendingConsents(tx *sql.Tx, d *dialect) error {
+	for _, table := range []string{"email_verifications", "sponsor_requests"} {
+		if _, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN consent TEXT`); err != nil {
+			return fmt.Errorf("failed to add column consent to %s: %v", table, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RecordCounts counts guest data rows per table.
+type RecordCounts struct {
+	Sessions           int `json:"sessions"`           // Rows of `user_sessions`.
+	FailedAttempts     int `json:"failedAttempts"`     // Rows of `login_failures`.
+	Verifications      int `json:"verifications"`      // Rows of `email_verifications`.
+	SponsorRequests    int `json:"sponsorRequests"`    // Rows of `sponsor_requests`.
+	AccountingSessions int `json:"accountingSessions"` // Rows of `radius_accounting`.
+	Consents           int `json:"consents"`           // Rows of `consents`.
+}
+
+// Total returns the number of rows counted.
+func (c RecordCounts) Total() int {
+	return c.Sessions + c.FailedAttempts + c.Verifications + c.SponsorRequests + c.AccountingSessions + c.Consents
+}
+
+// PurgeReport counts the rows affected by PurgePersonalData. Verifications, sponsor
+// requests and (stopped) accounting sessions are always deleted.
+type PurgeReport struct {
+	Cutoff    time.Time `json:"cutoff"`    // Data older than this time is purged.
+	Anonymize bool      `json:"anonymize"` // Whether sessions, failed logins and consents are anonymised rather than deleted.
+	DryRun    bool      `json:"dryRun"`    // Whether the rows were only counted.
+	RecordCounts
+}
+
+// PurgePersonalData deletes or anonymises the guest data older than a cutoff, in a single
+// transaction.
+//
+// Parameters:
+// - cutoff: Rows older than this time are purged. Sessions are dated by their expiry.
+// - anonymize: Anonymise sessions, failed logins and consents instead of deleting them.
+// - dryRun: Only count the rows that would be purged.
+// - actor: Admin username, ActorSystem or ActorCLI, recorded in the audit log.
+//
+// Behavior:
+//   - Anonymising replaces names, email addresses, IP addresses, identity subjects and
+//     sponsors with a short hash of the value (see pseudonym), so statistics still count
+//     returning guests, truncates client MAC addresses to their vendor prefix and clears
+//     the answers to custom form fields. Rows are anonymised once. Consents keep the
+//     terms version, as evidence of acceptance.
+//   - Email verifications, sponsor requests and stopped RADIUS accounting sessions only
+//     serve running logins and are deleted in both modes.
+//   - Unless dryRun is set, a purge that changed any row is recorded in the audit log
+//     with the report, in the same transaction.
+//
+// Returns the number of rows purged (or that would be purged) per table.
+func (s *sqlStore) PurgePersonalData(cutoff time.Time, anonymize bool, dryRun bool, actor string) (*PurgeReport, error) {
+	report := &PurgeReport{Cutoff: cutoff.UTC().Truncate(time.Second), Anonymize: anonymize, DryRun: dryRun}
+	before := func(column string) string {
+		return s.dialect.timestamp(column) + ` < ` + s.dialect.timestamp("?")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	sessions := before("COALESCE(expires_at, created_at)")
+	failures := before("created_at")
+	consents := before("accepted_at")
+	if anonymize {
+		sessions += ` AND anonymized_at IS NULL`
+		failures += ` AND anonymized_at IS NULL`
+		consents += ` AND anonymized_at IS NULL`
+	}
+
+	targets := []struct {
+		table     string
+		where     string
+		count     *int
+		anonymize func(tx *sql.Tx, where string, args ...interface{}) (int, error)
+	}{
+		{"user_sessions", sessions, &report.Sessions, s.anonymizeSessions},
+		{"login_failures", failures, &report.FailedAttempts, s.anonymizeFailures},
+		{"email_verifications", before("created_at"), &report.Verifications, nil},
+		{"sponsor_requests", before("created_at"), &report.SponsorRequests, nil},
+		{"radius_accounting", `stopped_at IS NOT NULL AND ` + before("started_at"), &report.AccountingSessions, nil},
+		{"consents", consents, &report.Consents, s.anonymizeConsents},
+	}
+	arg := cutoff.UTC().Format(time.RFC3339)
+	for _, target := range targets {
+		switch {
+		case dryRun:
+			err = tx.QueryRow(s.dialect.rebind(`SELECT COUNT(*) FROM `+target.table+` WHERE `+target.where), arg).Scan(target.count)
+		case anonymize && target.anonymize != nil:
+			*target.count, err = target.anonymize(tx, target.where, arg)
+		default:
+			var result sql.Result
+			if result, err = tx.Exec(s.dialect.rebind(`DELETE FROM `+target.table+` WHERE `+target.where), arg); err == nil {
+				deleted, _ := result.RowsAffected()
+				*target.count = int(deleted)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge %s: %v", target.table, err)
+		}
+	}
+
+	if !dryRun && report.Total() > 0 {
+		if err := s.writeAuditEntry(tx, actor, AuditRetentionPurge, report); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit purge: %v", err)
+	}
+	return report, nil
+}
+
+// anonymizeSessions anonymises the `user_sessions` rows matching a condition.
+func (s *sqlStore) anonymizeSessions(tx *sql.Tx, where string, args ...interface{}) (int, error) {
+	return s.anonymizeRows(tx, `SELECT cache_id, COALESCE(id, ''), COALESCE(name, ''), COALESCE(email, ''),
+		COALESCE(identity_subject, ''), COALESCE(sponsor, '') FROM user_sessions WHERE `+where, args,
+		`UPDATE user_sessions SET id = ?, name = ?, email = ?, identity_subject = ?, sponsor = ?, fields = NULL, anonymized_at = ?
+		WHERE cache_id = ?`,
+		truncateMAC, pseudonym, pseudonym, pseudonym, pseudonym)
+}
+
+// anonymizeFailures anonymises the `login_failures` rows matching a condition.
+func (s *sqlStore) anonymizeFailures(tx *sql.Tx, where string, args ...interface{}) (int, error) {
+	return s.anonymizeRows(tx, `SELECT attempt_id, COALESCE(id, ''), COALESCE(name, ''), COALESCE(email, '')
+		FROM login_failures WHERE `+where, args,
+		`UPDATE login_failures SET id = ?, name = ?, email = ?, anonymized_at = ? WHERE attempt_id = ?`,
+		truncateMAC, pseudonym, pseudonym)
+}
+
+// anonymizeConsents anonymises the `consents` rows matching a condition.
+func (s *sqlStore) anonymizeConsents(tx *sql.Tx, where string, args ...interface{}) (int, error) {
+	return s.anonymizeRows(tx, `SELECT cache_id, COALESCE(id, ''), COALESCE(ip, ''), COALESCE(email, '')
+		FROM consents WHERE `+where, args,
+		`UPDATE consents SET id = ?, ip = ?, email = ?, anonymized_at = ? WHERE cache_id = ?`,
+		truncateMAC, pseudonym, pseudonym)
+}
+
+// anonymizeRows anonymises the rows selected by query and returns their number.
+//
+// Parameters:
+// - tx: Transaction of the purge.
+// - query: Selects the key of each row followed by its personal columns.
+// - args: Arguments of query.
+// - update: Sets the personal columns and `anonymized_at`, in that order, of the row with the key given last.
+// - transforms: Anonymisation of each personal column, in the order of query.
+//
+// The rows are read completely before the first update, as PostgreSQL connections
+// cannot run a statement while another one is returning rows.
+func (s *sqlStore) anonymizeRows(tx *sql.Tx, query string, args []interface{}, update string, transforms ...func(string) string) (int, error) {
+	rows, err := tx.Query(s.dialect.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	var selected [][]string
+	for rows.Next() {
+		row := make([]string, len(transforms)+1)
+		dest := make([]interface{}, len(row))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		selected = append(selected, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	update = s.dialect.rebind(update)
+	for _, row := range selected {
+		values := make([]interface{}, 0, len(row)+1)
+		for i, transform := range transforms {
+			values = append(values, transform(row[i+1]))
+		}
+		values = append(values, now, row[0])
+		if _, err := tx.Exec(update, values...); err != nil {
+			return 0, err
+		}
+	}
+	return len(selected), nil
+}
+
+// pseudonym replaces a personal value with a short hash of it, so anonymised rows of the
+// same guest can still be counted together. Values are compared case-insensitively, and
+// empty values stay empty.
+func pseudonym(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return "anon-" + hex.EncodeToString(sum[:8])
+}
+
+// truncateMAC keeps the vendor prefix (the first three octets) of a MAC address.
+func truncateMAC(mac string) string {
+	if len(mac) > len("aa:bb:cc") {
+		return mac[:len("aa:bb:cc")]
+	}
+	return mac
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// backdateGuest moves the records written by seedGuest for a cache ID to a point in time.
+func backdateGuest(t *testing.T, s *sqlStore, cacheId string, at time.Time) {
+	t.Helper()
+	value := at.UTC().Format(time.RFC3339)
+	updates := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE user_sessions SET created_at = ?, expires_at = ? WHERE cache_id = ?`, []interface{}{value, value, cacheId}},
+		{`UPDATE login_failures SET created_at = ? WHERE cache_id = ?`, []interface{}{value, "f-" + cacheId}},
+		{`UPDATE email_verifications SET created_at = ? WHERE cache_id = ?`, []interface{}{value, "v-" + cacheId}},
+		{`UPDATE sponsor_requests SET created_at = ? WHERE cache_id = ?`, []interface{}{value, "r-" + cacheId}},
+		{`UPDATE radius_accounting SET started_at = ? WHERE session_id = ?`, []interface{}{value, "a-" + cacheId}},
+		{`UPDATE consents SET accepted_at = ? WHERE cache_id = ?`, []interface{}{value, cacheId}},
+	}
+	for _, update := range updates {
+		if _, err := s.exec(update.query, update.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// auditEntries returns the number of entries in the audit log.
+func auditEntries(t *testing.T, s *sqlStore) int {
+	t.Helper()
+	_, total, err := s.ListAuditEntries(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return total
+}
+
+// seedRetention records a guest older than cutoff, whose accounting session was stopped,
+// a guest exactly at cutoff, and a recent guest. The recent guest has an old accounting
+// session that is still running.
+func seedRetention(t *testing.T, s *sqlStore, cutoff time.Time) {
+	t.Helper()
+	seedGuest(t, s, "old", ada.MAC, ada.Email)
+	backdateGuest(t, s, "old", cutoff.Add(-time.Second))
+	if err := s.MarkAccountingStopped("a-old"); err != nil {
+		t.Fatal(err)
+	}
+	seedGuest(t, s, "edge", "aa:bb:cc:dd:ee:03", "edge@example.com")
+	backdateGuest(t, s, "edge", cutoff)
+	if err := s.MarkAccountingStopped("a-edge"); err != nil {
+		t.Fatal(err)
+	}
+	seedGuest(t, s, "new", bob.MAC, bob.Email)
+	err := s.WriteAccountingStart(AccountingSession{SessionID: "a-running", CacheID: "new", ID: bob.MAC, Username: bob.Email,
+		StartedAt: cutoff.Add(-time.Hour), ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgePersonalData(t *testing.T) {
+	cutoff := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+	one := RecordCounts{1, 1, 1, 1, 1, 1}
+
+	for _, anonymize := range []bool{false, true} {
+		name := "delete"
+		if anonymize {
+			name = "anonymize"
+		}
+		t.Run(name, func(t *testing.T) {
+			forEachDriver(t, func(t *testing.T, s *sqlStore) {
+				seedRetention(t, s, cutoff)
+
+				// Only the rows older than the cutoff are purged
+				report, err := s.PurgePersonalData(cutoff, anonymize, false, ActorSystem)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.RecordCounts != one || report.Anonymize != anonymize || report.DryRun || !report.Cutoff.Equal(cutoff) {
+					t.Errorf("got report %+v", report)
+				}
+				entry := lastAuditEntry(t, s)
+				if entry.Action != AuditRetentionPurge || entry.Actor != ActorSystem || auditEntries(t, s) != 1 {
+					t.Errorf("got audit entry %+v", entry)
+				}
+
+				if got := exportCounts(t, s, Subject{Email: "edge@example.com"}); got != one {
+					t.Errorf("guest at the cutoff: got %+v", got)
+				}
+				if got := exportCounts(t, s, bob); got != (RecordCounts{1, 1, 1, 1, 2, 1}) {
+					t.Errorf("recent guest: got %+v, want its running accounting sessions kept", got)
+				}
+				if got := exportCounts(t, s, ada); got != (RecordCounts{}) {
+					t.Errorf("old guest: got %+v", got)
+				}
+
+				session, err := s.GetSession("old")
+				if !anonymize {
+					if err == nil {
+						t.Errorf("deleted session: got %+v", session)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if session.MAC != "aa:bb:cc" || session.Email == ada.Email || session.Name == "Guest old" || session.Fields != nil {
+					t.Errorf("anonymised session: got %+v", session)
+				}
+
+				// Rows are anonymised once, and a purge without changes is not audited
+				entries := auditEntries(t, s)
+				again, err := s.PurgePersonalData(cutoff, anonymize, false, ActorSystem)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if again.Total() != 0 {
+					t.Errorf("second purge: got %+v", again)
+				}
+				if auditEntries(t, s) != entries {
+					t.Error("second purge: got an audit entry")
+				}
+				if anonymized, err := s.GetSession("old"); err != nil || anonymized.Email != session.Email || anonymized.Name != session.Name {
+					t.Errorf("session after the second purge: got %+v, %v, want %+v", anonymized, err, session)
+				}
+			})
+		})
+	}
+}
+
+func TestPurgePersonalDataDryRun(t *testing.T) {
+	cutoff := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+	for _, anonymize := range []bool{false, true} {
+		name := "delete"
+		if anonymize {
+			name = "anonymize"
+		}
+		t.Run(name, func(t *testing.T) {
+			forEachDriver(t, func(t *testing.T, s *sqlStore) {
+				seedRetention(t, s, cutoff)
+
+				report, err := s.PurgePersonalData(cutoff, anonymize, true, ActorSystem)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.RecordCounts != (RecordCounts{1, 1, 1, 1, 1, 1}) || !report.DryRun {
+					t.Errorf("got report %+v", report)
+				}
+				if entries := auditEntries(t, s); entries != 0 {
+					t.Errorf("got %d audit entries, want none", entries)
+				}
+				if session, err := s.GetSession("old"); err != nil || session.Email != ada.Email || session.MAC != ada.MAC {
+					t.Errorf("session after a dry run: got %+v, %v", session, err)
+				}
+				if got := exportCounts(t, s, ada); got != (RecordCounts{1, 1, 1, 1, 1, 1}) {
+					t.Errorf("old guest after a dry run: got %+v", got)
+				}
+			})
+		})
+	}
+}
+
+func TestPurgePersonalDataWithoutOldData(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		seedGuest(t, s, "new", bob.MAC, bob.Email)
+
+		report, err := s.PurgePersonalData(time.Now().Add(-time.Hour), false, false, ActorSystem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Total() != 0 || auditEntries(t, s) != 0 {
+			t.Errorf("got report %+v and %d audit entries, want nothing purged or audited", report, auditEntries(t, s))
+		}
+	})
+}
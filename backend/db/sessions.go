@@ -0,0 +1,404 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SessionRecord represents a row of the `user_sessions` table as returned by the admin API.
+type SessionRecord struct {
+	CacheID         string     `json:"cacheId"`                   // Unique identifier of the session.
+	MAC             string     `json:"mac"`                       // MAC address of the guest client.
+	AP              string     `json:"ap"`                        // MAC address of the access point.
+	Site            string     `json:"site,omitempty"`            // UniFi site the guest was authorized on, if recorded.
+	Name            string     `json:"name"`                      // Name entered by (or looked up for) the guest.
+	Email           string     `json:"email"`                     // Email address of the guest.
+	Duration        int        `json:"duration"`                  // Total authorized duration in minutes.
+	Status          string     `json:"status"`                    // One of the Status* constants.
+	Active          bool       `json:"active"`                    // Whether the authorization is still running.
+	Voucher         string     `json:"voucher,omitempty"`         // Voucher code redeemed for the session, if any.
+	Sponsor         string     `json:"sponsor,omitempty"`         // Host who approved the session, if any.
+	IdentityIssuer  string     `json:"identityIssuer,omitempty"`  // Issuer of the verified identity, if any.
+	IdentitySubject string     `json:"identitySubject,omitempty"` // Subject of the verified identity, if any.
+	EmailVerified   bool       `json:"emailVerified"`             // Whether the identity provider verified the email address.
+	CreatedAt       time.Time  `json:"createdAt"`                 // Time the guest was authorized.
+	UpdatedAt       time.Time  `json:"updatedAt"`                 // Time of the last status change.
+	ExpiresAt       time.Time  `json:"expiresAt"`                 // Time the authorization runs out.
+	EndedAt         *time.Time `json:"endedAt,omitempty"`         // Time the session ended, if it has.
+	EndReason       string     `json:"endReason,omitempty"`       // Why the session ended (one of the End* constants), if it has.
+
+	Fields FieldValues `json:"fields,omitempty"` // Answers to the custom form fields, if any.
+}
+
+// SessionFilter selects sessions in ListSessions and CountSessions. Empty fields match
+// every session.
+type SessionFilter struct {
+	MAC       string    // Exact client MAC address (lowercase, colon-separated).
+	AP        string    // Exact access point MAC address.
+	Site      string    // Exact UniFi site name.
+	Email     string    // Exact email address, case-insensitive.
+	Name      string    // Part of the name, case-insensitive.
+	Query     string    // Part of the MAC address, email address or name, case-insensitive.
+	Status    string    // Exact session status.
+	Active    *bool     // Only sessions whose authorization is (or is no longer) running.
+	Marketing bool      // Only sessions whose guest agreed to receive marketing email.
+	Since     time.Time // Only sessions created at or after this time.
+	Until     time.Time // Only sessions created before this time.
+	Limit     int       // Maximum number of sessions to return (ListSessions only; 0 for all).
+	Offset    int       // Number of sessions to skip (ListSessions only).
+}
+
+// SessionStats holds aggregate counts over the sessions matching a filter.
+type SessionStats struct {
+	Total          int            `json:"total"`          // Number of sessions.
+	Active         int            `json:"active"`         // Number of sessions whose authorization is still running.
+	UniqueClients  int            `json:"uniqueClients"`  // Number of distinct client MAC addresses.
+	UniqueEmails   int            `json:"uniqueEmails"`   // Number of distinct email addresses.
+	ByStatus       map[string]int `json:"byStatus"`       // Number of sessions per status.
+	ByAP           map[string]int `json:"byAp"`           // Number of sessions per access point MAC address.
+	ByDay          []DayCount     `json:"byDay"`          // Number of sessions per day (UTC), oldest first.
+	FailedAttempts int            `json:"failedAttempts"` // Number of failed logins in the same time range.
+}
+
+// DayCount holds the number of sessions created on a day.
+type DayCount struct {
+	Date     string `json:"date"`     // Day in YYYY-MM-DD format (UTC).
+	Sessions int    `json:"sessions"` // Number of sessions created that day.
+}
+
+// sessionColumns lists the `user_sessions` columns read by scanSession, in order.
+const sessionColumns = `cache_id, COALESCE(id, ''), COALESCE(ap, ''), COALESCE(name, ''), COALESCE(email, ''),
+	COALESCE(duration, 0), status, created_at, COALESCE(updated_at, created_at), COALESCE(voucher, ''),
+	COALESCE(sponsor, ''), COALESCE(identity_issuer, ''), COALESCE(identity_subject, ''), email_verified,
+	COALESCE(expires_at, created_at), ended_at, COALESCE(end_reason, ''), COALESCE(site, ''), fields`
+
+// activeCondition returns the condition matching the sessions whose authorization is
+// still running: sessions that have not been revoked or ended and are not past their
+// expiry, which the reconciler may not have noticed yet. A kicked client keeps its
+// authorization, so kicked sessions are still running.
+func (d *dialect) activeCondition() string {
+	return fmt.Sprintf(`status <> '%s' AND ended_at IS NULL AND %s > %s`, StatusRevoked,
+		d.timestamp("expires_at"), d.now)
+}
+
+// ListSessions returns the sessions matching a filter, newest first.
+//
+// Parameters:
+// - filter: Selects the sessions and the page to return.
+//
+// Returns:
+// - []SessionRecord: The sessions of the requested page.
+// - int: The number of sessions matching the filter across all pages.
+// - error: An error if the database cannot be queried.
+func (s *sqlStore) ListSessions(filter SessionFilter) ([]SessionRecord, int, error) {
+	where, args := filter.where(s.dialect)
+
+	var total int
+	if err := s.queryRow(`SELECT COUNT(*) FROM user_sessions WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count sessions: %v", err)
+	}
+
+	query := `SELECT ` + sessionColumns + ` FROM user_sessions WHERE ` + where + ` ORDER BY ` + s.dialect.timestamp("created_at") + ` DESC, cache_id`
+	if filter.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, filter.Limit, filter.Offset)
+	}
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list sessions: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := []SessionRecord{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, total, rows.Err()
+}
+
+// StreamSessions passes the sessions matching a filter to visit one at a time, oldest
+// first, without loading them all into memory. The Limit and Offset fields of the filter
+// are ignored.
+//
+// Returns the first error returned by visit, or an error if the database cannot be queried.
+func (s *sqlStore) StreamSessions(filter SessionFilter, visit func(SessionRecord) error) error {
+	where, args := filter.where(s.dialect)
+	rows, err := s.query(`SELECT `+sessionColumns+` FROM user_sessions WHERE `+where+
+		` ORDER BY `+s.dialect.timestamp("created_at")+`, cache_id`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return err
+		}
+		if err := visit(*session); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetSession returns a single session.
+//
+// Returns ErrNoSession if no session has the given cache ID.
+func (s *sqlStore) GetSession(cacheId string) (*SessionRecord, error) {
+	row := s.queryRow(`SELECT `+sessionColumns+` FROM user_sessions WHERE cache_id = ?`, cacheId)
+	session, err := scanSession(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoSession
+	}
+	return session, err
+}
+
+// CountSessions returns aggregate counts over the sessions matching a filter. The Limit
+// and Offset fields of the filter are ignored, and failed attempts are only filtered by
+// Since and Until.
+func (s *sqlStore) CountSessions(filter SessionFilter) (*SessionStats, error) {
+	where, args := filter.where(s.dialect)
+	stats := &SessionStats{ByStatus: map[string]int{}, ByAP: map[string]int{}, ByDay: []DayCount{}}
+
+	err := s.queryRow(`SELECT COUNT(*), COALESCE(SUM(CASE WHEN `+s.dialect.activeCondition()+` THEN 1 ELSE 0 END), 0),
+		COUNT(DISTINCT id), COUNT(DISTINCT NULLIF(lower(email), '')) FROM user_sessions WHERE `+where, args...).
+		Scan(&stats.Total, &stats.Active, &stats.UniqueClients, &stats.UniqueEmails)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sessions: %v", err)
+	}
+
+	if err := s.queryCounts(`SELECT status, COUNT(*) FROM user_sessions WHERE `+where+` GROUP BY status`, args, func(key string, count int) {
+		stats.ByStatus[key] = count
+	}); err != nil {
+		return nil, err
+	}
+	if err := s.queryCounts(`SELECT COALESCE(ap, ''), COUNT(*) FROM user_sessions WHERE `+where+` GROUP BY COALESCE(ap, '')`, args, func(key string, count int) {
+		stats.ByAP[key] = count
+	}); err != nil {
+		return nil, err
+	}
+	day := s.dialect.day("created_at")
+	if err := s.queryCounts(`SELECT `+day+`, COUNT(*) FROM user_sessions WHERE `+where+
+		` GROUP BY `+day+` ORDER BY `+day, args, func(key string, count int) {
+		stats.ByDay = append(stats.ByDay, DayCount{Date: key, Sessions: count})
+	}); err != nil {
+		return nil, err
+	}
+
+	failures, failureArgs := SessionFilter{Since: filter.Since, Until: filter.Until}.where(s.dialect)
+	if err := s.queryRow(`SELECT COUNT(*) FROM login_failures WHERE `+failures, failureArgs...).Scan(&stats.FailedAttempts); err != nil {
+		return nil, fmt.Errorf("failed to count failed attempts: %v", err)
+	}
+	return stats, nil
+}
+
+// SetSessionStatus records a change of state (revocation, kick, ...) on a session. A
+// revocation also ends the session.
+//
+// Returns ErrNoSession if no session has the given cache ID.
+func (s *sqlStore) SetSessionStatus(cacheId string, status string) error {
+	set, args := statusUpdate(status)
+	return s.updateSession(cacheId, set, args...)
+}
+
+// SetSessionExtended records that a session has been extended.
+//
+// Parameters:
+// - cacheId: Unique identifier of the session.
+// - duration: New total session duration in minutes.
+// - expiresAt: New expiry of the authorization.
+//
+// Returns ErrNoSession if no session has the given cache ID.
+func (s *sqlStore) SetSessionExtended(cacheId string, duration int, expiresAt time.Time) error {
+	return s.updateSession(cacheId, extensionUpdate, StatusExtended, duration, expiresAt.UTC().Format(time.RFC3339))
+}
+
+// EndExpiredSessions ends the sessions that are past their expiry, with the EndExpired
+// reason and their expiry as end time.
+//
+// Returns the number of sessions ended.
+func (s *sqlStore) EndExpiredSessions(now time.Time) (int, error) {
+	result, err := s.exec(`UPDATE user_sessions SET ended_at = expires_at, end_reason = ?
+		WHERE ended_at IS NULL AND `+s.dialect.timestamp("expires_at")+` <= `+s.dialect.timestamp("?"),
+		EndExpired, now.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, fmt.Errorf("failed to end expired sessions: %v", err)
+	}
+	ended, _ := result.RowsAffected()
+	return int(ended), nil
+}
+
+// EndSession ends a session that has not ended yet, for when its authorization turned
+// out to be gone on the controller.
+//
+// Parameters:
+// - cacheId: Unique identifier of the session.
+// - reason: One of the End* constants.
+//
+// Returns ErrNoSession if no running session has the given cache ID.
+func (s *sqlStore) EndSession(cacheId string, reason string) error {
+	now := time.Now()
+	result, err := s.exec(`UPDATE user_sessions SET ended_at = ?, end_reason = ?, updated_at = ?
+		WHERE cache_id = ? AND ended_at IS NULL`,
+		now.UTC().Format(time.RFC3339), reason, now.Format(time.RFC3339), cacheId)
+	if err != nil {
+		return fmt.Errorf("failed to end session: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNoSession
+	}
+	return nil
+}
+
+// SetSessionExpiry moves the expiry of a session, for when the controller reports a
+// different end for its authorization (e.g. after an extension in the UniFi interface).
+//
+// Returns ErrNoSession if no session has the given cache ID.
+func (s *sqlStore) SetSessionExpiry(cacheId string, expiresAt time.Time) error {
+	return s.updateSession(cacheId, `expires_at = ?`, expiresAt.UTC().Format(time.RFC3339))
+}
+
+// extensionUpdate is the SET clause recording an extension, with the status, duration
+// and expiry as parameters. An extended session has not ended.
+const extensionUpdate = `status = ?, duration = ?, expires_at = ?, ended_at = NULL, end_reason = NULL`
+
+// statusUpdate returns the SET clause and arguments recording a new session status.
+// Revocations also end the session, unless it ended before.
+func statusUpdate(status string) (string, []interface{}) {
+	if status != StatusRevoked {
+		return `status = ?`, []interface{}{status}
+	}
+	return `status = ?, end_reason = COALESCE(end_reason, ?), ended_at = COALESCE(ended_at, ?)`,
+		[]interface{}{status, EndRevoked, time.Now().UTC().Format(time.RFC3339)}
+}
+
+// updateSession applies an update to a session and stamps its `updated_at` column.
+func (s *sqlStore) updateSession(cacheId string, set string, args ...interface{}) error {
+	args = append(args, time.Now().Format(time.RFC3339), cacheId)
+	result, err := s.exec(fmt.Sprintf(`UPDATE user_sessions SET %s, updated_at = ? WHERE cache_id = ?`, set), args...)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNoSession
+	}
+	return nil
+}
+
+// where builds the WHERE clause and arguments selecting the sessions matching the filter.
+// Text matches are case-insensitive in every dialect.
+func (f SessionFilter) where(d *dialect) (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+
+	if f.MAC != "" {
+		conditions = append(conditions, `id = ?`)
+		args = append(args, f.MAC)
+	}
+	if f.AP != "" {
+		conditions = append(conditions, `ap = ?`)
+		args = append(args, f.AP)
+	}
+	if f.Site != "" {
+		conditions = append(conditions, `site = ?`)
+		args = append(args, f.Site)
+	}
+	if f.Email != "" {
+		conditions = append(conditions, `lower(email) = lower(?)`)
+		args = append(args, f.Email)
+	}
+	if f.Name != "" {
+		conditions = append(conditions, `lower(name) LIKE lower(?) ESCAPE '\'`)
+		args = append(args, likePattern(f.Name))
+	}
+	if f.Query != "" {
+		conditions = append(conditions, `(lower(id) LIKE lower(?) ESCAPE '\' OR lower(email) LIKE lower(?) ESCAPE '\' OR lower(name) LIKE lower(?) ESCAPE '\')`)
+		pattern := likePattern(f.Query)
+		args = append(args, pattern, pattern, pattern)
+	}
+	if f.Status != "" {
+		conditions = append(conditions, `status = ?`)
+		args = append(args, f.Status)
+	}
+	if f.Active != nil {
+		if *f.Active {
+			conditions = append(conditions, `(`+d.activeCondition()+`)`)
+		} else {
+			conditions = append(conditions, `NOT (`+d.activeCondition()+`)`)
+		}
+	}
+	if f.Marketing {
+		conditions = append(conditions, `cache_id IN (SELECT cache_id FROM consents WHERE marketing = 1)`)
+	}
+	if !f.Since.IsZero() {
+		conditions = append(conditions, d.timestamp("created_at")+` >= `+d.timestamp("?"))
+		args = append(args, f.Since.UTC().Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		conditions = append(conditions, d.timestamp("created_at")+` < `+d.timestamp("?"))
+		args = append(args, f.Until.UTC().Format(time.RFC3339))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// likePattern returns a LIKE pattern matching any value containing s, with the LIKE
+// wildcards in s escaped.
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// queryCounts runs a query returning (key, count) rows and passes each row to add.
+func (s *sqlStore) queryCounts(query string, args []interface{}, add func(key string, count int)) error {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to count sessions: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key sql.NullString
+		var count int
+		if err := rows.Scan(&key, &count); err != nil {
+			return fmt.Errorf("failed to read session counts: %v", err)
+		}
+		add(key.String, count)
+	}
+	return rows.Err()
+}
+
+// scanSession reads a row of sessionColumns into a SessionRecord.
+func scanSession(row interface{ Scan(...interface{}) error }) (*SessionRecord, error) {
+	var s SessionRecord
+	var createdAt, updatedAt, expiresAt string
+	var endedAt, fields sql.NullString
+	err := row.Scan(&s.CacheID, &s.MAC, &s.AP, &s.Name, &s.Email, &s.Duration, &s.Status, &createdAt, &updatedAt,
+		&s.Voucher, &s.Sponsor, &s.IdentityIssuer, &s.IdentitySubject, &s.EmailVerified, &expiresAt, &endedAt, &s.EndReason, &s.Site, &fields)
+	if err == sql.ErrNoRows {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read session: %v", err)
+	}
+
+	s.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	s.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+	s.CreatedAt, s.UpdatedAt = s.CreatedAt.UTC(), s.UpdatedAt.UTC()
+	s.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt)
+	s.ExpiresAt = s.ExpiresAt.UTC()
+	if endedAt.Valid {
+		if t, err := time.Parse(time.RFC3339, endedAt.String); err == nil {
+			t = t.UTC()
+			s.EndedAt = &t
+		}
+	}
+	s.Fields = decodeFields(fields)
+	s.Active = s.Status != StatusRevoked && s.EndedAt == nil && time.Now().Before(s.ExpiresAt)
+	return &s, nil
+}
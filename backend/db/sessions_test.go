@@ -0,0 +1,216 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cacheIDs returns the cache IDs of sessions, sorted.
+func cacheIDs(sessions []SessionRecord) string {
+	ids := []string{}
+	for _, session := range sessions {
+		ids = append(ids, session.CacheID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestSessions(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		writes := []Session{
+			{CacheID: "s1", ID: "aa:aa:aa:aa:aa:01", AP: "ap-1", Site: "default", Name: "Ada Lovelace", Email: "ada@example.com",
+				Duration: 60, Fields: FieldValues{"company": "ACME", "visitor": true}},
+			{CacheID: "s2", ID: "aa:aa:aa:aa:aa:02", AP: "ap-2", Site: "other", Name: "Bob", Email: "bob@example.com", Duration: 60},
+			{CacheID: "s3", ID: "aa:aa:aa:aa:aa:01", AP: "ap-1", Site: "default", Name: "Ada Lovelace", Email: "ADA@example.com",
+				Duration: 120, Voucher: "1234"},
+		}
+		for _, session := range writes {
+			if err := s.WriteSession(session); err != nil {
+				t.Fatalf("write %s: %v", session.CacheID, err)
+			}
+		}
+		s.WriteFailedAttempt("f1", "aa:aa:aa:aa:aa:03", "ap-1", "Eve", "", "invalid_passphrase", "wrong passphrase")
+
+		// The second login of the same client replaces the first
+		first, err := s.GetSession("s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.Active || first.EndReason != EndReplaced || first.EndedAt == nil {
+			t.Errorf("replaced session: got active %v, end %q at %v", first.Active, first.EndReason, first.EndedAt)
+		}
+		if first.Fields["company"] != "ACME" || first.Fields["visitor"] != true || first.Site != "default" {
+			t.Errorf("replaced session: got fields %v, site %q", first.Fields, first.Site)
+		}
+		if _, err := s.GetSession("missing"); !errors.Is(err, ErrNoSession) {
+			t.Errorf("missing session: got %v, want ErrNoSession", err)
+		}
+
+		active, inactive := true, false
+		tests := []struct {
+			name   string
+			filter SessionFilter
+			want   string
+		}{
+			{"all", SessionFilter{}, "s1,s2,s3"},
+			{"site", SessionFilter{Site: "default"}, "s1,s3"},
+			{"active on site", SessionFilter{Site: "default", Active: &active}, "s3"},
+			{"ended", SessionFilter{Active: &inactive}, "s1"},
+			{"client", SessionFilter{MAC: "aa:aa:aa:aa:aa:02"}, "s2"},
+			{"access point", SessionFilter{AP: "ap-1"}, "s1,s3"},
+			{"email in another case", SessionFilter{Email: "Ada@Example.com"}, "s1,s3"},
+			{"name part", SessionFilter{Name: "LOVE"}, "s1,s3"},
+			{"query on MAC", SessionFilter{Query: "AA:02"}, "s2"},
+			{"query with wildcard", SessionFilter{Query: "%"}, ""},
+			{"status", SessionFilter{Status: StatusAuthorized}, "s1,s2,s3"},
+			{"since", SessionFilter{Since: time.Now().Add(-time.Hour)}, "s1,s2,s3"},
+			{"until", SessionFilter{Until: time.Now().Add(-time.Hour)}, ""},
+		}
+		for _, tt := range tests {
+			sessions, total, err := s.ListSessions(tt.filter)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if got := cacheIDs(sessions); got != tt.want || total != len(sessions) {
+				t.Errorf("%s: got %q (total %d), want %q", tt.name, got, total, tt.want)
+			}
+		}
+
+		page, total, err := s.ListSessions(SessionFilter{Limit: 2, Offset: 2})
+		if err != nil || len(page) != 1 || total != 3 {
+			t.Errorf("last page: got %d sessions of %d, %v", len(page), total, err)
+		}
+		var streamed []SessionRecord
+		err = s.StreamSessions(SessionFilter{Site: "default"}, func(session SessionRecord) error {
+			streamed = append(streamed, session)
+			return nil
+		})
+		if err != nil || cacheIDs(streamed) != "s1,s3" {
+			t.Errorf("stream: got %q, %v", cacheIDs(streamed), err)
+		}
+
+		stats, err := s.CountSessions(SessionFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		today := time.Now().UTC().Format("2006-01-02")
+		if stats.Total != 3 || stats.Active != 2 || stats.UniqueClients != 2 || stats.UniqueEmails != 2 || stats.FailedAttempts != 1 {
+			t.Errorf("stats: got %+v", stats)
+		}
+		if stats.ByStatus[StatusAuthorized] != 3 || stats.ByAP["ap-1"] != 2 || len(stats.ByDay) != 1 || stats.ByDay[0] != (DayCount{today, 3}) {
+			t.Errorf("stats breakdown: got %v, %v, %v", stats.ByStatus, stats.ByAP, stats.ByDay)
+		}
+	})
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		for _, session := range []Session{
+			{CacheID: "s1", ID: "aa:aa:aa:aa:aa:01", Duration: 60},
+			{CacheID: "s2", ID: "aa:aa:aa:aa:aa:02", Duration: 60},
+		} {
+			if err := s.WriteSession(session); err != nil {
+				t.Fatal(err)
+			}
+		}
+		get := func(cacheId string) *SessionRecord {
+			t.Helper()
+			session, err := s.GetSession(cacheId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return session
+		}
+
+		if err := s.EndSession("s2", EndRevoked); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.EndSession("s2", EndRevoked); !errors.Is(err, ErrNoSession) {
+			t.Errorf("ending an ended session: got %v, want ErrNoSession", err)
+		}
+		if session := get("s2"); session.Active || session.EndReason != EndRevoked {
+			t.Errorf("ended session: got %+v", session)
+		}
+
+		// An authorization cut short on the controller ends on the next reconciliation
+		past := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+		if err := s.SetSessionExpiry("s1", past); err != nil {
+			t.Fatal(err)
+		}
+		if ended, err := s.EndExpiredSessions(time.Now()); err != nil || ended != 1 {
+			t.Errorf("end expired sessions: got %d, %v", ended, err)
+		}
+		if session := get("s1"); session.EndReason != EndExpired || session.EndedAt == nil || !session.EndedAt.Equal(past) {
+			t.Errorf("expired session: got end %q at %v, want expired at %v", session.EndReason, session.EndedAt, past)
+		}
+
+		future := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		if err := s.SetSessionExtended("s1", 120, future); err != nil {
+			t.Fatal(err)
+		}
+		if session := get("s1"); !session.Active || session.Status != StatusExtended || session.Duration != 120 ||
+			!session.ExpiresAt.Equal(future) || session.EndedAt != nil {
+			t.Errorf("extended session: got %+v", session)
+		}
+
+		if err := s.SetSessionStatus("s1", StatusRevoked); err != nil {
+			t.Fatal(err)
+		}
+		if session := get("s1"); session.Active || session.EndReason != EndRevoked {
+			t.Errorf("revoked session: got %+v", session)
+		}
+		if err := s.SetSessionStatus("missing", StatusKicked); !errors.Is(err, ErrNoSession) {
+			t.Errorf("missing session: got %v, want ErrNoSession", err)
+		}
+
+		// Updates by client MAC address target its latest session
+		if err := s.ExtendSession("aa:aa:aa:aa:aa:02", "default", 90, future); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.UpdateSessionStatus("aa:aa:aa:aa:aa:02", "default", StatusKicked); err != nil {
+			t.Fatal(err)
+		}
+		if session := get("s2"); session.Status != StatusKicked || session.Duration != 90 {
+			t.Errorf("session updated by MAC: got %+v", session)
+		}
+		if err := s.UpdateSessionStatus("aa:aa:aa:aa:aa:09", "default", StatusKicked); !errors.Is(err, ErrNoSession) {
+			t.Errorf("unknown client: got %v, want ErrNoSession", err)
+		}
+	})
+}
+
+func TestUpdateLatestSession(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		// s2 was recorded after s1 in text order, but before it in time; s3 is the newest
+		// session of the client, on another site
+		for _, session := range []struct {
+			cacheId, site, createdAt string
+		}{
+			{"s1", "default", "2026-01-01T09:30:00Z"},
+			{"s2", "default", "2026-01-01T10:00:00+02:00"},
+			{"s3", "other", "2026-01-01T11:00:00Z"},
+		} {
+			if err := s.WriteSession(Session{CacheID: session.cacheId, ID: "aa:aa:aa:aa:aa:01", Site: session.site, Duration: 60}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.exec(`UPDATE user_sessions SET created_at = ? WHERE cache_id = ?`, session.createdAt, session.cacheId); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := s.UpdateSessionStatus("aa:aa:aa:aa:aa:01", "default", StatusKicked); err != nil {
+			t.Fatal(err)
+		}
+		for cacheId, want := range map[string]string{"s1": StatusKicked, "s2": StatusAuthorized, "s3": StatusAuthorized} {
+			if session, err := s.GetSession(cacheId); err != nil || session.Status != want {
+				t.Errorf("%s: got %+v, %v, want status %s", cacheId, session, err, want)
+			}
+		}
+		if err := s.UpdateSessionStatus("aa:aa:aa:aa:aa:01", "third", StatusKicked); !errors.Is(err, ErrNoSession) {
+			t.Errorf("client without a session on the site: got %v, want ErrNoSession", err)
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoSetting is returned when a setting has never been written.
+var ErrNoSetting = errors.New("setting not found")
+
+// GetSetting returns the value of a setting and the time it was written, or ErrNoSetting.
+func (s *sqlStore) GetSetting(name string) (string, time.Time, error) {
+	var value, updatedAt string
+	err := s.queryRow(`SELECT value, updated_at FROM settings WHERE name = ?`, name).Scan(&value, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", time.Time{}, ErrNoSetting
+	} else if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read setting %s: %v", name, err)
+	}
+	at, _ := time.Parse(time.RFC3339, updatedAt)
+	return value, at, nil
+}
+
+// WriteSetting stores the value of a setting, replacing the previous value.
+func (s *sqlStore) WriteSetting(name string, value string, updatedAt time.Time) error {
+	_, err := s.exec(`INSERT INTO settings (name, value, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+		name, value, updatedAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to store setting %s: %v", name, err)
+	}
+	return nil
+}
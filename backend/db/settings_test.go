@@ -0,0 +1,25 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSettings(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		if _, _, err := s.GetSetting("terms"); !errors.Is(err, ErrNoSetting) {
+			t.Errorf("missing setting: got %v, want ErrNoSetting", err)
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		for i, value := range []string{"first", "second"} {
+			if err := s.WriteSetting("terms", value, now.Add(time.Duration(i)*time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if value, at, err := s.GetSetting("terms"); err != nil || value != "second" || !at.Equal(now.Add(time.Minute)) {
+			t.Errorf("got %q at %v, %v", value, at, err)
+		}
+	})
+}
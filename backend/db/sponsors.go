@@ -0,0 +1,170 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sponsor request statuses stored in the `status` column of `sponsor_requests`.
+const (
+	SponsorPending   = "pending"   // Waiting for the host's decision.
+	SponsorApproved  = "approved"  // The host approved and the guest was authorized.
+	SponsorDenied    = "denied"    // The host denied the request.
+	SponsorWithdrawn = "withdrawn" // The guest withdrew the request, e.g. to ask another host.
+	SponsorExpired   = "expired"   // The host did not answer in time (never stored, derived on read).
+)
+
+var (
+	// ErrNoSponsorRequest is returned when no sponsor request exists for a cache ID.
+	ErrNoSponsorRequest = errors.New("no sponsor request")
+	// ErrSponsorRequestClosed is returned when a decision targets a request that was
+	// already decided or has expired.
+	ErrSponsorRequestClosed = errors.New("sponsor request already decided or expired")
+	// ErrSponsorRequestPending is returned when a new request would replace one that is
+	// still waiting for the host's decision.
+	ErrSponsorRequestPending = errors.New("sponsor request still pending")
+)
+
+// SponsorRequest represents a guest waiting for a host's approval in the `sponsor_requests` table.
+//
+// The client and AP MAC addresses are stored with the request, so the guest can still be
+// authorized after the login cache entry has been purged.
+type SponsorRequest struct {
+	CacheID   string     `json:"cacheId"`             // Cache identifier of the guest's login.
+	ID        string     `json:"mac"`                 // User or device identifier (client MAC address).
+	AP        string     `json:"ap"`                  // Access point identifier (AP MAC address).
+	Name      string     `json:"name"`                // Name entered by the guest.
+	Email     string     `json:"email"`               // Email address entered by the guest.
+	Sponsor   string     `json:"sponsor"`             // Email address of the host asked for approval.
+	Status    string     `json:"status"`              // One of the Sponsor* statuses.
+	CreatedAt time.Time  `json:"createdAt"`           // Time the request was made.
+	ExpiresAt time.Time  `json:"expiresAt"`           // Time after which the host can no longer decide.
+	DecidedAt *time.Time `json:"decidedAt,omitempty"` // Time of the host's decision, if any.
+
+	Fields  FieldValues `json:"fields,omitempty"`  // Answers to the custom form fields, recorded with the session once approved.
+	Consent *Consent    `json:"consent,omitempty"` // Consent given with the request, recorded once approved.
+}
+
+// WriteSponsorRequest stores a new pending sponsor request, replacing any earlier one for
+// the same cache ID that is no longer pending (e.g. when the guest asks another host after
+// withdrawing the request, or after it expired).
+//
+// Returns ErrSponsorRequestPending if the earlier request is still pending, so a host
+// never decides on a request other than the one they were asked about.
+func (s *sqlStore) WriteSponsorRequest(req SponsorRequest) error {
+	fields, err := encodeFields(req.Fields)
+	if err != nil {
+		return err
+	}
+	consent, err := encodeConsent(req.Consent)
+	if err != nil {
+		return err
+	}
+	createdAt := req.CreatedAt.UTC().Format(time.RFC3339)
+	result, err := s.exec(`INSERT INTO sponsor_requests
+		(cache_id, id, ap, name, email, sponsor, status, created_at, expires_at, decided_at, fields, consent)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULL, ?, ?)
+		ON CONFLICT (cache_id) DO UPDATE SET id = excluded.id, ap = excluded.ap, name = excluded.name,
+			email = excluded.email, sponsor = excluded.sponsor, status = excluded.status, created_at = excluded.created_at,
+			expires_at = excluded.expires_at, decided_at = excluded.decided_at, fields = excluded.fields, consent = excluded.consent
+		WHERE sponsor_requests.status <> ? OR sponsor_requests.expires_at <= ?`,
+		req.CacheID, req.ID, req.AP, req.Name, req.Email, req.Sponsor, SponsorPending,
+		createdAt, req.ExpiresAt.UTC().Format(time.RFC3339), fields, consent, SponsorPending, createdAt)
+	if err != nil {
+		return fmt.Errorf("failed to store sponsor request: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrSponsorRequestPending
+	}
+	return nil
+}
+
+// sponsorColumns lists the `sponsor_requests` columns read by scanSponsorRequest, in order.
+const sponsorColumns = `cache_id, id, ap, name, email, sponsor, status, created_at, expires_at, decided_at, fields, consent`
+
+// GetSponsorRequest returns the sponsor request of a cache ID, or ErrNoSponsorRequest.
+// Pending requests past their expiry are returned with the SponsorExpired status.
+func (s *sqlStore) GetSponsorRequest(cacheId string) (*SponsorRequest, error) {
+	req, err := scanSponsorRequest(s.queryRow(`SELECT `+sponsorColumns+` FROM sponsor_requests WHERE cache_id = ?`, cacheId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoSponsorRequest
+	}
+	return req, err
+}
+
+// scanSponsorRequest reads a row of sponsorColumns into a SponsorRequest, deriving the
+// SponsorExpired status.
+func scanSponsorRequest(row interface{ Scan(...interface{}) error }) (*SponsorRequest, error) {
+	var req SponsorRequest
+	var createdAt, expiresAt string
+	var decidedAt, fields, consent sql.NullString
+	err := row.Scan(&req.CacheID, &req.ID, &req.AP, &req.Name, &req.Email, &req.Sponsor, &req.Status, &createdAt, &expiresAt, &decidedAt, &fields, &consent)
+	if err == sql.ErrNoRows {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read sponsor request: %v", err)
+	}
+
+	req.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	req.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt)
+	if decidedAt.Valid {
+		if t, err := time.Parse(time.RFC3339, decidedAt.String); err == nil {
+			req.DecidedAt = &t
+		}
+	}
+	req.Fields = decodeFields(fields)
+	req.Consent = decodeConsent(consent)
+	if req.Status == SponsorPending && time.Now().After(req.ExpiresAt) {
+		req.Status = SponsorExpired
+	}
+	return &req, nil
+}
+
+// DecideSponsorRequest records the host's decision on a pending request.
+//
+// The check and the update are a single UPDATE statement, so a request opened twice
+// (e.g. approve and deny links clicked at the same time) is only decided once.
+//
+// Parameters:
+// - cacheId: Cache identifier of the request.
+// - status: SponsorApproved or SponsorDenied.
+//
+// Returns ErrSponsorRequestClosed if the request is not pending or has expired.
+func (s *sqlStore) DecideSponsorRequest(cacheId string, status string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	return s.updateSponsorRequest(`UPDATE sponsor_requests SET status = ?, decided_at = ?
+		WHERE cache_id = ? AND status = ? AND expires_at > ?`,
+		status, now, cacheId, SponsorPending, now)
+}
+
+// WithdrawSponsorRequest closes a pending request at the guest's wish, so the approve and
+// deny links sent to the host stop working and the guest can ask another host.
+//
+// Returns ErrSponsorRequestClosed if the request is not pending.
+func (s *sqlStore) WithdrawSponsorRequest(cacheId string) error {
+	return s.updateSponsorRequest(`UPDATE sponsor_requests SET status = ?, decided_at = ?
+		WHERE cache_id = ? AND status = ?`,
+		SponsorWithdrawn, time.Now().UTC().Format(time.RFC3339), cacheId, SponsorPending)
+}
+
+// ReopenSponsorRequest puts an approved request back into the pending state, for when
+// the guest could not be authorized after all and the host should be able to retry.
+func (s *sqlStore) ReopenSponsorRequest(cacheId string) error {
+	return s.updateSponsorRequest(`UPDATE sponsor_requests SET status = ?, decided_at = NULL
+		WHERE cache_id = ? AND status = ?`, SponsorPending, cacheId, SponsorApproved)
+}
+
+// updateSponsorRequest runs a single update statement against `sponsor_requests` and
+// returns ErrSponsorRequestClosed if no row was changed.
+func (s *sqlStore) updateSponsorRequest(query string, args ...interface{}) error {
+	result, err := s.exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update sponsor request: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrSponsorRequestClosed
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSponsorRequests(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		now := time.Now().UTC().Truncate(time.Second)
+		request := func(cacheId, sponsor string, expiresAt time.Time) SponsorRequest {
+			return SponsorRequest{CacheID: cacheId, ID: "aa:aa:aa:aa:aa:01", AP: "ap-1", Name: "Ada", Email: "ada@example.com",
+				Sponsor: sponsor, CreatedAt: now, ExpiresAt: expiresAt, Fields: FieldValues{"company": "ACME"}}
+		}
+		status := func(cacheId string) string {
+			t.Helper()
+			req, err := s.GetSponsorRequest(cacheId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return req.Status
+		}
+
+		if err := s.WriteSponsorRequest(request("r1", "host@example.com", now.Add(time.Hour))); err != nil {
+			t.Fatal(err)
+		}
+		req, err := s.GetSponsorRequest("r1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Status != SponsorPending || req.Sponsor != "host@example.com" || req.Fields["company"] != "ACME" ||
+			!req.CreatedAt.Equal(now) || req.DecidedAt != nil {
+			t.Errorf("pending request: got %+v", req)
+		}
+		if _, err := s.GetSponsorRequest("missing"); !errors.Is(err, ErrNoSponsorRequest) {
+			t.Errorf("missing request: got %v, want ErrNoSponsorRequest", err)
+		}
+
+		// A pending request cannot be replaced until it is withdrawn
+		if err := s.WriteSponsorRequest(request("r1", "other@example.com", now.Add(time.Hour))); !errors.Is(err, ErrSponsorRequestPending) {
+			t.Errorf("replacing a pending request: got %v, want ErrSponsorRequestPending", err)
+		}
+		if err := s.WithdrawSponsorRequest("r1"); err != nil {
+			t.Fatal(err)
+		}
+		if got := status("r1"); got != SponsorWithdrawn {
+			t.Errorf("withdrawn request: got %s", got)
+		}
+		if err := s.WithdrawSponsorRequest("r1"); !errors.Is(err, ErrSponsorRequestClosed) {
+			t.Errorf("withdrawing twice: got %v, want ErrSponsorRequestClosed", err)
+		}
+		if err := s.WriteSponsorRequest(request("r1", "other@example.com", now.Add(time.Hour))); err != nil {
+			t.Fatalf("replacing a withdrawn request: %v", err)
+		}
+		if req, _ := s.GetSponsorRequest("r1"); req.Status != SponsorPending || req.Sponsor != "other@example.com" {
+			t.Errorf("replaced request: got %+v", req)
+		}
+
+		// A request is decided once, and an approval can be reopened
+		if err := s.DecideSponsorRequest("r1", SponsorApproved); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DecideSponsorRequest("r1", SponsorDenied); !errors.Is(err, ErrSponsorRequestClosed) {
+			t.Errorf("deciding twice: got %v, want ErrSponsorRequestClosed", err)
+		}
+		if req, _ := s.GetSponsorRequest("r1"); req.Status != SponsorApproved || req.DecidedAt == nil {
+			t.Errorf("approved request: got %+v", req)
+		}
+		if err := s.ReopenSponsorRequest("r1"); err != nil {
+			t.Fatal(err)
+		}
+		if req, _ := s.GetSponsorRequest("r1"); req.Status != SponsorPending || req.DecidedAt != nil {
+			t.Errorf("reopened request: got %+v", req)
+		}
+		if err := s.ReopenSponsorRequest("r1"); !errors.Is(err, ErrSponsorRequestClosed) {
+			t.Errorf("reopening a pending request: got %v, want ErrSponsorRequestClosed", err)
+		}
+
+		// An expired request can no longer be decided, but can be replaced
+		if err := s.WriteSponsorRequest(request("r2", "host@example.com", now.Add(-time.Minute))); err != nil {
+			t.Fatal(err)
+		}
+		if got := status("r2"); got != SponsorExpired {
+			t.Errorf("expired request: got %s", got)
+		}
+		if err := s.DecideSponsorRequest("r2", SponsorApproved); !errors.Is(err, ErrSponsorRequestClosed) {
+			t.Errorf("deciding an expired request: got %v, want ErrSponsorRequestClosed", err)
+		}
+		if err := s.WriteSponsorRequest(request("r2", "host@example.com", now.Add(time.Hour))); err != nil {
+			t.Fatalf("replacing an expired request: %v", err)
+		}
+		if got := status("r2"); got != SponsorPending {
+			t.Errorf("replaced expired request: got %s", got)
+		}
+	})
+}
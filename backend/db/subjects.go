@@ -0,0 +1,258 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoSubject is returned when a data subject request names neither an email address
+// nor a MAC address.
+var ErrNoSubject = errors.New("an email address or a MAC address is required")
+
+// Subject identifies a guest in a data subject request (GDPR access or erasure). Records
+// matching the email address or the client MAC address are included.
+type Subject struct {
+	Email string `json:"email,omitempty"` // Email address, case-insensitive.
+	MAC   string `json:"mac,omitempty"`   // Client MAC address (lowercase, colon-separated).
+}
+
+// FailedAttempt represents a row of the `login_failures` table.
+type FailedAttempt struct {
+	ID        int64     `json:"id"`        // Identifier of the attempt.
+	CacheID   string    `json:"cacheId"`   // Cache identifier of the login.
+	MAC       string    `json:"mac"`       // MAC address of the guest client.
+	AP        string    `json:"ap"`        // MAC address of the access point.
+	Name      string    `json:"name"`      // Name entered by the guest.
+	Email     string    `json:"email"`     // Email address entered by the guest.
+	Code      string    `json:"code"`      // Error code returned to the guest.
+	Message   string    `json:"message"`   // Error message returned to the guest.
+	CreatedAt time.Time `json:"createdAt"` // Time of the attempt.
+}
+
+// SubjectData holds every record stored about a data subject.
+type SubjectData struct {
+	Subject            Subject             `json:"subject"`            // The subject as requested.
+	ExportedAt         time.Time           `json:"exportedAt"`         // Time of the export.
+	Sessions           []SessionRecord     `json:"sessions"`           // Guest sessions.
+	FailedAttempts     []FailedAttempt     `json:"failedAttempts"`     // Logins that could not be authorized.
+	Verifications      []EmailVerification `json:"verifications"`      // Email verification codes and attempts.
+	SponsorRequests    []SponsorRequest    `json:"sponsorRequests"`    // Requests for a host's approval.
+	AccountingSessions []AccountingSession `json:"accountingSessions"` // RADIUS accounting sessions.
+	Consents           []Consent           `json:"consents"`           // Terms of service and marketing consents.
+}
+
+// ErasureReport counts the records removed by EraseSubject.
+type ErasureReport struct {
+	Anonymize bool `json:"anonymize"` // Whether sessions, failed logins and consents were anonymised rather than deleted.
+	RecordCounts
+}
+
+// ExportSubject returns every record stored about a data subject and records the access
+// in the audit log. The audit entry leaves out the subject's identifiers (see
+// auditDetails), as the log can never be edited to erase them.
+//
+// Parameters:
+// - subject: The guest whose records to export.
+// - actor: Admin username or ActorCLI, recorded in the audit log.
+// - reference: Free-text reference of the request (e.g. a ticket number), recorded in the audit log.
+//
+// Returns ErrNoSubject if the subject is empty.
+func (s *sqlStore) ExportSubject(subject Subject, actor string, reference string) (*SubjectData, error) {
+	if subject.Email == "" && subject.MAC == "" {
+		return nil, ErrNoSubject
+	}
+	data := &SubjectData{
+		Subject:            subject,
+		ExportedAt:         time.Now().UTC().Truncate(time.Second),
+		Sessions:           []SessionRecord{},
+		FailedAttempts:     []FailedAttempt{},
+		Verifications:      []EmailVerification{},
+		SponsorRequests:    []SponsorRequest{},
+		AccountingSessions: []AccountingSession{},
+		Consents:           []Consent{},
+	}
+
+	reads := []struct {
+		query string
+		where string
+		scan  func(row interface{ Scan(...interface{}) error }) error
+	}{
+		{`SELECT ` + sessionColumns + ` FROM user_sessions`, subject.where("email", "id"), func(row interface{ Scan(...interface{}) error }) error {
+			session, err := scanSession(row)
+			if err == nil {
+				data.Sessions = append(data.Sessions, *session)
+			}
+			return err
+		}},
+		{`SELECT attempt_id, COALESCE(cache_id, ''), COALESCE(id, ''), COALESCE(ap, ''), COALESCE(name, ''),
+			COALESCE(email, ''), COALESCE(code, ''), COALESCE(message, ''), created_at FROM login_failures`,
+			subject.where("email", "id"), func(row interface{ Scan(...interface{}) error }) error {
+				var f FailedAttempt
+				var createdAt string
+				if err := row.Scan(&f.ID, &f.CacheID, &f.MAC, &f.AP, &f.Name, &f.Email, &f.Code, &f.Message, &createdAt); err != nil {
+					return err
+				}
+				f.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+				data.FailedAttempts = append(data.FailedAttempts, f)
+				return nil
+			}},
+		{`SELECT ` + verificationColumns + ` FROM email_verifications`, subject.where("email", "id"), func(row interface{ Scan(...interface{}) error }) error {
+			v, err := scanVerification(row)
+			if err == nil {
+				data.Verifications = append(data.Verifications, *v)
+			}
+			return err
+		}},
+		{`SELECT ` + sponsorColumns + ` FROM sponsor_requests`, subject.where("email", "id"), func(row interface{ Scan(...interface{}) error }) error {
+			req, err := scanSponsorRequest(row)
+			if err == nil {
+				data.SponsorRequests = append(data.SponsorRequests, *req)
+			}
+			return err
+		}},
+		{`SELECT ` + consentColumns + ` FROM consents`, subject.where("email", "id"), func(row interface{ Scan(...interface{}) error }) error {
+			c, err := scanConsent(row)
+			if err == nil {
+				data.Consents = append(data.Consents, *c)
+			}
+			return err
+		}},
+	}
+	for _, read := range reads {
+		rows, err := s.query(read.query+` WHERE `+read.where, subject.args()...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export records: %v", err)
+		}
+		for rows.Next() {
+			if err := read.scan(rows); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to export records: %v", err)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to export records: %v", err)
+		}
+	}
+
+	accounting, err := s.queryAccountingSessions(subject.where("username", "id"), subject.args()...)
+	if err != nil {
+		return nil, err
+	}
+	data.AccountingSessions = append(data.AccountingSessions, accounting...)
+
+	counts := RecordCounts{
+		Sessions:           len(data.Sessions),
+		FailedAttempts:     len(data.FailedAttempts),
+		Verifications:      len(data.Verifications),
+		SponsorRequests:    len(data.SponsorRequests),
+		AccountingSessions: len(data.AccountingSessions),
+		Consents:           len(data.Consents),
+	}
+	if err := s.WriteAuditEntry(actor, AuditSubjectAccess, subject.auditDetails(reference, counts)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// EraseSubject deletes or anonymises every record stored about a data subject, in a
+// single transaction with its audit entry.
+//
+// Parameters:
+//   - subject: The guest whose records to erase.
+//   - anonymize: Anonymise sessions, failed logins and consents (as PurgePersonalData does)
+//     instead of deleting them. Verifications, sponsor requests and accounting sessions are always deleted.
+//   - actor: Admin username or ActorCLI, recorded in the audit log.
+//   - reference: Free-text reference of the request (e.g. a ticket number), recorded in the audit log.
+//
+// Returns the number of records erased per table, or ErrNoSubject if the subject is empty.
+func (s *sqlStore) EraseSubject(subject Subject, anonymize bool, actor string, reference string) (*ErasureReport, error) {
+	if subject.Email == "" && subject.MAC == "" {
+		return nil, ErrNoSubject
+	}
+	report := &ErasureReport{Anonymize: anonymize}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	targets := []struct {
+		table     string
+		where     string
+		count     *int
+		anonymize func(tx *sql.Tx, where string, args ...interface{}) (int, error)
+	}{
+		{"user_sessions", subject.where("email", "id"), &report.Sessions, s.anonymizeSessions},
+		{"login_failures", subject.where("email", "id"), &report.FailedAttempts, s.anonymizeFailures},
+		{"email_verifications", subject.where("email", "id"), &report.Verifications, nil},
+		{"sponsor_requests", subject.where("email", "id"), &report.SponsorRequests, nil},
+		{"radius_accounting", subject.where("username", "id"), &report.AccountingSessions, nil},
+		{"consents", subject.where("email", "id"), &report.Consents, s.anonymizeConsents},
+	}
+	for _, target := range targets {
+		if anonymize && target.anonymize != nil {
+			*target.count, err = target.anonymize(tx, target.where, subject.args()...)
+		} else {
+			var result sql.Result
+			if result, err = tx.Exec(s.dialect.rebind(`DELETE FROM `+target.table+` WHERE `+target.where), subject.args()...); err == nil {
+				deleted, _ := result.RowsAffected()
+				*target.count = int(deleted)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to erase %s: %v", target.table, err)
+		}
+	}
+
+	details := subject.auditDetails(reference, report.RecordCounts)
+	details["anonymize"] = anonymize
+	if err := s.writeAuditEntry(tx, actor, AuditSubjectErasure, details); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit erasure: %v", err)
+	}
+	return report, nil
+}
+
+// where returns the condition matching the rows of the subject, given the columns holding
+// the email address and the MAC address. Use with args.
+func (sub Subject) where(emailColumn, macColumn string) string {
+	var conditions []string
+	if sub.Email != "" {
+		conditions = append(conditions, `lower(`+emailColumn+`) = lower(?)`)
+	}
+	if sub.MAC != "" {
+		conditions = append(conditions, `lower(`+macColumn+`) = lower(?)`)
+	}
+	return `(` + strings.Join(conditions, ` OR `) + `)`
+}
+
+// args returns the arguments of the condition returned by where.
+func (sub Subject) args() []interface{} {
+	var args []interface{}
+	if sub.Email != "" {
+		args = append(args, sub.Email)
+	}
+	if sub.MAC != "" {
+		args = append(args, sub.MAC)
+	}
+	return args
+}
+
+// auditDetails describes a data subject request for the audit log. Only the kinds of
+// identifiers given are recorded, not their values: even a hash of an email address or a
+// MAC address can be reversed by trying the likely values, and the log cannot be edited
+// after an erasure. The reference ties the entry to the request.
+func (sub Subject) auditDetails(reference string, counts RecordCounts) map[string]interface{} {
+	return map[string]interface{}{
+		"subject":   map[string]bool{"email": sub.Email != "", "mac": sub.MAC != ""},
+		"reference": reference,
+		"records":   counts,
+	}
+}
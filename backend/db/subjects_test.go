@@ -0,0 +1,185 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seedGuest records a login of a guest in every table holding guest data: a session,
+// a failed attempt, an email verification, a sponsor request, a RADIUS accounting
+// session and a consent.
+func seedGuest(t *testing.T, s *sqlStore, cacheId, mac, email string) {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Second)
+	name := "Guest " + cacheId
+	steps := []error{
+		s.WriteSession(Session{CacheID: cacheId, ID: mac, AP: "11:22:33:44:55:66", Name: name, Email: email, Duration: 60,
+			Sponsor: "host@example.com", Fields: FieldValues{"company": "ACME"}}),
+		s.WriteVerification(EmailVerification{CacheID: "v-" + cacheId, ID: mac, AP: "11:22:33:44:55:66", Name: name, Email: email,
+			CodeHash: "hash", CreatedAt: now, ExpiresAt: now.Add(10 * time.Minute)}),
+		s.WriteSponsorRequest(SponsorRequest{CacheID: "r-" + cacheId, ID: mac, AP: "11:22:33:44:55:66", Name: name, Email: email,
+			Sponsor: "host@example.com", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}),
+		s.WriteAccountingStart(AccountingSession{SessionID: "a-" + cacheId, CacheID: cacheId, ID: mac, AP: "11:22:33:44:55:66",
+			Username: email, StartedAt: now, ExpiresAt: now.Add(time.Hour)}),
+		s.WriteConsent(Consent{CacheID: cacheId, ID: mac, AP: "11:22:33:44:55:66", IP: "10.0.0.1", Email: email,
+			TermsVersion: "v1", Marketing: true, AcceptedAt: now}),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.WriteFailedAttempt("f-"+cacheId, mac, "11:22:33:44:55:66", name, email, "invalid_passphrase", "wrong passphrase")
+}
+
+// exportCounts exports the records of a subject and counts them per table.
+func exportCounts(t *testing.T, s *sqlStore, subject Subject) RecordCounts {
+	t.Helper()
+	data, err := s.ExportSubject(subject, "admin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return RecordCounts{
+		Sessions:           len(data.Sessions),
+		FailedAttempts:     len(data.FailedAttempts),
+		Verifications:      len(data.Verifications),
+		SponsorRequests:    len(data.SponsorRequests),
+		AccountingSessions: len(data.AccountingSessions),
+		Consents:           len(data.Consents),
+	}
+}
+
+// lastAuditEntry returns the newest entry of the audit log.
+func lastAuditEntry(t *testing.T, s *sqlStore) AuditEntry {
+	t.Helper()
+	entries, _, err := s.ListAuditEntries(1, 0)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("got %v, %v, want an audit entry", entries, err)
+	}
+	return entries[0]
+}
+
+// checkSubjectAudit checks that the audit entry of a subject request records the request
+// without the subject's identifiers.
+func checkSubjectAudit(t *testing.T, entry AuditEntry, action string, subject Subject) {
+	t.Helper()
+	if entry.Action != action || entry.Actor != "admin" {
+		t.Errorf("got audit entry %s by %s, want %s by admin", entry.Action, entry.Actor, action)
+	}
+	for _, identifier := range []string{"ada", "aa:bb:cc", "anon-"} {
+		if strings.Contains(strings.ToLower(string(entry.Details)), identifier) {
+			t.Errorf("audit details %s contain %q", entry.Details, identifier)
+		}
+	}
+	var details struct {
+		Subject   map[string]bool `json:"subject"`
+		Reference string          `json:"reference"`
+	}
+	json.Unmarshal(entry.Details, &details)
+	if details.Subject["email"] != (subject.Email != "") || details.Subject["mac"] != (subject.MAC != "") || details.Reference != "ticket-1" {
+		t.Errorf("got audit details %s", entry.Details)
+	}
+}
+
+var (
+	ada = Subject{Email: "ada@example.com", MAC: "aa:bb:cc:dd:ee:01"}
+	bob = Subject{Email: "bob@example.com", MAC: "aa:bb:cc:dd:ee:02"}
+)
+
+func TestExportSubject(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		seedGuest(t, s, "s1", ada.MAC, ada.Email)
+		seedGuest(t, s, "s2", bob.MAC, bob.Email)
+
+		one := RecordCounts{1, 1, 1, 1, 1, 1}
+		tests := []struct {
+			name    string
+			subject Subject
+			want    RecordCounts
+		}{
+			{"email in another case", Subject{Email: "ADA@Example.com"}, one},
+			{"MAC address", Subject{MAC: ada.MAC}, one},
+			{"email and MAC of different guests", Subject{Email: bob.Email, MAC: ada.MAC}, RecordCounts{2, 2, 2, 2, 2, 2}},
+			{"unknown guest", Subject{Email: "eve@example.com"}, RecordCounts{}},
+		}
+		for _, tt := range tests {
+			data, err := s.ExportSubject(tt.subject, "admin", "ticket-1")
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			got := RecordCounts{len(data.Sessions), len(data.FailedAttempts), len(data.Verifications),
+				len(data.SponsorRequests), len(data.AccountingSessions), len(data.Consents)}
+			if got != tt.want {
+				t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+			}
+			checkSubjectAudit(t, lastAuditEntry(t, s), AuditSubjectAccess, tt.subject)
+		}
+
+		if _, err := s.ExportSubject(Subject{}, "admin", ""); !errors.Is(err, ErrNoSubject) {
+			t.Errorf("empty subject: got %v, want ErrNoSubject", err)
+		}
+	})
+}
+
+func TestEraseSubject(t *testing.T) {
+	for _, anonymize := range []bool{false, true} {
+		name := "delete"
+		if anonymize {
+			name = "anonymize"
+		}
+		t.Run(name, func(t *testing.T) {
+			forEachDriver(t, func(t *testing.T, s *sqlStore) {
+				seedGuest(t, s, "s1", ada.MAC, ada.Email)
+				seedGuest(t, s, "s2", bob.MAC, bob.Email)
+
+				report, err := s.EraseSubject(Subject{Email: "ADA@example.com"}, anonymize, "admin", "ticket-1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.Anonymize != anonymize || report.RecordCounts != (RecordCounts{1, 1, 1, 1, 1, 1}) {
+					t.Errorf("got report %+v", report)
+				}
+				checkSubjectAudit(t, lastAuditEntry(t, s), AuditSubjectErasure, Subject{Email: ada.Email})
+				if result, err := s.VerifyAuditLog(); err != nil || !result.Valid {
+					t.Errorf("audit log: got %+v, %v", result, err)
+				}
+
+				// Nothing about the subject is left, and the other guest is untouched
+				if got := exportCounts(t, s, ada); got != (RecordCounts{}) {
+					t.Errorf("erased subject: got %+v", got)
+				}
+				if got := exportCounts(t, s, bob); got != (RecordCounts{1, 1, 1, 1, 1, 1}) {
+					t.Errorf("other guest: got %+v", got)
+				}
+
+				session, err := s.GetSession("s1")
+				if !anonymize {
+					if !errors.Is(err, ErrNoSession) {
+						t.Errorf("deleted session: got %+v, %v", session, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if session.MAC != "aa:bb:cc" || session.Name == "Guest s1" || session.Email == "" || session.Email == ada.Email ||
+					session.Sponsor == "host@example.com" || session.Fields != nil {
+					t.Errorf("anonymised session: got %+v", session)
+				}
+				consent, err := s.GetConsent("s1")
+				if err != nil || consent.ID != "aa:bb:cc" || consent.IP == "10.0.0.1" || consent.Email == ada.Email || consent.TermsVersion != "v1" {
+					t.Errorf("anonymised consent: got %+v, %v", consent, err)
+				}
+			})
+		})
+	}
+
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		if _, err := s.EraseSubject(Subject{}, false, "admin", ""); !errors.Is(err, ErrNoSubject) {
+			t.Errorf("empty subject: got %v, want ErrNoSubject", err)
+		}
+	})
+}
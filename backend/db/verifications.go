@@ -0,0 +1,169 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoVerification is returned when no email verification exists for a cache ID.
+var ErrNoVerification = errors.New("no email verification pending")
+
+// ErrTooManyAttempts is returned by RecordVerificationAttempt when no attempt is left.
+var ErrTooManyAttempts = errors.New("too many verification attempts")
+
+// EmailVerification represents a pending or completed email verification in the
+// `email_verifications` table. There is at most one verification per cache ID.
+type EmailVerification struct {
+	CacheID    string     `json:"cacheId"`              // Cache identifier of the login being verified.
+	ID         string     `json:"mac"`                  // User or device identifier (client MAC address).
+	AP         string     `json:"ap"`                   // Access point identifier (AP MAC address).
+	Name       string     `json:"name"`                 // Name entered by the guest.
+	Email      string     `json:"email"`                // Email address the code was sent to.
+	CodeHash   string     `json:"-"`                    // Hash of the one-time code.
+	Attempts   int        `json:"attempts"`             // Number of codes entered.
+	CreatedAt  time.Time  `json:"createdAt"`            // Time the code was sent.
+	ExpiresAt  time.Time  `json:"expiresAt"`            // Time after which the code is no longer accepted.
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"` // Time the code was entered correctly, if it has been.
+	GraceAt    *time.Time `json:"graceAt,omitempty"`    // Time a grace authorization was granted, if one was.
+
+	Fields  FieldValues `json:"fields,omitempty"`  // Answers to the custom form fields, recorded with the session once verified.
+	Consent *Consent    `json:"consent,omitempty"` // Consent given with the login, recorded once verified.
+}
+
+// WriteVerification stores a new email verification, replacing any earlier one for the
+// same cache ID (e.g. when the guest asks for a new code). The grace authorization of the
+// earlier verification is kept while its code is still pending (see ClaimGrace).
+func (s *sqlStore) WriteVerification(v EmailVerification) error {
+	fields, err := encodeFields(v.Fields)
+	if err != nil {
+		return err
+	}
+	consent, err := encodeConsent(v.Consent)
+	if err != nil {
+		return err
+	}
+	d := s.dialect
+	_, err = s.exec(`INSERT INTO email_verifications
+		(cache_id, id, ap, name, email, code_hash, attempts, created_at, expires_at, verified_at, fields, consent)
+		VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?, NULL, ?, ?)
+		ON CONFLICT (cache_id) DO UPDATE SET id = excluded.id, ap = excluded.ap, name = excluded.name,
+			email = excluded.email, code_hash = excluded.code_hash, attempts = excluded.attempts, created_at = excluded.created_at,
+			expires_at = excluded.expires_at, verified_at = excluded.verified_at, fields = excluded.fields, consent = excluded.consent,
+			grace_at = CASE WHEN email_verifications.verified_at IS NULL
+				AND `+d.timestamp("email_verifications.expires_at")+` > `+d.timestamp("excluded.created_at")+`
+				THEN email_verifications.grace_at END`,
+		v.CacheID, v.ID, v.AP, v.Name, v.Email, v.CodeHash,
+		v.CreatedAt.UTC().Format(time.RFC3339), v.ExpiresAt.UTC().Format(time.RFC3339), fields, consent)
+	if err != nil {
+		return fmt.Errorf("failed to store email verification: %v", err)
+	}
+	return nil
+}
+
+// verificationColumns lists the `email_verifications` columns read by scanVerification, in order.
+const verificationColumns = `cache_id, id, ap, name, email, code_hash, attempts, created_at, expires_at, verified_at, fields, grace_at, consent`
+
+// GetVerification returns the email verification of a cache ID, or ErrNoVerification.
+func (s *sqlStore) GetVerification(cacheId string) (*EmailVerification, error) {
+	v, err := scanVerification(s.queryRow(`SELECT `+verificationColumns+` FROM email_verifications WHERE cache_id = ?`, cacheId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoVerification
+	}
+	return v, err
+}
+
+// scanVerification reads a row of verificationColumns into an EmailVerification.
+func scanVerification(row interface{ Scan(...interface{}) error }) (*EmailVerification, error) {
+	var v EmailVerification
+	var createdAt, expiresAt string
+	var verifiedAt, fields, graceAt, consent sql.NullString
+	err := row.Scan(&v.CacheID, &v.ID, &v.AP, &v.Name, &v.Email, &v.CodeHash, &v.Attempts, &createdAt, &expiresAt, &verifiedAt, &fields, &graceAt, &consent)
+	if err == sql.ErrNoRows {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read email verification: %v", err)
+	}
+
+	v.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	v.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt)
+	if verifiedAt.Valid {
+		if t, err := time.Parse(time.RFC3339, verifiedAt.String); err == nil {
+			v.VerifiedAt = &t
+		}
+	}
+	if graceAt.Valid {
+		if t, err := time.Parse(time.RFC3339, graceAt.String); err == nil {
+			v.GraceAt = &t
+		}
+	}
+	v.Fields = decodeFields(fields)
+	v.Consent = decodeConsent(consent)
+	return &v, nil
+}
+
+// RecordVerificationAttempt counts a code entered for a cache ID, before it is compared.
+// The count is raised in a single conditional update, so concurrent requests cannot enter
+// more than maxAttempts codes between them.
+//
+// Returns the number of codes entered including this one, ErrTooManyAttempts if
+// maxAttempts codes were entered already, or ErrNoVerification.
+func (s *sqlStore) RecordVerificationAttempt(cacheId string, maxAttempts int) (int, error) {
+	var attempts int
+	err := s.queryRow(`UPDATE email_verifications SET attempts = attempts + 1
+		WHERE cache_id = ? AND attempts < ? RETURNING attempts`, cacheId, maxAttempts).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := s.GetVerification(cacheId); err != nil {
+			return 0, err
+		}
+		return 0, ErrTooManyAttempts
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to update email verification: %v", err)
+	}
+	return attempts, nil
+}
+
+// ClaimGrace records the grace authorization of a verification, if the guest may have
+// one: a device gets a single grace authorization while a code is pending, until it is
+// verified or expires.
+//
+// Parameters:
+//   - cacheId: Cache identifier of the verification.
+//   - id: Client MAC address of the guest.
+//   - now: Time of the claim.
+//
+// Returns whether the grace authorization may be granted. It is refused if it was granted
+// for the verification already, or for another pending verification of the same device.
+func (s *sqlStore) ClaimGrace(cacheId string, id string, now time.Time) (bool, error) {
+	d := s.dialect
+	at := now.UTC().Format(time.RFC3339)
+	result, err := s.exec(`UPDATE email_verifications SET grace_at = ?
+		WHERE cache_id = ? AND grace_at IS NULL AND NOT EXISTS (
+			SELECT 1 FROM email_verifications other
+			WHERE other.id = ? AND other.cache_id <> ? AND other.grace_at IS NOT NULL AND other.verified_at IS NULL
+				AND `+d.timestamp("other.expires_at")+` > `+d.timestamp("?")+`)`,
+		at, cacheId, id, cacheId, at)
+	if err != nil {
+		return false, fmt.Errorf("failed to update email verification: %v", err)
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update email verification: %v", err)
+	}
+	return claimed == 1, nil
+}
+
+// MarkVerified records that the code of a cache ID was entered correctly.
+func (s *sqlStore) MarkVerified(cacheId string) error {
+	return s.updateVerification(`UPDATE email_verifications SET verified_at = ? WHERE cache_id = ?`,
+		time.Now().UTC().Format(time.RFC3339), cacheId)
+}
+
+// updateVerification runs a single update statement against `email_verifications`.
+func (s *sqlStore) updateVerification(query string, args ...interface{}) error {
+	if _, err := s.exec(query, args...); err != nil {
+		return fmt.Errorf("failed to update email verification: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifications(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		now := time.Now().UTC().Truncate(time.Second)
+		verification := func(cacheId, id, codeHash string) EmailVerification {
+			return EmailVerification{CacheID: cacheId, ID: id, AP: "ap-1", Name: "Ada", Email: "ada@example.com",
+				CodeHash: codeHash, CreatedAt: now, ExpiresAt: now.Add(10 * time.Minute), Fields: FieldValues{"company": "ACME"}}
+		}
+		get := func(cacheId string) *EmailVerification {
+			t.Helper()
+			v, err := s.GetVerification(cacheId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+
+		if err := s.WriteVerification(verification("v1", "aa:aa:aa:aa:aa:01", "hash-1")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetVerification("missing"); !errors.Is(err, ErrNoVerification) {
+			t.Errorf("missing verification: got %v, want ErrNoVerification", err)
+		}
+
+		for want := 1; want <= 2; want++ {
+			if attempts, err := s.RecordVerificationAttempt("v1", 2); err != nil || attempts != want {
+				t.Errorf("attempt %d: got %d, %v", want, attempts, err)
+			}
+		}
+		if _, err := s.RecordVerificationAttempt("v1", 2); !errors.Is(err, ErrTooManyAttempts) {
+			t.Errorf("third attempt: got %v, want ErrTooManyAttempts", err)
+		}
+		if _, err := s.RecordVerificationAttempt("missing", 2); !errors.Is(err, ErrNoVerification) {
+			t.Errorf("missing verification: got %v, want ErrNoVerification", err)
+		}
+
+		// A device gets a single grace authorization while a code is pending
+		if claimed, err := s.ClaimGrace("v1", "aa:aa:aa:aa:aa:01", now); err != nil || !claimed {
+			t.Errorf("first claim: got %v, %v", claimed, err)
+		}
+		if claimed, err := s.ClaimGrace("v1", "aa:aa:aa:aa:aa:01", now); err != nil || claimed {
+			t.Errorf("second claim: got %v, %v", claimed, err)
+		}
+		if err := s.WriteVerification(verification("v2", "aa:aa:aa:aa:aa:01", "hash-2")); err != nil {
+			t.Fatal(err)
+		}
+		if claimed, err := s.ClaimGrace("v2", "aa:aa:aa:aa:aa:01", now); err != nil || claimed {
+			t.Errorf("claim for another login of the device: got %v, %v", claimed, err)
+		}
+
+		// A new code resets the attempts and keeps the pending grace authorization
+		if err := s.WriteVerification(verification("v1", "aa:aa:aa:aa:aa:01", "hash-3")); err != nil {
+			t.Fatal(err)
+		}
+		if v := get("v1"); v.Attempts != 0 || v.CodeHash != "hash-3" || v.GraceAt == nil || !v.GraceAt.Equal(now) || v.Fields["company"] != "ACME" {
+			t.Errorf("new code: got %+v", v)
+		}
+
+		if err := s.MarkVerified("v1"); err != nil {
+			t.Fatal(err)
+		}
+		if v := get("v1"); v.VerifiedAt == nil {
+			t.Errorf("verified: got %+v", v)
+		}
+		if claimed, err := s.ClaimGrace("v2", "aa:aa:aa:aa:aa:01", now); err != nil || !claimed {
+			t.Errorf("claim after the other login was verified: got %v, %v", claimed, err)
+		}
+
+		// Once verified, a new code no longer carries the grace authorization over
+		if err := s.WriteVerification(verification("v1", "aa:aa:aa:aa:aa:01", "hash-4")); err != nil {
+			t.Fatal(err)
+		}
+		if v := get("v1"); v.GraceAt != nil || v.VerifiedAt != nil {
+			t.Errorf("new code after verification: got %+v", v)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrVoucherInvalid is returned when a voucher does not exist, has expired, or has no uses left.
+var ErrVoucherInvalid = errors.New("voucher is invalid, expired or used up")
+
+// Voucher represents a printed access code in the `vouchers` table.
+type Voucher struct {
+	Code      string     `json:"code"`                // Access code (digits only, see voucher.Format for display).
+	Batch     string     `json:"batch"`               // Identifier of the batch the voucher was generated in.
+	Duration  int        `json:"duration"`            // Guest session duration in minutes.
+	MaxUses   int        `json:"maxUses"`             // Number of times the voucher can be redeemed.
+	Uses      int        `json:"uses"`                // Number of times the voucher has been redeemed.
+	Quota     int        `json:"quota"`               // Data quota per session in MB (0 for the default).
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // Time after which the voucher can no longer be redeemed.
+	Note      string     `json:"note"`                // Free-text note (e.g. room number or event).
+	CreatedAt time.Time  `json:"createdAt"`           // Time the voucher was generated.
+}
+
+// CreateVouchers inserts a batch of vouchers in a single transaction.
+//
+// Parameters:
+// - vouchers: The vouchers to insert. The Uses and CreatedAt fields are ignored.
+//
+// Returns an error if any voucher cannot be inserted, in which case none are.
+func (s *sqlStore) CreateVouchers(vouchers []Voucher) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	currentTime := time.Now().UTC().Format(time.RFC3339)
+	insertQuery := s.dialect.rebind(`INSERT INTO vouchers (code, batch, duration, max_uses, uses, quota, expires_at, note, created_at)
+					VALUES (?, ?, ?, ?, 0, ?, ?, ?, ?)`)
+	for _, v := range vouchers {
+		_, err := tx.Exec(insertQuery, v.Code, v.Batch, v.Duration, v.MaxUses, v.Quota, formatOptionalTime(v.ExpiresAt), v.Note, currentTime)
+		if err != nil {
+			return fmt.Errorf("failed to insert voucher: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit vouchers: %v", err)
+	}
+	return nil
+}
+
+// ListVouchers returns the vouchers of a batch, or all vouchers if batch is empty,
+// ordered by creation time and code.
+func (s *sqlStore) ListVouchers(batch string) ([]Voucher, error) {
+	rows, err := s.query(`SELECT code, batch, duration, max_uses, uses, quota, expires_at, note, created_at
+		FROM vouchers WHERE ? = '' OR batch = ? ORDER BY created_at, code`, batch, batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vouchers: %v", err)
+	}
+	defer rows.Close()
+
+	vouchers := []Voucher{}
+	for rows.Next() {
+		v, err := scanVoucher(rows)
+		if err != nil {
+			return nil, err
+		}
+		vouchers = append(vouchers, *v)
+	}
+	return vouchers, rows.Err()
+}
+
+// RedeemVoucher consumes one use of a voucher and returns it.
+//
+// The check and the increment are a single UPDATE statement, so concurrent redemptions
+// can never use a voucher more often than its MaxUses.
+//
+// Returns ErrVoucherInvalid if the voucher does not exist, has expired, or has no uses left.
+func (s *sqlStore) RedeemVoucher(code string) (*Voucher, error) {
+	result, err := s.exec(`UPDATE vouchers SET uses = uses + 1
+		WHERE code = ? AND uses < max_uses AND (expires_at IS NULL OR expires_at > ?)`,
+		code, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem voucher: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, ErrVoucherInvalid
+	}
+
+	row := s.queryRow(`SELECT code, batch, duration, max_uses, uses, quota, expires_at, note, created_at
+		FROM vouchers WHERE code = ?`, code)
+	return scanVoucher(row)
+}
+
+// ReleaseVoucher gives back a use consumed by RedeemVoucher, for when the guest could
+// not be authorized after all.
+func (s *sqlStore) ReleaseVoucher(code string) error {
+	if _, err := s.exec(`UPDATE vouchers SET uses = uses - 1 WHERE code = ? AND uses > 0`, code); err != nil {
+		return fmt.Errorf("failed to release voucher: %v", err)
+	}
+	return nil
+}
+
+// scanVoucher reads a voucher from a row selected with the column order used in this file.
+func scanVoucher(row interface{ Scan(...interface{}) error }) (*Voucher, error) {
+	var v Voucher
+	var expiresAt sql.NullString
+	var createdAt string
+	err := row.Scan(&v.Code, &v.Batch, &v.Duration, &v.MaxUses, &v.Uses, &v.Quota, &expiresAt, &v.Note, &createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read voucher: %v", err)
+	}
+
+	v.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	if expiresAt.Valid {
+		if t, err := time.Parse(time.RFC3339, expiresAt.String); err == nil {
+			v.ExpiresAt = &t
+		}
+	}
+	return &v, nil
+}
+
+// formatOptionalTime formats t as a UTC RFC3339 string, or returns nil (SQL NULL) if t is nil.
+// Timestamps compared in SQL must be stored in UTC so that string comparison orders them correctly.
+func formatOptionalTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVouchers(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, s *sqlStore) {
+		past := time.Now().Add(-time.Hour)
+		vouchers := []Voucher{
+			{Code: "1111", Batch: "a", Duration: 60, MaxUses: 1, Quota: 100, Note: "room 1"},
+			{Code: "2222", Batch: "a", Duration: 60, MaxUses: 2},
+			{Code: "3333", Batch: "b", Duration: 30, MaxUses: 1, ExpiresAt: &past},
+		}
+		if err := s.CreateVouchers(vouchers); err != nil {
+			t.Fatal(err)
+		}
+
+		// A batch with a duplicate code is rejected as a whole
+		if err := s.CreateVouchers([]Voucher{{Code: "4444", Batch: "c", MaxUses: 1}, {Code: "1111", Batch: "c", MaxUses: 1}}); err == nil {
+			t.Error("duplicate code: got no error")
+		}
+
+		tests := []struct {
+			batch string
+			want  int
+		}{
+			{"", 3},
+			{"a", 2},
+			{"b", 1},
+			{"c", 0},
+		}
+		for _, tt := range tests {
+			if list, err := s.ListVouchers(tt.batch); err != nil || len(list) != tt.want {
+				t.Errorf("batch %q: got %d vouchers, %v, want %d", tt.batch, len(list), err, tt.want)
+			}
+		}
+
+		v, err := s.RedeemVoucher("1111")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Uses != 1 || v.Duration != 60 || v.Quota != 100 || v.Note != "room 1" || v.ExpiresAt != nil || v.CreatedAt.IsZero() {
+			t.Errorf("redeemed voucher: got %+v", v)
+		}
+		for _, code := range []string{"1111", "3333", "9999"} {
+			if _, err := s.RedeemVoucher(code); !errors.Is(err, ErrVoucherInvalid) {
+				t.Errorf("redeem %s: got %v, want ErrVoucherInvalid", code, err)
+			}
+		}
+
+		// A released use can be redeemed again, but no more
+		if err := s.ReleaseVoucher("1111"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ReleaseVoucher("1111"); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := s.RedeemVoucher("1111"); err != nil || v.Uses != 1 {
+			t.Errorf("redeem after release: got %+v, %v", v, err)
+		}
+
+		// Concurrent redemptions never exceed the maximum number of uses
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		redeemed := 0
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.RedeemVoucher("2222"); err == nil {
+					mu.Lock()
+					redeemed++
+					mu.Unlock()
+				} else if !errors.Is(err, ErrVoucherInvalid) {
+					t.Errorf("concurrent redeem: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if redeemed != 2 {
+			t.Errorf("concurrent redeem: got %d uses, want 2", redeemed)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+// Package export writes guest sessions as CSV, JSON or NDJSON for use outside the portal
+// (e.g. guest lists for marketing). Sessions are streamed from the database row by row,
+// so large exports do not have to fit in memory, and every export is recorded in the
+// audit log.
+package export
+
+import (
+	"backend/db"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats.
+const (
+	FormatCSV    = "csv"    // Comma-separated values with a header row.
+	FormatJSON   = "json"   // A JSON array of sessions.
+	FormatNDJSON = "ndjson" // One JSON session per line.
+)
+
+// csvColumns lists the header of CSV exports, named after the JSON fields of db.SessionRecord.
+var csvColumns = []string{
+	"cacheId", "mac", "ap", "site", "name", "email", "duration", "status", "voucher", "sponsor",
+	"identityIssuer", "emailVerified", "createdAt", "expiresAt", "endedAt", "endReason", "fields",
+}
+
+// ValidFormat reports whether format is one of the Format* constants.
+func ValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatJSON || format == FormatNDJSON
+}
+
+// ContentType returns the MIME type of a format.
+func ContentType(format string) string {
+	switch format {
+	case FormatJSON:
+		return "application/json"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// Sessions writes the sessions matching a filter to out, oldest first, and records the
+// export in the audit log.
+//
+// Parameters:
+// - store: Shared database.
+// - filter: Selects the sessions; Limit and Offset are ignored.
+// - format: One of the Format* constants.
+// - actor: Admin username or db.ActorCLI, recorded in the audit log.
+// - out: Destination of the export.
+//
+// Returns the number of sessions written, and an error if the format is unknown or the
+// export failed part way, in which case out holds an incomplete export.
+func Sessions(store db.Storage, filter db.SessionFilter, format string, actor string, out io.Writer) (int, error) {
+	if !ValidFormat(format) {
+		return 0, fmt.Errorf("unknown export format %q", format)
+	}
+
+	var write func(db.SessionRecord) error
+	var finish func() error
+	count := 0
+	switch format {
+	case FormatCSV:
+		w := csv.NewWriter(out)
+		write = func(session db.SessionRecord) error {
+			if count == 0 {
+				if err := w.Write(csvColumns); err != nil {
+					return err
+				}
+			}
+			return w.Write(csvRecord(session))
+		}
+		finish = func() error {
+			if count == 0 {
+				w.Write(csvColumns)
+			}
+			w.Flush()
+			return w.Error()
+		}
+	case FormatJSON:
+		encoder := json.NewEncoder(out)
+		write = func(session db.SessionRecord) error {
+			separator := ",\n"
+			if count == 0 {
+				separator = "[\n"
+			}
+			if _, err := io.WriteString(out, separator); err != nil {
+				return err
+			}
+			return encoder.Encode(session)
+		}
+		finish = func() error {
+			closing := "]\n"
+			if count == 0 {
+				closing = "[]\n"
+			}
+			_, err := io.WriteString(out, closing)
+			return err
+		}
+	case FormatNDJSON:
+		encoder := json.NewEncoder(out)
+		write = func(session db.SessionRecord) error { return encoder.Encode(session) }
+		finish = func() error { return nil }
+	}
+
+	err := store.StreamSessions(filter, func(session db.SessionRecord) error {
+		if err := write(session); err != nil {
+			return fmt.Errorf("failed to write export: %v", err)
+		}
+		count++
+		return nil
+	})
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		return count, err
+	}
+
+	details := map[string]interface{}{"format": format, "sessions": count, "filter": auditFilter(filter)}
+	if err := store.WriteAuditEntry(actor, db.AuditSessionExport, details); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// csvRecord returns the CSV row of a session, in the order of csvColumns. The answers to
+// custom form fields are written as a JSON object in a single column, as the fields
+// differ between sessions when the form changes.
+func csvRecord(s db.SessionRecord) []string {
+	endedAt := ""
+	if s.EndedAt != nil {
+		endedAt = s.EndedAt.Format(time.RFC3339)
+	}
+	fields := ""
+	if len(s.Fields) > 0 {
+		encoded, _ := json.Marshal(s.Fields)
+		fields = string(encoded)
+	}
+	return []string{
+		s.CacheID, s.MAC, s.AP, csvText(s.Site), csvText(s.Name), csvText(s.Email), strconv.Itoa(s.Duration), s.Status,
+		csvText(s.Voucher), csvText(s.Sponsor), csvText(s.IdentityIssuer), strconv.FormatBool(s.EmailVerified),
+		s.CreatedAt.Format(time.RFC3339), s.ExpiresAt.Format(time.RFC3339), endedAt, s.EndReason, fields,
+	}
+}
+
+// csvText neutralises text entered by guests that spreadsheets would run as a formula
+// (a leading =, +, - or @) by prefixing it with a quote.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// auditFilter describes the filter of an export for the audit log. Filters that name a
+// guest are only recorded as present, so the log keeps no personal data.
+func auditFilter(f db.SessionFilter) map[string]interface{} {
+	details := map[string]interface{}{}
+	for key, value := range map[string]string{"site": f.Site, "ap": f.AP, "status": f.Status} {
+		if value != "" {
+			details[key] = value
+		}
+	}
+	for key, value := range map[string]string{"mac": f.MAC, "email": f.Email, "name": f.Name, "q": f.Query} {
+		if value != "" {
+			details[key] = true
+		}
+	}
+	if f.Active != nil {
+		details["active"] = *f.Active
+	}
+	if f.Marketing {
+		details["marketing"] = true
+	}
+	if !f.Since.IsZero() {
+		details["since"] = f.Since.UTC().Format(time.RFC3339)
+	}
+	if !f.Until.IsZero() {
+		details["until"] = f.Until.UTC().Format(time.RFC3339)
+	}
+	return details
+}
@@ -0,0 +1,177 @@
+package export
+
+import (
+	"backend/db"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// newStore opens an empty SQLite database.
+func newStore(t *testing.T) db.Storage {
+	t.Helper()
+	store, err := db.Open(db.DriverSQLite, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// seedSessions records two sessions whose guests typed in formulas.
+func seedSessions(t *testing.T, store db.Storage) {
+	t.Helper()
+	for _, session := range []db.Session{
+		{CacheID: "s1", ID: "aa:bb:cc:dd:ee:01", Site: "default", Name: "=HYPERLINK(\"http://evil.example\")", Email: "ada@example.com",
+			Duration: 60, Fields: db.FieldValues{"company": "+ACME"}},
+		{CacheID: "s2", ID: "aa:bb:cc:dd:ee:02", Site: "default", Name: "Bob", Email: "@bob", Sponsor: "-host@example.com", Duration: 60},
+	} {
+		if err := store.WriteSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCSVText(t *testing.T) {
+	tests := map[string]string{
+		"":              "",
+		"Ada":           "Ada",
+		"=1+1":          "'=1+1",
+		"+33 1 23":      "'+33 1 23",
+		"-2":            "'-2",
+		"@SUM(A1)":      "'@SUM(A1)",
+		"\t=1":          "'\t=1",
+		"\r=1":          "'\r=1",
+		"a=1":           "a=1",
+		"ada@example.c": "ada@example.c",
+	}
+	for value, want := range tests {
+		if got := csvText(value); got != want {
+			t.Errorf("csvText(%q): got %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestEmptyExports(t *testing.T) {
+	store := newStore(t)
+	tests := map[string]string{
+		FormatCSV:    strings.Join(csvColumns, ",") + "\n",
+		FormatJSON:   "[]\n",
+		FormatNDJSON: "",
+	}
+	for format, want := range tests {
+		var out bytes.Buffer
+		count, err := Sessions(store, db.SessionFilter{}, format, "admin", &out)
+		if err != nil || count != 0 || out.String() != want {
+			t.Errorf("%s: got %d, %v, %q, want %q", format, count, err, out.String(), want)
+		}
+	}
+	if _, err := Sessions(store, db.SessionFilter{}, "xml", "admin", &bytes.Buffer{}); err == nil {
+		t.Error("unknown format: got no error")
+	}
+}
+
+func TestCSVExport(t *testing.T) {
+	store := newStore(t)
+	seedSessions(t, store)
+
+	var out bytes.Buffer
+	if count, err := Sessions(store, db.SessionFilter{}, FormatCSV, "admin", &out); err != nil || count != 2 {
+		t.Fatalf("got %d, %v", count, err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || strings.Join(records[0], ",") != strings.Join(csvColumns, ",") {
+		t.Fatalf("got %q, want a header and 2 rows", records)
+	}
+	column := func(row []string, name string) string {
+		for i, header := range csvColumns {
+			if header == name {
+				return row[i]
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return ""
+	}
+	first, second := records[1], records[2]
+	if got := column(first, "name"); got != `'=HYPERLINK("http://evil.example")` {
+		t.Errorf("name: got %q", got)
+	}
+	if got := column(first, "fields"); got != `{"company":"+ACME"}` {
+		t.Errorf("fields: got %q, want a JSON object", got)
+	}
+	if column(second, "email") != "'@bob" || column(second, "sponsor") != "'-host@example.com" || column(second, "name") != "Bob" {
+		t.Errorf("second row: got %q", second)
+	}
+}
+
+func TestJSONExport(t *testing.T) {
+	store := newStore(t)
+	seedSessions(t, store)
+
+	for _, format := range []string{FormatJSON, FormatNDJSON} {
+		var out bytes.Buffer
+		if count, err := Sessions(store, db.SessionFilter{}, format, "admin", &out); err != nil || count != 2 {
+			t.Fatalf("%s: got %d, %v", format, count, err)
+		}
+
+		var sessions []db.SessionRecord
+		if format == FormatJSON {
+			if err := json.Unmarshal(out.Bytes(), &sessions); err != nil {
+				t.Fatalf("json: invalid array %s: %v", out.String(), err)
+			}
+		} else {
+			for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+				var session db.SessionRecord
+				if err := json.Unmarshal([]byte(line), &session); err != nil {
+					t.Fatalf("ndjson: invalid line %q: %v", line, err)
+				}
+				sessions = append(sessions, session)
+			}
+		}
+
+		// JSON is not run by spreadsheets, so the values are exported as entered
+		if len(sessions) != 2 || sessions[0].CacheID != "s1" || sessions[1].CacheID != "s2" || sessions[1].Email != "@bob" {
+			t.Errorf("%s: got %+v, want s1 then s2 as entered", format, sessions)
+		}
+	}
+}
+
+func TestExportAuditEntry(t *testing.T) {
+	store := newStore(t)
+	seedSessions(t, store)
+
+	active := true
+	filter := db.SessionFilter{Site: "default", Email: "ada@example.com", Active: &active}
+	if count, err := Sessions(store, filter, FormatNDJSON, "ada", &bytes.Buffer{}); err != nil || count != 1 {
+		t.Fatalf("got %d, %v", count, err)
+	}
+
+	entries, _, err := store.ListAuditEntries(1, 0)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("got %v, %v, want an audit entry", entries, err)
+	}
+	entry := entries[0]
+	if entry.Action != db.AuditSessionExport || entry.Actor != "ada" {
+		t.Errorf("got %s by %s", entry.Action, entry.Actor)
+	}
+	var details struct {
+		Format   string                 `json:"format"`
+		Sessions int                    `json:"sessions"`
+		Filter   map[string]interface{} `json:"filter"`
+	}
+	if err := json.Unmarshal(entry.Details, &details); err != nil {
+		t.Fatal(err)
+	}
+	if details.Format != FormatNDJSON || details.Sessions != 1 || details.Filter["site"] != "default" ||
+		details.Filter["email"] != true || details.Filter["active"] != true {
+		t.Errorf("got details %s", entry.Details)
+	}
+	if strings.Contains(string(entry.Details), "ada@example.com") {
+		t.Errorf("details %s contain the email address of the filter", entry.Details)
+	}
+}
@@ -0,0 +1,204 @@
+// Package formfields defines the custom fields of the guest form (e.g. company, phone
+// number or the person visited), configured in FORM_FIELDS. The portal page renders the
+// fields from their definitions, and the answers are validated here before a login is
+// accepted, then stored with the session.
+package formfields
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// Field types.
+const (
+	TypeText     = "text"     // Single line of text.
+	TypeTextarea = "textarea" // Multiple lines of text.
+	TypeEmail    = "email"    // Email address.
+	TypeTel      = "tel"      // Phone number.
+	TypeNumber   = "number"   // Whole or decimal number.
+	TypeSelect   = "select"   // One of a list of options.
+	TypeCheckbox = "checkbox" // Yes or no; a required checkbox must be ticked.
+)
+
+// defaultMaxLength is the length limit of text answers when a field sets none.
+const defaultMaxLength = 200
+
+// namePattern restricts field names to identifiers, so they are safe as JSON keys and
+// element IDs.
+var namePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,39}$`)
+
+// numberPattern matches the answers of number fields.
+var numberPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// Field is the definition of a custom form field.
+type Field struct {
+	Name        string   `json:"name"`                  // Key of the answer in the stored values.
+	Label       string   `json:"label"`                 // Label shown to the guest.
+	Type        string   `json:"type"`                  // One of the Type* constants (default: text).
+	Required    bool     `json:"required,omitempty"`    // Whether the guest must answer (or tick the checkbox).
+	Pattern     string   `json:"pattern,omitempty"`     // Regular expression the whole answer must match, if any.
+	Message     string   `json:"message,omitempty"`     // Error shown when the answer does not match the pattern.
+	Options     []string `json:"options,omitempty"`     // Choices of a select field.
+	Placeholder string   `json:"placeholder,omitempty"` // Hint shown in the empty input.
+	MaxLength   int      `json:"maxLength,omitempty"`   // Length limit of text answers (default: 200).
+
+	pattern *regexp.Regexp // Compiled Pattern, anchored to the whole answer.
+}
+
+// ValidationError reports an answer that does not satisfy its field.
+type ValidationError struct {
+	Field   string // Name of the field.
+	Message string // Explanation for the guest.
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("field %s: %s", e.Field, e.Message)
+}
+
+// Parse reads the JSON list of field definitions of FORM_FIELDS, e.g.
+// [{"name":"company","label":"Company","required":true}].
+//
+// Returns an error if the JSON is invalid, a name is missing, invalid or used twice, a
+// type is unknown, a pattern does not compile, or a select field has no options.
+func Parse(data string) ([]Field, error) {
+	var fields []Field
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i := range fields {
+		field := &fields[i]
+		if !namePattern.MatchString(field.Name) {
+			return nil, fmt.Errorf("field %d: name %q must start with a letter and contain only letters, digits and underscores", i+1, field.Name)
+		}
+		if seen[field.Name] {
+			return nil, fmt.Errorf("field %s is defined twice", field.Name)
+		}
+		seen[field.Name] = true
+
+		if field.Label == "" {
+			field.Label = field.Name
+		}
+		switch field.Type {
+		case "":
+			field.Type = TypeText
+		case TypeText, TypeTextarea, TypeEmail, TypeTel, TypeNumber, TypeCheckbox:
+		case TypeSelect:
+			if len(field.Options) == 0 {
+				return nil, fmt.Errorf("field %s: a select field needs options", field.Name)
+			}
+		default:
+			return nil, fmt.Errorf("field %s: unknown type %q", field.Name, field.Type)
+		}
+		if field.MaxLength < 0 {
+			return nil, fmt.Errorf("field %s: maxLength must not be negative", field.Name)
+		} else if field.MaxLength == 0 {
+			field.MaxLength = defaultMaxLength
+		}
+		if field.Pattern != "" {
+			if _, err := regexp.Compile(field.Pattern); err != nil {
+				return nil, fmt.Errorf("field %s: invalid pattern: %v", field.Name, err)
+			}
+			field.pattern = regexp.MustCompile(`^(?:` + field.Pattern + `)$`)
+		}
+	}
+	return fields, nil
+}
+
+// Validate checks the answers of a guest against the field definitions.
+//
+// Parameters:
+// - fields: Field definitions, as returned by Parse.
+// - values: Answers sent by the guest, by field name; strings, or booleans for checkboxes.
+//
+// Returns the answers to store: text trimmed, empty answers and unticked checkboxes
+// left out, and nil if there are none. Returns a *ValidationError for the first
+// answer that is missing, malformed or not defined.
+func Validate(fields []Field, values map[string]interface{}) (map[string]interface{}, error) {
+	defined := map[string]bool{}
+	answers := map[string]interface{}{}
+	for _, field := range fields {
+		defined[field.Name] = true
+		value, present := values[field.Name]
+
+		if field.Type == TypeCheckbox {
+			ticked, ok := value.(bool)
+			if present && value != nil && !ok {
+				return nil, &ValidationError{field.Name, fmt.Sprintf("%s must be ticked or left empty.", field.Label)}
+			}
+			if field.Required && !ticked {
+				return nil, &ValidationError{field.Name, fmt.Sprintf("Please tick \"%s\".", field.Label)}
+			}
+			if ticked {
+				answers[field.Name] = true
+			}
+			continue
+		}
+
+		text, ok := value.(string)
+		if present && value != nil && !ok {
+			return nil, &ValidationError{field.Name, fmt.Sprintf("%s must be text.", field.Label)}
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			if field.Required {
+				return nil, &ValidationError{field.Name, fmt.Sprintf("Please fill in %s.", field.Label)}
+			}
+			continue
+		}
+		if err := field.check(text); err != nil {
+			return nil, err
+		}
+		answers[field.Name] = text
+	}
+
+	for name := range values {
+		if !defined[name] {
+			return nil, &ValidationError{name, fmt.Sprintf("Unknown field %q.", name)}
+		}
+	}
+	if len(answers) == 0 {
+		return nil, nil
+	}
+	return answers, nil
+}
+
+// check validates a non-empty text answer against the type, length and pattern of a field.
+func (f *Field) check(text string) error {
+	invalid := func(message string) error {
+		if f.Message != "" {
+			message = f.Message
+		}
+		return &ValidationError{f.Name, message}
+	}
+
+	if len([]rune(text)) > f.MaxLength {
+		return &ValidationError{f.Name, fmt.Sprintf("%s must be at most %d characters long.", f.Label, f.MaxLength)}
+	}
+	switch f.Type {
+	case TypeEmail:
+		if address, err := mail.ParseAddress(text); err != nil || address.Address != text {
+			return invalid(fmt.Sprintf("%s must be a valid email address.", f.Label))
+		}
+	case TypeNumber:
+		if !numberPattern.MatchString(text) {
+			return invalid(fmt.Sprintf("%s must be a number.", f.Label))
+		}
+	case TypeSelect:
+		valid := false
+		for _, option := range f.Options {
+			valid = valid || option == text
+		}
+		if !valid {
+			return &ValidationError{f.Name, fmt.Sprintf("Please choose one of the options of %s.", f.Label)}
+		}
+	}
+	if f.pattern != nil && !f.pattern.MatchString(text) {
+		return invalid(fmt.Sprintf("%s is not in the expected format.", f.Label))
+	}
+	return nil
+}
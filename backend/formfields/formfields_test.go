@@ -0,0 +1,157 @@
+package formfields
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	fields, err := Parse(`[{"name":"company"},{"name":"visiting","type":"select","options":["Sales","Support"]},
+		{"name":"code","pattern":"[0-9]{4}","maxLength":10}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields[0].Type != TypeText || fields[0].Label != "company" || fields[0].MaxLength != defaultMaxLength {
+		t.Errorf("defaults: got %+v", fields[0])
+	}
+	if fields[2].pattern == nil || fields[2].MaxLength != 10 {
+		t.Errorf("pattern field: got %+v", fields[2])
+	}
+
+	invalid := map[string]string{
+		"invalid JSON":         `[{"name":}]`,
+		"missing name":         `[{"label":"Company"}]`,
+		"name with a space":    `[{"name":"my company"}]`,
+		"name with a digit":    `[{"name":"1st"}]`,
+		"name used twice":      `[{"name":"company"},{"name":"company","type":"textarea"}]`,
+		"unknown type":         `[{"name":"company","type":"date"}]`,
+		"select, no options":   `[{"name":"visiting","type":"select"}]`,
+		"broken pattern":       `[{"name":"code","pattern":"[0-9"}]`,
+		"negative max length":  `[{"name":"company","maxLength":-1}]`,
+		"not a list of fields": `{"name":"company"}`,
+	}
+	for name, data := range invalid {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+// mustParse parses field definitions, failing the test on error.
+func mustParse(t *testing.T, data string) []Field {
+	t.Helper()
+	fields, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func TestValidate(t *testing.T) {
+	fields := mustParse(t, `[
+		{"name":"company","label":"Company","required":true,"maxLength":5},
+		{"name":"code","label":"Code","pattern":"[0-9]{4}","message":"Enter the 4 digits of your badge."},
+		{"name":"visiting","label":"Visiting","type":"select","options":["Sales","Support"]},
+		{"name":"phone","label":"Phone","type":"number"},
+		{"name":"email","label":"Email","type":"email"},
+		{"name":"terms","label":"I agree","type":"checkbox","required":true},
+		{"name":"news","label":"Newsletter","type":"checkbox"}
+	]`)
+	valid := map[string]interface{}{"company": "ACME", "terms": true}
+
+	// with returns the valid answers with the given changes; a nil value removes an answer.
+	with := func(changes map[string]interface{}) map[string]interface{} {
+		values := map[string]interface{}{}
+		for name, value := range valid {
+			values[name] = value
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(values, name)
+			} else {
+				values[name] = value
+			}
+		}
+		return values
+	}
+
+	tests := []struct {
+		name      string
+		values    map[string]interface{}
+		wantField string // Field of the expected *ValidationError, or empty.
+		wantMsg   string // Part of the expected message.
+	}{
+		{"valid", with(nil), "", ""},
+		{"all answered", with(map[string]interface{}{"code": "1234", "visiting": "Sales", "phone": "-12.5",
+			"email": "ada@example.com", "news": false}), "", ""},
+		{"required missing", with(map[string]interface{}{"company": nil}), "company", "Please fill in Company."},
+		{"required blank", with(map[string]interface{}{"company": "   "}), "company", "Please fill in Company."},
+		{"text of another type", with(map[string]interface{}{"company": 42.0}), "company", "must be text"},
+		{"required checkbox unticked", with(map[string]interface{}{"terms": false}), "terms", `Please tick "I agree".`},
+		{"required checkbox missing", with(map[string]interface{}{"terms": nil}), "terms", "Please tick"},
+		{"checkbox as text", with(map[string]interface{}{"news": "yes"}), "news", "must be ticked or left empty"},
+		{"pattern matching part", with(map[string]interface{}{"code": "12345"}), "code", "Enter the 4 digits of your badge."},
+		{"pattern with a prefix", with(map[string]interface{}{"code": "x1234"}), "code", "Enter the 4 digits"},
+		{"unknown option", with(map[string]interface{}{"visiting": "sales"}), "visiting", "Please choose one of the options"},
+		{"not a number", with(map[string]interface{}{"phone": "1e3"}), "phone", "must be a number"},
+		{"email with a name", with(map[string]interface{}{"email": "Ada <ada@example.com>"}), "email", "valid email address"},
+		{"too long", with(map[string]interface{}{"company": "ACME Corp"}), "company", "at most 5 characters"},
+		{"unknown field", with(map[string]interface{}{"admin": "true"}), "admin", `Unknown field "admin".`},
+	}
+	for _, tt := range tests {
+		_, err := Validate(fields, tt.values)
+		var validation *ValidationError
+		if tt.wantField == "" {
+			if err != nil {
+				t.Errorf("%s: got %v", tt.name, err)
+			}
+			continue
+		}
+		if !errors.As(err, &validation) || validation.Field != tt.wantField || !strings.Contains(validation.Message, tt.wantMsg) {
+			t.Errorf("%s: got %v, want field %s: %q", tt.name, err, tt.wantField, tt.wantMsg)
+		}
+	}
+}
+
+func TestValidateAnswers(t *testing.T) {
+	fields := mustParse(t, `[{"name":"company"},{"name":"news","type":"checkbox"}]`)
+
+	answers, err := Validate(fields, map[string]interface{}{"company": "  ACME  ", "news": false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(answers) != "map[company:ACME]" {
+		t.Errorf("got %v, want trimmed text and no unticked checkbox", answers)
+	}
+
+	answers, err = Validate(fields, map[string]interface{}{"company": "", "news": nil})
+	if err != nil || answers != nil {
+		t.Errorf("no answers: got %v, %v, want nil", answers, err)
+	}
+}
+
+func TestValidateMaxLengthCountsRunes(t *testing.T) {
+	fields := mustParse(t, `[{"name":"city","maxLength":6}]`)
+
+	// 6 characters, 12 bytes
+	if _, err := Validate(fields, map[string]interface{}{"city": "Zürich"}); err != nil {
+		t.Errorf("6 characters: got %v", err)
+	}
+	if _, err := Validate(fields, map[string]interface{}{"city": "Ελλάδα"}); err != nil {
+		t.Errorf("6 Greek characters: got %v", err)
+	}
+	if _, err := Validate(fields, map[string]interface{}{"city": "Zürich!"}); err == nil {
+		t.Error("7 characters: got no error")
+	}
+
+	// The default limit applies when none is set
+	fields = mustParse(t, `[{"name":"company"}]`)
+	if _, err := Validate(fields, map[string]interface{}{"company": strings.Repeat("é", defaultMaxLength)}); err != nil {
+		t.Errorf("%d characters: got %v", defaultMaxLength, err)
+	}
+	if _, err := Validate(fields, map[string]interface{}{"company": strings.Repeat("é", defaultMaxLength+1)}); err == nil {
+		t.Errorf("%d characters: got no error", defaultMaxLength+1)
+	}
+}
@@ -0,0 +1,169 @@
+package ldap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// BER identifier octets used by the LDAP messages of this package (RFC 4511).
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	tagBindRequest         = 0x60
+	tagBindResponse        = 0x61
+	tagUnbindRequest       = 0x42
+	tagSearchRequest       = 0x63
+	tagSearchResultEntry   = 0x64
+	tagSearchResultDone    = 0x65
+	tagSearchResultRef     = 0x73
+	tagExtendedRequest     = 0x77
+	tagExtendedResponse    = 0x78
+	tagSimpleAuth          = 0x80 // [0] simple password of a BindRequest.
+	tagExtendedRequestName = 0x80 // [0] requestName of an ExtendedRequest.
+	tagFilterEquality      = 0xa3 // [3] equalityMatch filter.
+)
+
+// maxMessageLength is the largest LDAP message accepted from the server.
+const maxMessageLength = 1 << 20
+
+// tlv encodes a BER element with the given identifier octet and contents.
+func tlv(tag byte, contents ...[]byte) []byte {
+	var length int
+	for _, c := range contents {
+		length += len(c)
+	}
+
+	out := []byte{tag}
+	switch {
+	case length < 0x80:
+		out = append(out, byte(length))
+	case length < 0x100:
+		out = append(out, 0x81, byte(length))
+	case length < 0x10000:
+		out = append(out, 0x82, byte(length>>8), byte(length))
+	default:
+		out = append(out, 0x84, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	}
+	for _, c := range contents {
+		out = append(out, c...)
+	}
+	return out
+}
+
+// berInt encodes a non-negative integer with the given tag (INTEGER or ENUMERATED).
+func berInt(tag byte, value int) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(value))
+	// Drop leading zero octets, keeping one if the next octet has its high bit set
+	for len(b) > 1 && b[0] == 0 && b[1]&0x80 == 0 {
+		b = b[1:]
+	}
+	return tlv(tag, b)
+}
+
+// octets encodes an OCTET STRING.
+func octets(value string) []byte {
+	return tlv(tagOctetString, []byte(value))
+}
+
+// berBool encodes a BOOLEAN.
+func berBool(value bool) []byte {
+	if value {
+		return tlv(tagBoolean, []byte{0xff})
+	}
+	return tlv(tagBoolean, []byte{0x00})
+}
+
+// element is a decoded BER element.
+type element struct {
+	Tag      byte
+	Contents []byte
+}
+
+// parseElement decodes the first BER element of b and returns it with the remaining bytes.
+func parseElement(b []byte) (element, []byte, error) {
+	if len(b) < 2 {
+		return element{}, nil, fmt.Errorf("truncated BER element")
+	}
+	tag, length, offset := b[0], int(b[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return element{}, nil, fmt.Errorf("unsupported BER length")
+		}
+		length = 0
+		for _, octet := range b[2 : 2+n] {
+			length = length<<8 | int(octet)
+		}
+		offset += n
+	}
+	if length < 0 || len(b)-offset < length {
+		return element{}, nil, fmt.Errorf("truncated BER element")
+	}
+	return element{Tag: tag, Contents: b[offset : offset+length]}, b[offset+length:], nil
+}
+
+// children decodes the contents of a constructed element into its elements.
+func (e element) children() ([]element, error) {
+	var elements []element
+	for rest := e.Contents; len(rest) > 0; {
+		child, next, err := parseElement(rest)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, child)
+		rest = next
+	}
+	return elements, nil
+}
+
+// int decodes the contents of an INTEGER or ENUMERATED element.
+func (e element) int() int {
+	var value int
+	for _, octet := range e.Contents {
+		value = value<<8 | int(octet)
+	}
+	return value
+}
+
+// readElement reads a complete BER element from a stream.
+func readElement(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("unsupported BER length")
+		}
+		extra := make([]byte, n)
+		if _, err := io.ReadFull(r, extra); err != nil {
+			return nil, err
+		}
+		header = append(header, extra...)
+		length = 0
+		for _, octet := range extra {
+			length = length<<8 | int(octet)
+		}
+	}
+	if length > maxMessageLength {
+		return nil, fmt.Errorf("LDAP message too large")
+	}
+
+	message := make([]byte, len(header)+length)
+	copy(message, header)
+	if _, err := io.ReadFull(r, message[len(header):]); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
@@ -0,0 +1,132 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTLVLengths(t *testing.T) {
+	tests := []struct {
+		length int
+		header []byte
+	}{
+		{0, []byte{tagOctetString, 0x00}},
+		{0x7f, []byte{tagOctetString, 0x7f}},
+		{0x80, []byte{tagOctetString, 0x81, 0x80}},
+		{0xff, []byte{tagOctetString, 0x81, 0xff}},
+		{0x100, []byte{tagOctetString, 0x82, 0x01, 0x00}},
+		{0xffff, []byte{tagOctetString, 0x82, 0xff, 0xff}},
+		{0x10000, []byte{tagOctetString, 0x84, 0x00, 0x01, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		contents := bytes.Repeat([]byte{'x'}, tt.length)
+		encoded := tlv(tagOctetString, contents)
+		if !bytes.HasPrefix(encoded, tt.header) || len(encoded) != len(tt.header)+tt.length {
+			t.Errorf("length %d: got header %x, want %x", tt.length, encoded[:len(tt.header)], tt.header)
+			continue
+		}
+
+		e, rest, err := parseElement(append(encoded, 0xaa))
+		if err != nil || e.Tag != tagOctetString || !bytes.Equal(e.Contents, contents) || !bytes.Equal(rest, []byte{0xaa}) {
+			t.Errorf("length %d: parse got tag 0x%x, %d bytes, rest %x, %v", tt.length, e.Tag, len(e.Contents), rest, err)
+		}
+
+		read, err := readElement(bufio.NewReader(bytes.NewReader(append(encoded, 0xaa))))
+		if err != nil || !bytes.Equal(read, encoded) {
+			t.Errorf("length %d: read got %d bytes, %v", tt.length, len(read), err)
+		}
+	}
+}
+
+func TestBerInt(t *testing.T) {
+	tests := []struct {
+		value    int
+		contents []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x01}},
+		{0x7f, []byte{0x7f}},
+		{0x80, []byte{0x00, 0x80}},
+		{0xff, []byte{0x00, 0xff}},
+		{0x100, []byte{0x01, 0x00}},
+		{0x7fffffff, []byte{0x7f, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		encoded := berInt(tagInteger, tt.value)
+		if want := append([]byte{tagInteger, byte(len(tt.contents))}, tt.contents...); !bytes.Equal(encoded, want) {
+			t.Errorf("%d: got %x, want %x", tt.value, encoded, want)
+		}
+		if e, _, err := parseElement(encoded); err != nil || e.int() != tt.value {
+			t.Errorf("%d: decoded %d, %v", tt.value, e.int(), err)
+		}
+	}
+}
+
+func TestNestedSequences(t *testing.T) {
+	long := strings.Repeat("g", 300)
+	encoded := tlv(tagSequence,
+		berInt(tagInteger, 7),
+		tlv(tagSequence, octets("a"), tlv(tagSet, octets(long), octets("b"))),
+		berBool(true),
+	)
+
+	outer, rest, err := parseElement(encoded)
+	if err != nil || len(rest) != 0 || outer.Tag != tagSequence {
+		t.Fatalf("outer: got %+v, rest %x, %v", outer.Tag, rest, err)
+	}
+	fields, err := outer.children()
+	if err != nil || len(fields) != 3 {
+		t.Fatalf("outer children: got %d, %v", len(fields), err)
+	}
+	if fields[0].int() != 7 || fields[2].Tag != tagBoolean || !bytes.Equal(fields[2].Contents, []byte{0xff}) {
+		t.Errorf("outer fields: got %+v", fields)
+	}
+
+	inner, err := fields[1].children()
+	if err != nil || len(inner) != 2 || string(inner[0].Contents) != "a" || inner[1].Tag != tagSet {
+		t.Fatalf("inner: got %+v, %v", inner, err)
+	}
+	set, err := inner[1].children()
+	if err != nil || len(set) != 2 || string(set[0].Contents) != long || string(set[1].Contents) != "b" {
+		t.Errorf("set: got %d values, %v", len(set), err)
+	}
+}
+
+func TestParseElementErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":                {},
+		"no length":            {tagSequence},
+		"short contents":       {tagOctetString, 0x03, 'a', 'b'},
+		"short long-form":      {tagOctetString, 0x82, 0x01},
+		"indefinite length":    {tagSequence, 0x80, 0x00, 0x00},
+		"five length octets":   {tagOctetString, 0x85, 0, 0, 0, 0, 1, 'a'},
+		"long-form past input": {tagOctetString, 0x81, 0x05, 'a'},
+	}
+	for name, b := range tests {
+		if _, _, err := parseElement(b); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+
+	nested := element{Tag: tagSequence, Contents: []byte{tagOctetString, 0x05, 'a'}}
+	if _, err := nested.children(); err == nil {
+		t.Error("truncated child: got no error")
+	}
+}
+
+func TestReadElementErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"truncated header":   {tagSequence},
+		"truncated length":   {tagSequence, 0x82, 0x01},
+		"truncated contents": {tagSequence, 0x05, 0x01},
+		"indefinite length":  {tagSequence, 0x80},
+		"too large":          {tagSequence, 0x84, 0x7f, 0xff, 0xff, 0xff},
+	}
+	for name, b := range tests {
+		if _, err := readElement(bufio.NewReader(bytes.NewReader(b))); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Package ldap authenticates users against an LDAP directory or Active Directory with
+// simple binds, and reads the groups they are a member of.
+//
+// Only the small part of LDAPv3 (RFC 4511) needed for this is implemented: simple bind,
+// a single equality search and StartTLS.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LDAP result codes handled by this package.
+const (
+	resultSuccess            = 0
+	resultInvalidCredentials = 49
+)
+
+// startTLSOID is the request name of the StartTLS extended operation (RFC 4511 section 4.14).
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// ErrInvalidCredentials is returned when the username is unknown or the password is wrong.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// User holds the directory attributes of an authenticated user.
+type User struct {
+	DN     string   // Distinguished name of the user entry.
+	Name   string   // Display name (displayName or cn).
+	Email  string   // Email address (mail).
+	Groups []string // Distinguished names of the groups the user is a member of (memberOf).
+}
+
+// Directory authenticates users against an LDAP server.
+type Directory struct {
+	serverURL     *url.URL
+	startTLS      bool
+	tlsConfig     *tls.Config
+	bindDN        string
+	bindPassword  string
+	baseDN        string
+	userAttribute string
+	timeout       time.Duration
+}
+
+// NewDirectory creates a Directory.
+//
+// Parameters:
+//   - serverURL: "ldap://host[:port]" or "ldaps://host[:port]".
+//   - startTLS: Whether to upgrade ldap:// connections with StartTLS.
+//   - skipVerify: Whether to skip verification of the server certificate.
+//   - bindDN, bindPassword: Service account used to look up users, or empty for anonymous lookups.
+//   - baseDN: Base of the user search.
+//   - userAttribute: Attribute holding the username (e.g. "uid", or "sAMAccountName" for Active Directory).
+//
+// Returns an error if the URL is not an LDAP URL.
+func NewDirectory(serverURL string, startTLS, skipVerify bool, bindDN, bindPassword, baseDN, userAttribute string) (*Directory, error) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil || (parsed.Scheme != "ldap" && parsed.Scheme != "ldaps") || parsed.Hostname() == "" {
+		return nil, fmt.Errorf("invalid LDAP URL %q (expected ldap://host or ldaps://host)", serverURL)
+	}
+	return &Directory{
+		serverURL:     parsed,
+		startTLS:      startTLS,
+		tlsConfig:     &tls.Config{ServerName: parsed.Hostname(), InsecureSkipVerify: skipVerify},
+		bindDN:        bindDN,
+		bindPassword:  bindPassword,
+		baseDN:        baseDN,
+		userAttribute: userAttribute,
+		timeout:       10 * time.Second,
+	}, nil
+}
+
+// Authenticate checks a user's password and returns the user's directory attributes.
+//
+// The user entry is looked up with the service account, then a bind as the user checks
+// the password. Empty passwords are always rejected, as LDAP servers treat a simple bind
+// without password as an anonymous bind that succeeds.
+//
+// Returns ErrInvalidCredentials if the user does not exist, is ambiguous or the password
+// is wrong, and another error if the server cannot be reached.
+func (d *Directory) Authenticate(username, password string) (*User, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	c, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	if err := c.bind(d.bindDN, d.bindPassword); err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			return nil, fmt.Errorf("LDAP service account bind failed: %v", err)
+		}
+		return nil, err
+	}
+
+	user, err := c.findUser(d.baseDN, d.userAttribute, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.bind(user.DN, password); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// conn is an open connection to the LDAP server.
+type conn struct {
+	net       net.Conn
+	reader    *bufio.Reader
+	messageID int
+	timeout   time.Duration
+}
+
+// dial connects to the server, using TLS for ldaps:// and, if configured, StartTLS for ldap://.
+func (d *Directory) dial() (*conn, error) {
+	host := d.serverURL.Host
+	if d.serverURL.Port() == "" {
+		port := "389"
+		if d.serverURL.Scheme == "ldaps" {
+			port = "636"
+		}
+		host = net.JoinHostPort(d.serverURL.Hostname(), port)
+	}
+
+	dialer := &net.Dialer{Timeout: d.timeout}
+	var netConn net.Conn
+	var err error
+	if d.serverURL.Scheme == "ldaps" {
+		netConn, err = tls.DialWithDialer(dialer, "tcp", host, d.tlsConfig)
+	} else {
+		netConn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %v", err)
+	}
+
+	c := &conn{net: netConn, reader: bufio.NewReader(netConn), timeout: d.timeout}
+	if d.serverURL.Scheme == "ldap" && d.startTLS {
+		if err := c.startTLS(d.tlsConfig); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// startTLS upgrades the connection to TLS.
+func (c *conn) startTLS(config *tls.Config) error {
+	op, err := c.roundTrip(tlv(tagExtendedRequest, tlv(tagExtendedRequestName, []byte(startTLSOID))), tagExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := checkResult(op); err != nil {
+		return fmt.Errorf("LDAP StartTLS failed: %v", err)
+	}
+
+	tlsConn := tls.Client(c.net, config)
+	tlsConn.SetDeadline(time.Now().Add(c.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("LDAP StartTLS handshake failed: %v", err)
+	}
+	c.net = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// bind performs a simple bind. An empty DN performs an anonymous bind.
+func (c *conn) bind(dn, password string) error {
+	op, err := c.roundTrip(tlv(tagBindRequest, berInt(tagInteger, 3), octets(dn), tlv(tagSimpleAuth, []byte(password))), tagBindResponse)
+	if err != nil {
+		return err
+	}
+	return checkResult(op)
+}
+
+// findUser searches the entry whose userAttribute equals username below baseDN.
+func (c *conn) findUser(baseDN, userAttribute, username string) (*User, error) {
+	request := tlv(tagSearchRequest,
+		octets(baseDN),
+		berInt(tagEnumerated, 2), // wholeSubtree
+		berInt(tagEnumerated, 0), // neverDerefAliases
+		berInt(tagInteger, 2),    // sizeLimit: two entries are enough to detect ambiguous usernames
+		berInt(tagInteger, int(c.timeout/time.Second)),
+		berBool(false),
+		tlv(tagFilterEquality, octets(userAttribute), octets(username)),
+		tlv(tagSequence, octets("displayName"), octets("cn"), octets("mail"), octets("memberOf")),
+	)
+	if err := c.send(request); err != nil {
+		return nil, err
+	}
+
+	var users []*User
+	for {
+		op, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch op.Tag {
+		case tagSearchResultEntry:
+			user, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			users = append(users, user)
+		case tagSearchResultRef:
+			// Referrals to other servers are not followed
+		case tagSearchResultDone:
+			// A size limit error still reports that more than one entry matched
+			if err := checkResult(op); err != nil && len(users) < 2 {
+				return nil, fmt.Errorf("LDAP search failed: %v", err)
+			}
+			if len(users) != 1 {
+				return nil, ErrInvalidCredentials
+			}
+			return users[0], nil
+		default:
+			return nil, fmt.Errorf("unexpected LDAP response 0x%x", op.Tag)
+		}
+	}
+}
+
+// parseEntry decodes a SearchResultEntry into a User.
+func parseEntry(op element) (*User, error) {
+	fields, err := op.children()
+	if err != nil || len(fields) != 2 {
+		return nil, fmt.Errorf("malformed LDAP search entry")
+	}
+	attributes, err := fields[1].children()
+	if err != nil {
+		return nil, fmt.Errorf("malformed LDAP search entry")
+	}
+
+	user := &User{DN: string(fields[0].Contents)}
+	var cn string
+	for _, attribute := range attributes {
+		parts, err := attribute.children()
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("malformed LDAP attribute")
+		}
+		values, err := parts[1].children()
+		if err != nil {
+			return nil, fmt.Errorf("malformed LDAP attribute")
+		}
+
+		for _, value := range values {
+			switch strings.ToLower(string(parts[0].Contents)) {
+			case "displayname":
+				user.Name = string(value.Contents)
+			case "cn":
+				cn = string(value.Contents)
+			case "mail":
+				user.Email = string(value.Contents)
+			case "memberof":
+				user.Groups = append(user.Groups, string(value.Contents))
+			}
+		}
+	}
+	if user.Name == "" {
+		user.Name = cn
+	}
+	return user, nil
+}
+
+// roundTrip sends a request and returns the protocol operation of the response, which
+// must have the expected tag.
+func (c *conn) roundTrip(request []byte, expected byte) (element, error) {
+	if err := c.send(request); err != nil {
+		return element{}, err
+	}
+	op, err := c.receive()
+	if err != nil {
+		return element{}, err
+	}
+	if op.Tag != expected {
+		return element{}, fmt.Errorf("unexpected LDAP response 0x%x", op.Tag)
+	}
+	return op, nil
+}
+
+// send wraps a protocol operation in an LDAPMessage with the next message ID and writes it.
+func (c *conn) send(op []byte) error {
+	c.messageID++
+	c.net.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.net.Write(tlv(tagSequence, berInt(tagInteger, c.messageID), op)); err != nil {
+		return fmt.Errorf("failed to send LDAP request: %v", err)
+	}
+	return nil
+}
+
+// receive reads the next LDAPMessage answering the current request and returns its
+// protocol operation.
+func (c *conn) receive() (element, error) {
+	for {
+		raw, err := readElement(c.reader)
+		if err != nil {
+			return element{}, fmt.Errorf("failed to read LDAP response: %v", err)
+		}
+		message, _, err := parseElement(raw)
+		if err != nil {
+			return element{}, err
+		}
+		fields, err := message.children()
+		if err != nil || len(fields) < 2 {
+			return element{}, fmt.Errorf("malformed LDAP response")
+		}
+		// Skip unsolicited notifications (message ID 0)
+		if fields[0].int() != c.messageID {
+			continue
+		}
+		return fields[1], nil
+	}
+}
+
+// close unbinds and closes the connection.
+func (c *conn) close() {
+	c.send(tlv(tagUnbindRequest))
+	c.net.Close()
+}
+
+// checkResult decodes the LDAPResult of a response. It returns ErrInvalidCredentials for
+// the invalidCredentials result code and a descriptive error for other failures.
+func checkResult(op element) error {
+	fields, err := op.children()
+	if err != nil || len(fields) < 3 {
+		return fmt.Errorf("malformed LDAP result")
+	}
+	switch code := fields[0].int(); code {
+	case resultSuccess:
+		return nil
+	case resultInvalidCredentials:
+		return ErrInvalidCredentials
+	default:
+		return fmt.Errorf("LDAP error %d: %s", code, fields[2].Contents)
+	}
+}
@@ -0,0 +1,248 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testBaseDN     = "ou=people,dc=example,dc=com"
+	testServiceDN  = "cn=portal,dc=example,dc=com"
+	testServicePwd = "service secret"
+)
+
+// fakeEntry is a user entry of the fake directory.
+type fakeEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// fakeServer is an LDAP server on a local TCP port that answers simple binds and
+// equality searches from a fixed set of entries.
+type fakeServer struct {
+	listener net.Listener
+	entries  []fakeEntry
+
+	mu    sync.Mutex
+	conns int      // Number of connections accepted.
+	binds []string // DNs of the successful binds.
+}
+
+// newFakeServer starts a fake server for the duration of a test.
+func newFakeServer(t *testing.T, entries ...fakeEntry) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeServer{listener: listener, entries: entries}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// directory returns a Directory using the fake server with the given service account password.
+func (s *fakeServer) directory(t *testing.T, servicePassword string) *Directory {
+	t.Helper()
+	d, err := NewDirectory("ldap://"+s.listener.Addr().String(), false, false, testServiceDN, servicePassword, testBaseDN, "uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// stats returns the number of connections and the DNs bound so far.
+func (s *fakeServer) stats() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, append([]string{}, s.binds...)
+}
+
+// serve answers the requests of a connection until it is unbound or closed.
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		raw, err := readElement(reader)
+		if err != nil {
+			return
+		}
+		message, _, err := parseElement(raw)
+		if err != nil {
+			return
+		}
+		fields, err := message.children()
+		if err != nil || len(fields) < 2 {
+			return
+		}
+		id, op := fields[0].int(), fields[1]
+		reply := func(ops ...[]byte) {
+			for _, op := range ops {
+				conn.Write(tlv(tagSequence, berInt(tagInteger, id), op))
+			}
+		}
+
+		switch op.Tag {
+		case tagBindRequest:
+			parts, _ := op.children()
+			dn, password := string(parts[1].Contents), string(parts[2].Contents)
+			reply(result(tagBindResponse, s.bind(dn, password), ""))
+		case tagSearchRequest:
+			parts, _ := op.children()
+			filter, _ := parts[6].children()
+			ops := s.search(string(filter[0].Contents), string(filter[1].Contents))
+			// Unsolicited notifications carry message ID 0 and must be skipped
+			conn.Write(tlv(tagSequence, berInt(tagInteger, 0), result(tagExtendedResponse, resultSuccess, "")))
+			reply(ops...)
+		case tagUnbindRequest:
+			return
+		default:
+			reply(result(tagExtendedResponse, 2, "unsupported operation"))
+		}
+	}
+}
+
+// bind checks a simple bind and returns its result code.
+func (s *fakeServer) bind(dn, password string) int {
+	valid := dn == testServiceDN && password == testServicePwd
+	for _, entry := range s.entries {
+		valid = valid || (dn == entry.dn && password == entry.password)
+	}
+	if !valid {
+		return resultInvalidCredentials
+	}
+	s.mu.Lock()
+	s.binds = append(s.binds, dn)
+	s.mu.Unlock()
+	return resultSuccess
+}
+
+// search returns the entries whose attribute equals value, followed by the result.
+func (s *fakeServer) search(attribute, value string) [][]byte {
+	var ops [][]byte
+	for _, entry := range s.entries {
+		if !contains(entry.attributes[attribute], value) {
+			continue
+		}
+		var attributes [][]byte
+		for name, values := range entry.attributes {
+			var set [][]byte
+			for _, v := range values {
+				set = append(set, octets(v))
+			}
+			attributes = append(attributes, tlv(tagSequence, octets(name), tlv(tagSet, set...)))
+		}
+		ops = append(ops, tlv(tagSearchResultEntry, octets(entry.dn), tlv(tagSequence, attributes...)))
+	}
+	return append(ops, result(tagSearchResultDone, resultSuccess, ""))
+}
+
+// result encodes an LDAPResult with the given tag.
+func result(tag byte, code int, message string) []byte {
+	return tlv(tag, berInt(tagEnumerated, code), octets(""), octets(message))
+}
+
+// contains reports whether values contains value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// testEntries returns the entries of the fake directory: "ada" with many groups, so the
+// entry needs long-form lengths, and "sam", which two entries share.
+func testEntries() []fakeEntry {
+	var groups []string
+	for i := 0; i < 20; i++ {
+		groups = append(groups, fmt.Sprintf("cn=group-%d,ou=groups,dc=example,dc=com", i))
+	}
+	return []fakeEntry{
+		{"uid=ada," + testBaseDN, "ada secret", map[string][]string{
+			"uid": {"ada"}, "cn": {"Ada L."}, "displayName": {"Ada Lovelace"}, "mail": {"ada@example.com"}, "memberOf": groups,
+		}},
+		{"uid=sam,ou=staff," + testBaseDN, "sam secret", map[string][]string{"uid": {"sam"}, "cn": {"Sam One"}}},
+		{"uid=sam,ou=guests," + testBaseDN, "sam secret", map[string][]string{"uid": {"sam"}, "cn": {"Sam Two"}}},
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newFakeServer(t, testEntries()...)
+
+	user, err := s.directory(t, testServicePwd).Authenticate("ada", "ada secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.DN != "uid=ada,"+testBaseDN || user.Name != "Ada Lovelace" || user.Email != "ada@example.com" {
+		t.Errorf("user: got %+v", user)
+	}
+	if len(user.Groups) != 20 || user.Groups[19] != "cn=group-19,ou=groups,dc=example,dc=com" {
+		t.Errorf("groups: got %v", user.Groups)
+	}
+	if _, binds := s.stats(); strings.Join(binds, ";") != testServiceDN+";uid=ada,"+testBaseDN {
+		t.Errorf("binds: got %v, want the service account then the user", binds)
+	}
+}
+
+func TestAuthenticateRejections(t *testing.T) {
+	tests := []struct {
+		name      string
+		username  string
+		password  string
+		wantConns int
+	}{
+		{"wrong password", "ada", "wrong", 1},
+		{"no match", "bob", "bob secret", 1},
+		{"multiple matches", "sam", "sam secret", 1},
+		{"empty password", "ada", "", 0},
+		{"empty username", "", "ada secret", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, testEntries()...)
+
+			_, err := s.directory(t, testServicePwd).Authenticate(tt.username, tt.password)
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("got %v, want ErrInvalidCredentials", err)
+			}
+			conns, binds := s.stats()
+			if conns != tt.wantConns {
+				t.Errorf("connections: got %d, want %d", conns, tt.wantConns)
+			}
+			for _, dn := range binds {
+				if dn != testServiceDN {
+					t.Errorf("bound as %s", dn)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticateServiceAccountFailure(t *testing.T) {
+	s := newFakeServer(t, testEntries()...)
+
+	_, err := s.directory(t, "wrong").Authenticate("ada", "ada secret")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) || !strings.Contains(err.Error(), "service account") {
+		t.Errorf("got %v, want a service account error", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Package lockout locks out keys, such as admin usernames or guest clients, after repeated
+// failed attempts. It is shared by the admin login and the passphrase mode.
+package lockout
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LockedOutError is returned by Limiter.Check and Limiter.Fail while a key is locked out.
+type LockedOutError struct {
+	Until time.Time // Time at which the key may be tried again.
+}
+
+// Error implements the error interface.
+func (e *LockedOutError) Error() string {
+	return fmt.Sprintf("too many failed attempts, locked out until %s", e.Until.Format(time.RFC3339))
+}
+
+// Limiter counts the failed attempts per key and locks a key out once it reaches the
+// limit. Attempts older than the lockout duration are forgotten. It is safe for
+// concurrent use.
+type Limiter struct {
+	maxAttempts int           // Failed attempts allowed before a key is locked out.
+	lockout     time.Duration // How long a key stays locked out.
+
+	mu       sync.Mutex
+	failures map[string]*failure
+}
+
+// failure tracks the failed attempts of a single key.
+type failure struct {
+	count       int       // Failed attempts since the last success or lockout.
+	last        time.Time // Time of the most recent failed attempt.
+	lockedUntil time.Time // End of the current lockout, if any.
+}
+
+// New creates a Limiter.
+//
+// Parameters:
+// - maxAttempts: Failed attempts allowed per key before it is locked out.
+// - lockout: How long a key stays locked out.
+func New(maxAttempts int, lockout time.Duration) *Limiter {
+	return &Limiter{maxAttempts: maxAttempts, lockout: lockout, failures: make(map[string]*failure)}
+}
+
+// Check returns a *LockedOutError if the key is locked out.
+func (l *Limiter) Check(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f := l.failures[key]; f != nil && time.Now().Before(f.lockedUntil) {
+		return &LockedOutError{Until: f.lockedUntil}
+	}
+	return nil
+}
+
+// Fail records a failed attempt for a key and returns a *LockedOutError if the key is
+// now locked out.
+func (l *Limiter) Fail(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.purge(now)
+
+	f := l.failures[key]
+	if f == nil || now.Sub(f.last) > l.lockout {
+		f = &failure{}
+		l.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= l.maxAttempts {
+		f.count = 0
+		f.lockedUntil = now.Add(l.lockout)
+		return &LockedOutError{Until: f.lockedUntil}
+	}
+	return nil
+}
+
+// Succeed clears the failed attempts recorded for a key.
+func (l *Limiter) Succeed(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// purge forgets keys whose last failed attempt and lockout are both older than the
+// lockout duration. The caller must hold l.mu.
+func (l *Limiter) purge(now time.Time) {
+	for key, f := range l.failures {
+		if now.Sub(f.last) > l.lockout && now.After(f.lockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+}
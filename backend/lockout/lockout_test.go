@@ -0,0 +1,53 @@
+package lockout
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(3, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if err := l.Fail("ada"); err != nil {
+			t.Fatalf("attempt %d: got %v", i+1, err)
+		}
+	}
+	var lockedOut *LockedOutError
+	if err := l.Fail("ada"); !errors.As(err, &lockedOut) || time.Until(lockedOut.Until) <= 0 {
+		t.Fatalf("third attempt: got %v, want a lockout", err)
+	}
+	if err := l.Check("ada"); !errors.As(err, &lockedOut) {
+		t.Errorf("check while locked out: got %v", err)
+	}
+	if err := l.Check("bob"); err != nil {
+		t.Errorf("other key: got %v", err)
+	}
+}
+
+func TestLimiterSucceed(t *testing.T) {
+	l := New(2, time.Minute)
+	l.Fail("ada")
+	l.Succeed("ada")
+	if err := l.Fail("ada"); err != nil {
+		t.Errorf("attempt after a success: got %v, want the count restarted", err)
+	}
+}
+
+func TestLimiterForgetsOldAttempts(t *testing.T) {
+	l := New(2, 50*time.Millisecond)
+	l.Fail("ada")
+	time.Sleep(60 * time.Millisecond)
+	if err := l.Fail("ada"); err != nil {
+		t.Errorf("attempt after the lockout duration: got %v, want the count restarted", err)
+	}
+
+	if err := l.Fail("ada"); err == nil {
+		t.Fatal("second recent attempt: got no lockout")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := l.Check("ada"); err != nil {
+		t.Errorf("after the lockout: got %v", err)
+	}
+}
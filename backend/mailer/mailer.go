@@ -0,0 +1,142 @@
+// Package mailer sends plain-text emails through a configurable SMTP server.
+// It is used for email verification codes and sponsor notifications.
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Connection security modes for the SMTP server.
+const (
+	SecurityStartTLS = "starttls" // Plain connection upgraded with STARTTLS (usually port 587).
+	SecurityTLS      = "tls"      // Implicit TLS from the start (usually port 465).
+	SecurityNone     = "none"     // No encryption, e.g. a local SMTP sink for testing.
+)
+
+// Mailer sends emails through a single SMTP server.
+type Mailer struct {
+	host     string // Host name of the SMTP server.
+	port     int    // Port of the SMTP server.
+	username string // Username for SMTP authentication, or empty for none.
+	password string // Password for SMTP authentication.
+	from     string // Sender address of all emails.
+	security string // Connection security mode (one of the Security* constants).
+}
+
+// New creates a Mailer.
+//
+// Parameters:
+//   - host: The host name of the SMTP server.
+//   - port: The port of the SMTP server.
+//   - username: The username for SMTP authentication, or empty to send without authentication.
+//   - password: The password for SMTP authentication.
+//   - from: The sender address of all emails.
+//   - security: The connection security mode (one of the Security* constants).
+func New(host string, port int, username, password, from, security string) *Mailer {
+	return &Mailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+		security: security,
+	}
+}
+
+// Send delivers a plain-text email to a single recipient.
+//
+// Parameters:
+//   - to: The recipient address.
+//   - subject: The subject line.
+//   - body: The plain-text body.
+//
+// Returns:
+//   - error: An error if the message could not be handed to the SMTP server, otherwise nil.
+func (m *Mailer) Send(to, subject, body string) error {
+	// Header values must not contain line breaks, or they could inject extra headers
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid email header value")
+	}
+
+	client, err := m.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if m.security == SecurityStartTLS {
+		if err := client.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+	if m.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("failed to authenticate with SMTP server: %v", err)
+		}
+	}
+
+	if err := client.Mail(m.from); err != nil {
+		return fmt.Errorf("failed to set sender: %v", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("failed to set recipient: %v", err)
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %v", err)
+	}
+	if _, err := writer.Write(m.compose(to, subject, body)); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+
+	return client.Quit()
+}
+
+// dial connects to the SMTP server, using implicit TLS if configured.
+func (m *Mailer) dial() (*smtp.Client, error) {
+	address := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if m.security == SecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: m.host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start SMTP session: %v", err)
+	}
+	return client, nil
+}
+
+// compose builds the RFC 5322 message with headers and a CRLF-normalised body.
+func (m *Mailer) compose(to, subject, body string) []byte {
+	var msg strings.Builder
+	msg.WriteString("From: " + m.from + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(msg.String())
+}
@@ -1,16 +1,31 @@
 // Package main serves as the entry point for the Unifi Guest Portal application.
 // It initializes the cache purge routine, loads environment configuration, and starts the HTTP server.
+// When started with arguments, it runs a maintenance command instead (see package cli).
 package main
 
 import (
 	"backend/cache"
+	"backend/cli"
 	"backend/config"
+	"backend/db"
+	"backend/retention"
 	"backend/router"
+	"fmt"
 	"log"
+	"os"
 	"time"
 )
 
 func main() {
+	// Run a maintenance command (e.g. `app admin create <username>`) instead of the server
+	if len(os.Args) > 1 {
+		if err := cli.Run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Start a goroutine to periodically purge expired cache entries.
 	// The cache is purged every 30 seconds to maintain optimal performance.
 	go cache.PurgeCacheEvery(30 * time.Second)
@@ -23,6 +38,16 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Open the database once; it is shared by all requests and migrated to the latest schema.
+	store, err := db.Open(cfg.DBDriver, cfg.DBSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	// Purge guest data past the retention period every hour (if RETENTION_DAYS is set).
+	go retention.PurgeEvery(store, cfg.Retention, time.Hour)
+
 	// Set up and start the HTTP server using the loaded configuration.
-	router.SetupServer(cfg)
+	router.SetupServer(cfg, store)
 }
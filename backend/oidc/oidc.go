@@ -0,0 +1,266 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE used to
+// sign guests in with an external identity provider (Google, Microsoft Entra ID,
+// Keycloak, ...).
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// knownWalledGardens lists the hosts, besides the authorization endpoint, that the sign-in
+// pages of common providers load before the guest is authorized.
+var knownWalledGardens = map[string][]string{
+	"accounts.google.com": {
+		"accounts.google.com", "accounts.youtube.com", "ssl.gstatic.com", "www.gstatic.com",
+		"fonts.gstatic.com", "fonts.googleapis.com", "apis.google.com",
+	},
+	"login.microsoftonline.com": {
+		"login.microsoftonline.com", "login.live.com", "aadcdn.msauth.net", "aadcdn.msftauth.net",
+		"logincdn.msauth.net", "login.windows.net",
+	},
+}
+
+// Claims holds the verified identity claims of an ID token.
+type Claims struct {
+	Issuer        string `json:"iss"`            // Identifier of the provider that issued the token.
+	Subject       string `json:"sub"`            // Stable identifier of the user at the provider.
+	Email         string `json:"email"`          // Email address of the user, if the `email` scope was granted.
+	EmailVerified bool   `json:"email_verified"` // Whether the provider verified the email address.
+	Name          string `json:"name"`           // Display name of the user, if the `profile` scope was granted.
+	Nonce         string `json:"nonce"`          // Nonce sent in the authorization request.
+}
+
+// discovery holds the fields of the provider's `/.well-known/openid-configuration` document.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to an OpenID Connect provider on behalf of the portal.
+//
+// The discovery document and signing keys are fetched on first use and cached, so the
+// portal starts even while the provider is unreachable.
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	httpClient   *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]interface{} // Signing keys by key ID.
+	keysAt    time.Time              // Time the keys were last fetched.
+}
+
+// NewProvider creates a Provider.
+//
+// Parameters:
+//   - issuer: Issuer URL of the provider (e.g. "https://accounts.google.com"), exactly as the
+//     provider reports it, including any trailing "/".
+//   - clientID: Client ID registered with the provider.
+//   - clientSecret: Client secret, or empty for public clients relying on PKCE alone.
+//   - redirectURL: Callback URL registered with the provider.
+//   - scopes: Requested scopes; "openid" is always included.
+func NewProvider(issuer, clientID, clientSecret, redirectURL string, scopes []string) *Provider {
+	if !contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	return &Provider{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       scopes,
+		httpClient:   &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// AuthCodeURL returns the URL of the provider's sign-in page.
+//
+// Parameters:
+//   - state: Value returned unchanged in the callback, binding it to the login.
+//   - nonce: Value the provider must put in the ID token.
+//   - verifier: PKCE code verifier; only its S256 challenge is sent.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {strings.Join(p.scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims of the ID token.
+//
+// Parameters:
+//   - code: Authorization code from the callback.
+//   - verifier: PKCE code verifier used for the authorization request.
+//   - nonce: Nonce used for the authorization request.
+//
+// Returns an error if the token request fails or the ID token is not valid for this
+// client (signature, issuer, audience, expiry or nonce).
+func (p *Provider) Exchange(code, verifier, nonce string) (*Claims, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"client_id":     {p.clientID},
+		"code_verifier": {verifier},
+	}
+	if p.clientSecret != "" {
+		form.Set("client_secret", p.clientSecret)
+	}
+
+	resp, err := p.httpClient.PostForm(d.TokenEndpoint, form)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, body)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.IDToken == "" {
+		return nil, fmt.Errorf("token response has no ID token")
+	}
+
+	claims, err := p.verifyIDToken(token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("ID token nonce does not match")
+	}
+	return claims, nil
+}
+
+// WalledGarden returns the hosts guests must reach before they are authorized to sign in:
+// the provider's issuer and authorization endpoint, the hosts its sign-in pages are known
+// to load, and the configured extra hosts. They need to be allowed in the controller's
+// pre-authorization access list.
+func (p *Provider) WalledGarden(extra []string) []string {
+	var hosts []string
+	add := func(host string) {
+		if host != "" && !contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+
+	issuer, _ := url.Parse(p.issuer)
+	if issuer != nil {
+		add(issuer.Hostname())
+		for _, host := range knownWalledGardens[issuer.Hostname()] {
+			add(host)
+		}
+	}
+	if d, err := p.getDiscovery(); err == nil {
+		if endpoint, err := url.Parse(d.AuthorizationEndpoint); err == nil {
+			add(endpoint.Hostname())
+		}
+	}
+	for _, host := range extra {
+		add(host)
+	}
+	return hosts
+}
+
+// getDiscovery returns the provider's discovery document, fetching it on first use.
+func (p *Provider) getDiscovery() (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	// The issuer must match exactly, but the document sits below it either way
+	var d discovery
+	if err := p.getJSON(strings.TrimSuffix(p.issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider configuration: %v", err)
+	}
+	if d.Issuer != p.issuer {
+		return nil, fmt.Errorf("provider issuer %q does not match configured issuer %q", d.Issuer, p.issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("provider configuration is missing endpoints")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// getJSON fetches a URL and decodes its JSON body into v.
+func (p *Provider) getJSON(target string, v interface{}) error {
+	resp, err := p.httpClient.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// RandomString returns a URL-safe random string with 32 bytes of entropy, suitable for
+// the state, nonce and PKCE code verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// contains reports whether values contains value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID = "portal"
+	testNonce    = "nonce-123"
+)
+
+// fakeIdP is an OpenID Connect provider serving discovery, signing keys and a token
+// endpoint that returns whatever ID token the test sets.
+type fakeIdP struct {
+	server *httptest.Server
+	issuer string
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mu      sync.Mutex
+	idToken string
+}
+
+// newFakeIdP starts a fake provider. Its issuer is the server URL followed by suffix,
+// e.g. "/" for providers whose issuer ends in a slash.
+func newFakeIdP(t *testing.T, suffix string) *fakeIdP {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                idp.issuer,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": {
+			{Kid: "rsa", Kty: "RSA", Use: "sig", N: encode(rsaKey.N.Bytes()), E: encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{Kid: "ec", Kty: "EC", Use: "sig", Crv: "P-256", X: encode(ecKey.X.FillBytes(make([]byte, 32))), Y: encode(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "code" || r.PostFormValue("client_id") != testClientID {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.idToken})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	idp.issuer = idp.server.URL + suffix
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// provider returns a new Provider for the fake provider, so no keys are cached between cases.
+func (idp *fakeIdP) provider() *Provider {
+	return NewProvider(idp.issuer, testClientID, "secret", "http://portal.example.com/oidc/callback", nil)
+}
+
+// claims returns the claims of a valid ID token, to be changed by each case.
+func (idp *fakeIdP) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   idp.issuer,
+		"sub":   "user-1",
+		"aud":   testClientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": testNonce,
+		"email": "ada@example.com",
+	}
+}
+
+// sign returns an ID token with the given header and claims. RS256 tokens are signed with
+// key, or the provider's RSA key if nil; ES256 tokens with the provider's EC key; HS256
+// tokens with the provider's RSA modulus as the HMAC secret; other algorithms are unsigned.
+func (idp *fakeIdP) sign(t *testing.T, alg, kid string, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := segment(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		if key == nil {
+			key = idp.rsaKey
+		}
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, idp.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case "HS256":
+		mac := hmac.New(sha256.New, idp.rsaKey.N.Bytes())
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// exchange makes the token endpoint return token and redeems a code with the provider.
+func (idp *fakeIdP) exchange(p *Provider, token, nonce string) (*Claims, error) {
+	idp.mu.Lock()
+	idp.idToken = token
+	idp.mu.Unlock()
+	return p.Exchange("code", "verifier", nonce)
+}
+
+func TestExchange(t *testing.T) {
+	idp := newFakeIdP(t, "")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := idp.claims()
+		claims[name] = value
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		nonce   string
+		wantErr string
+	}{
+		{"valid RS256", idp.sign(t, "RS256", "rsa", nil, idp.claims()), testNonce, ""},
+		{"valid ES256", idp.sign(t, "ES256", "ec", nil, idp.claims()), testNonce, ""},
+		{"audience list", idp.sign(t, "RS256", "rsa", nil, with("aud", []string{"other", testClientID})), testNonce, ""},
+		{"bad signature", idp.sign(t, "RS256", "rsa", otherKey, idp.claims()), testNonce, "invalid ID token signature"},
+		{"tampered claims", tamper(idp.sign(t, "RS256", "rsa", nil, idp.claims()), with("sub", "admin")), testNonce, "invalid ID token signature"},
+		{"unknown kid", idp.sign(t, "RS256", "other", nil, idp.claims()), testNonce, "unknown ID token key"},
+		{"alg none", idp.sign(t, "none", "rsa", nil, idp.claims()), testNonce, "unsupported ID token algorithm"},
+		{"alg HS256", idp.sign(t, "HS256", "rsa", nil, idp.claims()), testNonce, "unsupported ID token algorithm"},
+		{"alg ES256 with RSA key", idp.sign(t, "ES256", "rsa", nil, idp.claims()), testNonce, "does not match algorithm"},
+		{"wrong issuer", idp.sign(t, "RS256", "rsa", nil, with("iss", "https://evil.example.com")), testNonce, "issuer"},
+		{"wrong audience", idp.sign(t, "RS256", "rsa", nil, with("aud", "other")), testNonce, "not issued for this client"},
+		{"expired", idp.sign(t, "RS256", "rsa", nil, with("exp", time.Now().Add(-2*clockSkew).Unix())), testNonce, "expired"},
+		{"issued in the future", idp.sign(t, "RS256", "rsa", nil, with("iat", time.Now().Add(2*clockSkew).Unix())), testNonce, "future"},
+		{"no subject", idp.sign(t, "RS256", "rsa", nil, with("sub", "")), testNonce, "no subject"},
+		{"nonce mismatch", idp.sign(t, "RS256", "rsa", nil, idp.claims()), "other-nonce", "nonce does not match"},
+		{"malformed", "not-a-token", testNonce, "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := idp.exchange(idp.provider(), tt.token, tt.nonce)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				if claims.Subject != "user-1" || claims.Email != "ada@example.com" {
+					t.Errorf("got claims %+v", claims)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// tamper replaces the claims of a signed token, keeping its header and signature.
+func tamper(token string, claims map[string]interface{}) string {
+	parts := strings.Split(token, ".")
+	data, _ := json.Marshal(claims)
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(data) + "." + parts[2]
+}
+
+func TestIssuerWithTrailingSlash(t *testing.T) {
+	idp := newFakeIdP(t, "/")
+	p := idp.provider()
+
+	if _, err := p.AuthCodeURL("state", testNonce, "verifier"); err != nil {
+		t.Fatalf("discovery: %v", err)
+	}
+	if _, err := idp.exchange(p, idp.sign(t, "RS256", "rsa", nil, idp.claims()), testNonce); err != nil {
+		t.Errorf("exchange: %v", err)
+	}
+
+	claims := idp.claims()
+	claims["iss"] = strings.TrimSuffix(idp.issuer, "/")
+	if _, err := idp.exchange(p, idp.sign(t, "RS256", "rsa", nil, claims), testNonce); err == nil {
+		t.Error("exchange of a token with a different issuer: got no error")
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newFakeIdP(t, "")
+	p := NewProvider(idp.issuer+"/", testClientID, "", "http://portal.example.com/oidc/callback", nil)
+	if _, err := p.AuthCodeURL("state", testNonce, "verifier"); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("got error %v, want an issuer mismatch", err)
+	}
+}
@@ -0,0 +1,153 @@
+// Package pages renders the HTML pages of the front-end build (the portal, the success
+// page and the admin dashboard) with html/template, so every value put into a page is
+// escaped for the context it lands in.
+//
+// The pages are produced by Vite and cannot hold template actions themselves (Vite
+// would rewrite them), so each page is turned into a template once when it is loaded:
+// the %VITE_PAGE_TITLE% placeholders, the lang attribute, the logo and the inline
+// styles become actions, and a script setting the page data is added before </body>.
+package pages
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Names of the pages.
+const (
+	Portal  = "index.html"   // Guest login page.
+	Success = "success.html" // Page shown once the guest is online.
+	Admin   = "admin.html"   // Admin dashboard.
+)
+
+// ErrNotFound is returned by Render for a page missing from the front-end build.
+var ErrNotFound = errors.New("page not found")
+
+// replacements turn the markup of the front-end build into template actions. "{{" is
+// escaped first so braces in the build are never read as actions.
+var replacements = []struct{ old, new string }{
+	{"{{", `{{"{{"}}`},
+	{"%VITE_PAGE_TITLE%", "{{.Title}}"},
+	{`<html lang="en">`, `<html lang="{{.Locale}}">`},
+	{`src="/logo.png"`, `src="{{.Branding.LogoURL}}"`},
+	{"<style>", `<style nonce="{{.Nonce}}">`},
+	{"</head>", `{{with .Branding.Color}}<style nonce="{{$.Nonce}}">:root { --brand-color: {{.}}; }</style>{{end}}</head>`},
+	{"</body>", `<script nonce="{{.Nonce}}">window.cacheId = {{.CacheID}}; window.authMode = {{.AuthMode}};</script></body>`},
+}
+
+// Branding holds the look of the pages.
+type Branding struct {
+	LogoURL string // URL of the logo shown above the forms.
+	Color   string // Accent colour as a CSS hex colour, or empty for the default.
+}
+
+// Data holds the values put into a page.
+type Data struct {
+	Title    string   // Portal name.
+	CacheID  string   // Cache identifier of the guest login, or empty outside the login flow.
+	AuthMode string   // Guest authentication mode, so the portal shows the matching form.
+	Branding Branding // Logo and accent colour.
+	Locale   string   // Language of the pages (BCP 47 tag, e.g. "en" or "de-CH").
+	Nonce    string   // Content Security Policy nonce of the inline scripts and styles.
+}
+
+// Renderer renders the pages of a front-end build directory.
+type Renderer struct {
+	dir    string                        // Directory of the front-end build.
+	reload bool                          // Whether the pages are parsed again on every render.
+	mu     sync.RWMutex                  // Guards pages.
+	pages  map[string]*template.Template // Parsed pages by name; missing pages are left out.
+}
+
+// New loads the pages of a front-end build.
+//
+// Parameters:
+//   - dir: Directory of the front-end build.
+//   - reload: Parse the pages again on every render, so a rebuild shows up without a
+//     restart (for development only).
+//
+// Returns an error if a page cannot be read or parsed. Pages missing from the build are
+// not an error; rendering them returns ErrNotFound.
+func New(dir string, reload bool) (*Renderer, error) {
+	renderer := &Renderer{dir: dir, reload: reload}
+	if err := renderer.load(); err != nil {
+		return nil, err
+	}
+	return renderer, nil
+}
+
+// Render writes a page filled in with data. The page is rendered to a buffer first, so
+// nothing is written if it fails.
+func (r *Renderer) Render(w io.Writer, name string, data Data) error {
+	if r.reload {
+		if err := r.load(); err != nil {
+			return err
+		}
+	}
+
+	r.mu.RLock()
+	page := r.pages[name]
+	r.mu.RUnlock()
+	if page == nil {
+		return ErrNotFound
+	}
+
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render %s: %v", name, err)
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// load parses all pages of the build directory.
+func (r *Renderer) load() error {
+	pages := map[string]*template.Template{}
+	for _, name := range []string{Portal, Success, Admin} {
+		source, err := os.ReadFile(filepath.Join(r.dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to read %s: %v", name, err)
+		}
+
+		markup := string(source)
+		for _, replacement := range replacements {
+			markup = strings.ReplaceAll(markup, replacement.old, replacement.new)
+		}
+		page, err := template.New(name).Parse(markup)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+		pages[name] = page
+	}
+
+	r.mu.Lock()
+	r.pages = pages
+	r.mu.Unlock()
+	return nil
+}
+
+// NewNonce returns a random Content Security Policy nonce, to be used for a single response.
+func NewNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(nonce), nil
+}
+
+// ContentSecurityPolicy returns the Content-Security-Policy header of a page rendered
+// with a nonce: scripts and styles must come from the portal itself or carry the nonce.
+func ContentSecurityPolicy(nonce string) string {
+	return fmt.Sprintf("script-src 'self' 'nonce-%[1]s'; style-src 'self' 'nonce-%[1]s'; object-src 'none'; base-uri 'self'", nonce)
+}
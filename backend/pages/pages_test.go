@@ -0,0 +1,137 @@
+package pages
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// build is a portal page as produced by Vite, with braces a template would read as actions.
+const build = `<!doctype html>
+<html lang="en">
+<head><title>%VITE_PAGE_TITLE%</title><style>body { margin: 0; }</style></head>
+<body><img src="/logo.png" alt=""><p>{{.Nonce}} {{template "x"}} {{ unclosed</p>
+<script type="module" src="/assets/index.js"></script></body>
+</html>`
+
+// render renders the portal page of build with data.
+func render(t *testing.T, data Data) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, Portal), []byte(build), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	renderer, err := New(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := renderer.Render(&out, Portal, data); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestRender(t *testing.T) {
+	page := render(t, Data{
+		Title:    "Guest Wi-Fi",
+		CacheID:  "abc123",
+		AuthMode: "email",
+		Branding: Branding{LogoURL: "/branding/logo.svg", Color: "#0a7cff"},
+		Locale:   "de-CH",
+		Nonce:    "n0nce",
+	})
+	for _, want := range []string{
+		`<html lang="de-CH">`,
+		"<title>Guest Wi-Fi</title>",
+		`<img src="/branding/logo.svg"`,
+		`<style nonce="n0nce">body { margin: 0; }</style>`,
+		`<style nonce="n0nce">:root { --brand-color: #0a7cff; }</style></head>`,
+		`<script nonce="n0nce">window.cacheId = "abc123"; window.authMode = "email";</script></body>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("got %s, want %s", page, want)
+		}
+	}
+
+	// Braces in the build are text, not actions
+	if !strings.Contains(page, `<p>{{.Nonce}} {{template "x"}} {{ unclosed</p>`) {
+		t.Errorf("got %s, want the braces of the build as written", page)
+	}
+
+	// Without an accent colour, the default of the build is kept
+	if page := render(t, Data{}); strings.Contains(page, "--brand-color") {
+		t.Errorf("got %s, want no accent colour", page)
+	}
+}
+
+func TestRenderEscapes(t *testing.T) {
+	page := render(t, Data{
+		Title:    `<img src=x onerror=alert(1)>`,
+		CacheID:  `</script><script>alert(1)</script>`,
+		AuthMode: `"; alert(1); "`,
+		Branding: Branding{LogoURL: "javascript:alert(1)", Color: "red; } body { background: url(https://evil.example/x)"},
+		Locale:   `en" onload="alert(1)`,
+		Nonce:    "n0nce",
+	})
+	tests := []struct {
+		name, want string
+	}{
+		{"title", "<title>&lt;img src=x onerror=alert(1)&gt;</title>"},
+		{"cache ID", `window.cacheId = "\u003c/script\u003e\u003cscript\u003ealert(1)\u003c/script\u003e";`},
+		{"auth mode", `window.authMode = "\"; alert(1); \"";`},
+		{"logo URL", `<img src="#ZgotmplZ"`},
+		{"colour", "--brand-color: ZgotmplZ;"},
+		{"locale", `<html lang="en&#34; onload=&#34;alert(1)">`},
+	}
+	for _, tt := range tests {
+		if !strings.Contains(page, tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.name, page, tt.want)
+		}
+	}
+	if strings.Contains(page, "<script>alert") || strings.Contains(page, "evil.example") {
+		t.Errorf("got %s, want no injected markup", page)
+	}
+
+	// A logo URL cannot leave its attribute nor a colour its style element
+	page = render(t, Data{Branding: Branding{LogoURL: `/logo.png" onerror="alert(1)`, Color: "</style><script>alert(1)</script>"}})
+	if !strings.Contains(page, `<img src="/logo.png%22%20onerror=%22alert%281%29"`) || strings.Contains(page, "<script>alert") {
+		t.Errorf("got %s", page)
+	}
+}
+
+func TestNonce(t *testing.T) {
+	nonce, err := NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := NewNonce(); other == nonce || len(nonce) != 22 {
+		t.Errorf("got %q and %q, want two different 128-bit nonces", nonce, other)
+	}
+
+	policy := ContentSecurityPolicy(nonce)
+	if !strings.Contains(policy, "script-src 'self' 'nonce-"+nonce+"'") || !strings.Contains(policy, "style-src 'self' 'nonce-"+nonce+"'") {
+		t.Errorf("got %q, want the nonce for scripts and styles", policy)
+	}
+
+	// Every inline script and style of the page carries the nonce of the policy
+	page := render(t, Data{Branding: Branding{Color: "#0a7cff"}, Nonce: nonce})
+	for _, tag := range []string{"<script", "<style"} {
+		inline := strings.Count(page, tag+">") + strings.Count(page, tag+" nonce=")
+		if withNonce := strings.Count(page, tag+` nonce="`+nonce+`"`); withNonce != inline || withNonce == 0 {
+			t.Errorf("%s: got %d of %d with the nonce in %s", tag, withNonce, inline, page)
+		}
+	}
+}
+
+func TestMissingPage(t *testing.T) {
+	renderer, err := New(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := renderer.Render(&strings.Builder{}, Admin, Data{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+}
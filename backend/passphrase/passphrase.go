@@ -0,0 +1,190 @@
+// Package passphrase manages the shared portal passphrase used by the passphrase
+// authentication mode. It supports manual and scheduled daily rotation, constant-time
+// comparison, and locking out clients after repeated wrong guesses from their MAC or IP
+// address.
+package passphrase
+
+import (
+	"backend/lockout"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alphabet holds the characters of generated passphrases. Characters that are easily
+// confused when copied from a whiteboard (0/o, 1/l/i) are left out.
+const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// generatedLength is the number of characters in a generated passphrase.
+const generatedLength = 8
+
+// ErrWrongPassphrase is returned by Check when the guess does not match.
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// Manager holds the current passphrase and the failed attempts per client MAC and IP address.
+// It is safe for concurrent use.
+type Manager struct {
+	onChange func(passphrase string, rotatedAt time.Time) error // Called after Set and Rotate, e.g. to save the passphrase.
+
+	mu        sync.Mutex
+	current   string           // The current passphrase.
+	rotatedAt time.Time        // When the current passphrase was set.
+	failures  *lockout.Limiter // Wrong guesses keyed by client MAC address and by IP address.
+}
+
+// NewManager creates a Manager. If initial is empty, a random passphrase is generated.
+//
+// Parameters:
+//   - initial: The initial passphrase.
+//   - maxAttempts: Wrong guesses allowed per client MAC or IP address before it is locked out.
+//   - lockoutDuration: How long a client stays locked out.
+func NewManager(initial string, maxAttempts int, lockoutDuration time.Duration) (*Manager, error) {
+	m := &Manager{failures: lockout.New(maxAttempts, lockoutDuration)}
+	if initial == "" {
+		if _, err := m.Rotate(); err != nil {
+			return nil, err
+		}
+	} else if err := m.Set(initial); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// OnChange registers a function called with every passphrase set by Set or Rotate,
+// including scheduled rotations, e.g. to save it so it survives a restart. An error of
+// the function is returned by Set and Rotate; the new passphrase stays in use.
+func (m *Manager) OnChange(fn func(passphrase string, rotatedAt time.Time) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = fn
+}
+
+// Restore puts back a passphrase saved before a restart, with the time it was set.
+// Unlike Set, it does not call the OnChange function.
+func (m *Manager) Restore(passphrase string, rotatedAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current = passphrase
+	m.rotatedAt = rotatedAt
+}
+
+// Current returns the current passphrase and the time it was set.
+func (m *Manager) Current() (string, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current, m.rotatedAt
+}
+
+// Set replaces the current passphrase and calls the OnChange function, if any.
+func (m *Manager) Set(passphrase string) error {
+	m.mu.Lock()
+	m.current = strings.TrimSpace(passphrase)
+	m.rotatedAt = time.Now()
+	current, rotatedAt, onChange := m.current, m.rotatedAt, m.onChange
+	m.mu.Unlock()
+
+	if onChange != nil {
+		return onChange(current, rotatedAt)
+	}
+	return nil
+}
+
+// Rotate replaces the current passphrase with a randomly generated one and returns it.
+func (m *Manager) Rotate() (string, error) {
+	var b strings.Builder
+	limit := big.NewInt(int64(len(alphabet)))
+	for i := 0; i < generatedLength; i++ {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate passphrase: %v", err)
+		}
+		b.WriteByte(alphabet[n.Int64()])
+	}
+
+	return b.String(), m.Set(b.String())
+}
+
+// Check verifies a passphrase guess from a client.
+//
+// The comparison runs in constant time. Wrong guesses are counted both per MAC address
+// and per IP address: the MAC address comes from the login the guest started, which a
+// client can forge to get fresh attempts, while its IP address is the one it connects
+// from. A MAC or IP address that guesses wrong maxAttempts times is locked out for the
+// lockout duration; while locked out, even the right passphrase is rejected. A correct
+// guess clears the failed attempts of both.
+//
+// Parameters:
+//   - clientMAC: The MAC address of the guessing client.
+//   - clientIP: The IP address the guess comes from, or empty if unknown.
+//   - guess: The passphrase supplied by the guest.
+//
+// Returns:
+//   - error: nil if the guess is correct, a *lockout.LockedOutError if the client is locked out,
+//     or ErrWrongPassphrase.
+func (m *Manager) Check(clientMAC, clientIP, guess string) error {
+	keys := []string{"mac " + clientMAC}
+	if clientIP != "" {
+		keys = append(keys, "ip "+clientIP)
+	}
+
+	// Holding m.mu from the lockout check to the recorded failure keeps parallel guesses
+	// of a client within maxAttempts
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		if err := m.failures.Check(key); err != nil {
+			return err
+		}
+	}
+
+	// Hash both sides so the comparison time doesn't depend on the passphrase length
+	expected := sha256.Sum256([]byte(m.current))
+	provided := sha256.Sum256([]byte(strings.TrimSpace(guess)))
+	if subtle.ConstantTimeCompare(expected[:], provided[:]) == 1 {
+		for _, key := range keys {
+			m.failures.Succeed(key)
+		}
+		return nil
+	}
+
+	var lockedOut error
+	for _, key := range keys {
+		if err := m.failures.Fail(key); err != nil {
+			lockedOut = err
+		}
+	}
+	if lockedOut != nil {
+		log.Printf("Client %s (%s) locked out after repeated wrong passphrase guesses", clientMAC, clientIP)
+		return lockedOut
+	}
+	return ErrWrongPassphrase
+}
+
+// RotateDailyAt rotates the passphrase every day at the given local time.
+//
+// This function blocks forever and is meant to be started in its own goroutine,
+// in the same way as cache.PurgeCacheEvery.
+func (m *Manager) RotateDailyAt(hour, minute int) {
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		time.Sleep(time.Until(next))
+
+		if _, err := m.Rotate(); err != nil {
+			log.Printf("Scheduled passphrase rotation failed: %v", err)
+			continue
+		}
+		log.Println("Portal passphrase rotated")
+	}
+}
@@ -0,0 +1,235 @@
+package radius
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+)
+
+// RADIUS packet codes (RFC 2865, RFC 2866).
+const (
+	codeAccessRequest      = 1
+	codeAccessAccept       = 2
+	codeAccessReject       = 3
+	codeAccountingRequest  = 4
+	codeAccountingResponse = 5
+	codeAccessChallenge    = 11
+)
+
+// RADIUS attribute types used by the portal.
+const (
+	attrUserName             = 1
+	attrUserPassword         = 2
+	attrCHAPPassword         = 3
+	attrReplyMessage         = 18
+	attrClass                = 25
+	attrVendorSpecific       = 26
+	attrSessionTimeout       = 27
+	attrCalledStationID      = 30
+	attrCallingStationID     = 31
+	attrNASIdentifier        = 32
+	attrAcctStatusType       = 40
+	attrAcctSessionID        = 44
+	attrAcctSessionTime      = 46
+	attrAcctTerminateCause   = 49
+	attrCHAPChallenge        = 60
+	attrMessageAuthenticator = 80
+)
+
+// Vendor-specific bandwidth attributes (WISPr, vendor ID 14122), in bits per second.
+const (
+	vendorWISPr           = 14122
+	wisprBandwidthMaxUp   = 7
+	wisprBandwidthMaxDown = 8
+)
+
+const (
+	// maxPacketLength is the largest RADIUS packet allowed by RFC 2865.
+	maxPacketLength = 4096
+
+	// messageAuthenticatorLen is the length of the Message-Authenticator attribute value.
+	messageAuthenticatorLen = 16
+)
+
+// attribute is a single type-length-value attribute of a packet.
+type attribute struct {
+	Type  byte
+	Value []byte
+}
+
+// packet is a decoded RADIUS packet.
+type packet struct {
+	Code          byte
+	Identifier    byte
+	Authenticator [16]byte
+	Attributes    []attribute
+}
+
+// add appends an attribute, ignoring values too long for a single attribute.
+func (p *packet) add(t byte, value []byte) {
+	if len(value) > 253 {
+		value = value[:253]
+	}
+	p.Attributes = append(p.Attributes, attribute{Type: t, Value: value})
+}
+
+// addUint32 appends an attribute holding a 32-bit integer.
+func (p *packet) addUint32(t byte, value uint32) {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, value)
+	p.add(t, b)
+}
+
+// get returns the value of the first attribute of the given type, or nil.
+func (p *packet) get(t byte) []byte {
+	for _, a := range p.Attributes {
+		if a.Type == t {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+// vendorUint32 returns a 32-bit integer vendor-specific attribute, or 0 if absent.
+func (p *packet) vendorUint32(vendor uint32, vendorType byte) uint32 {
+	for _, a := range p.Attributes {
+		if a.Type != attrVendorSpecific || len(a.Value) < 6 || binary.BigEndian.Uint32(a.Value) != vendor {
+			continue
+		}
+		// A vendor-specific attribute may hold several vendor attributes
+		for rest := a.Value[4:]; len(rest) >= 2 && int(rest[1]) <= len(rest) && rest[1] >= 2; rest = rest[rest[1]:] {
+			if rest[0] == vendorType && rest[1] == 6 {
+				return binary.BigEndian.Uint32(rest[2:6])
+			}
+		}
+	}
+	return 0
+}
+
+// encode serializes the packet.
+func (p *packet) encode() []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{p.Code, p.Identifier, 0, 0})
+	buf.Write(p.Authenticator[:])
+	for _, a := range p.Attributes {
+		buf.WriteByte(a.Type)
+		buf.WriteByte(byte(len(a.Value) + 2))
+		buf.Write(a.Value)
+	}
+	b := buf.Bytes()
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b
+}
+
+// decodePacket parses a RADIUS packet.
+func decodePacket(b []byte) (*packet, error) {
+	if len(b) < 20 {
+		return nil, fmt.Errorf("packet too short")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < 20 || length > len(b) {
+		return nil, fmt.Errorf("invalid packet length")
+	}
+
+	p := &packet{Code: b[0], Identifier: b[1]}
+	copy(p.Authenticator[:], b[4:20])
+	for rest := b[20:length]; len(rest) > 0; {
+		if len(rest) < 2 || rest[1] < 2 || int(rest[1]) > len(rest) {
+			return nil, fmt.Errorf("invalid attribute")
+		}
+		p.Attributes = append(p.Attributes, attribute{Type: rest[0], Value: rest[2:rest[1]]})
+		rest = rest[rest[1]:]
+	}
+	return p, nil
+}
+
+// signMessageAuthenticator sets the Message-Authenticator attribute (RFC 3579) to the
+// HMAC-MD5 of the packet. The attribute must already be present, filled with zeros.
+func (p *packet) signMessageAuthenticator(secret []byte) {
+	for i, a := range p.Attributes {
+		if a.Type == attrMessageAuthenticator {
+			mac := hmac.New(md5.New, secret)
+			mac.Write(p.encode())
+			p.Attributes[i].Value = mac.Sum(nil)
+			return
+		}
+	}
+}
+
+// verifyResponse checks the Response Authenticator of a reply and, if present, its
+// Message-Authenticator attribute.
+//
+// Parameters:
+//   - raw: The reply as received.
+//   - requestAuthenticator: The authenticator of the request the reply answers.
+//   - secret: The shared secret.
+func verifyResponse(raw []byte, requestAuthenticator [16]byte, secret []byte) error {
+	reply, err := decodePacket(raw)
+	if err != nil {
+		return err
+	}
+	raw = raw[:binary.BigEndian.Uint16(raw[2:4])]
+
+	hash := md5.New()
+	hash.Write(raw[:4])
+	hash.Write(requestAuthenticator[:])
+	hash.Write(raw[20:])
+	hash.Write(secret)
+	if !hmac.Equal(hash.Sum(nil), raw[4:20]) {
+		return fmt.Errorf("invalid response authenticator (wrong shared secret?)")
+	}
+
+	received := reply.get(attrMessageAuthenticator)
+	if received == nil {
+		return nil
+	}
+
+	// The Message-Authenticator of a reply is computed over the reply with the request
+	// authenticator and a zeroed Message-Authenticator
+	copy(reply.Authenticator[:], requestAuthenticator[:])
+	for i, a := range reply.Attributes {
+		if a.Type == attrMessageAuthenticator {
+			reply.Attributes[i].Value = make([]byte, messageAuthenticatorLen)
+		}
+	}
+	mac := hmac.New(md5.New, secret)
+	mac.Write(reply.encode())
+	if !hmac.Equal(mac.Sum(nil), received) {
+		return fmt.Errorf("invalid message authenticator")
+	}
+	return nil
+}
+
+// encryptPassword hides a PAP password as described in RFC 2865 section 5.2.
+func encryptPassword(password string, authenticator [16]byte, secret []byte) []byte {
+	padded := []byte(password)
+	if len(padded) == 0 || len(padded)%16 != 0 {
+		padded = append(padded, make([]byte, 16-len(padded)%16)...)
+	}
+	if len(padded) > 128 {
+		padded = padded[:128]
+	}
+
+	result := make([]byte, len(padded))
+	previous := authenticator[:]
+	for i := 0; i < len(padded); i += 16 {
+		hash := md5.Sum(append(append([]byte{}, secret...), previous...))
+		for j := 0; j < 16; j++ {
+			result[i+j] = padded[i+j] ^ hash[j]
+		}
+		previous = result[i : i+16]
+	}
+	return result
+}
+
+// chapPassword computes the CHAP-Password attribute value (RFC 2865 section 5.3) for a
+// CHAP identifier and challenge.
+func chapPassword(ident byte, password string, challenge []byte) []byte {
+	hash := md5.New()
+	hash.Write([]byte{ident})
+	hash.Write([]byte(password))
+	hash.Write(challenge)
+	return append([]byte{ident}, hash.Sum(nil)...)
+}
@@ -0,0 +1,270 @@
+// Package radius implements the RADIUS client of the radius authentication mode:
+// PAP and CHAP Access-Requests (RFC 2865) and Accounting-Requests (RFC 2866) over UDP.
+package radius
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Authentication methods selectable with RADIUS_AUTH_METHOD.
+const (
+	MethodPAP  = "pap"  // Password hidden with the shared secret.
+	MethodCHAP = "chap" // Challenge-response; the server must store clear-text passwords.
+)
+
+// Acct-Terminate-Cause values (RFC 2866 section 5.10) reported in Accounting-Stop records.
+const (
+	TerminateUserRequest    = 1 // The guest logged out.
+	TerminateSessionTimeout = 5 // The authorized duration ran out.
+	TerminateAdminReset     = 6 // An administrator revoked the guest or disconnected the client.
+)
+
+// Acct-Status-Type values.
+const (
+	acctStart = 1
+	acctStop  = 2
+)
+
+// attempts is the number of times a request is sent before giving up.
+const attempts = 3
+
+// RejectError is returned when the RADIUS server rejects the credentials.
+type RejectError struct {
+	Message string // Reply-Message sent by the server, if any.
+}
+
+// Error implements the error interface.
+func (e *RejectError) Error() string {
+	if e.Message != "" {
+		return "RADIUS access rejected: " + e.Message
+	}
+	return "RADIUS access rejected"
+}
+
+// Accept holds the authorization attributes of an Access-Accept.
+type Accept struct {
+	SessionTimeout time.Duration // Session-Timeout, or 0 if the server sent none.
+	UpBps          uint32        // WISPr-Bandwidth-Max-Up in bits per second, or 0.
+	DownBps        uint32        // WISPr-Bandwidth-Max-Down in bits per second, or 0.
+	Class          []byte        // Class attribute to echo in accounting records.
+}
+
+// AccountingRecord describes a guest session in Accounting-Requests.
+type AccountingRecord struct {
+	SessionID      string        // Acct-Session-Id, unique per session.
+	Username       string        // User-Name the guest authenticated with.
+	ClientMAC      string        // MAC address of the guest client.
+	APMAC          string        // MAC address of the access point.
+	Class          []byte        // Class attribute from the Access-Accept.
+	SessionTime    time.Duration // Duration of the session (stop records only).
+	TerminateCause int           // One of the Terminate* constants (stop records only).
+}
+
+// Client sends requests to a RADIUS server.
+type Client struct {
+	server           string
+	accountingServer string
+	secret           []byte
+	nasIdentifier    string
+	method           string
+	timeout          time.Duration
+}
+
+// NewClient creates a Client.
+//
+// Parameters:
+//   - server: Authentication server as "host:port".
+//   - accountingServer: Accounting server as "host:port", or empty to disable accounting.
+//   - secret: Shared secret.
+//   - nasIdentifier: NAS-Identifier sent with every request.
+//   - method: MethodPAP or MethodCHAP.
+//   - timeout: Time to wait for each reply before resending.
+func NewClient(server, accountingServer, secret, nasIdentifier, method string, timeout time.Duration) *Client {
+	return &Client{
+		server:           server,
+		accountingServer: accountingServer,
+		secret:           []byte(secret),
+		nasIdentifier:    nasIdentifier,
+		method:           method,
+		timeout:          timeout,
+	}
+}
+
+// Authenticate sends an Access-Request for a guest.
+//
+// Parameters:
+//   - username, password: Credentials entered by the guest.
+//   - clientMAC: MAC address of the guest client (sent as Calling-Station-Id).
+//   - apMAC: MAC address of the access point (sent as Called-Station-Id).
+//
+// Returns:
+//   - *Accept: The authorization attributes of the Access-Accept.
+//   - error: A *RejectError if the credentials were rejected, or another error if the server did not answer correctly.
+func (c *Client) Authenticate(username, password, clientMAC, apMAC string) (*Accept, error) {
+	req := &packet{Code: codeAccessRequest}
+	if _, err := rand.Read(req.Authenticator[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate authenticator: %v", err)
+	}
+
+	req.add(attrUserName, []byte(username))
+	if c.method == MethodCHAP {
+		ident := req.Authenticator[0]
+		req.add(attrCHAPPassword, chapPassword(ident, password, req.Authenticator[:]))
+		req.add(attrCHAPChallenge, req.Authenticator[:])
+	} else {
+		req.add(attrUserPassword, encryptPassword(password, req.Authenticator, c.secret))
+	}
+	c.addStationAttributes(req, clientMAC, apMAC)
+	req.add(attrMessageAuthenticator, make([]byte, messageAuthenticatorLen))
+
+	reply, err := c.exchange(c.server, req, func(p *packet) []byte {
+		p.signMessageAuthenticator(c.secret)
+		return p.encode()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch reply.Code {
+	case codeAccessAccept:
+		accept := &Accept{
+			UpBps:   reply.vendorUint32(vendorWISPr, wisprBandwidthMaxUp),
+			DownBps: reply.vendorUint32(vendorWISPr, wisprBandwidthMaxDown),
+			Class:   reply.get(attrClass),
+		}
+		if timeout := reply.get(attrSessionTimeout); len(timeout) == 4 {
+			accept.SessionTimeout = time.Duration(binary.BigEndian.Uint32(timeout)) * time.Second
+		}
+		return accept, nil
+	case codeAccessReject:
+		return nil, &RejectError{Message: string(reply.get(attrReplyMessage))}
+	case codeAccessChallenge:
+		return nil, &RejectError{Message: "multi-step authentication is not supported"}
+	}
+	return nil, fmt.Errorf("unexpected RADIUS reply code %d", reply.Code)
+}
+
+// AccountingEnabled reports whether an accounting server is configured.
+func (c *Client) AccountingEnabled() bool {
+	return c.accountingServer != ""
+}
+
+// AccountingStart sends an Accounting-Start record for a guest session.
+func (c *Client) AccountingStart(rec AccountingRecord) error {
+	return c.account(acctStart, rec)
+}
+
+// AccountingStop sends an Accounting-Stop record for a guest session.
+func (c *Client) AccountingStop(rec AccountingRecord) error {
+	return c.account(acctStop, rec)
+}
+
+// account sends an Accounting-Request with the given Acct-Status-Type.
+func (c *Client) account(status uint32, rec AccountingRecord) error {
+	if !c.AccountingEnabled() {
+		return nil
+	}
+
+	req := &packet{Code: codeAccountingRequest}
+	req.addUint32(attrAcctStatusType, status)
+	req.add(attrAcctSessionID, []byte(rec.SessionID))
+	req.add(attrUserName, []byte(rec.Username))
+	c.addStationAttributes(req, rec.ClientMAC, rec.APMAC)
+	if len(rec.Class) > 0 {
+		req.add(attrClass, rec.Class)
+	}
+	if status == acctStop {
+		req.addUint32(attrAcctSessionTime, uint32(rec.SessionTime/time.Second))
+		req.addUint32(attrAcctTerminateCause, uint32(rec.TerminateCause))
+	}
+
+	reply, err := c.exchange(c.accountingServer, req, func(p *packet) []byte {
+		// The Request Authenticator of accounting requests is the MD5 of the packet with
+		// a zeroed authenticator followed by the shared secret
+		p.Authenticator = [16]byte{}
+		p.Authenticator = md5.Sum(append(p.encode(), c.secret...))
+		return p.encode()
+	})
+	if err != nil {
+		return err
+	}
+	if reply.Code != codeAccountingResponse {
+		return fmt.Errorf("unexpected RADIUS accounting reply code %d", reply.Code)
+	}
+	return nil
+}
+
+// addStationAttributes adds the NAS and station identifiers shared by all requests.
+func (c *Client) addStationAttributes(p *packet, clientMAC, apMAC string) {
+	p.add(attrNASIdentifier, []byte(c.nasIdentifier))
+	p.add(attrCallingStationID, []byte(stationID(clientMAC)))
+	if apMAC != "" {
+		p.add(attrCalledStationID, []byte(stationID(apMAC)))
+	}
+}
+
+// exchange sends a request and waits for the matching, authenticated reply. The request
+// is resent up to attempts times if no valid reply arrives in time.
+//
+// Parameters:
+//   - server: Server address as "host:port".
+//   - req: Request to send; its identifier is assigned here.
+//   - encode: Finalizes the request (authenticators) and serializes it.
+func (c *Client) exchange(server string, req *packet, encode func(*packet) []byte) (*packet, error) {
+	var ident [1]byte
+	if _, err := rand.Read(ident[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate identifier: %v", err)
+	}
+	req.Identifier = ident[0]
+	raw := encode(req)
+
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach RADIUS server: %v", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, maxPacketLength)
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		if _, err := conn.Write(raw); err != nil {
+			return nil, fmt.Errorf("failed to send RADIUS request: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(c.timeout))
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				lastErr = err
+				break
+			}
+			// Ignore stray replies to earlier requests
+			if n < 20 || buf[1] != req.Identifier {
+				continue
+			}
+			if err := verifyResponse(buf[:n], req.Authenticator, c.secret); err != nil {
+				return nil, err
+			}
+			return decodePacket(buf[:n])
+		}
+
+		var netErr net.Error
+		if !errors.As(lastErr, &netErr) || !netErr.Timeout() {
+			break
+		}
+	}
+	return nil, fmt.Errorf("no answer from RADIUS server %s: %v", server, lastErr)
+}
+
+// stationID formats a MAC address as a Calling/Called-Station-Id (RFC 3580),
+// e.g. "AA-BB-CC-DD-EE-FF".
+func stationID(mac string) string {
+	return strings.ToUpper(strings.ReplaceAll(mac, ":", "-"))
+}
@@ -0,0 +1,367 @@
+package radius
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Example Access-Request and Access-Accept of RFC 2865 section 7.1: user "nemo" with
+// password "arctangent", shared secret "xyzzy5461".
+var (
+	rfcSecret  = []byte("xyzzy5461")
+	rfcRequest = mustHex("01000038 0f403f94 73978057 bd83d5cb 98f4227a 01066e65 6d6f0212 0dbe708d" +
+		"93d413ce 3196e43f 782a0aee 0406c0a8 01100506 00000003")
+	rfcAccept = mustHex("02000026 86fe220e 7624ba2a 1005f6bf 9b55e0b2 06060000 00010f06 00000000" +
+		"0e06c0a8 0103")
+)
+
+// mustHex decodes a hex dump as printed in the RFC.
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestPacketRFC2865(t *testing.T) {
+	req, err := decodePacket(rfcRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Code != codeAccessRequest || req.Identifier != 0 || len(req.Attributes) != 4 {
+		t.Fatalf("decoded request: got %+v", req)
+	}
+	if got := string(req.get(attrUserName)); got != "nemo" {
+		t.Errorf("User-Name: got %q, want nemo", got)
+	}
+	if got := req.encode(); !bytes.Equal(got, rfcRequest) {
+		t.Errorf("encode: got %x, want %x", got, rfcRequest)
+	}
+
+	if got := encryptPassword("arctangent", req.Authenticator, rfcSecret); !bytes.Equal(got, req.get(attrUserPassword)) {
+		t.Errorf("User-Password: got %x, want %x", got, req.get(attrUserPassword))
+	}
+	if err := verifyResponse(rfcAccept, req.Authenticator, rfcSecret); err != nil {
+		t.Errorf("verify Access-Accept: %v", err)
+	}
+}
+
+func TestVerifyResponseRejectsForgeries(t *testing.T) {
+	req, _ := decodePacket(rfcRequest)
+
+	tampered := append([]byte{}, rfcAccept...)
+	tampered[len(tampered)-1] ^= 1
+	otherRequest := req.Authenticator
+	otherRequest[0] ^= 1
+
+	tests := []struct {
+		name          string
+		raw           []byte
+		authenticator [16]byte
+		secret        []byte
+	}{
+		{"wrong secret", rfcAccept, req.Authenticator, []byte("xyzzy5462")},
+		{"tampered attribute", tampered, req.Authenticator, rfcSecret},
+		{"other request", rfcAccept, otherRequest, rfcSecret},
+		{"truncated", rfcAccept[:19], req.Authenticator, rfcSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyResponse(tt.raw, tt.authenticator, tt.secret); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestDecodePacketRejectsInvalidAttributes(t *testing.T) {
+	header := func(length int, rest ...byte) []byte {
+		raw := append([]byte{codeAccessAccept, 0, byte(length >> 8), byte(length)}, make([]byte, 16)...)
+		return append(raw, rest...)
+	}
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"shorter than the header", []byte{codeAccessAccept, 0, 0, 20}},
+		{"length below the header", header(10)},
+		{"length beyond the buffer", header(40)},
+		{"attribute length below 2", header(22, attrReplyMessage, 1)},
+		{"attribute past the packet", header(23, attrReplyMessage, 10, 'x')},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodePacket(tt.raw); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+// fakeServer is a RADIUS server on a local UDP port that answers each request with the
+// reply returned by handle, or not at all if it returns nil.
+type fakeServer struct {
+	conn   *net.UDPConn
+	secret []byte
+	handle func(s *fakeServer, req *packet, raw []byte) []byte
+
+	mu       sync.Mutex
+	requests int          // Number of requests received.
+	from     *net.UDPAddr // Address of the last request.
+}
+
+// newFakeServer starts a fake server for the duration of a test.
+func newFakeServer(t *testing.T, secret string, handle func(s *fakeServer, req *packet, raw []byte) []byte) *fakeServer {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	s := &fakeServer{conn: conn, secret: []byte(secret), handle: handle}
+	go func() {
+		buf := make([]byte, maxPacketLength)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.requests++
+			s.from = addr
+			s.mu.Unlock()
+
+			raw := append([]byte{}, buf[:n]...)
+			req, err := decodePacket(raw)
+			if err != nil {
+				continue
+			}
+			if reply := s.handle(s, req, raw); reply != nil {
+				conn.WriteToUDP(reply, addr)
+			}
+		}
+	}()
+	return s
+}
+
+// addr returns the address of the server as "host:port".
+func (s *fakeServer) addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// received returns the number of requests received so far.
+func (s *fakeServer) received() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// lastClient returns the address of the last request.
+func (s *fakeServer) lastClient() *net.UDPAddr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.from
+}
+
+// client returns a client of the server using the given method and shared secret.
+func (s *fakeServer) client(method, secret string) *Client {
+	return NewClient(s.addr(), s.addr(), secret, "portal", method, 50*time.Millisecond)
+}
+
+// reply builds a signed reply to req, with a Message-Authenticator if messageAuth is set.
+func (s *fakeServer) reply(req *packet, code byte, messageAuth bool, attributes ...attribute) []byte {
+	reply := &packet{Code: code, Identifier: req.Identifier, Authenticator: req.Authenticator, Attributes: attributes}
+	if messageAuth {
+		reply.add(attrMessageAuthenticator, make([]byte, messageAuthenticatorLen))
+		reply.signMessageAuthenticator(s.secret)
+	}
+	raw := reply.encode()
+	authenticator := md5.Sum(append(raw, s.secret...))
+	copy(raw[4:20], authenticator[:])
+	return raw
+}
+
+// checkRequest reports whether an Access-Request has a valid Message-Authenticator and
+// carries password, either hidden in User-Password or as the CHAP response.
+func (s *fakeServer) checkRequest(req *packet, raw []byte, password string) bool {
+	received := req.get(attrMessageAuthenticator)
+	zeroed := bytes.Replace(raw, received, make([]byte, messageAuthenticatorLen), 1)
+	mac := hmac.New(md5.New, s.secret)
+	mac.Write(zeroed)
+	if !hmac.Equal(mac.Sum(nil), received) {
+		return false
+	}
+
+	if chap := req.get(attrCHAPPassword); chap != nil {
+		return bytes.Equal(chap, chapPassword(chap[0], password, req.get(attrCHAPChallenge)))
+	}
+	return decryptPassword(req.get(attrUserPassword), req.Authenticator, s.secret) == password
+}
+
+// decryptPassword reverses encryptPassword, as the server does.
+func decryptPassword(hidden []byte, authenticator [16]byte, secret []byte) string {
+	result := make([]byte, len(hidden))
+	previous := authenticator[:]
+	for i := 0; i+16 <= len(hidden); i += 16 {
+		hash := md5.Sum(append(append([]byte{}, secret...), previous...))
+		for j := 0; j < 16; j++ {
+			result[i+j] = hidden[i+j] ^ hash[j]
+		}
+		previous = hidden[i : i+16]
+	}
+	return string(bytes.TrimRight(result, "\x00"))
+}
+
+func TestAuthenticate(t *testing.T) {
+	vendor := append([]byte{0, 0, 0x37, 0x2a}, wisprBandwidthMaxUp, 6, 0, 0x0f, 0x42, 0x40, wisprBandwidthMaxDown, 6, 0, 0x1e, 0x84, 0x80)
+	s := newFakeServer(t, "secret", func(s *fakeServer, req *packet, raw []byte) []byte {
+		if string(req.get(attrUserName)) != "ada" || !s.checkRequest(req, raw, "a long password of more than 16 bytes") {
+			return s.reply(req, codeAccessReject, true, attribute{attrReplyMessage, []byte("wrong password")})
+		}
+		return s.reply(req, codeAccessAccept, true,
+			attribute{attrSessionTimeout, []byte{0, 0, 0x0e, 0x10}},
+			attribute{attrClass, []byte("class-1")},
+			attribute{attrVendorSpecific, vendor})
+	})
+
+	for _, method := range []string{MethodPAP, MethodCHAP} {
+		t.Run(method, func(t *testing.T) {
+			client := s.client(method, "secret")
+
+			accept, err := client.Authenticate("ada", "a long password of more than 16 bytes", "aa:bb:cc:dd:ee:ff", "")
+			if err != nil {
+				t.Fatalf("valid credentials: %v", err)
+			}
+			if accept.SessionTimeout != time.Hour || accept.UpBps != 1000000 || accept.DownBps != 2000000 || string(accept.Class) != "class-1" {
+				t.Errorf("accept: got %+v", accept)
+			}
+
+			_, err = client.Authenticate("ada", "wrong", "aa:bb:cc:dd:ee:ff", "")
+			var reject *RejectError
+			if !errors.As(err, &reject) || reject.Message != "wrong password" {
+				t.Errorf("wrong password: got %v, want a reject with the reply message", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateBadAuthenticator(t *testing.T) {
+	s := newFakeServer(t, "other secret", func(s *fakeServer, req *packet, raw []byte) []byte {
+		return s.reply(req, codeAccessAccept, false)
+	})
+
+	_, err := s.client(MethodPAP, "secret").Authenticate("ada", "password", "aa:bb:cc:dd:ee:ff", "")
+	if err == nil || !strings.Contains(err.Error(), "invalid response authenticator") {
+		t.Errorf("got %v, want an invalid response authenticator", err)
+	}
+}
+
+func TestAuthenticateBadMessageAuthenticator(t *testing.T) {
+	s := newFakeServer(t, "secret", func(s *fakeServer, req *packet, raw []byte) []byte {
+		reply := &packet{Code: codeAccessAccept, Identifier: req.Identifier, Authenticator: req.Authenticator}
+		reply.add(attrMessageAuthenticator, make([]byte, messageAuthenticatorLen))
+		out := reply.encode()
+		authenticator := md5.Sum(append(out, s.secret...))
+		copy(out[4:20], authenticator[:])
+		return out
+	})
+
+	_, err := s.client(MethodPAP, "secret").Authenticate("ada", "password", "aa:bb:cc:dd:ee:ff", "")
+	if err == nil || !strings.Contains(err.Error(), "invalid message authenticator") {
+		t.Errorf("got %v, want an invalid message authenticator", err)
+	}
+}
+
+func TestAuthenticateMismatchedIdentifier(t *testing.T) {
+	s := newFakeServer(t, "secret", func(s *fakeServer, req *packet, raw []byte) []byte {
+		stray := *req
+		stray.Identifier++
+		return s.reply(&stray, codeAccessAccept, true)
+	})
+
+	_, err := s.client(MethodPAP, "secret").Authenticate("ada", "password", "aa:bb:cc:dd:ee:ff", "")
+	if err == nil || !strings.Contains(err.Error(), "no answer") {
+		t.Errorf("got %v, want no answer", err)
+	}
+	if got := s.received(); got != attempts {
+		t.Errorf("requests sent: got %d, want %d", got, attempts)
+	}
+}
+
+func TestAuthenticateStrayReplyIsSkipped(t *testing.T) {
+	s := newFakeServer(t, "secret", func(s *fakeServer, req *packet, raw []byte) []byte {
+		// A reply to an earlier request arrives first
+		stray := *req
+		stray.Identifier++
+		s.conn.WriteToUDP(s.reply(&stray, codeAccessReject, true), s.lastClient())
+		return s.reply(req, codeAccessAccept, true)
+	})
+
+	if _, err := s.client(MethodPAP, "secret").Authenticate("ada", "password", "aa:bb:cc:dd:ee:ff", ""); err != nil {
+		t.Errorf("got %v, want the reply after the stray one to be accepted", err)
+	}
+}
+
+func TestAuthenticateTimeout(t *testing.T) {
+	s := newFakeServer(t, "secret", func(s *fakeServer, req *packet, raw []byte) []byte { return nil })
+
+	start := time.Now()
+	_, err := s.client(MethodPAP, "secret").Authenticate("ada", "password", "aa:bb:cc:dd:ee:ff", "")
+	if err == nil || !strings.Contains(err.Error(), "no answer") {
+		t.Errorf("got %v, want no answer", err)
+	}
+	if got := s.received(); got != attempts {
+		t.Errorf("requests sent: got %d, want %d", got, attempts)
+	}
+	if elapsed := time.Since(start); elapsed < attempts*50*time.Millisecond {
+		t.Errorf("gave up after %v, before every attempt timed out", elapsed)
+	}
+}
+
+func TestAccounting(t *testing.T) {
+	stops := make(chan *packet, 1)
+	s := newFakeServer(t, "secret", func(s *fakeServer, req *packet, raw []byte) []byte {
+		// The Request Authenticator is the MD5 of the request with a zeroed authenticator
+		zeroed := append([]byte{}, raw...)
+		copy(zeroed[4:20], make([]byte, 16))
+		if req.Code != codeAccountingRequest || md5.Sum(append(zeroed, s.secret...)) != req.Authenticator {
+			return nil
+		}
+		if binary.BigEndian.Uint32(req.get(attrAcctStatusType)) == acctStop {
+			stops <- req
+		}
+		return s.reply(req, codeAccountingResponse, false)
+	})
+
+	client := s.client(MethodPAP, "secret")
+	record := AccountingRecord{SessionID: "s-1", Username: "ada", ClientMAC: "aa:bb:cc:dd:ee:ff", APMAC: "11:22:33:44:55:66", Class: []byte("class-1")}
+	if err := client.AccountingStart(record); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	record.SessionTime = 90 * time.Second
+	record.TerminateCause = TerminateUserRequest
+	if err := client.AccountingStop(record); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	stop := <-stops
+	switch {
+	case string(stop.get(attrCallingStationID)) != "AA-BB-CC-DD-EE-FF" || string(stop.get(attrCalledStationID)) != "11-22-33-44-55-66":
+		t.Errorf("station IDs: got %q and %q", stop.get(attrCallingStationID), stop.get(attrCalledStationID))
+	case binary.BigEndian.Uint32(stop.get(attrAcctSessionTime)) != 90 || binary.BigEndian.Uint32(stop.get(attrAcctTerminateCause)) != TerminateUserRequest:
+		t.Errorf("stop record: got %+v", stop.Attributes)
+	case string(stop.get(attrClass)) != "class-1":
+		t.Errorf("class: got %q", stop.get(attrClass))
+	}
+}
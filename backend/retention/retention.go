@@ -0,0 +1,75 @@
+// Package retention enforces the data retention policy of the portal: guest data older
+// than the retention period is deleted or anonymised by a scheduled job, and every purge
+// is recorded in the audit log.
+package retention
+
+import (
+	"backend/db"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Retention modes, which decide what happens to sessions and failed logins past the
+// retention period.
+const (
+	ModeDelete    = "delete"    // Delete the rows.
+	ModeAnonymize = "anonymize" // Keep the rows for statistics with the personal data hashed or truncated.
+)
+
+// ErrDisabled is returned by Purge when no retention period is configured.
+var ErrDisabled = errors.New("no retention period is configured")
+
+// Policy describes how long guest data is kept.
+type Policy struct {
+	Days int    `json:"days"` // Retention period in days (0 keeps data forever).
+	Mode string `json:"mode"` // ModeDelete or ModeAnonymize.
+}
+
+// Enabled reports whether the policy purges any data.
+func (p Policy) Enabled() bool {
+	return p.Days > 0
+}
+
+// Cutoff returns the time before which data is purged, as of now.
+func (p Policy) Cutoff(now time.Time) time.Time {
+	return now.AddDate(0, 0, -p.Days)
+}
+
+// Purge applies the policy once.
+//
+// Parameters:
+// - store: Shared database.
+// - policy: The retention policy.
+// - actor: Admin username, db.ActorSystem or db.ActorCLI, recorded in the audit log.
+// - dryRun: Only report what would be purged, without changing or auditing anything.
+//
+// Returns the number of rows purged per table, or ErrDisabled if the policy is disabled.
+func Purge(store db.Storage, policy Policy, actor string, dryRun bool) (*db.PurgeReport, error) {
+	if !policy.Enabled() {
+		return nil, ErrDisabled
+	}
+	return store.PurgePersonalData(policy.Cutoff(time.Now()), policy.Mode == ModeAnonymize, dryRun, actor)
+}
+
+// PurgeEvery applies the policy at start and then periodically, in the same way as
+// cache.PurgeCacheEvery. It returns immediately if the policy is disabled.
+func PurgeEvery(store db.Storage, policy Policy, interval time.Duration) {
+	if !policy.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		report, err := Purge(store, policy, db.ActorSystem, false)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if report.Total() > 0 {
+			fmt.Printf("Purged %d rows of guest data older than %d days (%s mode)\n", report.Total(), policy.Days, policy.Mode)
+		}
+	}
+}
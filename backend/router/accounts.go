@@ -0,0 +1,383 @@
+package router
+
+import (
+	"backend/adminauth"
+	"backend/db"
+	"backend/lockout"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// sessionCookie is the name of the cookie holding the admin login session token.
+const sessionCookie = "admin_session"
+
+// Wrong passwords allowed per admin username, and the lockout that follows.
+const (
+	adminMaxAttempts = 5
+	adminLockout     = 15 * time.Minute
+)
+
+// apiTokenUser is the account name reported for requests authenticated with ADMIN_API_TOKEN.
+const apiTokenUser = "api-token"
+
+// adminContextKey is the request context key of the authenticated admin account.
+type adminContextKey struct{}
+
+// AdminLoginRequest represents the JSON body of POST /api/admin/login.
+type AdminLoginRequest struct {
+	Username string `json:"username"` // Login name.
+	Password string `json:"password"` // Password.
+}
+
+// CreateAccountRequest represents the JSON body of POST /api/admin/accounts.
+type CreateAccountRequest struct {
+	Username string `json:"username"` // Login name of the new account.
+	Password string `json:"password"` // Initial password.
+	Role     string `json:"role"`     // One of viewer, front-desk or admin.
+}
+
+// UpdateAccountRequest represents the JSON body of PUT /api/admin/accounts/{username}.
+// Empty fields are left unchanged.
+type UpdateAccountRequest struct {
+	Password string `json:"password"` // New password; signs the account out everywhere.
+	Role     string `json:"role"`     // New role.
+}
+
+// ChangePasswordRequest represents the JSON body of PUT /api/admin/me/password.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"` // Password the account signed in with.
+	NewPassword     string `json:"newPassword"`     // Replacement password.
+}
+
+// authenticateAdmin returns a middleware that identifies the admin account of a request,
+// from its session cookie or, for scripts, an `Authorization: Bearer <ADMIN_API_TOKEN>`
+// header with the admin role. Requests without either are rejected with 401.
+//
+// Cookie-authenticated requests that change state must come from the portal's own
+// pages: a cross-site Origin header is rejected with 403.
+func authenticateAdmin(store db.Storage, apiToken string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var admin *db.Admin
+			if header := r.Header.Get("Authorization"); header != "" {
+				expected := []byte("Bearer " + apiToken)
+				if apiToken != "" && subtle.ConstantTimeCompare([]byte(header), expected) == 1 {
+					admin = &db.Admin{Username: apiTokenUser, Role: adminauth.RoleAdmin}
+				}
+			} else if cookie, err := r.Cookie(sessionCookie); err == nil {
+				admin, err = store.GetAdminSession(adminauth.HashToken(cookie.Value))
+				if err != nil && !errors.Is(err, db.ErrNoAdminSession) {
+					fmt.Println(err)
+					writeInternalError(w)
+					return
+				}
+				if admin != nil && r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+					writeError(w, http.StatusForbidden, APIError{Code: ErrCodeForbidden, Message: "Cross-site requests are not allowed."})
+					return
+				}
+			}
+
+			if admin == nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, APIError{Code: ErrCodeUnauthorized, Message: "Please sign in."})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminContextKey{}, admin)))
+		})
+	}
+}
+
+// requireRole returns a middleware that rejects requests of accounts whose role is below
+// the required one with 403. It must run after authenticateAdmin.
+func requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !adminauth.Allows(currentAdmin(r).Role, role) {
+				writeError(w, http.StatusForbidden, APIError{
+					Code:    ErrCodeForbidden,
+					Message: fmt.Sprintf("This action requires the %s role.", role),
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// currentAdmin returns the admin account authenticated by authenticateAdmin.
+func currentAdmin(r *http.Request) *db.Admin {
+	admin, _ := r.Context().Value(adminContextKey{}).(*db.Admin)
+	if admin == nil {
+		return &db.Admin{}
+	}
+	return admin
+}
+
+// sameOrigin reports whether the Origin header of a request, if any, matches its host.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && parsed.Host == r.Host
+}
+
+// handleAdminLogin handles POST /api/admin/login by checking the credentials and setting
+// the session cookie.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - limiter: Locks out usernames after repeated wrong passwords.
+// - lifetime: How long the login session stays valid.
+// - secure: Whether the cookie is restricted to HTTPS.
+func handleAdminLogin(w http.ResponseWriter, r *http.Request, store db.Storage, limiter *lockout.Limiter, lifetime time.Duration, secure bool) {
+	var req AdminLoginRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	username := adminauth.NormalizeUsername(req.Username)
+	var lockedOut *lockout.LockedOutError
+	if err := limiter.Check(username); errors.As(err, &lockedOut) {
+		writeLockedOut(w, lockedOut)
+		return
+	}
+
+	admin, err := adminauth.Authenticate(store, req.Username, req.Password)
+	if errors.Is(err, adminauth.ErrWrongPassword) {
+		if err := limiter.Fail(username); errors.As(err, &lockedOut) {
+			log.Printf("Admin account %q locked out after %d wrong passwords", username, adminMaxAttempts)
+			writeLockedOut(w, lockedOut)
+			return
+		}
+		writeError(w, http.StatusUnauthorized, APIError{Code: ErrCodeInvalidCredentials, Message: "The username or password is incorrect."})
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	limiter.Succeed(username)
+
+	token, hash, err := adminauth.NewSessionToken()
+	if err == nil {
+		err = store.CreateAdminSession(hash, admin.Username, time.Now().Add(lifetime))
+	}
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	if err := store.RecordAdminLogin(admin.Username); err != nil {
+		fmt.Println(err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(lifetime / time.Second),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	writeJSON(w, http.StatusOK, admin)
+}
+
+// handleAdminLogout handles POST /api/admin/logout by ending the login session.
+func handleAdminLogout(w http.ResponseWriter, r *http.Request, store db.Storage, secure bool) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if err := store.DeleteAdminSession(adminauth.HashToken(cookie.Value)); err != nil {
+			fmt.Println(err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleChangeOwnPassword handles PUT /api/admin/me/password. The current password must
+// be given again, and all login sessions of the account end, including the current one.
+func handleChangeOwnPassword(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	admin := currentAdmin(r)
+	if admin.Username == apiTokenUser {
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: "The API token has no password."})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if _, err := adminauth.Authenticate(store, admin.Username, req.CurrentPassword); err != nil {
+		writeError(w, http.StatusForbidden, APIError{Code: ErrCodeInvalidCredentials, Message: "The current password is incorrect."})
+		return
+	}
+
+	if err := adminauth.SetPassword(store, admin.Username, req.NewPassword); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListAccounts handles GET /api/admin/accounts.
+func handleListAccounts(w http.ResponseWriter, store db.Storage) {
+	admins, err := store.ListAdmins()
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, admins)
+}
+
+// handleCreateAccount handles POST /api/admin/accounts.
+func handleCreateAccount(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	var req CreateAccountRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	admin, err := adminauth.CreateAccount(store, req.Username, req.Password, req.Role)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, admin)
+}
+
+// handleUpdateAccount handles PUT /api/admin/accounts/{username} by changing the role
+// and/or resetting the password of an account. The last admin cannot be demoted.
+func handleUpdateAccount(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	var req UpdateAccountRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	username := adminauth.NormalizeUsername(chi.URLParam(r, "username"))
+
+	admin, err := store.GetAdmin(username)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	if req.Role != "" && req.Role != admin.Role {
+		if !adminauth.ValidRole(req.Role) {
+			writeAccountError(w, adminauth.ErrInvalidRole)
+			return
+		}
+		if admin.Role == adminauth.RoleAdmin && !otherAdminsExist(w, store) {
+			return
+		}
+		if err := store.SetAdminRole(username, req.Role); err != nil {
+			writeAccountError(w, err)
+			return
+		}
+	}
+	if req.Password != "" {
+		if err := adminauth.SetPassword(store, username, req.Password); err != nil {
+			writeAccountError(w, err)
+			return
+		}
+	}
+
+	admin, err = store.GetAdmin(username)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, admin)
+}
+
+// handleDeleteAccount handles DELETE /api/admin/accounts/{username}. The last admin
+// cannot be deleted.
+func handleDeleteAccount(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	username := adminauth.NormalizeUsername(chi.URLParam(r, "username"))
+
+	admin, err := store.GetAdmin(username)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	if admin.Role == adminauth.RoleAdmin && !otherAdminsExist(w, store) {
+		return
+	}
+
+	if err := store.DeleteAdmin(username); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// otherAdminsExist reports whether more than one account has the admin role. It writes
+// a 409 response and returns false otherwise, so the last admin is never removed.
+func otherAdminsExist(w http.ResponseWriter, store db.Storage) bool {
+	count, err := store.CountAdmins(adminauth.RoleAdmin)
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return false
+	}
+	if count <= 1 {
+		writeError(w, http.StatusConflict, APIError{Code: ErrCodeLastAdmin, Message: "The last admin account cannot be removed or demoted."})
+		return false
+	}
+	return true
+}
+
+// writeAccountError writes the response for a failed account operation.
+func writeAccountError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrNoAdmin):
+		writeError(w, http.StatusNotFound, APIError{Code: ErrCodeNotFound, Message: "No admin account with this username."})
+	case errors.Is(err, db.ErrAdminExists):
+		writeError(w, http.StatusConflict, APIError{Code: ErrCodeAccountExists, Message: "An account with this username already exists."})
+	case errors.Is(err, adminauth.ErrInvalidUsername), errors.Is(err, adminauth.ErrInvalidRole), errors.Is(err, adminauth.ErrWeakPassword):
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: capitalize(err.Error()) + "."})
+	default:
+		fmt.Println(err)
+		writeInternalError(w)
+	}
+}
+
+// writeLockedOut writes the 429 response for a locked-out admin username.
+func writeLockedOut(w http.ResponseWriter, lockedOut *lockout.LockedOutError) {
+	wait := time.Until(lockedOut.Until).Round(time.Minute)
+	if wait < time.Minute {
+		wait = time.Minute
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+	writeError(w, http.StatusTooManyRequests, APIError{
+		Code:    ErrCodeLockedOut,
+		Message: fmt.Sprintf("Too many wrong passwords. Please try again in %.0f minute(s).", wait.Minutes()),
+	})
+}
+
+// capitalize returns s with its first letter in upper case.
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
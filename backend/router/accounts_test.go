@@ -0,0 +1,103 @@
+package router
+
+import (
+	"backend/adminauth"
+	"backend/db"
+	"backend/lockout"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminLogin posts a username and password to POST /api/admin/login.
+func adminLogin(store db.Storage, limiter *lockout.Limiter, username, password string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"username":%q,"password":%q}`, username, password)
+	w := httptest.NewRecorder()
+	handleAdminLogin(w, httptest.NewRequest(http.MethodPost, "/api/admin/login", strings.NewReader(body)), store, limiter, time.Hour, false)
+	return w
+}
+
+func TestAdminLoginLockout(t *testing.T) {
+	p := newAdminPortal(t)
+	if _, err := adminauth.CreateAccount(p.store, "ada", "correct horse", adminauth.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	limiter := lockout.New(2, time.Minute)
+
+	if w := adminLogin(p.store, limiter, "ada", "wrong"); w.Code != http.StatusUnauthorized || errorCode(w) != ErrCodeInvalidCredentials {
+		t.Fatalf("wrong password: got %d %s", w.Code, w.Body)
+	}
+	// The username is locked out in any case, and even the right password is rejected
+	if w := adminLogin(p.store, limiter, "ADA", "wrong"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("second wrong password: got %d %s", w.Code, w.Body)
+	}
+	if w := adminLogin(p.store, limiter, "ada", "correct horse"); w.Code != http.StatusTooManyRequests || errorCode(w) != ErrCodeLockedOut {
+		t.Errorf("right password while locked out: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAdminSessionMiddleware(t *testing.T) {
+	p := newAdminPortal(t)
+	if _, err := adminauth.CreateAccount(p.store, "ada", "correct horse", adminauth.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	login := adminLogin(p.store, lockout.New(5, time.Minute), "ada", "correct horse")
+	if login.Code != http.StatusOK || len(login.Result().Cookies()) != 1 {
+		t.Fatalf("login: got %d %s", login.Code, login.Body)
+	}
+	cookie := login.Result().Cookies()[0]
+
+	// A viewer endpoint and a front-desk endpoint behind the session middleware
+	handler := func(role string) http.Handler {
+		return authenticateAdmin(p.store, "api secret")(requireRole(role)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, currentAdmin(r).Username)
+		})))
+	}
+	tests := []struct {
+		name     string
+		method   string
+		role     string
+		cookie   *http.Cookie
+		header   map[string]string
+		wantCode int
+		wantBody string
+	}{
+		{"no credentials", http.MethodGet, adminauth.RoleViewer, nil, nil, http.StatusUnauthorized, ""},
+		{"session cookie", http.MethodGet, adminauth.RoleViewer, cookie, nil, http.StatusOK, "ada"},
+		{"unknown session", http.MethodGet, adminauth.RoleViewer, &http.Cookie{Name: sessionCookie, Value: "forged"}, nil, http.StatusUnauthorized, ""},
+		{"role too low", http.MethodPost, adminauth.RoleFrontDesk, cookie, nil, http.StatusForbidden, ""},
+		{"same-origin POST", http.MethodPost, adminauth.RoleViewer, cookie, map[string]string{"Origin": "http://example.com"}, http.StatusOK, "ada"},
+		{"cross-site POST", http.MethodPost, adminauth.RoleViewer, cookie, map[string]string{"Origin": "http://evil.example"}, http.StatusForbidden, ""},
+		{"API token", http.MethodPost, adminauth.RoleAdmin, nil, map[string]string{"Authorization": "Bearer api secret"}, http.StatusOK, apiTokenUser},
+		{"wrong API token", http.MethodGet, adminauth.RoleViewer, cookie, map[string]string{"Authorization": "Bearer wrong"}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://example.com/api/admin/test", nil)
+		if tt.cookie != nil {
+			r.AddCookie(tt.cookie)
+		}
+		for name, value := range tt.header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler(tt.role).ServeHTTP(w, r)
+		if w.Code != tt.wantCode || (tt.wantBody != "" && w.Body.String() != tt.wantBody) {
+			t.Errorf("%s: got %d %s, want %d %s", tt.name, w.Code, w.Body, tt.wantCode, tt.wantBody)
+		}
+	}
+
+	// Signing out everywhere ends the session
+	if err := p.store.DeleteAdminSessions("ada"); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/admin/test", nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	handler(adminauth.RoleViewer).ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("after signing out: got %d %s", w.Code, w.Body)
+	}
+}
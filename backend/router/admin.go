@@ -0,0 +1,337 @@
+package router
+
+import (
+	"backend/adminauth"
+	"backend/authorization"
+	"backend/config"
+	"backend/db"
+	"backend/lockout"
+	"backend/passphrase"
+	"backend/radius"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// GuestActionResponse represents the JSON body returned by the guest management endpoints.
+type GuestActionResponse struct {
+	MAC       string `json:"mac"`                 // MAC address of the guest client.
+	Status    string `json:"status"`              // Session status after the action.
+	Duration  int    `json:"duration,omitempty"`  // Total authorized duration in minutes (extend only).
+	ExpiresAt string `json:"expiresAt,omitempty"` // New expiry time in RFC3339 format (extend only).
+}
+
+// setupAdminRoutes registers the admin login and the authenticated guest management endpoints.
+//
+// Parameters:
+// - r: Router to register the routes on.
+// - store: Shared database.
+// - client: Shared UniFi controller client.
+// - cfg: Configuration object.
+// - passphrases: Shared passphrase of the passphrase mode, or nil in other modes.
+// - accounting: RADIUS client notified of revoked and extended guests in radius mode, or nil in other modes.
+//
+// Every endpoint but the login requires a session cookie from POST /api/admin/login, or
+// the ADMIN_API_TOKEN as a bearer token (admin role). The role each endpoint requires is
+// given in brackets; a role includes the ones listed before it (viewer, front-desk, admin).
+//
+// Routes:
+// - POST /api/admin/login: Checks a username and password and sets the session cookie.
+// - POST /api/admin/logout: Ends the login session [viewer].
+// - GET /api/admin/me: Shows the signed-in account [viewer].
+// - PUT /api/admin/me/password: Changes the signed-in account's password [viewer].
+// - GET /api/admin/sessions: Lists sessions, filtered and paginated by query parameters (see parseSessionFilter) [viewer].
+// - GET /api/admin/sessions/stats: Aggregate counts over the sessions matching the same filters [viewer].
+// - GET /api/admin/sessions/export: Downloads the sessions matching the same filters as CSV, JSON or NDJSON [viewer].
+// - GET /api/admin/sessions/{cacheId}: Shows a single session [viewer].
+// - POST /api/admin/sessions/{cacheId}/revoke: Revokes the authorization of an active session [front-desk].
+// - POST /api/admin/sessions/{cacheId}/extend: Extends the authorization of an active session [front-desk].
+// - GET /api/admin/settings: Describes the running configuration, without secrets [viewer].
+// - POST /api/admin/guests/{mac}/unauthorize: Revokes the guest's authorization [front-desk].
+// - POST /api/admin/guests/{mac}/extend: Extends the guest's authorization [front-desk].
+// - POST /api/admin/guests/{mac}/kick: Disconnects the client [front-desk].
+// - POST /api/admin/vouchers: Generates a batch of vouchers [front-desk].
+// - GET /api/admin/vouchers: Lists vouchers, optionally filtered by `?batch=` [viewer].
+// - GET /api/admin/vouchers/sheet: Renders a printable voucher sheet for `?batch=` [viewer].
+// - GET /api/admin/passphrase: Shows the current passphrase (passphrase mode only) [front-desk].
+// - PUT /api/admin/passphrase: Sets a new passphrase, kept across restarts (passphrase mode only) [admin].
+// - POST /api/admin/passphrase/rotate: Replaces the passphrase with a random one (passphrase mode only) [admin].
+// - GET /api/admin/accounts: Lists the admin accounts [admin].
+// - POST /api/admin/accounts: Creates an admin account [admin].
+// - PUT /api/admin/accounts/{username}: Changes the role or resets the password of an account [admin].
+// - DELETE /api/admin/accounts/{username}: Deletes an account [admin].
+// - GET /api/admin/retention: Reports what the retention policy would purge now (dry run) [admin].
+// - POST /api/admin/retention/purge: Purges the data past the retention period now [admin].
+// - GET /api/admin/subjects: Exports every record about the guest in `?email=` and/or `?mac=` [admin].
+// - POST /api/admin/subjects/erase: Deletes or anonymises every record about a guest [admin].
+// - GET /api/admin/audit: Lists the audit log, paginated by `?limit=` and `?offset=` [admin].
+// - GET /api/admin/audit/verify: Checks the hash chain of the audit log [admin].
+func setupAdminRoutes(r chi.Router, store db.Storage, client *authorization.Client, cfg config.Config, passphrases *passphrase.Manager, accounting *radius.Client) {
+	// Another portal sharing the database may have created the account first
+	if err := adminauth.Bootstrap(store, cfg.AdminBootstrapUsername, cfg.AdminBootstrapPassword); err != nil && !errors.Is(err, db.ErrAdminExists) {
+		log.Fatal(err)
+	}
+	if count, err := store.CountAdmins(""); err == nil && count == 0 && cfg.AdminToken == "" {
+		fmt.Println("No admin accounts exist. Create one with `app admin create <username>` or ADMIN_BOOTSTRAP_USERNAME.")
+	}
+
+	limiter := lockout.New(adminMaxAttempts, adminLockout)
+	lifetime := time.Duration(cfg.AdminSessionHours) * time.Hour
+
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Post("/login", func(w http.ResponseWriter, r *http.Request) {
+			handleAdminLogin(w, r, store, limiter, lifetime, cfg.AdminCookieSecure)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(authenticateAdmin(store, cfg.AdminToken))
+
+			r.Post("/logout", func(w http.ResponseWriter, r *http.Request) {
+				handleAdminLogout(w, r, store, cfg.AdminCookieSecure)
+			})
+			r.Get("/me", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, currentAdmin(r))
+			})
+			r.Put("/me/password", func(w http.ResponseWriter, r *http.Request) {
+				handleChangeOwnPassword(w, r, store)
+			})
+
+			r.Get("/sessions", func(w http.ResponseWriter, r *http.Request) {
+				handleListSessions(w, r, store)
+			})
+			r.Get("/sessions/stats", func(w http.ResponseWriter, r *http.Request) {
+				handleSessionStats(w, r, store)
+			})
+			r.Get("/sessions/export", func(w http.ResponseWriter, r *http.Request) {
+				handleExportSessions(w, r, store)
+			})
+			r.Get("/sessions/{cacheId}", func(w http.ResponseWriter, r *http.Request) {
+				handleGetSession(w, r, store)
+			})
+			r.Get("/settings", func(w http.ResponseWriter, r *http.Request) {
+				handleSettings(w, cfg)
+			})
+			r.Get("/vouchers", func(w http.ResponseWriter, r *http.Request) {
+				handleListVouchers(w, r, store)
+			})
+			r.Get("/vouchers/sheet", func(w http.ResponseWriter, r *http.Request) {
+				handleVoucherSheet(w, r, store)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(requireRole(adminauth.RoleFrontDesk))
+
+				r.Post("/sessions/{cacheId}/revoke", func(w http.ResponseWriter, r *http.Request) {
+					handleRevokeSession(w, r, store, client, accounting)
+				})
+				r.Post("/sessions/{cacheId}/extend", func(w http.ResponseWriter, r *http.Request) {
+					handleExtendSession(w, r, store, client, accounting)
+				})
+
+				r.Post("/guests/{mac}/unauthorize", func(w http.ResponseWriter, r *http.Request) {
+					handleGuestAction(w, r, store, client.Site(), db.StatusRevoked, func(mac string) error {
+						return revokeGuest(store, client, accounting, mac)
+					})
+				})
+				r.Post("/guests/{mac}/kick", func(w http.ResponseWriter, r *http.Request) {
+					handleGuestAction(w, r, store, client.Site(), db.StatusKicked, client.KickClient)
+				})
+				r.Post("/guests/{mac}/extend", func(w http.ResponseWriter, r *http.Request) {
+					handleGuestExtension(w, r, store, client, accounting)
+				})
+
+				r.Post("/vouchers", func(w http.ResponseWriter, r *http.Request) {
+					handleCreateVouchers(w, r, store, cfg.Duration)
+				})
+
+				if passphrases != nil {
+					r.Get("/passphrase", func(w http.ResponseWriter, r *http.Request) {
+						writePassphrase(w, passphrases)
+					})
+				}
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(requireRole(adminauth.RoleAdmin))
+
+				if passphrases != nil {
+					r.Put("/passphrase", func(w http.ResponseWriter, r *http.Request) {
+						handleSetPassphrase(w, r, passphrases)
+					})
+					r.Post("/passphrase/rotate", func(w http.ResponseWriter, r *http.Request) {
+						handleRotatePassphrase(w, passphrases)
+					})
+				}
+
+				r.Get("/accounts", func(w http.ResponseWriter, r *http.Request) {
+					handleListAccounts(w, store)
+				})
+				r.Post("/accounts", func(w http.ResponseWriter, r *http.Request) {
+					handleCreateAccount(w, r, store)
+				})
+				r.Put("/accounts/{username}", func(w http.ResponseWriter, r *http.Request) {
+					handleUpdateAccount(w, r, store)
+				})
+				r.Delete("/accounts/{username}", func(w http.ResponseWriter, r *http.Request) {
+					handleDeleteAccount(w, r, store)
+				})
+
+				r.Get("/retention", func(w http.ResponseWriter, r *http.Request) {
+					handleRetentionReport(w, store, cfg.Retention)
+				})
+				r.Post("/retention/purge", func(w http.ResponseWriter, r *http.Request) {
+					handleRetentionPurge(w, r, store, cfg.Retention)
+				})
+
+				r.Get("/subjects", func(w http.ResponseWriter, r *http.Request) {
+					handleExportSubject(w, r, store)
+				})
+				r.Post("/subjects/erase", func(w http.ResponseWriter, r *http.Request) {
+					handleEraseSubject(w, r, store)
+				})
+				r.Get("/audit", func(w http.ResponseWriter, r *http.Request) {
+					handleListAuditEntries(w, r, store)
+				})
+				r.Get("/audit/verify", func(w http.ResponseWriter, r *http.Request) {
+					handleVerifyAuditLog(w, store)
+				})
+			})
+		})
+	})
+}
+
+// controllerRequestError is the error of admin requests whose controller command failed.
+var controllerRequestError = APIError{
+	Code:      ErrCodeControllerUnreachable,
+	Message:   "The UniFi controller request failed. Please try again in a moment.",
+	Retryable: true,
+}
+
+// handleGuestAction runs a controller command against the guest in the `{mac}` URL
+// parameter and records the resulting status on the guest's latest session.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - site: UniFi site the guest is authorized on.
+// - status: Session status to record once the command succeeds.
+// - action: Controller command to run with the guest's MAC address.
+func handleGuestAction(w http.ResponseWriter, r *http.Request, store db.Storage, site string, status string, action func(clientMAC string) error) {
+	mac, ok := parseMACParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := action(mac); err != nil {
+		fmt.Println(err)
+		writeError(w, http.StatusBadGateway, controllerRequestError)
+		return
+	}
+
+	recordSessionUpdate(mac, store.UpdateSessionStatus(mac, site, status))
+	writeJSON(w, http.StatusOK, GuestActionResponse{MAC: mac, Status: status})
+}
+
+// handleGuestExtension extends the authorization of the guest in the `{mac}` URL parameter.
+func handleGuestExtension(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client, accounting *radius.Client) {
+	mac, ok := parseMACParam(w, r)
+	if !ok {
+		return
+	}
+
+	duration, expiresAt, err := extendGuest(store, client, accounting, mac)
+	if errors.Is(err, errGuestNotAuthorized) {
+		writeError(w, http.StatusNotFound, APIError{Code: ErrCodeNotFound, Message: "The guest is not authorized on the controller."})
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		writeError(w, http.StatusBadGateway, controllerRequestError)
+		return
+	}
+
+	recordSessionUpdate(mac, store.ExtendSession(mac, client.Site(), duration, expiresAt))
+	writeJSON(w, http.StatusOK, GuestActionResponse{
+		MAC:       mac,
+		Status:    db.StatusExtended,
+		Duration:  duration,
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// errGuestNotAuthorized is returned by extendGuest when the controller has no running
+// authorization for the client.
+var errGuestNotAuthorized = errors.New("guest is not authorized")
+
+// extendGuest extends the authorization of a guest on the controller.
+//
+// The controller extends a guest by its original duration, so the guest is looked up
+// again afterwards to learn the new total duration.
+//
+// Returns:
+// - int: New total duration of the authorization in minutes.
+// - time.Time: New expiry time of the authorization.
+// - error: errGuestNotAuthorized, or an error if a controller request failed.
+func extendGuest(store db.Storage, client *authorization.Client, accounting *radius.Client, mac string) (int, time.Time, error) {
+	guest, err := client.Guest(mac)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if guest == nil {
+		return 0, time.Time{}, errGuestNotAuthorized
+	}
+
+	if err := client.ExtendGuest(guest.ID); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	extended, err := client.Guest(mac)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to read extended guest: %v", err)
+	}
+	if extended == nil {
+		return 0, time.Time{}, fmt.Errorf("failed to read extended guest: guest not found")
+	}
+
+	expiresAt := time.Unix(extended.End, 0)
+	extendAccounting(store, accounting, mac, expiresAt)
+	return int((extended.End - extended.Start) / 60), expiresAt, nil
+}
+
+// revokeGuest revokes the authorization of a guest on the controller and stops its RADIUS
+// accounting sessions.
+func revokeGuest(store db.Storage, client *authorization.Client, accounting *radius.Client, mac string) error {
+	if err := client.UnauthorizeGuest(mac); err != nil {
+		return err
+	}
+	stopAccounting(store, accounting, mac, radius.TerminateAdminReset)
+	return nil
+}
+
+// parseMACParam reads the `{mac}` URL parameter and normalises it to the lowercase,
+// colon-separated form used by the controller. It writes a 400 response and returns
+// false if the parameter is not a valid MAC address.
+func parseMACParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	hw, err := net.ParseMAC(chi.URLParam(r, "mac"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: "Invalid MAC address."})
+		return "", false
+	}
+	return strings.ToLower(hw.String()), true
+}
+
+// recordSessionUpdate logs the outcome of a session update. The controller action has
+// already succeeded at this point, so a missing or failed database row does not fail the request.
+func recordSessionUpdate(mac string, err error) {
+	if errors.Is(err, db.ErrNoSession) {
+		fmt.Printf("No session recorded for %s, database not updated\n", mac)
+	} else if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,99 @@
+package router
+
+import (
+	"backend/db"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// guestAction posts to a guest endpoint with the `{mac}` URL parameter.
+func (p *adminPortal) guestAction(action, mac string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := withURLParam(httptest.NewRequest(http.MethodPost, "/api/admin/guests/"+mac+"/"+action, nil), "mac", mac)
+	switch action {
+	case "unauthorize":
+		handleGuestAction(w, r, p.store, p.client.Site(), db.StatusRevoked, func(mac string) error {
+			return revokeGuest(p.store, p.client, nil, mac)
+		})
+	case "kick":
+		handleGuestAction(w, r, p.store, p.client.Site(), db.StatusKicked, p.client.KickClient)
+	case "extend":
+		handleGuestExtension(w, r, p.store, p.client, nil)
+	}
+	return w
+}
+
+func TestGuestExtension(t *testing.T) {
+	p := newAdminPortal(t)
+	p.authorize(t, "s1", "aa:bb:cc:dd:ee:01")
+	guest, err := p.client.Guest("aa:bb:cc:dd:ee:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := p.guestAction("extend", "AA:BB:CC:DD:EE:01")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if extensions := p.controller.received("extend"); len(extensions) != 1 || extensions[0]["_id"] != guest.ID {
+		t.Errorf("controller got %v, want an extension of %s", extensions, guest.ID)
+	}
+
+	// The new total is read back from the controller
+	var response GuestActionResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	wantExpiry := time.Unix(guest.Start, 0).Add(120 * time.Minute).UTC().Format(time.RFC3339)
+	if response.MAC != "aa:bb:cc:dd:ee:01" || response.Status != db.StatusExtended || response.Duration != 120 || response.ExpiresAt != wantExpiry {
+		t.Errorf("got %+v, want 120 minutes until %s", response, wantExpiry)
+	}
+	session, err := p.store.GetSession("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Status != db.StatusExtended || session.Duration != 120 || session.ExpiresAt.Format(time.RFC3339) != wantExpiry {
+		t.Errorf("recorded session: got %+v", session)
+	}
+}
+
+func TestGuestActions(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     string
+		mac        string
+		authorized bool // Whether the guest is authorized on the controller.
+		failWith   int  // Status of the controller's stamgr answers, or 0.
+		wantCode   int
+		wantError  string
+		wantStatus string // Recorded session status.
+	}{
+		{"unauthorize", "unauthorize", "aa:bb:cc:dd:ee:01", true, 0, http.StatusOK, "", db.StatusRevoked},
+		{"kick", "kick", "aa-bb-cc-dd-ee-01", true, 0, http.StatusOK, "", db.StatusKicked},
+		{"invalid MAC", "kick", "not-a-mac", true, 0, http.StatusBadRequest, ErrCodeInvalidRequest, db.StatusAuthorized},
+		{"extend unknown guest", "extend", "aa:bb:cc:dd:ee:01", false, 0, http.StatusNotFound, ErrCodeNotFound, db.StatusAuthorized},
+		{"unauthorize failing", "unauthorize", "aa:bb:cc:dd:ee:01", true, http.StatusInternalServerError, http.StatusBadGateway, ErrCodeControllerUnreachable, db.StatusAuthorized},
+		{"kick failing", "kick", "aa:bb:cc:dd:ee:01", true, http.StatusInternalServerError, http.StatusBadGateway, ErrCodeControllerUnreachable, db.StatusAuthorized},
+		{"extend failing", "extend", "aa:bb:cc:dd:ee:01", true, http.StatusInternalServerError, http.StatusBadGateway, ErrCodeControllerUnreachable, db.StatusAuthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newAdminPortal(t)
+			if tt.authorized {
+				p.authorize(t, "s1", "aa:bb:cc:dd:ee:01")
+			} else if err := p.store.WriteSession(db.Session{CacheID: "s1", ID: "aa:bb:cc:dd:ee:01", Duration: 60}); err != nil {
+				t.Fatal(err)
+			}
+			p.controller.failWith = tt.failWith
+
+			w := p.guestAction(tt.action, tt.mac)
+			if w.Code != tt.wantCode || errorCode(w) != tt.wantError {
+				t.Errorf("got %d %s, want %d %q", w.Code, w.Body, tt.wantCode, tt.wantError)
+			}
+			if session, err := p.store.GetSession("s1"); err != nil || session.Status != tt.wantStatus {
+				t.Errorf("recorded session: got %+v, %v, want status %s", session, err, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package router
+
+import (
+	"backend/cache"
+	"backend/db"
+	"backend/terms"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ConsentFields holds the consent given with a guest login request.
+type ConsentFields struct {
+	AcceptTerms      bool   `json:"acceptTerms"`      // Whether the guest ticked the terms of service checkbox.
+	TermsVersion     string `json:"termsVersion"`     // Version of the terms shown to the guest.
+	MarketingConsent bool   `json:"marketingConsent"` // Whether the guest opted in to marketing email.
+}
+
+// TermsResponse represents the JSON body returned by GET /api/terms.
+type TermsResponse struct {
+	Required         bool   `json:"required"`           // Whether guests must accept terms of service.
+	Version          string `json:"version,omitempty"`  // Version of the terms, to send back with the acceptance.
+	Markdown         string `json:"markdown,omitempty"` // Text of the terms in Markdown.
+	MarketingConsent bool   `json:"marketingConsent"`   // Whether guests are offered to opt in to marketing email.
+}
+
+// consentPolicy describes what guests consent to before logging in.
+type consentPolicy struct {
+	terms     *terms.Document // Terms every guest must accept, or nil if none are configured.
+	marketing bool            // Whether guests are offered to opt in to marketing email.
+}
+
+// response describes the policy for the portal page.
+func (p *consentPolicy) response() TermsResponse {
+	response := TermsResponse{Required: p.terms != nil, MarketingConsent: p.marketing}
+	if p.terms != nil {
+		response.Version, response.Markdown = p.terms.Version, p.terms.Markdown
+	}
+	return response
+}
+
+// check validates the consent of a login request against the policy. It returns nil if
+// the login may proceed, or the status code and error to report to the guest.
+func (p *consentPolicy) check(consent ConsentFields) (int, *APIError) {
+	if p.terms == nil {
+		return 0, nil
+	}
+	if !consent.AcceptTerms {
+		return http.StatusBadRequest, &APIError{
+			Code:    ErrCodeTermsNotAccepted,
+			Message: "Please accept the terms of use to get online.",
+		}
+	}
+	if consent.TermsVersion != p.terms.Version {
+		return http.StatusConflict, &APIError{
+			Code:    ErrCodeTermsChanged,
+			Message: "The terms of use have changed. Please read and accept the new version.",
+		}
+	}
+	return 0, nil
+}
+
+// pending returns the consent of a login, to record once the guest gets access (see
+// recordConsent). It returns nil when there are no terms and the guest did not opt in to
+// marketing email, as there is nothing to record.
+//
+// Parameters:
+// - r: HTTP request of the login, whose remote address is recorded.
+// - cacheId: Cache identifier of the login.
+// - cacheInfo: Cache entry holding the client and AP MAC addresses.
+// - consent: Consent sent with the request, already checked.
+// - email: Email address entered with the request, if any.
+func (p *consentPolicy) pending(r *http.Request, cacheId string, cacheInfo *cache.LoginCache, consent ConsentFields, email string) *db.Consent {
+	marketing := p.marketing && consent.MarketingConsent
+	if p.terms == nil && !marketing {
+		return nil
+	}
+
+	record := &db.Consent{
+		CacheID:    cacheId,
+		ID:         cacheInfo.ID,
+		AP:         cacheInfo.AP,
+		IP:         remoteIP(r),
+		Email:      email,
+		Marketing:  marketing,
+		AcceptedAt: time.Now(),
+	}
+	if p.terms != nil {
+		record.TermsVersion = p.terms.Version
+	}
+	return record
+}
+
+// checkConsent checks the consent of a login request before its credentials, so a guest
+// who did not accept the terms learns it first. If the consent is missing, it writes an
+// error response and returns false.
+func checkConsent(w http.ResponseWriter, consents *consentPolicy, consent ConsentFields) bool {
+	if status, apiErr := consents.check(consent); apiErr != nil {
+		writeError(w, status, *apiErr)
+		return false
+	}
+	return true
+}
+
+// writeConsent records the consent of a login that gets access. A nil consent is not
+// recorded (see consentPolicy.pending).
+func writeConsent(store db.Storage, consent *db.Consent) error {
+	if consent == nil {
+		return nil
+	}
+	return store.WriteConsent(*consent)
+}
+
+// recordConsent records the consent of a login that gets access. If it cannot be
+// recorded, it writes an error response and returns false, so no guest gets access
+// without their consent on record.
+func recordConsent(w http.ResponseWriter, store db.Storage, consent *db.Consent) bool {
+	if err := writeConsent(store, consent); err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return false
+	}
+	return true
+}
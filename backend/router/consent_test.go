@@ -0,0 +1,140 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/cache"
+	"backend/db"
+	"backend/passphrase"
+	"backend/terms"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsentIsRecordedOnlyOnAccess(t *testing.T) {
+	p := newEmailPortal(t)
+	passphrases, err := passphrase.NewManager("open sesame", 5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consents := &consentPolicy{marketing: true}
+	cacheId := cache.AddToCache("aa:bb:cc:dd:ee:20", "11:22:33:44:55:66")
+
+	login := func(phrase string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"cacheId":%q,"username":"Ada","email":"ada@example.com","passphrase":%q,"marketingConsent":true}`, cacheId, phrase)
+		w := httptest.NewRecorder()
+		handleGuestAuthorization(w, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)),
+			p.store, p.client, 480, authorization.Limits{}, passphrases, nil, consents, nil)
+		return w
+	}
+
+	if w := login("wrong"); w.Code != http.StatusForbidden || errorCode(w) != ErrCodeInvalidPassphrase {
+		t.Fatalf("wrong passphrase: got %d %s", w.Code, w.Body)
+	}
+	if _, err := p.store.GetConsent(cacheId); !errors.Is(err, db.ErrNoConsent) {
+		t.Errorf("consent after a wrong passphrase: got %v, want none", err)
+	}
+
+	if w := login("open sesame"); w.Code != http.StatusSeeOther {
+		t.Fatalf("right passphrase: got %d %s", w.Code, w.Body)
+	}
+	if consent, err := p.store.GetConsent(cacheId); err != nil || !consent.Marketing || consent.Email != "ada@example.com" {
+		t.Errorf("consent after login: got %+v, %v", consent, err)
+	}
+}
+
+func TestConsentIsNotRecordedForInvalidVoucher(t *testing.T) {
+	p := newEmailPortal(t)
+	cacheId := cache.AddToCache("aa:bb:cc:dd:ee:21", "11:22:33:44:55:66")
+
+	body := fmt.Sprintf(`{"cacheId":%q,"voucher":"NOPE-NOPE","marketingConsent":true}`, cacheId)
+	w := httptest.NewRecorder()
+	handleVoucherLogin(w, httptest.NewRequest(http.MethodPost, "/api/login/voucher", strings.NewReader(body)),
+		p.store, p.client, authorization.Limits{}, &consentPolicy{marketing: true})
+
+	if w.Code != http.StatusForbidden || errorCode(w) != ErrCodeInvalidVoucher {
+		t.Fatalf("invalid voucher: got %d %s", w.Code, w.Body)
+	}
+	if _, err := p.store.GetConsent(cacheId); !errors.Is(err, db.ErrNoConsent) {
+		t.Errorf("consent after an invalid voucher: got %v, want none", err)
+	}
+}
+
+func TestConsentIsCheckedBeforeCredentials(t *testing.T) {
+	p := newEmailPortal(t)
+	passphrases, err := passphrase.NewManager("open sesame", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consents := &consentPolicy{terms: &terms.Document{Version: "v2"}}
+	cacheId := cache.AddToCache("aa:bb:cc:dd:ee:22", "11:22:33:44:55:66")
+
+	login := func(phrase string, accept bool) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"cacheId":%q,"username":"Ada","passphrase":%q,"acceptTerms":%v,"termsVersion":"v2"}`, cacheId, phrase, accept)
+		w := httptest.NewRecorder()
+		handleGuestAuthorization(w, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)),
+			p.store, p.client, 480, authorization.Limits{}, passphrases, nil, consents, nil)
+		return w
+	}
+
+	// The wrong passphrase is not tried, so it does not count towards the lockout
+	if w := login("wrong", false); w.Code != http.StatusBadRequest || errorCode(w) != ErrCodeTermsNotAccepted {
+		t.Fatalf("terms not accepted: got %d %s", w.Code, w.Body)
+	}
+	if w := login("open sesame", true); w.Code != http.StatusSeeOther {
+		t.Fatalf("login: got %d %s", w.Code, w.Body)
+	}
+	if consent, err := p.store.GetConsent(cacheId); err != nil || consent.TermsVersion != "v2" {
+		t.Errorf("consent after login: got %+v, %v", consent, err)
+	}
+}
+
+func TestEmailConsentIsRecordedOnVerification(t *testing.T) {
+	p := newEmailPortal(t)
+	cacheId := cache.AddToCache("aa:bb:cc:dd:ee:23", "11:22:33:44:55:66")
+
+	body := fmt.Sprintf(`{"cacheId":%q,"username":"Ada","email":"ada@example.com","marketingConsent":true}`, cacheId)
+	w := httptest.NewRecorder()
+	handleEmailLogin(w, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)),
+		p.store, p.client, p.sender, 10, 0, authorization.Limits{}, &consentPolicy{marketing: true}, nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("login: got %d %s", w.Code, w.Body)
+	}
+	if _, err := p.store.GetConsent(cacheId); !errors.Is(err, db.ErrNoConsent) {
+		t.Errorf("consent before verification: got %v, want none", err)
+	}
+
+	if w := p.verify(cacheId, p.sink.code(t)); w.Code != http.StatusSeeOther {
+		t.Fatalf("verify: got %d %s", w.Code, w.Body)
+	}
+	if consent, err := p.store.GetConsent(cacheId); err != nil || !consent.Marketing || consent.Email != "ada@example.com" || consent.ID != "aa:bb:cc:dd:ee:23" {
+		t.Errorf("consent after verification: got %+v, %v", consent, err)
+	}
+}
+
+func TestSponsorConsentIsRecordedOnApproval(t *testing.T) {
+	p := newSponsorPortal(t)
+	cacheId := cache.AddToCache("aa:bb:cc:dd:ee:24", "11:22:33:44:55:66")
+
+	body := fmt.Sprintf(`{"cacheId":%q,"username":"Ada","email":"ada@example.com","sponsor":"alice@example.com","marketingConsent":true}`, cacheId)
+	w := httptest.NewRecorder()
+	handleSponsorLogin(w, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)),
+		p.store, p.sender, p.signer, p.cfg, &consentPolicy{marketing: true}, nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("ask: got %d %s", w.Code, w.Body)
+	}
+	if _, err := p.store.GetConsent(cacheId); !errors.Is(err, db.ErrNoConsent) {
+		t.Errorf("consent before approval: got %v, want none", err)
+	}
+
+	if w := p.decide(p.approveLink(t)); w.Code != http.StatusOK {
+		t.Fatalf("approve: got %d %s", w.Code, w.Body)
+	}
+	if consent, err := p.store.GetConsent(cacheId); err != nil || !consent.Marketing || consent.Email != "ada@example.com" || consent.IP == "" {
+		t.Errorf("consent after approval: got %+v, %v", consent, err)
+	}
+}
@@ -0,0 +1,113 @@
+package router
+
+import (
+	"backend/authorization"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeController is a legacy UniFi controller that accepts every login, keeps the guest
+// authorizations it is sent and records every stamgr command.
+type fakeController struct {
+	mu       sync.Mutex
+	commands []map[string]interface{} // Payloads of the stamgr commands, in order.
+	guests   []authorization.Guest    // Guest authorizations, including revoked ones.
+	failWith int                      // HTTP status to answer stamgr commands with, or 0 to run them.
+}
+
+// newFakeController starts a fake controller and returns a client for its "default" site.
+func newFakeController(t *testing.T) (*fakeController, *authorization.Client) {
+	t.Helper()
+	controller := &fakeController{}
+	server := httptest.NewServer(http.HandlerFunc(controller.serve))
+	t.Cleanup(server.Close)
+	return controller, authorization.NewClient(server.URL, "default", "admin", "secret", false, authorization.FlavorLegacy)
+}
+
+// serve answers the login, the stamgr commands and the guest list.
+func (c *fakeController) serve(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/api/s/default/cmd/stamgr":
+		if c.failWith != 0 {
+			w.WriteHeader(c.failWith)
+			w.Write([]byte(`{"meta":{"rc":"error","msg":"api.err.Internal"},"data":[]}`))
+			return
+		}
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		c.commands = append(c.commands, payload)
+		if !c.run(payload) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"meta":{"rc":"error","msg":"api.err.InvalidObject"},"data":[]}`))
+			return
+		}
+	case "/api/s/default/stat/guest":
+		json.NewEncoder(w).Encode(map[string]interface{}{"meta": map[string]string{"rc": "ok"}, "data": c.guests})
+		return
+	}
+	w.Write([]byte(`{"meta":{"rc":"ok"},"data":[]}`))
+}
+
+// run applies a stamgr command to the guest authorizations. It returns false if the
+// command targets an unknown guest.
+func (c *fakeController) run(payload map[string]interface{}) bool {
+	mac, _ := payload["mac"].(string)
+	switch payload["cmd"] {
+	case "authorize-guest":
+		minutes := int(payload["minutes"].(float64))
+		start := time.Now().Unix()
+		c.guests = append(c.guests, authorization.Guest{
+			ID:      fmt.Sprintf("guest-%d", len(c.guests)+1),
+			MAC:     mac,
+			Start:   start,
+			End:     start + int64(minutes)*60,
+			Minutes: minutes,
+		})
+	case "unauthorize-guest":
+		for i := range c.guests {
+			if strings.EqualFold(c.guests[i].MAC, mac) {
+				c.guests[i].Expired = true
+			}
+		}
+	case "extend":
+		for i := range c.guests {
+			if c.guests[i].ID == payload["_id"] && !c.guests[i].Expired {
+				c.guests[i].End += int64(c.guests[i].Minutes) * 60
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// authorizations returns the minutes of each guest authorization received so far.
+func (c *fakeController) authorizations() []int {
+	minutes := []int{}
+	for _, payload := range c.received("authorize-guest") {
+		minutes = append(minutes, int(payload["minutes"].(float64)))
+	}
+	return minutes
+}
+
+// received returns the payloads of the stamgr commands of a kind received so far.
+func (c *fakeController) received(cmd string) []map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	payloads := []map[string]interface{}{}
+	for _, payload := range c.commands {
+		if payload["cmd"] == cmd {
+			payloads = append(payloads, payload)
+		}
+	}
+	return payloads
+}
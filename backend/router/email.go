@@ -0,0 +1,250 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/db"
+	"backend/formfields"
+	"backend/mailer"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+const (
+	// maxCodeAttempts is the number of codes a guest may enter before a code is invalidated.
+	maxCodeAttempts = 5
+
+	// resendInterval is the minimum time between two codes sent for the same login.
+	resendInterval = 30 * time.Second
+)
+
+// VerifyRequest represents the structure of the JSON body for the verification API.
+type VerifyRequest struct {
+	CacheID string `json:"cacheId"` // Cache identifier
+	Code    string `json:"code"`    // One-time code from the verification email
+}
+
+// VerificationResponse represents the JSON body returned after a verification code was sent.
+type VerificationResponse struct {
+	Status       string    `json:"status"`                 // Always "verification_required".
+	Email        string    `json:"email"`                  // Address the code was sent to.
+	ExpiresAt    time.Time `json:"expiresAt"`              // Time after which the code is no longer accepted.
+	GraceMinutes int       `json:"graceMinutes,omitempty"` // Minutes of access granted to open the mailbox.
+}
+
+// handleEmailLogin handles the POST /api/login requests in email mode by sending a
+// one-time code to the guest's email address.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - client: Shared UniFi controller client.
+// - sender: Mailer used to send the code.
+// - codeTTL: Minutes the code stays valid.
+// - grace: Minutes of access granted while the guest opens their mailbox (0 disables).
+// - limits: Bandwidth and data quota restrictions for the grace authorization.
+// - consents: Terms of service and marketing consent policy.
+// - fields: Custom form fields to validate the answers against.
+//
+// Behavior:
+// - Validates the answers to the custom form fields and the email address, and rejects logins that did not accept the current terms of service.
+// - Stores a hashed 6-digit code, the answers and the consent for the cache ID; the consent is recorded once the code is verified.
+// - Sends the code by email and, if configured, grants a short grace authorization, once per device while its code is pending.
+// - Responds with 202 Accepted and a VerificationResponse; the guest is fully authorized by POST /api/verify.
+func handleEmailLogin(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client, sender *mailer.Mailer, codeTTL, grace int, limits authorization.Limits, consents *consentPolicy, fields []formfields.Field) {
+	var req LoginRequest
+
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if !validateLogin(w, &req, true) {
+		return
+	}
+
+	cacheInfo, ok := lookupCache(w, req.CacheID)
+	if !ok {
+		return
+	}
+
+	answers, ok := checkFormFields(w, fields, req.Fields)
+	if !ok {
+		return
+	}
+
+	address, err := mail.ParseAddress(req.Email)
+	if err != nil || address.Address != req.Email {
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidEmail, Message: "Please enter a valid email address."})
+		return
+	}
+
+	if !checkConsent(w, consents, req.ConsentFields) {
+		return
+	}
+
+	if previous, err := store.GetVerification(req.CacheID); err == nil && time.Since(previous.CreatedAt) < resendInterval {
+		writeError(w, http.StatusTooManyRequests, APIError{
+			Code:    ErrCodeCodeRecentlySent,
+			Message: "A code was sent moments ago. Please check your mailbox or wait a few seconds before asking for a new one.",
+		})
+		return
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+
+	now := time.Now()
+	verification := db.EmailVerification{
+		CacheID:   req.CacheID,
+		ID:        cacheInfo.ID,
+		AP:        cacheInfo.AP,
+		Name:      req.Name,
+		Email:     address.Address,
+		CodeHash:  hashCode(req.CacheID, code),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(codeTTL) * time.Minute),
+		Fields:    answers,
+		Consent:   consents.pending(r, req.CacheID, cacheInfo, req.ConsentFields, address.Address),
+	}
+	if err := store.WriteVerification(verification); err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+
+	subject := fmt.Sprintf("%s verification code", pageTitle())
+	body := fmt.Sprintf("Your Wi-Fi verification code is: %s\n\n"+
+		"Enter this code on the portal page to connect to %s. The code expires in %d minutes.\n\n"+
+		"If you didn't request this code, you can ignore this email.\n", code, pageTitle(), codeTTL)
+	if err := sender.Send(address.Address, subject, body); err != nil {
+		fmt.Println(err)
+		store.WriteFailedAttempt(req.CacheID, cacheInfo.ID, cacheInfo.AP, req.Name, address.Address, ErrCodeEmailFailed, err.Error())
+		writeError(w, http.StatusBadGateway, APIError{
+			Code:      ErrCodeEmailFailed,
+			Message:   "We couldn't send the verification email. Please check the address or try again.",
+			Retryable: true,
+		})
+		return
+	}
+
+	// Let the guest reach their mailbox; the full authorization replaces this one. A new
+	// code does not renew it, or resending would keep an unverified guest online
+	if grace > 0 {
+		claimed, err := store.ClaimGrace(req.CacheID, cacheInfo.ID, now)
+		if err != nil {
+			fmt.Println(err)
+		}
+		if !claimed {
+			grace = 0
+		} else if err := client.AuthorizeGuestProcess(cacheInfo.ID, cacheInfo.AP, grace, limits); err != nil {
+			fmt.Println("Grace authorization failed:", err)
+			grace = 0
+		}
+	}
+
+	writeJSON(w, http.StatusAccepted, VerificationResponse{
+		Status:       "verification_required",
+		Email:        address.Address,
+		ExpiresAt:    verification.ExpiresAt,
+		GraceMinutes: grace,
+	})
+}
+
+// handleEmailVerification handles the POST /api/verify requests by checking the one-time
+// code and, if it is correct, recording the consent given with the login and authorizing
+// the guest for the full session duration.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - client: Shared UniFi controller client.
+// - duration: Session duration.
+// - limits: Bandwidth and data quota restrictions for the guest.
+func handleEmailVerification(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client, duration int, limits authorization.Limits) {
+	var req VerifyRequest
+
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	cacheInfo, ok := lookupCache(w, req.CacheID)
+	if !ok {
+		return
+	}
+
+	verification, err := store.GetVerification(req.CacheID)
+	if errors.Is(err, db.ErrNoVerification) {
+		writeError(w, http.StatusConflict, APIError{Code: ErrCodeNoVerification, Message: "Please request a verification code first."})
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+
+	if time.Now().After(verification.ExpiresAt) {
+		writeError(w, http.StatusGone, APIError{Code: ErrCodeCodeExpired, Message: "This code has expired. Please request a new one."})
+		return
+	}
+
+	// Count the attempt before comparing, so parallel requests share the same budget
+	attempts, err := store.RecordVerificationAttempt(req.CacheID, maxCodeAttempts)
+	if errors.Is(err, db.ErrTooManyAttempts) {
+		writeError(w, http.StatusTooManyRequests, APIError{Code: ErrCodeTooManyAttempts, Message: "Too many wrong codes. Please request a new one."})
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+
+	expected := []byte(verification.CodeHash)
+	provided := []byte(hashCode(req.CacheID, strings.TrimSpace(req.Code)))
+	if subtle.ConstantTimeCompare(expected, provided) != 1 {
+		store.WriteFailedAttempt(req.CacheID, cacheInfo.ID, cacheInfo.AP, verification.Name, verification.Email, ErrCodeInvalidCode, "wrong verification code")
+		writeError(w, http.StatusForbidden, APIError{
+			Code:    ErrCodeInvalidCode,
+			Message: fmt.Sprintf("The code is incorrect. %d attempt(s) left.", maxCodeAttempts-attempts),
+		})
+		return
+	}
+
+	if err := store.MarkVerified(req.CacheID); err != nil {
+		fmt.Println(err)
+	}
+
+	if !recordConsent(w, store, verification.Consent) {
+		return
+	}
+
+	completeLogin(w, r, store, client, req.CacheID, cacheInfo, db.Session{Name: verification.Name, Email: verification.Email, Duration: duration, Fields: verification.Fields}, limits)
+}
+
+// generateCode returns a random 6-digit one-time code.
+func generateCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate verification code: %v", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashCode hashes a one-time code together with its cache ID, so codes are never stored in clear.
+func hashCode(cacheId, code string) string {
+	sum := sha256.Sum256([]byte(cacheId + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,288 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/cache"
+	"backend/db"
+	"backend/mailer"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpSink is a local SMTP server that accepts every message and keeps it.
+type smtpSink struct {
+	port     int
+	messages chan string
+}
+
+// newSMTPSink starts an SMTP sink for the duration of a test.
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	sink := &smtpSink{port: listener.Addr().(*net.TCPAddr).Port, messages: make(chan string, 100)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+// serve speaks just enough SMTP for net/smtp to deliver a message.
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 sink ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch command := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 sink")
+		case command == "DATA":
+			reply("354 go ahead")
+			var message strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				message.WriteString(line)
+			}
+			s.messages <- message.String()
+			reply("250 queued")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// code waits for the next message and returns the verification code in it.
+func (s *smtpSink) code(t *testing.T) string {
+	t.Helper()
+	select {
+	case message := <-s.messages:
+		code := regexp.MustCompile(`code is: (\d{6})`).FindStringSubmatch(message)
+		if code == nil {
+			t.Fatalf("no code in message:\n%s", message)
+		}
+		return code[1]
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+		return ""
+	}
+}
+
+// emailPortal holds the dependencies of the email mode handlers under test.
+type emailPortal struct {
+	store      db.Storage
+	sink       *smtpSink
+	controller *fakeController
+	client     *authorization.Client
+	sender     *mailer.Mailer
+}
+
+// newEmailPortal sets up a SQLite database, an SMTP sink and a fake controller.
+func newEmailPortal(t *testing.T) *emailPortal {
+	t.Helper()
+	store, err := db.Open(db.DriverSQLite, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	sink := newSMTPSink(t)
+	controller, client := newFakeController(t)
+	return &emailPortal{
+		store:      store,
+		sink:       sink,
+		controller: controller,
+		client:     client,
+		sender:     mailer.New("127.0.0.1", sink.port, "", "", "portal@example.com", mailer.SecurityNone),
+	}
+}
+
+// login posts an email login for a cache ID with a grace period of 5 minutes.
+func (p *emailPortal) login(cacheId string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"cacheId":%q,"username":"Ada","email":"ada@example.com"}`, cacheId)
+	w := httptest.NewRecorder()
+	handleEmailLogin(w, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)),
+		p.store, p.client, p.sender, 10, 5, authorization.Limits{}, &consentPolicy{}, nil)
+	return w
+}
+
+// verify posts a verification code for a cache ID.
+func (p *emailPortal) verify(cacheId, code string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"cacheId":%q,"code":%q}`, cacheId, code)
+	w := httptest.NewRecorder()
+	handleEmailVerification(w, httptest.NewRequest(http.MethodPost, "/api/verify", strings.NewReader(body)),
+		p.store, p.client, 480, authorization.Limits{})
+	return w
+}
+
+// backdate moves the code of a cache ID into the past, so a new one can be requested
+// without waiting for resendInterval; expired also lets the code run out.
+func (p *emailPortal) backdate(t *testing.T, cacheId string, expired bool) {
+	t.Helper()
+	verification, err := p.store.GetVerification(cacheId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification.CreatedAt = verification.CreatedAt.Add(-time.Minute)
+	if expired {
+		verification.ExpiresAt = time.Now().Add(-time.Second)
+	}
+	if err := p.store.WriteVerification(*verification); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// errorCode returns the code of a JSON error response.
+func errorCode(w *httptest.ResponseRecorder) string {
+	var body struct {
+		Error APIError `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return body.Error.Code
+}
+
+func TestEmailVerification(t *testing.T) {
+	p := newEmailPortal(t)
+	cacheId := cache.AddToCache("aa:bb:cc:dd:ee:01", "11:22:33:44:55:66")
+
+	if w := p.login(cacheId); w.Code != http.StatusAccepted {
+		t.Fatalf("login: got %d %s", w.Code, w.Body)
+	}
+	code := p.sink.code(t)
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	w := p.verify(cacheId, wrong)
+	if w.Code != http.StatusForbidden || errorCode(w) != ErrCodeInvalidCode {
+		t.Fatalf("wrong code: got %d %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "4 attempt(s) left") {
+		t.Errorf("wrong code: got %s, want 4 attempts left", w.Body)
+	}
+
+	if w := p.verify(cacheId, code); w.Code != http.StatusSeeOther {
+		t.Fatalf("right code: got %d %s", w.Code, w.Body)
+	}
+	if got := p.controller.authorizations(); len(got) != 2 || got[0] != 5 || got[1] != 480 {
+		t.Errorf("authorizations: got %v, want grace of 5 then 480 minutes", got)
+	}
+	if session, err := p.store.GetSession(cacheId); err != nil || session.Email != "ada@example.com" {
+		t.Errorf("session: got %+v, %v", session, err)
+	}
+}
+
+func TestEmailVerificationAttemptLimit(t *testing.T) {
+	p := newEmailPortal(t)
+	cacheId := cache.AddToCache("aa:bb:cc:dd:ee:02", "11:22:33:44:55:66")
+
+	if w := p.login(cacheId); w.Code != http.StatusAccepted {
+		t.Fatalf("login: got %d %s", w.Code, w.Body)
+	}
+	code := p.sink.code(t)
+
+	// Parallel guesses must not get past the attempt limit between them
+	const guesses = 20
+	statuses := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			guess := fmt.Sprintf("%06d", i)
+			if guess == code {
+				guess = "999999"
+			}
+			statuses <- p.verify(cacheId, guess).Code
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusForbidden] != maxCodeAttempts || counts[http.StatusTooManyRequests] != guesses-maxCodeAttempts {
+		t.Errorf("statuses: got %v, want %d wrong codes and the rest rejected", counts, maxCodeAttempts)
+	}
+
+	if w := p.verify(cacheId, code); w.Code != http.StatusTooManyRequests {
+		t.Errorf("right code after the limit: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestEmailGraceOnce(t *testing.T) {
+	p := newEmailPortal(t)
+	mac := "aa:bb:cc:dd:ee:03"
+	cacheId := cache.AddToCache(mac, "11:22:33:44:55:66")
+
+	graceMinutes := func(w *httptest.ResponseRecorder) int {
+		t.Helper()
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("login: got %d %s", w.Code, w.Body)
+		}
+		p.sink.code(t)
+		var response VerificationResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.GraceMinutes
+	}
+
+	if got := graceMinutes(p.login(cacheId)); got != 5 {
+		t.Fatalf("first code: got %d grace minutes, want 5", got)
+	}
+
+	p.backdate(t, cacheId, false)
+	if got := graceMinutes(p.login(cacheId)); got != 0 {
+		t.Errorf("new code while pending: got %d grace minutes, want 0", got)
+	}
+
+	other := cache.AddToCache(mac, "11:22:33:44:55:66")
+	if got := graceMinutes(p.login(other)); got != 0 {
+		t.Errorf("new login of the same device: got %d grace minutes, want 0", got)
+	}
+
+	p.backdate(t, cacheId, true)
+	p.backdate(t, other, true)
+	if got := graceMinutes(p.login(cacheId)); got != 5 {
+		t.Errorf("new code after expiry: got %d grace minutes, want 5", got)
+	}
+
+	if got := p.controller.authorizations(); len(got) != 2 {
+		t.Errorf("authorizations: got %v, want two grace authorizations", got)
+	}
+}
@@ -0,0 +1,37 @@
+package router
+
+import (
+	"backend/db"
+	"backend/formfields"
+	"errors"
+	"net/http"
+)
+
+// FormFieldsResponse represents the JSON body returned by GET /api/fields.
+type FormFieldsResponse struct {
+	Fields []formfields.Field `json:"fields"` // Custom fields of the guest form, in display order.
+}
+
+// checkFormFields validates the answers of a login request to the custom form fields.
+// If an answer is invalid, it writes an invalid_field error naming the field and returns
+// false.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - fields: Custom fields of the guest form, or nil in modes without the guest form.
+// - values: Answers sent with the request.
+//
+// Returns the answers to store with the session.
+func checkFormFields(w http.ResponseWriter, fields []formfields.Field, values map[string]interface{}) (db.FieldValues, bool) {
+	answers, err := formfields.Validate(fields, values)
+	if err != nil {
+		apiErr := APIError{Code: ErrCodeInvalidField, Message: err.Error()}
+		var invalid *formfields.ValidationError
+		if errors.As(err, &invalid) {
+			apiErr.Message, apiErr.Field = invalid.Message, invalid.Field
+		}
+		writeError(w, http.StatusBadRequest, apiErr)
+		return nil, false
+	}
+	return answers, true
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/cache"
+	"backend/config"
+	"backend/db"
+	"backend/ldap"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// staffDirectory checks credentials in ldap mode and maps the user's groups to a session policy.
+type staffDirectory struct {
+	directory *ldap.Directory      // Directory the credentials are checked against.
+	policies  []config.GroupPolicy // Session policies by group; the first matching group applies.
+}
+
+// authenticate checks the credentials of a login request. It writes an ErrorResponse and
+// returns false if they are rejected or the directory cannot be reached.
+func (s *staffDirectory) authenticate(w http.ResponseWriter, store db.Storage, req LoginRequest, cacheInfo *cache.LoginCache) (*ldap.User, bool) {
+	user, err := s.directory.Authenticate(req.Name, req.Password)
+	if errors.Is(err, ldap.ErrInvalidCredentials) {
+		store.WriteFailedAttempt(req.CacheID, cacheInfo.ID, cacheInfo.AP, req.Name, req.Email, ErrCodeInvalidCredentials, err.Error())
+		writeError(w, http.StatusForbidden, APIError{
+			Code:    ErrCodeInvalidCredentials,
+			Message: "The username or password is incorrect.",
+		})
+		return nil, false
+	} else if err != nil {
+		fmt.Println(err)
+		store.WriteFailedAttempt(req.CacheID, cacheInfo.ID, cacheInfo.AP, req.Name, req.Email, ErrCodeAuthServerUnreachable, err.Error())
+		writeError(w, http.StatusBadGateway, APIError{
+			Code:      ErrCodeAuthServerUnreachable,
+			Message:   "The login service is not responding right now. Please try again in a moment.",
+			Retryable: true,
+		})
+		return nil, false
+	}
+	return user, true
+}
+
+// policy returns the session duration and limits of a user: those of the first policy
+// whose group the user is a member of, or the defaults if none matches.
+func (s *staffDirectory) policy(user *ldap.User, duration int, limits authorization.Limits) (int, authorization.Limits) {
+	for _, policy := range s.policies {
+		for _, group := range user.Groups {
+			if !groupMatches(group, policy.Group) {
+				continue
+			}
+			if policy.Duration > 0 {
+				duration = policy.Duration
+			}
+			return duration, authorization.Limits{Up: policy.Up, Down: policy.Down, Bytes: policy.Quota}
+		}
+	}
+	return duration, limits
+}
+
+// groupMatches reports whether a group DN matches a configured group, given either as a
+// full DN or as the group's CN. Matching is case-insensitive.
+func groupMatches(dn, group string) bool {
+	dn, group = strings.ToLower(dn), strings.ToLower(group)
+	if strings.Contains(group, "=") {
+		return dn == group
+	}
+	return dn == "cn="+group || strings.HasPrefix(dn, "cn="+group+",")
+}
@@ -0,0 +1,178 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/cache"
+	"backend/db"
+	"backend/oidc"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OIDCInfoResponse represents the JSON body describing the OpenID Connect sign-in.
+type OIDCInfoResponse struct {
+	Provider     string   `json:"provider"`     // Provider name shown on the sign-in button.
+	WalledGarden []string `json:"walledGarden"` // Hosts to allow in the controller's pre-authorization access list.
+}
+
+// handleOIDCStart handles the GET /api/oidc/start requests by redirecting the guest to
+// the provider's sign-in page.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request, with the `cacheId` and the `acceptTerms`, `termsVersion` and `marketingConsent` consent parameters.
+// - provider: OpenID Connect provider.
+// - consents: Terms of service and marketing consent policy.
+//
+// Behavior:
+// - Rejects sign-ins that did not accept the current terms of service.
+// - Generates a state, nonce and PKCE code verifier and stores them in the guest's cache entry, with the consent to record once the sign-in succeeds.
+// - The state starts with the cache ID, so the callback can find the entry it is bound to.
+// - On failure, redirects back to the portal with an `error` code the front-end reports.
+func handleOIDCStart(w http.ResponseWriter, r *http.Request, provider *oidc.Provider, consents *consentPolicy) {
+	query := r.URL.Query()
+	cacheId := query.Get("cacheId")
+	cacheInfo := cache.GetRecord(cacheId)
+	if cacheInfo == nil {
+		redirectWithError(w, r, "", ErrCodeSessionExpired)
+		return
+	}
+
+	consent := ConsentFields{
+		AcceptTerms:      query.Get("acceptTerms") == "true",
+		TermsVersion:     query.Get("termsVersion"),
+		MarketingConsent: query.Get("marketingConsent") == "true",
+	}
+	if _, apiErr := consents.check(consent); apiErr != nil {
+		redirectWithError(w, r, cacheId, apiErr.Code)
+		return
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		value, err := oidc.RandomString()
+		if err != nil {
+			fmt.Println(err)
+			redirectWithError(w, r, cacheId, ErrCodeInternal)
+			return
+		}
+		secrets[i] = value
+	}
+	state := cache.OAuthState{State: cacheId + "." + secrets[0], Nonce: secrets[1], Verifier: secrets[2],
+		Consent: consents.pending(r, cacheId, cacheInfo, consent, "")}
+
+	target, err := provider.AuthCodeURL(state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		fmt.Println(err)
+		redirectWithError(w, r, cacheId, ErrCodeProviderUnreachable)
+		return
+	}
+	if !cache.SetOAuthState(cacheId, &state) {
+		redirectWithError(w, r, "", ErrCodeSessionExpired)
+		return
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleOIDCCallback handles the GET /api/oidc/callback requests the provider redirects
+// the guest to after signing in.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - client: Shared UniFi controller client.
+// - provider: OpenID Connect provider.
+// - duration: Session duration.
+// - limits: Bandwidth and data quota restrictions for the guest.
+//
+// Behavior:
+// - Checks the state against the cache entry it is bound to; each state can only be used once.
+// - Redeems the code with the PKCE verifier and verifies the ID token.
+// - Records the consent with the verified email address, authorizes the guest and records the verified identity claims in the session.
+// - On failure, records the failed attempt and redirects back to the portal with an `error` code.
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client, provider *oidc.Provider, duration int, limits authorization.Limits) {
+	query := r.URL.Query()
+	cacheId, _, _ := strings.Cut(query.Get("state"), ".")
+	cacheInfo := cache.GetRecord(cacheId)
+	if cacheInfo == nil || cacheInfo.OAuth == nil ||
+		subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(cacheInfo.OAuth.State)) != 1 {
+		redirectWithError(w, r, "", ErrCodeSessionExpired)
+		return
+	}
+	secrets := cacheInfo.OAuth
+	cache.SetOAuthState(cacheId, nil)
+
+	if reason := query.Get("error"); reason != "" {
+		code := ErrCodeSignInFailed
+		if reason == "access_denied" {
+			code = ErrCodeSignInCancelled
+		}
+		store.WriteFailedAttempt(cacheId, cacheInfo.ID, cacheInfo.AP, "", "", code, reason+": "+query.Get("error_description"))
+		redirectWithError(w, r, cacheId, code)
+		return
+	}
+
+	claims, err := provider.Exchange(query.Get("code"), secrets.Verifier, secrets.Nonce)
+	if err != nil {
+		fmt.Println(err)
+		store.WriteFailedAttempt(cacheId, cacheInfo.ID, cacheInfo.AP, "", "", ErrCodeSignInFailed, err.Error())
+		redirectWithError(w, r, cacheId, ErrCodeSignInFailed)
+		return
+	}
+
+	// The email address is only known after the sign-in
+	if secrets.Consent != nil {
+		secrets.Consent.Email = claims.Email
+	}
+	if err := writeConsent(store, secrets.Consent); err != nil {
+		fmt.Println(err)
+		redirectWithError(w, r, cacheId, ErrCodeInternal)
+		return
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.Email
+	}
+
+	err = client.AuthorizeGuestProcess(cacheInfo.ID, cacheInfo.AP, duration, limits)
+	if err != nil {
+		fmt.Println(err)
+		_, apiErr := authorizationError(err)
+		store.WriteFailedAttempt(cacheId, cacheInfo.ID, cacheInfo.AP, name, claims.Email, apiErr.Code, err.Error())
+		redirectWithError(w, r, cacheId, apiErr.Code)
+		return
+	}
+
+	recordSession(store, client, db.Session{
+		CacheID:         cacheId,
+		ID:              cacheInfo.ID,
+		AP:              cacheInfo.AP,
+		Name:            name,
+		Email:           claims.Email,
+		Duration:        duration,
+		IdentityIssuer:  claims.Issuer,
+		IdentitySubject: claims.Subject,
+		EmailVerified:   claims.EmailVerified,
+	})
+	cache.RemoveFromCache(cacheId)
+
+	http.Redirect(w, r, "/success", http.StatusSeeOther)
+}
+
+// redirectWithError sends the guest back to the portal page with an `error` code, for
+// flows where the browser navigates away from the portal and cannot receive an
+// ErrorResponse. If cacheId is set, the portal page keeps the guest's login so they can
+// try again.
+func redirectWithError(w http.ResponseWriter, r *http.Request, cacheId string, code string) {
+	params := url.Values{"error": {code}}
+	if cacheId != "" {
+		params.Set("cacheId", cacheId)
+	}
+	http.Redirect(w, r, "/?"+params.Encode(), http.StatusSeeOther)
+}
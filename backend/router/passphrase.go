@@ -0,0 +1,142 @@
+package router
+
+import (
+	"backend/cache"
+	"backend/config"
+	"backend/db"
+	"backend/lockout"
+	"backend/passphrase"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PassphraseResponse represents the JSON body returned by the passphrase admin endpoints.
+type PassphraseResponse struct {
+	Passphrase string    `json:"passphrase"` // The current passphrase.
+	RotatedAt  time.Time `json:"rotatedAt"`  // When the passphrase was last changed.
+}
+
+// SetPassphraseRequest represents the JSON body for setting the passphrase.
+type SetPassphraseRequest struct {
+	Passphrase string `json:"passphrase"` // The new passphrase.
+}
+
+// loadPassphrases creates the passphrase manager of the passphrase mode. The passphrase
+// is saved in the database whenever it changes, so a passphrase set or rotated by an admin
+// survives a restart.
+//
+// Behavior:
+// - Restores the saved passphrase of the site, unless PORTAL_PASSPHRASE changed since it was saved.
+// - Otherwise starts with PORTAL_PASSPHRASE, or a random passphrase if it is unset, and saves it.
+func loadPassphrases(store db.Storage, cfg config.Config) (*passphrase.Manager, error) {
+	// The seed records which PORTAL_PASSPHRASE the saved passphrase descends from
+	name := "passphrase:" + cfg.Site
+	sum := sha256.Sum256([]byte(cfg.Passphrase))
+	seed := hex.EncodeToString(sum[:])
+
+	passphrases, err := passphrase.NewManager(cfg.Passphrase, cfg.PassphraseMaxAttempts, time.Duration(cfg.PassphraseLockout)*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	passphrases.OnChange(func(current string, rotatedAt time.Time) error {
+		if err := store.WriteSetting(name, current, rotatedAt); err != nil {
+			return fmt.Errorf("passphrase changed but not saved: %v", err)
+		}
+		return nil
+	})
+
+	saved, savedAt, err := store.GetSetting(name)
+	if err != nil && !errors.Is(err, db.ErrNoSetting) {
+		return nil, err
+	}
+	savedSeed, _, seedErr := store.GetSetting(name + ":seed")
+	if seedErr != nil && !errors.Is(seedErr, db.ErrNoSetting) {
+		return nil, seedErr
+	}
+	if err == nil && savedSeed == seed {
+		passphrases.Restore(saved, savedAt)
+		return passphrases, nil
+	}
+
+	current, rotatedAt := passphrases.Current()
+	if err := store.WriteSetting(name+":seed", seed, rotatedAt); err != nil {
+		return nil, err
+	}
+	if err := store.WriteSetting(name, current, rotatedAt); err != nil {
+		return nil, err
+	}
+	return passphrases, nil
+}
+
+// checkPassphrase verifies the passphrase of a login request. If the passphrase is wrong or
+// the client is locked out, by its MAC address or the IP address of the request, it
+// records the failed attempt, writes an error response and returns false.
+func checkPassphrase(w http.ResponseWriter, r *http.Request, store db.Storage, passphrases *passphrase.Manager, req LoginRequest, cacheInfo *cache.LoginCache) bool {
+	err := passphrases.Check(cacheInfo.ID, remoteIP(r), req.Passphrase)
+	if err == nil {
+		return true
+	}
+
+	var lockedOut *lockout.LockedOutError
+	if errors.As(err, &lockedOut) {
+		store.WriteFailedAttempt(req.CacheID, cacheInfo.ID, cacheInfo.AP, req.Name, req.Email, ErrCodeLockedOut, err.Error())
+		wait := time.Until(lockedOut.Until).Round(time.Minute)
+		if wait < time.Minute {
+			wait = time.Minute
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+		writeError(w, http.StatusTooManyRequests, APIError{
+			Code:    ErrCodeLockedOut,
+			Message: fmt.Sprintf("Too many wrong passphrases. Please try again in %.0f minute(s).", wait.Minutes()),
+		})
+		return false
+	}
+
+	store.WriteFailedAttempt(req.CacheID, cacheInfo.ID, cacheInfo.AP, req.Name, req.Email, ErrCodeInvalidPassphrase, err.Error())
+	writeError(w, http.StatusForbidden, APIError{
+		Code:    ErrCodeInvalidPassphrase,
+		Message: "The Wi-Fi passphrase is incorrect.",
+	})
+	return false
+}
+
+// writePassphrase handles GET /api/admin/passphrase by returning the current passphrase.
+func writePassphrase(w http.ResponseWriter, passphrases *passphrase.Manager) {
+	current, rotatedAt := passphrases.Current()
+	writeJSON(w, http.StatusOK, PassphraseResponse{Passphrase: current, RotatedAt: rotatedAt})
+}
+
+// handleSetPassphrase handles PUT /api/admin/passphrase by replacing the passphrase.
+func handleSetPassphrase(w http.ResponseWriter, r *http.Request, passphrases *passphrase.Manager) {
+	var req SetPassphraseRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Passphrase) == "" {
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: "A non-empty passphrase is required."})
+		return
+	}
+
+	if err := passphrases.Set(req.Passphrase); err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writePassphrase(w, passphrases)
+}
+
+// handleRotatePassphrase handles POST /api/admin/passphrase/rotate by generating a new passphrase.
+func handleRotatePassphrase(w http.ResponseWriter, passphrases *passphrase.Manager) {
+	if _, err := passphrases.Rotate(); err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writePassphrase(w, passphrases)
+}
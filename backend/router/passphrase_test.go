@@ -0,0 +1,52 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/cache"
+	"backend/passphrase"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPassphraseLockoutByIP(t *testing.T) {
+	p := newAdminPortal(t)
+	passphrases, err := passphrase.NewManager("open sesame", 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// login starts a new login with a MAC address of the client's choosing, as a client
+	// forging the redirect of the controller can, and guesses the passphrase
+	login := func(mac, remoteAddr, phrase string) *httptest.ResponseRecorder {
+		cacheId := cache.AddToCache(mac, "11:22:33:44:55:66")
+		body := fmt.Sprintf(`{"cacheId":%q,"username":"Eve","passphrase":%q}`, cacheId, phrase)
+		r := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handleGuestAuthorization(w, r, p.store, p.client, 480, authorization.Limits{}, passphrases, nil, &consentPolicy{}, nil)
+		return w
+	}
+
+	if w := login("aa:bb:cc:dd:ee:40", "10.0.0.7:50000", "wrong"); w.Code != http.StatusForbidden || errorCode(w) != ErrCodeInvalidPassphrase {
+		t.Fatalf("first guess: got %d %s", w.Code, w.Body)
+	}
+	// A fresh MAC address does not give the address fresh attempts
+	if w := login("aa:bb:cc:dd:ee:41", "10.0.0.7:50001", "wrong"); w.Code != http.StatusTooManyRequests || errorCode(w) != ErrCodeLockedOut {
+		t.Fatalf("guess with another MAC address: got %d %s", w.Code, w.Body)
+	}
+	if w := login("aa:bb:cc:dd:ee:42", "10.0.0.7:50002", "open sesame"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("right passphrase while locked out: got %d %s", w.Code, w.Body)
+	}
+
+	// Other guests are not locked out
+	if w := login("aa:bb:cc:dd:ee:43", "10.0.0.8:50000", "open sesame"); w.Code != http.StatusSeeOther {
+		t.Errorf("other guest: got %d %s", w.Code, w.Body)
+	}
+	if got := len(p.controller.authorizations()); got != 1 {
+		t.Errorf("got %d authorizations, want the other guest only", got)
+	}
+}
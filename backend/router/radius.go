@@ -0,0 +1,192 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/db"
+	"backend/radius"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// handleRADIUSLogin handles the POST /api/login requests in radius mode.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - client: Shared UniFi controller client.
+// - accounting: RADIUS client checking the credentials and receiving accounting records.
+// - duration: Default session duration, used when the server sends no Session-Timeout.
+// - limits: Default bandwidth and data quota restrictions, overridden by WISPr bandwidth attributes.
+// - consents: Terms of service and marketing consent policy.
+//
+// Behavior:
+// - Rejects logins that did not accept the current terms of service before sending the credentials.
+// - Sends an Access-Request with the guest's username and password, and records the consent once the server accepts them.
+// - Authorizes the guest with the Session-Timeout and bandwidth limits of the Access-Accept.
+// - Records the session and sends an Accounting-Start; the Accounting-Stop is sent when the session expires or is revoked.
+func handleRADIUSLogin(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client, rc *radius.Client, duration int, limits authorization.Limits, consents *consentPolicy) {
+	var req LoginRequest
+
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if !validateLogin(w, &req, false) {
+		return
+	}
+
+	cacheInfo, ok := lookupCache(w, req.CacheID)
+	if !ok {
+		return
+	}
+
+	if req.Name == "" || req.Password == "" {
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: "Please enter your username and password."})
+		return
+	}
+
+	if !checkConsent(w, consents, req.ConsentFields) {
+		return
+	}
+
+	accept, err := rc.Authenticate(req.Name, req.Password, cacheInfo.ID, cacheInfo.AP)
+	var reject *radius.RejectError
+	if errors.As(err, &reject) {
+		store.WriteFailedAttempt(req.CacheID, cacheInfo.ID, cacheInfo.AP, req.Name, req.Email, ErrCodeInvalidCredentials, err.Error())
+		writeError(w, http.StatusForbidden, APIError{
+			Code:    ErrCodeInvalidCredentials,
+			Message: "The username or password is incorrect.",
+		})
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		store.WriteFailedAttempt(req.CacheID, cacheInfo.ID, cacheInfo.AP, req.Name, req.Email, ErrCodeAuthServerUnreachable, err.Error())
+		writeError(w, http.StatusBadGateway, APIError{
+			Code:      ErrCodeAuthServerUnreachable,
+			Message:   "The login service is not responding right now. Please try again in a moment.",
+			Retryable: true,
+		})
+		return
+	}
+
+	// The Access-Accept overrides the configured defaults
+	if accept.SessionTimeout > 0 {
+		duration = int((accept.SessionTimeout + time.Minute - 1) / time.Minute)
+	}
+	if accept.UpBps > 0 {
+		limits.Up = int(accept.UpBps / 1000)
+	}
+	if accept.DownBps > 0 {
+		limits.Down = int(accept.DownBps / 1000)
+	}
+
+	if !recordConsent(w, store, consents.pending(r, req.CacheID, cacheInfo, req.ConsentFields, req.Email)) {
+		return
+	}
+
+	session := db.Session{Name: req.Name, Email: req.Email, Duration: duration}
+	if !completeLogin(w, r, store, client, req.CacheID, cacheInfo, session, limits) {
+		return
+	}
+
+	if rc.AccountingEnabled() {
+		now := time.Now()
+		startAccounting(store, rc, db.AccountingSession{
+			SessionID: req.CacheID,
+			CacheID:   req.CacheID,
+			ID:        cacheInfo.ID,
+			AP:        cacheInfo.AP,
+			Username:  req.Name,
+			Class:     accept.Class,
+			StartedAt: now,
+			ExpiresAt: now.Add(time.Duration(duration) * time.Minute),
+		})
+	}
+}
+
+// startAccounting records a RADIUS session and sends its Accounting-Start in the background.
+func startAccounting(store db.Storage, rc *radius.Client, session db.AccountingSession) {
+	if err := store.WriteAccountingStart(session); err != nil {
+		fmt.Println(err)
+	}
+	go func() {
+		if err := rc.AccountingStart(accountingRecord(session)); err != nil {
+			fmt.Println("Accounting-Start failed:", err)
+		}
+	}()
+}
+
+// stopExpiredAccountingEvery periodically sends the Accounting-Stop records of RADIUS
+// sessions whose authorization ran out. Sessions whose stop cannot be delivered are
+// retried on the next run, including after a restart.
+func stopExpiredAccountingEvery(store db.Storage, rc *radius.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		sessions, err := store.ExpiredAccountingSessions(time.Now())
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		for _, session := range sessions {
+			stopAccountingSession(store, rc, session, session.ExpiresAt, radius.TerminateSessionTimeout)
+		}
+	}
+}
+
+// stopAccounting sends the Accounting-Stop records of a client's open RADIUS sessions,
+// for when an administrator revoked the guest. It does nothing if rc is nil.
+func stopAccounting(store db.Storage, rc *radius.Client, mac string, cause int) {
+	if rc == nil || !rc.AccountingEnabled() {
+		return
+	}
+	sessions, err := store.OpenAccountingSessions(mac)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, session := range sessions {
+		stopAccountingSession(store, rc, session, time.Now(), cause)
+	}
+}
+
+// extendAccounting moves the expiry of a client's open RADIUS sessions, for when an
+// administrator extended the guest. It does nothing if rc is nil.
+func extendAccounting(store db.Storage, rc *radius.Client, mac string, expiresAt time.Time) {
+	if rc == nil || !rc.AccountingEnabled() {
+		return
+	}
+	if err := store.ExtendAccountingSessions(mac, expiresAt); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// stopAccountingSession sends the Accounting-Stop of a session that ended at endedAt and
+// marks it as stopped once the server acknowledged it.
+func stopAccountingSession(store db.Storage, rc *radius.Client, session db.AccountingSession, endedAt time.Time, cause int) {
+	record := accountingRecord(session)
+	record.SessionTime = endedAt.Sub(session.StartedAt)
+	record.TerminateCause = cause
+	if err := rc.AccountingStop(record); err != nil {
+		fmt.Println("Accounting-Stop failed:", err)
+		return
+	}
+	if err := store.MarkAccountingStopped(session.SessionID); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// accountingRecord converts a stored RADIUS session into an accounting record.
+func accountingRecord(session db.AccountingSession) radius.AccountingRecord {
+	return radius.AccountingRecord{
+		SessionID: session.SessionID,
+		Username:  session.Username,
+		ClientMAC: session.ID,
+		APMAC:     session.AP,
+		Class:     session.Class,
+	}
+}
@@ -0,0 +1,71 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes returned in the `code` field of an APIError.
+const (
+	ErrCodeInvalidRequest        = "invalid_request"         // The request body could not be decoded.
+	ErrCodeSessionExpired        = "session_expired"         // The cache ID is missing or no longer known.
+	ErrCodeControllerUnreachable = "controller_unreachable"  // The UniFi controller could not be reached.
+	ErrCodeAuthorizationRejected = "authorization_rejected"  // The UniFi controller refused the authorization.
+	ErrCodeInvalidVoucher        = "invalid_voucher"         // The voucher does not exist, expired or is used up.
+	ErrCodeInvalidPassphrase     = "invalid_passphrase"      // The portal passphrase is wrong.
+	ErrCodeLockedOut             = "locked_out"              // Too many wrong guesses; the client must wait before trying again.
+	ErrCodeInvalidEmail          = "invalid_email"           // The email address is missing or malformed.
+	ErrCodeEmailFailed           = "email_failed"            // The verification email could not be sent.
+	ErrCodeCodeRecentlySent      = "code_recently_sent"      // A verification code was sent moments ago.
+	ErrCodeNoVerification        = "no_verification"         // No verification code was requested for the login.
+	ErrCodeInvalidCode           = "invalid_code"            // The verification code is wrong.
+	ErrCodeCodeExpired           = "code_expired"            // The verification code has expired.
+	ErrCodeTooManyAttempts       = "too_many_attempts"       // Too many wrong verification codes were entered.
+	ErrCodeInvalidSponsor        = "invalid_sponsor"         // The host email address is malformed or not allowed.
+	ErrCodeRequestRecentlySent   = "request_recently_sent"   // The host was asked for approval moments ago.
+	ErrCodeNoSponsorRequest      = "no_sponsor_request"      // No approval was requested for the login.
+	ErrCodeSponsorRequestPending = "sponsor_request_pending" // The host has not decided on the previous request yet.
+	ErrCodeProviderUnreachable   = "provider_unreachable"    // The identity provider could not be reached.
+	ErrCodeSignInCancelled       = "sign_in_cancelled"       // The guest cancelled the sign-in at the identity provider.
+	ErrCodeSignInFailed          = "sign_in_failed"          // The identity provider's response could not be verified.
+	ErrCodeInvalidCredentials    = "invalid_credentials"     // The username or password was rejected.
+	ErrCodeAuthServerUnreachable = "auth_unreachable"        // The authentication server could not be reached.
+	ErrCodeNotFound              = "not_found"               // The requested record does not exist.
+	ErrCodeSessionNotActive      = "session_not_active"      // The session has expired or was revoked.
+	ErrCodeInternal              = "internal_error"          // An unexpected server-side failure occurred.
+	ErrCodeUnauthorized          = "unauthorized"            // The admin request is not signed in.
+	ErrCodeForbidden             = "forbidden"               // The admin account's role does not allow the action.
+	ErrCodeAccountExists         = "account_exists"          // An admin account with the username already exists.
+	ErrCodeLastAdmin             = "last_admin"              // The last admin account cannot be removed or demoted.
+	ErrCodeRetentionDisabled     = "retention_disabled"      // No retention period is configured.
+	ErrCodeTermsNotAccepted      = "terms_not_accepted"      // The guest did not accept the terms of service.
+	ErrCodeTermsChanged          = "terms_changed"           // The terms of service changed since the guest read them.
+	ErrCodeInvalidField          = "invalid_field"           // A form field is missing, too long or malformed.
+	ErrCodeRequestTooLarge       = "request_too_large"       // The request body exceeds the size limit.
+	ErrCodeMethodNotAllowed      = "method_not_allowed"      // The endpoint does not support the request method.
+)
+
+// APIError represents the structured error returned by the JSON API.
+type APIError struct {
+	Code      string `json:"code"`            // Machine-readable error code (one of the ErrCode* constants).
+	Message   string `json:"message"`         // Human-readable message that can be shown to the guest.
+	Retryable bool   `json:"retryable"`       // Whether sending the same request again may succeed.
+	Field     string `json:"field,omitempty"` // Name of the form field the error is about, if any.
+}
+
+// ErrorResponse represents the JSON body of an API error response.
+type ErrorResponse struct {
+	Error APIError `json:"error"` // Details of the error.
+}
+
+// writeJSON encodes v as the JSON body of a response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an ErrorResponse with the given status code.
+func writeError(w http.ResponseWriter, status int, apiErr APIError) {
+	writeJSON(w, status, ErrorResponse{Error: apiErr})
+}
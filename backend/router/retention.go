@@ -0,0 +1,51 @@
+package router
+
+import (
+	"backend/db"
+	"backend/retention"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// RetentionResponse represents the JSON body returned by the retention endpoints.
+type RetentionResponse struct {
+	Policy retention.Policy `json:"policy"`           // Configured retention policy.
+	Report *db.PurgeReport  `json:"report,omitempty"` // Rows purged, or that a purge would affect now (omitted when disabled).
+}
+
+// handleRetentionReport handles GET /api/admin/retention by reporting what a purge would
+// affect now, without changing anything.
+func handleRetentionReport(w http.ResponseWriter, store db.Storage, policy retention.Policy) {
+	report, err := retention.Purge(store, policy, "", true)
+	if errors.Is(err, retention.ErrDisabled) {
+		writeJSON(w, http.StatusOK, RetentionResponse{Policy: policy})
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, RetentionResponse{Policy: policy, Report: report})
+}
+
+// handleRetentionPurge handles POST /api/admin/retention/purge by purging the data past
+// the retention period now, instead of waiting for the hourly job. The purge is recorded
+// in the audit log under the signed-in account.
+//
+// Responds with the rows purged, or 409 if no retention period is configured.
+func handleRetentionPurge(w http.ResponseWriter, r *http.Request, store db.Storage, policy retention.Policy) {
+	report, err := retention.Purge(store, policy, currentAdmin(r).Username, false)
+	if errors.Is(err, retention.ErrDisabled) {
+		writeError(w, http.StatusConflict, APIError{
+			Code:    ErrCodeRetentionDisabled,
+			Message: "No retention period is configured (RETENTION_DAYS).",
+		})
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, RetentionResponse{Policy: policy, Report: report})
+}
@@ -7,17 +7,27 @@ import (
 	"backend/cache"
 	"backend/config"
 	"backend/db"
-	"encoding/json"
+	"backend/formfields"
+	"backend/ldap"
+	"backend/mailer"
+	"backend/oidc"
+	"backend/pages"
+	"backend/passphrase"
+	"backend/radius"
+	"backend/sponsor"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // LoginRequest represents the structure of the JSON body for the login API.
@@ -25,29 +35,189 @@ type LoginRequest struct {
 	CacheID string `json:"cacheId"`  // Cache identifier
 	Name    string `json:"username"` // User's name
 	Email   string `json:"email"`    // User's email address
+
+	Passphrase string `json:"passphrase"` // Shared portal passphrase (passphrase mode only)
+	Sponsor    string `json:"sponsor"`    // Host email address (sponsor mode only)
+	Password   string `json:"password"`   // Account password (radius and ldap modes only)
+
+	Fields map[string]interface{} `json:"fields"` // Answers to the custom form fields (form, passphrase, email and sponsor modes only)
+
+	ConsentFields // Terms of service acceptance and marketing opt-in
 }
 
 // SetupServer initializes the HTTP server and defines application routes.
 //
 // Parameters:
 // - cfg: Configuration object containing environment-specific settings.
+// - store: Shared database.
 //
 // Routes:
-// - POST /api/login: Handles guest login requests.
+// - GET /api/terms: Returns the terms of service and whether marketing consent is offered.
+// - GET /api/fields: Lists the custom fields of the guest form (form, passphrase, email and sponsor modes).
+// - POST /api/login: Handles guest login requests (form, passphrase, email, sponsor, radius and ldap modes).
+// - POST /api/verify: Checks the emailed one-time code (email mode).
+// - GET /api/sponsor/hosts: Lists the hosts guests can ask for approval (sponsor mode).
+// - GET /api/sponsor/status/{cacheId}: Reports the host's decision on a guest request (sponsor mode).
+// - POST /api/sponsor/withdraw/{cacheId}: Withdraws a pending guest request to ask another host (sponsor mode).
+// - GET, POST /sponsor/decide: Confirmation page of the host's approve/deny links (sponsor mode).
+// - GET /api/oidc: Describes the identity provider and its walled-garden hosts (oidc mode).
+// - GET /api/oidc/start: Redirects the guest to the identity provider (oidc mode).
+// - GET /api/oidc/callback: Completes the sign-in when the identity provider redirects back (oidc mode).
+// - POST /api/login/voucher: Handles voucher code redemption (voucher mode).
+// - /api/admin/*: Admin login and the guest management endpoints (see setupAdminRoutes).
+// - GET /admin: Serves the admin dashboard.
 // - GET /success: Serves the success page.
 // - GET /*: Serves the front-end assets or dynamically injects content.
+// - /api/*: Responds to unknown API endpoints with a not_found ErrorResponse.
+//
+// Request bodies are limited in size (see decodeJSON), and panics in handlers are turned
+// into internal_error responses (see recoverJSON).
 //
-// The server listens on the port specified in the configuration.
-func SetupServer(cfg config.Config) {
+// The server listens on the port specified in the configuration. A single UniFi
+// client is created here and shared by all handlers, so the controller session is
+// reused across guest logins. The session reconciler runs in the background.
+func SetupServer(cfg config.Config, store db.Storage) {
+	client := authorization.NewClient(cfg.URL, cfg.Site, cfg.Username, cfg.Password, cfg.DisableTLS, cfg.ControllerType)
+	go reconcileSessionsEvery(store, client, time.Duration(cfg.SessionReconcileMinutes)*time.Minute, cfg.SessionControllerCheck)
+
+	var passphrases *passphrase.Manager
+	if cfg.AuthMode == config.AuthModePassphrase {
+		var err error
+		passphrases, err = loadPassphrases(store, cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cfg.PassphraseRotateAt != "" {
+			at, _ := time.Parse("15:04", cfg.PassphraseRotateAt)
+			go passphrases.RotateDailyAt(at.Hour(), at.Minute())
+		}
+	}
+
+	consents := &consentPolicy{terms: cfg.Terms, marketing: cfg.MarketingConsent}
+
+	// Pages are parsed once; DEBUG_MODE serves the dist directory and picks up rebuilds
+	frontendDir := "./"
+	debugMode, _ := strconv.ParseBool(os.Getenv("DEBUG_MODE"))
+	if debugMode {
+		frontendDir = "dist"
+	}
+	renderer, err := pages.New(frontendDir, debugMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	site := pages.Data{Title: pageTitle(), AuthMode: cfg.AuthMode, Branding: cfg.Branding, Locale: cfg.Locale}
+
+	// Custom form fields only apply to the modes with the guest form
+	var formFields []formfields.Field
+	switch cfg.AuthMode {
+	case config.AuthModeForm, config.AuthModePassphrase, config.AuthModeEmail, config.AuthModeSponsor:
+		formFields = cfg.FormFields
+	}
+
+	// RADIUS client of the radius mode, which also receives accounting records
+	var accounting *radius.Client
+
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
+	setupAPIErrors(r)
+
+	r.Get("/api/terms", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, consents.response())
+	})
+	r.Get("/api/fields", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, FormFieldsResponse{Fields: formFields})
+	})
+
+	// Only the login endpoint of the configured mode is registered, so guests cannot
+	// bypass e.g. vouchers by posting to the plain form endpoint
+	switch cfg.AuthMode {
+	case config.AuthModeForm, config.AuthModePassphrase:
+		r.Post("/api/login", func(w http.ResponseWriter, r *http.Request) {
+			handleGuestAuthorization(w, r, store, client, cfg.Duration, cfg.Limits, passphrases, nil, consents, formFields)
+		})
+	case config.AuthModeLDAP:
+		directory, err := ldap.NewDirectory(cfg.LDAPURL, cfg.LDAPStartTLS, cfg.LDAPSkipVerify,
+			cfg.LDAPBindDN, cfg.LDAPBindPassword, cfg.LDAPBaseDN, cfg.LDAPUserAttribute)
+		if err != nil {
+			log.Fatal(err)
+		}
+		staff := &staffDirectory{directory: directory, policies: cfg.LDAPGroupPolicies}
+		r.Post("/api/login", func(w http.ResponseWriter, r *http.Request) {
+			handleGuestAuthorization(w, r, store, client, cfg.Duration, cfg.Limits, nil, staff, consents, nil)
+		})
+	case config.AuthModeEmail:
+		sender := mailer.New(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom, cfg.SMTPSecurity)
+		r.Post("/api/login", func(w http.ResponseWriter, r *http.Request) {
+			handleEmailLogin(w, r, store, client, sender, cfg.EmailCodeTTL, cfg.EmailGrace, cfg.Limits, consents, formFields)
+		})
+		r.Post("/api/verify", func(w http.ResponseWriter, r *http.Request) {
+			handleEmailVerification(w, r, store, client, cfg.Duration, cfg.Limits)
+		})
+	case config.AuthModeSponsor:
+		sender := mailer.New(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom, cfg.SMTPSecurity)
+		signer, err := sponsor.NewSigner(cfg.SponsorSecret)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Post("/api/login", func(w http.ResponseWriter, r *http.Request) {
+			handleSponsorLogin(w, r, store, sender, signer, cfg, consents, formFields)
+		})
+		r.Get("/api/sponsor/hosts", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, SponsorHostsResponse{Hosts: cfg.SponsorHosts, Domains: cfg.SponsorDomains})
+		})
+		r.Get("/api/sponsor/status/{cacheId}", func(w http.ResponseWriter, r *http.Request) {
+			handleSponsorStatus(w, r, store)
+		})
+		r.Post("/api/sponsor/withdraw/{cacheId}", func(w http.ResponseWriter, r *http.Request) {
+			handleSponsorWithdraw(w, r, store)
+		})
+		r.Get("/sponsor/decide", func(w http.ResponseWriter, r *http.Request) {
+			handleSponsorDecisionPage(w, r, store, signer)
+		})
+		r.Post("/sponsor/decide", func(w http.ResponseWriter, r *http.Request) {
+			handleSponsorDecision(w, r, store, client, signer, cfg.Duration, cfg.Limits)
+		})
+	case config.AuthModeOIDC:
+		provider := oidc.NewProvider(cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret,
+			cfg.PortalURL+"/api/oidc/callback", cfg.OIDCScopes)
+		go func() {
+			fmt.Printf("Allow these hosts in the controller's pre-authorization access: %s\n",
+				strings.Join(provider.WalledGarden(cfg.OIDCWalledGarden), ", "))
+		}()
+		r.Get("/api/oidc", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, OIDCInfoResponse{
+				Provider:     cfg.OIDCProviderName,
+				WalledGarden: provider.WalledGarden(cfg.OIDCWalledGarden),
+			})
+		})
+		r.Get("/api/oidc/start", func(w http.ResponseWriter, r *http.Request) {
+			handleOIDCStart(w, r, provider, consents)
+		})
+		r.Get("/api/oidc/callback", func(w http.ResponseWriter, r *http.Request) {
+			handleOIDCCallback(w, r, store, client, provider, cfg.Duration, cfg.Limits)
+		})
+	case config.AuthModeRADIUS:
+		accounting = radius.NewClient(cfg.RADIUSServer, cfg.RADIUSAccountingServer, cfg.RADIUSSecret,
+			cfg.RADIUSNASIdentifier, cfg.RADIUSAuthMethod, time.Duration(cfg.RADIUSTimeout)*time.Second)
+		if accounting.AccountingEnabled() {
+			go stopExpiredAccountingEvery(store, accounting, time.Minute)
+		}
+		r.Post("/api/login", func(w http.ResponseWriter, r *http.Request) {
+			handleRADIUSLogin(w, r, store, client, accounting, cfg.Duration, cfg.Limits, consents)
+		})
+	case config.AuthModeVoucher:
+		r.Post("/api/login/voucher", func(w http.ResponseWriter, r *http.Request) {
+			handleVoucherLogin(w, r, store, client, cfg.Limits, consents)
+		})
+	}
 
-	r.Post("/api/login", func(w http.ResponseWriter, r *http.Request) {
-		handleGuestAuthorization(w, r, cfg.URL, cfg.Site, cfg.Username, cfg.Password, cfg.Duration, cfg.DisableTLS)
+	setupAdminRoutes(r, store, client, cfg, passphrases, accounting)
+	r.Get("/admin", func(w http.ResponseWriter, r *http.Request) {
+		serveFrontend(w, r, frontendDir, renderer, site)
 	})
 
 	r.Get("/success", func(w http.ResponseWriter, r *http.Request) {
-		serveFrontend(w, r, "")
+		serveFrontend(w, r, frontendDir, renderer, site)
 	})
 
 	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
@@ -56,8 +226,13 @@ func SetupServer(cfg config.Config) {
 			id := r.URL.Query().Get("id")
 			ap := r.URL.Query().Get("ap")
 			cacheId = cache.AddToCache(id, ap)
+		} else if existing := r.URL.Query().Get("cacheId"); existing != "" && cache.GetRecord(existing) != nil {
+			// Returning from a redirect flow (e.g. a failed OIDC sign-in) keeps the login
+			cacheId = existing
 		}
-		serveFrontend(w, r, cacheId)
+		page := site
+		page.CacheID = cacheId
+		serveFrontend(w, r, frontendDir, renderer, page)
 	})
 
 	appUrl := fmt.Sprintf("0.0.0.0:%s", cfg.Port)
@@ -65,55 +240,81 @@ func SetupServer(cfg config.Config) {
 	http.ListenAndServe(appUrl, r)
 }
 
-// serveFrontend serves the front-end assets and injects dynamic content as needed.
+// setupAPIErrors makes API errors, including panics in handlers and requests to unknown
+// endpoints or with unsupported methods, always an ErrorResponse. It must be called before
+// the routes are registered.
+func setupAPIErrors(r chi.Router) {
+	r.Use(recoverJSON)
+
+	methodNotAllowed := func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, APIError{
+			Code:    ErrCodeMethodNotAllowed,
+			Message: fmt.Sprintf("%s is not supported on %s.", r.Method, r.URL.Path),
+		})
+	}
+	apiNotFound := func(w http.ResponseWriter, r *http.Request) {
+		// The catch-all below matches every method, so chi never reports an API endpoint
+		// requested with the wrong method; look for a route with another method instead
+		routes := chi.RouteContext(r.Context()).Routes
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			rctx := chi.NewRouteContext()
+			if method != r.Method && routes.Match(rctx, method, r.URL.Path) && rctx.RoutePattern() != "/api/*" {
+				methodNotAllowed(w, r)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, APIError{Code: ErrCodeNotFound, Message: "No such API endpoint."})
+	}
+	r.MethodNotAllowed(methodNotAllowed)
+	r.NotFound(apiNotFound)
+	r.HandleFunc("/api/*", apiNotFound)
+}
+
+// serveFrontend serves the front-end pages and assets.
 //
 // Parameters:
 // - w: HTTP response writer.
 // - r: HTTP request.
-// - cacheId: Cache identifier to inject into the front-end, if applicable.
+// - frontendDir: Directory of the front-end build.
+// - renderer: Pages of the front-end build.
+// - page: Values to put into the page, including the cache identifier, if applicable.
 //
 // Behavior:
 // - Serves `index.html` for the root route or default guest routes.
 // - Serves `success.html` for the `/success` route.
+// - Serves `admin.html` for the `/admin` route.
 // - Serves static assets like CSS, JS, or images for other routes.
-// - Dynamically replaces placeholders in HTML files with runtime values (e.g., `cacheId` and app name).
-func serveFrontend(w http.ResponseWriter, r *http.Request, cacheId string) {
-	frontendDir := "./"
-	debugMode, _ := strconv.ParseBool(os.Getenv("DEBUG_MODE"))
-	if debugMode {
-		frontendDir = "dist"
-	}
-
-	serveHTML := func(fileName string, w http.ResponseWriter, r *http.Request, cacheId string) {
-		filePath := filepath.Join(frontendDir, fileName)
-		fileContent, err := os.ReadFile(filePath)
+// - Renders the pages as templates with a fresh CSP nonce, escaping every value for its context.
+func serveFrontend(w http.ResponseWriter, r *http.Request, frontendDir string, renderer *pages.Renderer, page pages.Data) {
+	serveHTML := func(name string) {
+		nonce, err := pages.NewNonce()
 		if err != nil {
-			http.NotFound(w, r)
+			fmt.Println(err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		page.Nonce = nonce
 
-		if cacheId != "" {
-			fileContent = []byte(strings.Replace(string(fileContent), "</body>",
-				fmt.Sprintf(`<script>window.cacheId = "%s";</script></body>`, cacheId), 1))
-		}
-		appName := os.Getenv("VITE_PAGE_TITLE")
-		if appName == "" {
-			fmt.Println("Error getting the page title. Falling back to default.")
-			appName = "Unifi Guest Portal"
+		// Render only writes once the page is complete, so the errors below replace the headers
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", pages.ContentSecurityPolicy(nonce))
+		if err := renderer.Render(w, name, page); errors.Is(err, pages.ErrNotFound) {
+			http.NotFound(w, r)
+		} else if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
-		fileContent = []byte(strings.Replace(string(fileContent), "%VITE_PAGE_TITLE%", appName, -1))
-
-		w.Header().Set("Content-Type", "text/html")
-		w.Write(fileContent)
 	}
 
-	if r.URL.Path == "/" || r.URL.Path == "" || r.URL.Path == "/guest/s/default/" {
-		serveHTML("index.html", w, r, cacheId)
+	switch r.URL.Path {
+	case "/", "", "/guest/s/default/":
+		serveHTML(pages.Portal)
 		return
-	}
-
-	if r.URL.Path == "/success" {
-		serveHTML("success.html", w, r, cacheId)
+	case "/success":
+		serveHTML(pages.Success)
+		return
+	case "/admin":
+		serveHTML(pages.Admin)
 		return
 	}
 
@@ -130,33 +331,165 @@ func serveFrontend(w http.ResponseWriter, r *http.Request, cacheId string) {
 // Parameters:
 // - w: HTTP response writer.
 // - r: HTTP request.
-// - url, site, username, password: Credentials and URL for Unifi API.
+// - store: Shared database.
+// - client: Shared UniFi controller client.
 // - duration: Session duration.
-// - disableTLS: Whether to disable TLS verification.
+// - limits: Bandwidth and data quota restrictions for the guest.
+// - passphrases: Shared passphrase to check before authorizing, or nil in other modes.
+// - staff: Directory to check the credentials against in ldap mode, or nil in other modes.
+// - consents: Terms of service and marketing consent policy.
+// - fields: Custom form fields to validate the answers against, or nil in ldap mode.
 //
 // Behavior:
-// - Decodes the JSON body of the request.
+// - Decodes the JSON body of the request and checks it against the field rules (see validateLogin).
+// - Rejects missing or invalid answers to the custom form fields.
+// - In passphrase mode, rejects wrong passphrases and locked-out clients.
+// - In ldap mode, checks the credentials and applies the session policy of the user's groups.
+// - Rejects logins that did not accept the current terms of service before checking the credentials, and records the consent once they pass.
 // - Retrieves cache details and processes guest authorization.
-// - Writes the session to the database and removes it from the cache.
-// - Redirects the client to the `/success` page.
-func handleGuestAuthorization(w http.ResponseWriter, r *http.Request, url, site, username, password string, duration int, disableTLS bool) {
+// - On success, writes the session to the database, removes it from the cache and redirects to `/success`.
+// - On failure, records the failed attempt and responds with an ErrorResponse, keeping the cache entry for a retry.
+func handleGuestAuthorization(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client, duration int, limits authorization.Limits, passphrases *passphrase.Manager, staff *staffDirectory, consents *consentPolicy, fields []formfields.Field) {
 	var req LoginRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	cacheId := req.CacheID
-	if cacheId != "" {
-		cacheInfo := cache.GetRecord(cacheId)
-		err := authorization.AuthorizeGuestProcess(url, site, username, password, cacheInfo.ID, cacheInfo.AP, duration, disableTLS)
-		if err != nil {
-			fmt.Println(err)
+	if !validateLogin(w, &req, staff == nil) {
+		return
+	}
+
+	cacheInfo, ok := lookupCache(w, req.CacheID)
+	if !ok {
+		return
+	}
+
+	answers, ok := checkFormFields(w, fields, req.Fields)
+	if !ok {
+		return
+	}
+
+	if !checkConsent(w, consents, req.ConsentFields) {
+		return
+	}
+
+	if passphrases != nil && !checkPassphrase(w, r, store, passphrases, req, cacheInfo) {
+		return
+	}
+
+	session := db.Session{Name: req.Name, Email: req.Email, Duration: duration, Fields: answers}
+	if staff != nil {
+		user, ok := staff.authenticate(w, store, req, cacheInfo)
+		if !ok {
+			return
+		}
+		if user.Name != "" {
+			session.Name = user.Name
 		}
-		db.WriteToDb(cacheId, cacheInfo.ID, cacheInfo.AP, req.Name, req.Email, duration)
-		cache.RemoveFromCache(cacheId)
+		session.Email = user.Email
+		session.Duration, limits = staff.policy(user, duration, limits)
+	}
+
+	// Consent is only recorded for logins that get access
+	if !recordConsent(w, store, consents.pending(r, req.CacheID, cacheInfo, req.ConsentFields, req.Email)) {
+		return
+	}
+
+	completeLogin(w, r, store, client, req.CacheID, cacheInfo, session, limits)
+}
+
+// lookupCache retrieves the cache entry of a login request. If the cache ID is missing or
+// unknown (e.g. purged after an hour), it writes a session_expired error and returns false.
+func lookupCache(w http.ResponseWriter, cacheId string) (*cache.LoginCache, bool) {
+	cacheInfo := cache.GetRecord(cacheId)
+	if cacheInfo == nil {
+		writeError(w, http.StatusGone, APIError{
+			Code:    ErrCodeSessionExpired,
+			Message: "Your login session has expired. Please reconnect to the Wi-Fi network and try again.",
+		})
+		return nil, false
 	}
+	return cacheInfo, true
+}
+
+// remoteIP returns the IP address a request comes from.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// completeLogin authorizes a cached guest on the UniFi controller and records the outcome.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - client: Shared UniFi controller client.
+// - cacheId: Cache identifier of the login.
+// - cacheInfo: Cache entry holding the client and AP MAC addresses.
+// - session: Session details to record; the cache and MAC fields are filled in from the cache entry.
+// - limits: Bandwidth and data quota restrictions for the guest.
+//
+// Behavior:
+// - On success, writes the session to the database, removes it from the cache and redirects to `/success`.
+// - On failure, records the failed attempt and responds with an ErrorResponse, keeping the cache entry for a retry.
+//
+// Returns true if the guest was authorized.
+func completeLogin(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client, cacheId string, cacheInfo *cache.LoginCache, session db.Session, limits authorization.Limits) bool {
+	session.CacheID, session.ID, session.AP = cacheId, cacheInfo.ID, cacheInfo.AP
+
+	err := client.AuthorizeGuestProcess(cacheInfo.ID, cacheInfo.AP, session.Duration, limits)
+	if err != nil {
+		fmt.Println(err)
+		status, apiErr := authorizationError(err)
+		store.WriteFailedAttempt(cacheId, cacheInfo.ID, cacheInfo.AP, session.Name, session.Email, apiErr.Code, err.Error())
+		writeError(w, status, apiErr)
+		return false
+	}
+
+	recordSession(store, client, session)
+	cache.RemoveFromCache(cacheId)
 
 	http.Redirect(w, r, "/success", http.StatusSeeOther)
+	return true
+}
+
+// recordSession stores the session of a guest authorized by client, on the client's site.
+// A failure is only logged: the guest is already online, and failing the login now would
+// only make them retry.
+func recordSession(store db.Storage, client *authorization.Client, session db.Session) {
+	session.Site = client.Site()
+	if err := store.WriteSession(session); err != nil {
+		log.Printf("Failed to record session of %s: %v", session.ID, err)
+	}
+}
+
+// authorizationError maps an error returned by the UniFi client to the HTTP status code
+// and APIError reported to the guest.
+func authorizationError(err error) (int, APIError) {
+	if authorization.IsRetryable(err) {
+		return http.StatusBadGateway, APIError{
+			Code:      ErrCodeControllerUnreachable,
+			Message:   "The Wi-Fi controller is not responding right now. Please try again in a moment.",
+			Retryable: true,
+		}
+	}
+	return http.StatusBadGateway, APIError{
+		Code:    ErrCodeAuthorizationRejected,
+		Message: "Your device could not be authorized on the network. Please contact staff for assistance.",
+	}
+}
+
+// pageTitle returns the portal name configured in VITE_PAGE_TITLE, or a default name.
+func pageTitle() string {
+	appName := os.Getenv("VITE_PAGE_TITLE")
+	if appName == "" {
+		fmt.Println("Error getting the page title. Falling back to default.")
+		appName = "Unifi Guest Portal"
+	}
+	return appName
 }
@@ -0,0 +1,207 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/cache"
+	"backend/db"
+	"backend/pages"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestAuthorizationError(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantCode      string
+		wantRetryable bool
+	}{
+		{"unreachable", &authorization.Error{Message: "failed to reach UniFi"}, ErrCodeControllerUnreachable, true},
+		{"rate limited", &authorization.Error{StatusCode: http.StatusTooManyRequests}, ErrCodeControllerUnreachable, true},
+		{"server error", fmt.Errorf("authorization failed: %w", &authorization.Error{StatusCode: http.StatusServiceUnavailable}), ErrCodeControllerUnreachable, true},
+		{"error result on 200", &authorization.Error{StatusCode: http.StatusOK, Message: "request failed: api.err.InvalidPayload"}, ErrCodeAuthorizationRejected, false},
+		{"bad request", &authorization.Error{StatusCode: http.StatusBadRequest}, ErrCodeAuthorizationRejected, false},
+		{"other error", errors.New("failed to create request"), ErrCodeAuthorizationRejected, false},
+	}
+	for _, tt := range tests {
+		status, apiErr := authorizationError(tt.err)
+		if status != http.StatusBadGateway || apiErr.Code != tt.wantCode || apiErr.Retryable != tt.wantRetryable || apiErr.Message == "" {
+			t.Errorf("%s: got %d %+v, want %s, retryable %v", tt.name, status, apiErr, tt.wantCode, tt.wantRetryable)
+		}
+	}
+}
+
+func TestGuestSeesControllerFailure(t *testing.T) {
+	tests := []struct {
+		name          string
+		failWith      int
+		wantCode      string
+		wantRetryable bool
+	}{
+		{"controller down", http.StatusServiceUnavailable, ErrCodeControllerUnreachable, true},
+		{"controller overloaded", http.StatusTooManyRequests, ErrCodeControllerUnreachable, true},
+		{"error result on 200", http.StatusOK, ErrCodeAuthorizationRejected, false},
+		{"request rejected", http.StatusBadRequest, ErrCodeAuthorizationRejected, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newAdminPortal(t)
+			p.controller.failWith = tt.failWith
+			cacheId := cache.AddToCache(fmt.Sprintf("aa:bb:cc:dd:ee:%02x", 0x30+i), "11:22:33:44:55:66")
+
+			body := fmt.Sprintf(`{"cacheId":%q,"username":"Ada","email":"ada@example.com"}`, cacheId)
+			w := httptest.NewRecorder()
+			handleGuestAuthorization(w, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)),
+				p.store, p.client, 480, authorization.Limits{}, nil, nil, &consentPolicy{}, nil)
+
+			var response struct {
+				Error APIError `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			if w.Code != http.StatusBadGateway || response.Error.Code != tt.wantCode || response.Error.Retryable != tt.wantRetryable {
+				t.Errorf("got %d %s, want %s, retryable %v", w.Code, w.Body, tt.wantCode, tt.wantRetryable)
+			}
+
+			// The failure is recorded and the guest can retry with the same login
+			if stats, err := p.store.CountSessions(db.SessionFilter{}); err != nil || stats.Total != 0 || stats.FailedAttempts != 1 {
+				t.Errorf("got %+v, %v, want a failed attempt and no session", stats, err)
+			}
+			if cache.GetRecord(cacheId) == nil {
+				t.Error("the login was removed from the cache")
+			}
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{"object", `{"cacheId":"abc"}`, http.StatusOK, ""},
+		{"trailing newline", "{\"cacheId\":\"abc\"}\n", http.StatusOK, ""},
+		{"invalid JSON", `{"cacheId":`, http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"wrong shape", `["abc"]`, http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"trailing object", `{"cacheId":"abc"} {"cacheId":"def"}`, http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"trailing garbage", `{"cacheId":"abc"}x`, http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"at the limit", `{"cacheId":"` + strings.Repeat("a", maxBodyBytes-15) + `"}`, http.StatusOK, ""},
+		{"over the limit", `{"cacheId":"` + strings.Repeat("a", maxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, ErrCodeRequestTooLarge},
+	}
+	for _, tt := range tests {
+		var req LoginRequest
+		w := httptest.NewRecorder()
+		ok := decodeJSON(w, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(tt.body)), &req)
+		if tt.wantErr == "" {
+			if !ok || req.CacheID != strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(tt.body), `{"cacheId":"`), `"}`) {
+				t.Errorf("%s: got %v %d %s", tt.name, ok, w.Code, w.Body)
+			}
+			continue
+		}
+		if ok || w.Code != tt.wantCode || errorCode(w) != tt.wantErr {
+			t.Errorf("%s: got %v %d %s, want %d %s", tt.name, ok, w.Code, w.Body, tt.wantCode, tt.wantErr)
+		}
+	}
+}
+
+func TestUnknownCacheID(t *testing.T) {
+	p := newAdminPortal(t)
+	body := `{"cacheId":"unknown","username":"Ada","email":"ada@example.com","password":"secret","code":"ABCD-EFGH"}`
+
+	// A login whose cache entry expired or never existed ends with session_expired, not a panic
+	handlers := map[string]http.HandlerFunc{
+		"guest": func(w http.ResponseWriter, r *http.Request) {
+			handleGuestAuthorization(w, r, p.store, p.client, 480, authorization.Limits{}, nil, nil, &consentPolicy{}, nil)
+		},
+		"voucher": func(w http.ResponseWriter, r *http.Request) {
+			handleVoucherLogin(w, r, p.store, p.client, authorization.Limits{}, &consentPolicy{})
+		},
+		"RADIUS": func(w http.ResponseWriter, r *http.Request) {
+			handleRADIUSLogin(w, r, p.store, p.client, nil, 480, authorization.Limits{}, &consentPolicy{})
+		},
+		"email": func(w http.ResponseWriter, r *http.Request) {
+			handleEmailLogin(w, r, p.store, p.client, nil, 600, 0, authorization.Limits{}, &consentPolicy{}, nil)
+		},
+	}
+	for name, handler := range handlers {
+		w := httptest.NewRecorder()
+		recoverJSON(handler).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)))
+		if w.Code != http.StatusGone || errorCode(w) != ErrCodeSessionExpired {
+			t.Errorf("%s: got %d %s, want %d %s", name, w.Code, w.Body, http.StatusGone, ErrCodeSessionExpired)
+		}
+	}
+	if len(p.controller.authorizations()) != 0 {
+		t.Errorf("got authorizations %v, want none", p.controller.authorizations())
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	r := chi.NewRouter()
+	setupAPIErrors(r)
+	r.Post("/api/login", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/api/sponsor/status/{cacheId}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "front end") })
+	r.Get("/api/panic", func(w http.ResponseWriter, r *http.Request) {
+		var cacheInfo *cache.LoginCache
+		fmt.Fprint(w, cacheInfo.ID)
+	})
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantCode     string
+	}{
+		{http.MethodGet, "/api/nothing", http.StatusNotFound, ErrCodeNotFound}, // Not the front end
+		{http.MethodPost, "/api/admin/nothing", http.StatusNotFound, ErrCodeNotFound},
+		{http.MethodGet, "/api/login", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{http.MethodDelete, "/api/sponsor/status/abc", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{http.MethodPost, "/index.html", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{http.MethodGet, "/api/panic", http.StatusInternalServerError, ErrCodeInternal},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.wantStatus || errorCode(w) != tt.wantCode || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			t.Errorf("%s %s: got %d %s %s, want %d %s", tt.method, tt.path, w.Code, w.Header().Get("Content-Type"), w.Body, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
+
+func TestServeFrontendNonce(t *testing.T) {
+	dir := t.TempDir()
+	page := `<html lang="en"><head><style>p {}</style></head><body><p>{{.Nonce}}</p></body></html>`
+	if err := os.WriteFile(filepath.Join(dir, pages.Portal), []byte(page), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	renderer, err := pages.New(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each response has its own nonce, the same in the policy and on the inline script and style
+	var previous string
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		serveFrontend(w, httptest.NewRequest(http.MethodGet, "/", nil), dir, renderer, pages.Data{CacheID: "abc"})
+		match := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(w.Header().Get("Content-Security-Policy"))
+		if w.Code != http.StatusOK || match == nil || match[1] == previous {
+			t.Fatalf("got %d with policy %q", w.Code, w.Header().Get("Content-Security-Policy"))
+		}
+		nonce := match[1]
+		if strings.Count(w.Body.String(), `nonce="`+nonce+`"`) != 2 || !strings.Contains(w.Body.String(), "<p>{{.Nonce}}</p>") {
+			t.Errorf("got %s, want the nonce %s on the script and the style", w.Body, nonce)
+		}
+		previous = nonce
+	}
+}
@@ -0,0 +1,406 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/db"
+	"backend/export"
+	"backend/radius"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// Page sizes of GET /api/admin/sessions.
+const (
+	defaultSessionPage = 50
+	maxSessionPage     = 500
+)
+
+// SessionListResponse represents the JSON body returned by GET /api/admin/sessions.
+type SessionListResponse struct {
+	Sessions []db.SessionRecord `json:"sessions"` // Sessions of the requested page, newest first.
+	Total    int                `json:"total"`    // Number of sessions matching the filters across all pages.
+	Limit    int                `json:"limit"`    // Page size used.
+	Offset   int                `json:"offset"`   // Number of sessions skipped.
+}
+
+// handleListSessions handles GET /api/admin/sessions by listing the sessions matching the
+// query parameters described in parseSessionFilter.
+func handleListSessions(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	filter, ok := parseSessionFilter(w, r.URL.Query(), true)
+	if !ok {
+		return
+	}
+
+	sessions, total, err := store.ListSessions(filter)
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, SessionListResponse{Sessions: sessions, Total: total, Limit: filter.Limit, Offset: filter.Offset})
+}
+
+// handleExportSessions handles GET /api/admin/sessions/export by streaming the sessions
+// matching the query parameters described in parseSessionFilter as a file download, in
+// the `format` given by the query (csv, json or ndjson; default csv).
+//
+// Errors found before the first byte is sent get a JSON error response; later ones can
+// only be logged, and the download ends early.
+func handleExportSessions(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	query := r.URL.Query()
+	filter, ok := parseSessionFilter(w, query, false)
+	if !ok {
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	if !export.ValidFormat(format) {
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: "format must be csv, json or ndjson."})
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="sessions-%s.%s"`, time.Now().Format("2006-01-02"), format))
+	out := &trackingWriter{ResponseWriter: w}
+	if _, err := export.Sessions(store, filter, format, currentAdmin(r).Username, out); err != nil {
+		fmt.Println(err)
+		if !out.written {
+			w.Header().Del("Content-Disposition")
+			writeInternalError(w)
+		}
+	}
+}
+
+// trackingWriter records whether anything was written to a response.
+type trackingWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.written = true
+	return t.ResponseWriter.Write(p)
+}
+
+// handleSessionStats handles GET /api/admin/sessions/stats by counting the sessions
+// matching the query parameters described in parseSessionFilter.
+func handleSessionStats(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	filter, ok := parseSessionFilter(w, r.URL.Query(), false)
+	if !ok {
+		return
+	}
+
+	stats, err := store.CountSessions(filter)
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// handleGetSession handles GET /api/admin/sessions/{cacheId}.
+func handleGetSession(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	session, ok := lookupSession(w, r, store)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// handleRevokeSession handles POST /api/admin/sessions/{cacheId}/revoke by revoking the
+// guest's authorization on the controller.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - client: Shared UniFi controller client.
+// - accounting: RADIUS client notified of the revocation in radius mode, or nil in other modes.
+//
+// Responds with the updated session, or 409 if the session is no longer active.
+func handleRevokeSession(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client, accounting *radius.Client) {
+	session, ok := lookupActiveSession(w, r, store)
+	if !ok {
+		return
+	}
+
+	if err := revokeGuest(store, client, accounting, session.MAC); err != nil {
+		writeControllerFailure(w, err)
+		return
+	}
+
+	recordSessionUpdate(session.MAC, store.SetSessionStatus(session.CacheID, db.StatusRevoked))
+	writeUpdatedSession(w, store, session.CacheID)
+}
+
+// handleExtendSession handles POST /api/admin/sessions/{cacheId}/extend by extending the
+// guest's authorization on the controller by its original duration.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - client: Shared UniFi controller client.
+// - accounting: RADIUS client notified of the new expiry in radius mode, or nil in other modes.
+//
+// Responds with the updated session, or 409 if the session is no longer active.
+func handleExtendSession(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client, accounting *radius.Client) {
+	session, ok := lookupActiveSession(w, r, store)
+	if !ok {
+		return
+	}
+
+	duration, expiresAt, err := extendGuest(store, client, accounting, session.MAC)
+	if err != nil {
+		writeControllerFailure(w, err)
+		return
+	}
+
+	recordSessionUpdate(session.MAC, store.SetSessionExtended(session.CacheID, duration, expiresAt))
+	writeUpdatedSession(w, store, session.CacheID)
+}
+
+// reconcileSessionsEvery periodically brings the recorded sessions in line with reality:
+// sessions past their expiry are marked as ended and, if checkController is set, the
+// running sessions are cross-checked against the controller (see reconcileWithController).
+func reconcileSessionsEvery(store db.Storage, client *authorization.Client, interval time.Duration, checkController bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		if ended, err := store.EndExpiredSessions(time.Now()); err != nil {
+			fmt.Println(err)
+		} else if ended > 0 {
+			fmt.Printf("Marked %d expired sessions as ended\n", ended)
+		}
+		if checkController {
+			reconcileWithController(store, client)
+		}
+	}
+}
+
+// reconcileWithController compares the running sessions with the guest authorizations
+// on the controller, for changes made outside the portal (e.g. in the UniFi interface).
+//
+// Behavior:
+//   - Only the sessions of the client's site are checked, as other portals may share the database.
+//   - Sessions whose client has no valid authorization are ended as revoked.
+//   - Sessions whose authorization ends more than a minute away from the recorded expiry
+//     take the controller's expiry.
+//   - Sessions recorded after the guest list was read are left alone, and nothing is
+//     changed if the list cannot be read.
+func reconcileWithController(store db.Storage, client *authorization.Client) {
+	listedAt := time.Now()
+	guests, err := client.Guests()
+	if err != nil {
+		fmt.Println("Skipping session cross-check:", err)
+		return
+	}
+
+	// Latest end of the authorizations of each client
+	ends := map[string]time.Time{}
+	for _, guest := range guests {
+		mac := strings.ToLower(guest.MAC)
+		if end := time.Unix(guest.End, 0); end.After(ends[mac]) {
+			ends[mac] = end
+		}
+	}
+
+	active := true
+	sessions, _, err := store.ListSessions(db.SessionFilter{Active: &active, Site: client.Site(), Until: listedAt})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, session := range sessions {
+		end, ok := ends[session.MAC]
+		if !ok {
+			fmt.Printf("Guest %s is no longer authorized on the controller, ending its session\n", session.MAC)
+			recordSessionUpdate(session.MAC, store.EndSession(session.CacheID, db.EndRevoked))
+			continue
+		}
+		if drift := end.Sub(session.ExpiresAt); drift > time.Minute || drift < -time.Minute {
+			recordSessionUpdate(session.MAC, store.SetSessionExpiry(session.CacheID, end))
+		}
+	}
+}
+
+// parseSessionFilter reads the session filters from the query parameters of a request.
+// It writes a 400 response and returns false if a parameter is invalid.
+//
+// Query parameters:
+// - mac, ap: Exact client or access point MAC address, in any common notation.
+// - site: Exact UniFi site name.
+// - email: Exact email address, case-insensitive.
+// - name: Part of the guest's name.
+// - q: Part of the MAC address, email address or name.
+// - status: One of authorized, extended, revoked or kicked.
+// - active: "true" for sessions whose authorization is still running, "false" for the others.
+// - marketing: "true" for sessions whose guest agreed to receive marketing email.
+// - since, until: Creation time range, as RFC3339 timestamps or YYYY-MM-DD dates (UTC); until is exclusive.
+// - limit, offset: Page size (default 50, at most 500) and number of sessions to skip, if paginate is true.
+func parseSessionFilter(w http.ResponseWriter, query url.Values, paginate bool) (db.SessionFilter, bool) {
+	filter := db.SessionFilter{
+		Site:  strings.TrimSpace(query.Get("site")),
+		Email: strings.TrimSpace(query.Get("email")),
+		Name:  strings.TrimSpace(query.Get("name")),
+		Query: strings.TrimSpace(query.Get("q")),
+	}
+
+	invalid := func(message string) (db.SessionFilter, bool) {
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: message})
+		return db.SessionFilter{}, false
+	}
+
+	for param, target := range map[string]*string{"mac": &filter.MAC, "ap": &filter.AP} {
+		if value := query.Get(param); value != "" {
+			hw, err := net.ParseMAC(value)
+			if err != nil {
+				return invalid(fmt.Sprintf("%s must be a MAC address.", param))
+			}
+			*target = strings.ToLower(hw.String())
+		}
+	}
+
+	switch status := query.Get("status"); status {
+	case "", db.StatusAuthorized, db.StatusExtended, db.StatusRevoked, db.StatusKicked:
+		filter.Status = status
+	default:
+		return invalid("status must be one of authorized, extended, revoked or kicked.")
+	}
+
+	if value := query.Get("active"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			return invalid("active must be true or false.")
+		}
+		filter.Active = &active
+	}
+
+	if value := query.Get("marketing"); value != "" {
+		marketing, err := strconv.ParseBool(value)
+		if err != nil {
+			return invalid("marketing must be true or false.")
+		}
+		filter.Marketing = marketing
+	}
+
+	for param, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			t, err := parseTimeParam(value)
+			if err != nil {
+				return invalid(fmt.Sprintf("%s must be an RFC3339 timestamp or a YYYY-MM-DD date.", param))
+			}
+			*target = t
+		}
+	}
+
+	if !paginate {
+		return filter, true
+	}
+
+	filter.Limit = defaultSessionPage
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSessionPage {
+			return invalid(fmt.Sprintf("limit must be between 1 and %d.", maxSessionPage))
+		}
+		filter.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return invalid("offset must not be negative.")
+		}
+		filter.Offset = offset
+	}
+	return filter, true
+}
+
+// parseTimeParam parses an RFC3339 timestamp or a YYYY-MM-DD date (midnight UTC).
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// lookupSession returns the session in the `{cacheId}` URL parameter. It writes a 404
+// response and returns false if there is no such session.
+func lookupSession(w http.ResponseWriter, r *http.Request, store db.Storage) (*db.SessionRecord, bool) {
+	session, err := store.GetSession(chi.URLParam(r, "cacheId"))
+	if errors.Is(err, db.ErrNoSession) {
+		writeError(w, http.StatusNotFound, APIError{Code: ErrCodeNotFound, Message: "No session with this ID."})
+		return nil, false
+	} else if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return nil, false
+	}
+	return session, true
+}
+
+// lookupActiveSession is lookupSession for actions on running authorizations. It writes
+// a 409 response and returns false if the session has expired or was revoked.
+func lookupActiveSession(w http.ResponseWriter, r *http.Request, store db.Storage) (*db.SessionRecord, bool) {
+	session, ok := lookupSession(w, r, store)
+	if ok && !session.Active {
+		writeSessionNotActive(w)
+		return nil, false
+	}
+	return session, ok
+}
+
+// writeControllerFailure writes the response for a failed revoke or extend: 409 if the
+// controller no longer knows the guest, 502 otherwise.
+func writeControllerFailure(w http.ResponseWriter, err error) {
+	if errors.Is(err, errGuestNotAuthorized) {
+		writeSessionNotActive(w)
+		return
+	}
+	fmt.Println(err)
+	writeError(w, http.StatusBadGateway, APIError{
+		Code:      ErrCodeControllerUnreachable,
+		Message:   "The UniFi controller request failed.",
+		Retryable: true,
+	})
+}
+
+// writeSessionNotActive writes the 409 response for actions on sessions that have ended.
+func writeSessionNotActive(w http.ResponseWriter) {
+	writeError(w, http.StatusConflict, APIError{
+		Code:    ErrCodeSessionNotActive,
+		Message: "The session has expired or was revoked.",
+	})
+}
+
+// writeUpdatedSession responds with the current state of a session after an action.
+func writeUpdatedSession(w http.ResponseWriter, store db.Storage, cacheId string) {
+	session, err := store.GetSession(cacheId)
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// writeInternalError writes the 500 response for unexpected failures, such as database errors.
+func writeInternalError(w http.ResponseWriter) {
+	writeError(w, http.StatusInternalServerError, APIError{
+		Code:      ErrCodeInternal,
+		Message:   "Something went wrong. Please try again.",
+		Retryable: true,
+	})
+}
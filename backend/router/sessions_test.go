@@ -0,0 +1,258 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/db"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// adminPortal holds the dependencies of the admin handlers under test.
+type adminPortal struct {
+	store      db.Storage
+	controller *fakeController
+	client     *authorization.Client
+}
+
+// newAdminPortal sets up a SQLite database and a fake controller.
+func newAdminPortal(t *testing.T) *adminPortal {
+	t.Helper()
+	store, err := db.Open(db.DriverSQLite, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	controller, client := newFakeController(t)
+	return &adminPortal{store: store, controller: controller, client: client}
+}
+
+// authorize authorizes a guest on the controller for an hour and records its session.
+func (p *adminPortal) authorize(t *testing.T, cacheId, mac string) {
+	t.Helper()
+	if err := p.client.AuthorizeGuestProcess(mac, "11:22:33:44:55:66", 60, authorization.Limits{}); err != nil {
+		t.Fatal(err)
+	}
+	session := db.Session{CacheID: cacheId, ID: mac, AP: "11:22:33:44:55:66", Site: "default", Name: "Ada", Email: "ada@example.com", Duration: 60}
+	if err := p.store.WriteSession(session); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sessionAction posts to a session endpoint, e.g. revoke, with the `{cacheId}` URL parameter.
+func (p *adminPortal) sessionAction(handler func(http.ResponseWriter, *http.Request, db.Storage, *authorization.Client), cacheId string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, withURLParam(httptest.NewRequest(http.MethodPost, "/api/admin/sessions/"+cacheId, nil), "cacheId", cacheId), p.store, p.client)
+	return w
+}
+
+// revoke posts to POST /api/admin/sessions/{cacheId}/revoke.
+func (p *adminPortal) revoke(cacheId string) *httptest.ResponseRecorder {
+	return p.sessionAction(func(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client) {
+		handleRevokeSession(w, r, store, client, nil)
+	}, cacheId)
+}
+
+// extend posts to POST /api/admin/sessions/{cacheId}/extend.
+func (p *adminPortal) extend(cacheId string) *httptest.ResponseRecorder {
+	return p.sessionAction(func(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client) {
+		handleExtendSession(w, r, store, client, nil)
+	}, cacheId)
+}
+
+// decodeSession reads the session of a JSON response.
+func decodeSession(t *testing.T, w *httptest.ResponseRecorder) db.SessionRecord {
+	t.Helper()
+	var session db.SessionRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+		t.Fatalf("invalid session %s: %v", w.Body, err)
+	}
+	return session
+}
+
+func TestKickedSessionCanBeRevoked(t *testing.T) {
+	p := newAdminPortal(t)
+	p.authorize(t, "s1", "aa:bb:cc:dd:ee:01")
+
+	if w := p.guestAction("kick", "AA-BB-CC-DD-EE-01"); w.Code != http.StatusOK {
+		t.Fatalf("kick: got %d %s", w.Code, w.Body)
+	}
+	if kicks := p.controller.received("kick-sta"); len(kicks) != 1 || kicks[0]["mac"] != "aa:bb:cc:dd:ee:01" {
+		t.Fatalf("kick: controller got %v", kicks)
+	}
+
+	// The kicked client is still authorized, so its session is still running
+	session, err := p.store.GetSession("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Status != db.StatusKicked || !session.Active {
+		t.Errorf("kicked session: got status %s, active %v", session.Status, session.Active)
+	}
+
+	w := p.revoke("s1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke after kick: got %d %s", w.Code, w.Body)
+	}
+	if session := decodeSession(t, w); session.Status != db.StatusRevoked || session.Active || session.EndReason != db.EndRevoked {
+		t.Errorf("revoked session: got %+v", session)
+	}
+	if revocations := p.controller.received("unauthorize-guest"); len(revocations) != 1 {
+		t.Errorf("revoke: controller got %v", revocations)
+	}
+}
+
+func TestParseSessionFilter(t *testing.T) {
+	active := true
+	tests := []struct {
+		query string
+		want  db.SessionFilter // Compared when the query is valid.
+		valid bool
+	}{
+		{"", db.SessionFilter{Limit: 50}, true},
+		{"mac=AA-BB-CC-DD-EE-01&ap=1122.3344.5566", db.SessionFilter{MAC: "aa:bb:cc:dd:ee:01", AP: "11:22:33:44:55:66", Limit: 50}, true},
+		{"site=+default+&email=Ada@Example.com&q=ada", db.SessionFilter{Site: "default", Email: "Ada@Example.com", Query: "ada", Limit: 50}, true},
+		{"status=kicked&active=true&marketing=1", db.SessionFilter{Status: db.StatusKicked, Active: &active, Marketing: true, Limit: 50}, true},
+		{"since=2026-01-02&until=2026-01-03T10:00:00Z", db.SessionFilter{Since: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+			Until: time.Date(2026, 1, 3, 10, 0, 0, 0, time.UTC), Limit: 50}, true},
+		{"limit=1&offset=0", db.SessionFilter{Limit: 1}, true},
+		{"limit=500&offset=1000", db.SessionFilter{Limit: 500, Offset: 1000}, true},
+		{"limit=0", db.SessionFilter{}, false},
+		{"limit=501", db.SessionFilter{}, false},
+		{"limit=ten", db.SessionFilter{}, false},
+		{"offset=-1", db.SessionFilter{}, false},
+		{"mac=not-a-mac", db.SessionFilter{}, false},
+		{"ap=aa:bb", db.SessionFilter{}, false},
+		{"status=expired", db.SessionFilter{}, false},
+		{"active=maybe", db.SessionFilter{}, false},
+		{"marketing=yes", db.SessionFilter{}, false},
+		{"since=yesterday", db.SessionFilter{}, false},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		w := httptest.NewRecorder()
+		filter, ok := parseSessionFilter(w, query, true)
+		if ok != tt.valid {
+			t.Errorf("%q: got valid %v, want %v (%d %s)", tt.query, ok, tt.valid, w.Code, w.Body)
+			continue
+		}
+		if !ok {
+			if w.Code != http.StatusBadRequest || errorCode(w) != ErrCodeInvalidRequest {
+				t.Errorf("%q: got %d %s, want 400 %s", tt.query, w.Code, w.Body, ErrCodeInvalidRequest)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(filter, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.query, filter, tt.want)
+		}
+	}
+
+	// Page parameters are ignored where there are no pages, e.g. in exports
+	query, _ := url.ParseQuery("limit=9999")
+	if filter, ok := parseSessionFilter(httptest.NewRecorder(), query, false); !ok || filter.Limit != 0 {
+		t.Errorf("without pages: got %+v, %v", filter, ok)
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	p := newAdminPortal(t)
+	for i := 1; i <= 3; i++ {
+		p.authorize(t, fmt.Sprintf("s%d", i), fmt.Sprintf("aa:bb:cc:dd:ee:0%d", i))
+	}
+
+	list := func(query string) (*httptest.ResponseRecorder, SessionListResponse) {
+		w := httptest.NewRecorder()
+		handleListSessions(w, httptest.NewRequest(http.MethodGet, "/api/admin/sessions?"+query, nil), p.store)
+		var response SessionListResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	w, page := list("limit=2&offset=1")
+	if w.Code != http.StatusOK || page.Total != 3 || len(page.Sessions) != 2 || page.Limit != 2 || page.Offset != 1 {
+		t.Errorf("second page: got %d %+v", w.Code, page)
+	}
+	if w, page := list(""); w.Code != http.StatusOK || page.Total != 3 || len(page.Sessions) != 3 || page.Limit != 50 {
+		t.Errorf("default page: got %d %+v", w.Code, page)
+	}
+	if w, page := list("mac=AA-BB-CC-DD-EE-02"); w.Code != http.StatusOK || page.Total != 1 || page.Sessions[0].CacheID != "s2" {
+		t.Errorf("by MAC address: got %d %+v", w.Code, page)
+	}
+	if w, page := list("offset=5"); w.Code != http.StatusOK || page.Total != 3 || page.Sessions == nil || len(page.Sessions) != 0 {
+		t.Errorf("past the last page: got %d %s", w.Code, w.Body)
+	}
+	if w, _ := list("limit=501"); w.Code != http.StatusBadRequest || errorCode(w) != ErrCodeInvalidRequest {
+		t.Errorf("limit too large: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestSessionActions(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     string
+		cacheId    string
+		authorized bool // Whether the guest is authorized on the controller.
+		ended      bool // Whether the recorded session has ended.
+		failWith   int  // Status of the controller's stamgr answers, or 0.
+		wantCode   int
+		wantError  string
+	}{
+		{"revoke unknown session", "revoke", "missing", true, false, 0, http.StatusNotFound, ErrCodeNotFound},
+		{"extend unknown session", "extend", "missing", true, false, 0, http.StatusNotFound, ErrCodeNotFound},
+		{"revoke ended session", "revoke", "s1", true, true, 0, http.StatusConflict, ErrCodeSessionNotActive},
+		{"extend ended session", "extend", "s1", true, true, 0, http.StatusConflict, ErrCodeSessionNotActive},
+		{"extend guest unknown to the controller", "extend", "s1", false, false, 0, http.StatusConflict, ErrCodeSessionNotActive},
+		{"revoke failing", "revoke", "s1", true, false, http.StatusInternalServerError, http.StatusBadGateway, ErrCodeControllerUnreachable},
+		{"extend failing", "extend", "s1", true, false, http.StatusInternalServerError, http.StatusBadGateway, ErrCodeControllerUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newAdminPortal(t)
+			if tt.authorized {
+				p.authorize(t, "s1", "aa:bb:cc:dd:ee:01")
+			} else if err := p.store.WriteSession(db.Session{CacheID: "s1", ID: "aa:bb:cc:dd:ee:01", Duration: 60}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.ended {
+				if err := p.store.EndSession("s1", db.EndExpired); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p.controller.failWith = tt.failWith
+
+			action := p.revoke
+			if tt.action == "extend" {
+				action = p.extend
+			}
+			w := action(tt.cacheId)
+			if w.Code != tt.wantCode || errorCode(w) != tt.wantError {
+				t.Errorf("got %d %s, want %d %q", w.Code, w.Body, tt.wantCode, tt.wantError)
+			}
+			if session, err := p.store.GetSession("s1"); err != nil || session.Status != db.StatusAuthorized {
+				t.Errorf("recorded session: got %+v, %v, want it unchanged", session, err)
+			}
+		})
+	}
+}
+
+func TestExtendSession(t *testing.T) {
+	p := newAdminPortal(t)
+	p.authorize(t, "s1", "aa:bb:cc:dd:ee:01")
+
+	w := p.extend("s1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if session := decodeSession(t, w); session.Status != db.StatusExtended || session.Duration != 120 || !session.Active {
+		t.Errorf("extended session: got %+v", session)
+	}
+	if extensions := p.controller.received("extend"); len(extensions) != 1 {
+		t.Errorf("controller got %v", extensions)
+	}
+}
@@ -0,0 +1,106 @@
+package router
+
+import (
+	"backend/config"
+	"backend/retention"
+	"net/http"
+	"strings"
+)
+
+// SettingsResponse represents the JSON body returned by GET /api/admin/settings. It
+// describes the running configuration for the admin dashboard and never contains
+// passwords, secrets or tokens.
+type SettingsResponse struct {
+	PageTitle  string             `json:"pageTitle"`  // Portal name (VITE_PAGE_TITLE).
+	AuthMode   string             `json:"authMode"`   // Guest authentication mode.
+	Controller ControllerSettings `json:"controller"` // UniFi controller connection.
+	Guests     GuestSettings      `json:"guests"`     // Default guest session restrictions.
+	Mode       map[string]string  `json:"mode"`       // Settings of the authentication mode, as label/value pairs.
+	Retention  retention.Policy   `json:"retention"`  // How long guest data is kept.
+
+	GroupPolicies []config.GroupPolicy `json:"groupPolicies,omitempty"` // Session policies by group (ldap mode only).
+}
+
+// ControllerSettings describes the UniFi controller connection.
+type ControllerSettings struct {
+	URL        string `json:"url"`        // URL of the controller.
+	Site       string `json:"site"`       // Site guests are authorized on.
+	Type       string `json:"type"`       // Controller type ("unifios", "legacy" or "auto").
+	DisableTLS bool   `json:"disableTls"` // Whether TLS verification is disabled.
+}
+
+// GuestSettings describes the default guest session restrictions.
+type GuestSettings struct {
+	Duration int `json:"duration"` // Session duration in minutes.
+	Up       int `json:"up"`       // Upload limit in kbps (0 for unlimited).
+	Down     int `json:"down"`     // Download limit in kbps (0 for unlimited).
+	Quota    int `json:"quota"`    // Data quota in MB (0 for unlimited).
+}
+
+// handleSettings handles GET /api/admin/settings by describing the running configuration.
+func handleSettings(w http.ResponseWriter, cfg config.Config) {
+	settings := SettingsResponse{
+		PageTitle: pageTitle(),
+		AuthMode:  cfg.AuthMode,
+		Controller: ControllerSettings{
+			URL:        cfg.URL,
+			Site:       cfg.Site,
+			Type:       string(cfg.ControllerType),
+			DisableTLS: cfg.DisableTLS,
+		},
+		Guests: GuestSettings{
+			Duration: cfg.Duration,
+			Up:       cfg.Limits.Up,
+			Down:     cfg.Limits.Down,
+			Quota:    cfg.Limits.Bytes,
+		},
+		Mode:      map[string]string{},
+		Retention: cfg.Retention,
+	}
+
+	switch cfg.AuthMode {
+	case config.AuthModePassphrase:
+		settings.Mode["Daily rotation"] = valueOr(cfg.PassphraseRotateAt, "disabled")
+	case config.AuthModeEmail:
+		settings.Mode["SMTP server"] = cfg.SMTPHost
+		settings.Mode["Sender"] = cfg.SMTPFrom
+	case config.AuthModeSponsor:
+		settings.Mode["SMTP server"] = cfg.SMTPHost
+		settings.Mode["Portal URL"] = cfg.PortalURL
+		settings.Mode["Hosts"] = joinOr(cfg.SponsorHosts, "none")
+		settings.Mode["Host domains"] = joinOr(cfg.SponsorDomains, "none")
+	case config.AuthModeOIDC:
+		settings.Mode["Issuer"] = cfg.OIDCIssuer
+		settings.Mode["Client ID"] = cfg.OIDCClientID
+		settings.Mode["Provider name"] = cfg.OIDCProviderName
+	case config.AuthModeRADIUS:
+		settings.Mode["Server"] = cfg.RADIUSServer
+		settings.Mode["Accounting server"] = valueOr(cfg.RADIUSAccountingServer, "disabled")
+		settings.Mode["Method"] = cfg.RADIUSAuthMethod
+		settings.Mode["NAS identifier"] = cfg.RADIUSNASIdentifier
+	case config.AuthModeLDAP:
+		settings.Mode["Server"] = cfg.LDAPURL
+		settings.Mode["Base DN"] = cfg.LDAPBaseDN
+		settings.Mode["User attribute"] = cfg.LDAPUserAttribute
+		settings.Mode["Service account"] = valueOr(cfg.LDAPBindDN, "anonymous")
+		settings.GroupPolicies = cfg.LDAPGroupPolicies
+	}
+
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// valueOr returns value, or fallback if value is empty.
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// joinOr returns the values separated by commas, or fallback if there are none.
+func joinOr(values []string, fallback string) string {
+	if len(values) == 0 {
+		return fallback
+	}
+	return strings.Join(values, ", ")
+}
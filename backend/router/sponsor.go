@@ -0,0 +1,392 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/cache"
+	"backend/config"
+	"backend/db"
+	"backend/formfields"
+	"backend/mailer"
+	"backend/sponsor"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// SponsorStatusResponse represents the JSON body describing the state of a sponsor request.
+type SponsorStatusResponse struct {
+	Status    string    `json:"status"`    // One of "pending", "approved", "denied", "withdrawn" or "expired".
+	Sponsor   string    `json:"sponsor"`   // Email address of the host asked for approval.
+	ExpiresAt time.Time `json:"expiresAt"` // Time after which the host can no longer approve.
+}
+
+// SponsorHostsResponse represents the JSON body listing the hosts a guest can ask for approval.
+type SponsorHostsResponse struct {
+	Hosts   []string `json:"hosts"`   // Host email addresses to pick from.
+	Domains []string `json:"domains"` // Email domains of hosts the guest can type in.
+}
+
+// handleSponsorLogin handles the POST /api/login requests in sponsor mode by emailing
+// the chosen host a signed link to approve or deny the guest.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - sender: Mailer used to contact the host.
+// - signer: Signer of the approve/deny links.
+// - cfg: Configuration object holding the portal URL, allowed hosts and request timeout.
+// - consents: Terms of service and marketing consent policy.
+// - fields: Custom form fields to validate the answers against.
+//
+// Behavior:
+// - Rejects missing or invalid answers to the custom form fields, which are stored with the request.
+// - Rejects requests that did not accept the current terms of service; the consent is stored with the request and recorded once approved.
+// - Checks that the host is in SPONSOR_HOSTS or has an address in SPONSOR_DOMAINS.
+// - Stores a pending request with the client and AP MAC addresses, so it outlives the login cache.
+// - Refuses a new request while the previous one is pending; the guest withdraws it first to ask another host.
+// - Sends the host an email with approve and deny links that expire with the request and are bound to its details.
+// - Responds with 202 Accepted and a SponsorStatusResponse; the guest polls GET /api/sponsor/status/{cacheId}.
+func handleSponsorLogin(w http.ResponseWriter, r *http.Request, store db.Storage, sender *mailer.Mailer, signer *sponsor.Signer, cfg config.Config, consents *consentPolicy, fields []formfields.Field) {
+	var req LoginRequest
+
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if !validateLogin(w, &req, true) {
+		return
+	}
+
+	cacheInfo, ok := lookupCache(w, req.CacheID)
+	if !ok {
+		return
+	}
+
+	answers, ok := checkFormFields(w, fields, req.Fields)
+	if !ok {
+		return
+	}
+
+	if !checkConsent(w, consents, req.ConsentFields) {
+		return
+	}
+
+	host, ok := allowedSponsor(req.Sponsor, cfg.SponsorHosts, cfg.SponsorDomains)
+	if !ok {
+		writeError(w, http.StatusBadRequest, APIError{
+			Code:    ErrCodeInvalidSponsor,
+			Message: "Please enter the email address of the person you are visiting.",
+		})
+		return
+	}
+
+	if previous, err := store.GetSponsorRequest(req.CacheID); err == nil {
+		switch {
+		case previous.Status == db.SponsorApproved:
+			writeSponsorStatus(w, http.StatusOK, previous)
+			return
+		case previous.Status == db.SponsorPending:
+			writeSponsorPending(w)
+			return
+		case time.Since(previous.CreatedAt) < resendInterval:
+			writeError(w, http.StatusTooManyRequests, APIError{
+				Code:    ErrCodeRequestRecentlySent,
+				Message: "Your host was contacted moments ago. Please wait a few seconds before asking again.",
+			})
+			return
+		}
+	}
+
+	now := time.Now()
+	request := db.SponsorRequest{
+		CacheID:   req.CacheID,
+		ID:        cacheInfo.ID,
+		AP:        cacheInfo.AP,
+		Name:      req.Name,
+		Email:     req.Email,
+		Sponsor:   host,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(cfg.SponsorTimeout) * time.Minute),
+		Fields:    answers,
+		Consent:   consents.pending(r, req.CacheID, cacheInfo, req.ConsentFields, req.Email),
+	}
+	if err := store.WriteSponsorRequest(request); errors.Is(err, db.ErrSponsorRequestPending) {
+		writeSponsorPending(w)
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+
+	decisionURL := func(action string) string {
+		return cfg.PortalURL + "/sponsor/decide?" + signer.Link(req.CacheID, requestFingerprint(&request), action, request.ExpiresAt).Encode()
+	}
+	guest := req.Name
+	if req.Email != "" {
+		guest = fmt.Sprintf("%s <%s>", req.Name, req.Email)
+	}
+	subject := fmt.Sprintf("%s: %s is asking for Wi-Fi access", pageTitle(), req.Name)
+	body := fmt.Sprintf("%s is asking for guest Wi-Fi access and named you as their host.\n\n"+
+		"Approve: %s\n\nDeny: %s\n\n"+
+		"The links expire in %d minutes. If you are not expecting this guest, deny the request or ignore this email.\n",
+		guest, decisionURL(sponsor.ActionApprove), decisionURL(sponsor.ActionDeny), cfg.SponsorTimeout)
+	if err := sender.Send(host, subject, body); err != nil {
+		fmt.Println(err)
+		store.WriteFailedAttempt(req.CacheID, cacheInfo.ID, cacheInfo.AP, req.Name, req.Email, ErrCodeEmailFailed, err.Error())
+		writeError(w, http.StatusBadGateway, APIError{
+			Code:      ErrCodeEmailFailed,
+			Message:   "We couldn't email your host. Please check the address or try again.",
+			Retryable: true,
+		})
+		return
+	}
+
+	request.Status = db.SponsorPending
+	writeSponsorStatus(w, http.StatusAccepted, &request)
+}
+
+// handleSponsorStatus handles the GET /api/sponsor/status/{cacheId} requests polled by
+// the guest while waiting for the host's decision.
+func handleSponsorStatus(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	request, err := store.GetSponsorRequest(chi.URLParam(r, "cacheId"))
+	if errors.Is(err, db.ErrNoSponsorRequest) {
+		writeError(w, http.StatusNotFound, APIError{
+			Code:    ErrCodeNoSponsorRequest,
+			Message: "No approval was requested for this login. Please fill in the form again.",
+		})
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+
+	writeSponsorStatus(w, http.StatusOK, request)
+}
+
+// handleSponsorWithdraw handles the POST /api/sponsor/withdraw/{cacheId} requests sent
+// when the guest gives up waiting to ask another host. The links sent to the host stop
+// working. Responds with the SponsorStatusResponse of the request, which may have been
+// decided in the meantime.
+func handleSponsorWithdraw(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	cacheId := chi.URLParam(r, "cacheId")
+	if err := store.WithdrawSponsorRequest(cacheId); err != nil && !errors.Is(err, db.ErrSponsorRequestClosed) {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	handleSponsorStatus(w, r, store)
+}
+
+// handleSponsorDecisionPage handles the GET /sponsor/decide requests opened from the
+// host's email. It only shows a confirmation button, so link scanners that prefetch
+// URLs in emails cannot approve or deny a guest.
+func handleSponsorDecisionPage(w http.ResponseWriter, r *http.Request, store db.Storage, signer *sponsor.Signer) {
+	request, action, ok := verifySponsorLink(w, store, signer, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	if request.Status != db.SponsorPending {
+		renderSponsorPage(w, http.StatusConflict, "Request closed", closedMessage(request.Status), nil, "")
+		return
+	}
+
+	guest := request.Name
+	if request.Email != "" {
+		guest = fmt.Sprintf("%s (%s)", request.Name, request.Email)
+	}
+	heading := "Approve Wi-Fi access?"
+	if action == sponsor.ActionDeny {
+		heading = "Deny Wi-Fi access?"
+	}
+	renderSponsorPage(w, http.StatusOK, heading,
+		fmt.Sprintf("%s is asking for guest Wi-Fi access and named you as their host.", guest), r.URL.Query(), action)
+}
+
+// handleSponsorDecision handles the POST /sponsor/decide requests sent by the
+// confirmation page.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - client: Shared UniFi controller client.
+// - signer: Signer of the approve/deny links.
+// - duration: Session duration.
+// - limits: Bandwidth and data quota restrictions for the guest.
+//
+// Behavior:
+// - Records the decision, so a request can only be decided once.
+// - On approval, records the consent stored with the request, authorizes the guest with its MAC addresses and writes the session to the database.
+// - If the authorization fails, the request is reopened so the host can try the link again.
+func handleSponsorDecision(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client, signer *sponsor.Signer, duration int, limits authorization.Limits) {
+	if err := r.ParseForm(); err != nil {
+		renderSponsorPage(w, http.StatusBadRequest, "Invalid link", "This link is invalid.", nil, "")
+		return
+	}
+	request, action, ok := verifySponsorLink(w, store, signer, r.PostForm)
+	if !ok {
+		return
+	}
+
+	status := db.SponsorApproved
+	if action == sponsor.ActionDeny {
+		status = db.SponsorDenied
+	}
+	if err := store.DecideSponsorRequest(request.CacheID, status); errors.Is(err, db.ErrSponsorRequestClosed) {
+		if latest, err := store.GetSponsorRequest(request.CacheID); err == nil {
+			request = latest
+		}
+		renderSponsorPage(w, http.StatusConflict, "Request closed", closedMessage(request.Status), nil, "")
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		renderSponsorPage(w, http.StatusInternalServerError, "Something went wrong", "The request could not be processed. Please try again in a moment.", nil, "")
+		return
+	}
+
+	if status == db.SponsorDenied {
+		renderSponsorPage(w, http.StatusOK, "Request denied", fmt.Sprintf("%s will not get Wi-Fi access.", request.Name), nil, "")
+		return
+	}
+
+	if err := writeConsent(store, request.Consent); err != nil {
+		fmt.Println(err)
+		if err := store.ReopenSponsorRequest(request.CacheID); err != nil {
+			fmt.Println(err)
+		}
+		renderSponsorPage(w, http.StatusInternalServerError, "Something went wrong", "The request could not be processed. Please try again in a moment.", nil, "")
+		return
+	}
+
+	err := client.AuthorizeGuestProcess(request.ID, request.AP, duration, limits)
+	if err != nil {
+		fmt.Println(err)
+		_, apiErr := authorizationError(err)
+		store.WriteFailedAttempt(request.CacheID, request.ID, request.AP, request.Name, request.Email, apiErr.Code, err.Error())
+		if err := store.ReopenSponsorRequest(request.CacheID); err != nil {
+			fmt.Println(err)
+		}
+		renderSponsorPage(w, http.StatusBadGateway, "Guest not connected",
+			"The guest could not be authorized on the network. Please open the link again in a moment.", nil, "")
+		return
+	}
+
+	recordSession(store, client, db.Session{
+		CacheID:  request.CacheID,
+		ID:       request.ID,
+		AP:       request.AP,
+		Name:     request.Name,
+		Email:    request.Email,
+		Duration: duration,
+		Sponsor:  request.Sponsor,
+		Fields:   request.Fields,
+	})
+	cache.RemoveFromCache(request.CacheID)
+
+	renderSponsorPage(w, http.StatusOK, "Access approved", fmt.Sprintf("%s is now connected to the Wi-Fi network.", request.Name), nil, "")
+}
+
+// verifySponsorLink checks the signature of approve/deny link parameters and loads the
+// request they refer to. It renders an error page and returns false if the link is
+// invalid, expired or refers to an unknown request.
+func verifySponsorLink(w http.ResponseWriter, store db.Storage, signer *sponsor.Signer, params url.Values) (*db.SponsorRequest, string, bool) {
+	request, err := store.GetSponsorRequest(params.Get("id"))
+	if errors.Is(err, db.ErrNoSponsorRequest) {
+		renderSponsorPage(w, http.StatusNotFound, "Invalid link", "This guest request no longer exists.", nil, "")
+		return nil, "", false
+	} else if err != nil {
+		fmt.Println(err)
+		renderSponsorPage(w, http.StatusInternalServerError, "Something went wrong", "The request could not be processed. Please try again in a moment.", nil, "")
+		return nil, "", false
+	}
+
+	// The signature covers the request details, so links to a replaced request fail here
+	_, action, err := signer.Verify(params, requestFingerprint(request))
+	if err != nil {
+		renderSponsorPage(w, http.StatusBadRequest, "Invalid link", "This link is invalid or has expired.", nil, "")
+		return nil, "", false
+	}
+	return request, action, true
+}
+
+// requestFingerprint returns the details of a sponsor request its links are bound to.
+func requestFingerprint(request *db.SponsorRequest) string {
+	return sponsor.Fingerprint(request.ID, request.AP, request.Name, request.Email, request.Sponsor, request.CreatedAt)
+}
+
+// writeSponsorPending writes the error for a new request while the previous one is pending.
+func writeSponsorPending(w http.ResponseWriter) {
+	writeError(w, http.StatusConflict, APIError{
+		Code:    ErrCodeSponsorRequestPending,
+		Message: "Your host has not answered yet. Withdraw the request to ask someone else.",
+	})
+}
+
+// allowedSponsor normalises a host email address and checks it against the configured
+// hosts and domains.
+//
+// Returns:
+//   - string: The lowercased host address.
+//   - bool: Whether the guest may ask this host for approval.
+func allowedSponsor(value string, hosts, domains []string) (string, bool) {
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Address != strings.TrimSpace(value) {
+		return "", false
+	}
+	host := strings.ToLower(address.Address)
+
+	for _, allowed := range hosts {
+		if host == allowed {
+			return host, true
+		}
+	}
+	domain := host[strings.LastIndex(host, "@")+1:]
+	for _, allowed := range domains {
+		if domain == allowed {
+			return host, true
+		}
+	}
+	return "", false
+}
+
+// closedMessage describes a request that can no longer be decided.
+func closedMessage(status string) string {
+	switch status {
+	case db.SponsorApproved:
+		return "This guest has already been approved."
+	case db.SponsorDenied:
+		return "This guest has already been denied."
+	case db.SponsorWithdrawn:
+		return "The guest withdrew this request."
+	}
+	return "This request has expired. The guest needs to ask again."
+}
+
+// writeSponsorStatus writes a SponsorStatusResponse for a request.
+func writeSponsorStatus(w http.ResponseWriter, status int, request *db.SponsorRequest) {
+	writeJSON(w, status, SponsorStatusResponse{
+		Status:    request.Status,
+		Sponsor:   request.Sponsor,
+		ExpiresAt: request.ExpiresAt,
+	})
+}
+
+// renderSponsorPage writes a sponsor page with the given status code.
+func renderSponsorPage(w http.ResponseWriter, status int, heading, message string, form url.Values, action string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	page := sponsor.DecisionPage{Title: pageTitle(), Heading: heading, Message: message, Form: form, Action: action}
+	if err := sponsor.RenderDecisionPage(w, page); err != nil {
+		fmt.Println(err)
+	}
+}
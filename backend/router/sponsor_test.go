@@ -0,0 +1,176 @@
+package router
+
+import (
+	"backend/cache"
+	"backend/config"
+	"backend/db"
+	"backend/sponsor"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// sponsorPortal holds the dependencies of the sponsor mode handlers under test.
+type sponsorPortal struct {
+	*emailPortal
+	signer *sponsor.Signer
+	cfg    config.Config
+}
+
+// newSponsorPortal sets up the sponsor mode with two hosts.
+func newSponsorPortal(t *testing.T) *sponsorPortal {
+	t.Helper()
+	signer, err := sponsor.NewSigner("test secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sponsorPortal{
+		emailPortal: newEmailPortal(t),
+		signer:      signer,
+		cfg: config.Config{
+			PortalURL:      "http://portal.example.com",
+			SponsorHosts:   []string{"alice@example.com", "bob@example.com"},
+			SponsorTimeout: 60,
+			Duration:       480,
+		},
+	}
+}
+
+// ask posts a sponsor login for a cache ID.
+func (p *sponsorPortal) ask(cacheId, name, host string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"cacheId":%q,"username":%q,"sponsor":%q}`, cacheId, name, host)
+	w := httptest.NewRecorder()
+	handleSponsorLogin(w, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)),
+		p.store, p.sender, p.signer, p.cfg, &consentPolicy{}, nil)
+	return w
+}
+
+// approveLink waits for the next host email and returns the parameters of its approve link.
+func (p *sponsorPortal) approveLink(t *testing.T) url.Values {
+	t.Helper()
+	select {
+	case message := <-p.sink.messages:
+		link := regexp.MustCompile(`Approve: \S+\?(\S+)`).FindStringSubmatch(message)
+		if link == nil {
+			t.Fatalf("no approve link in message:\n%s", message)
+		}
+		params, err := url.ParseQuery(link[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return params
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+		return nil
+	}
+}
+
+// decide posts the confirmation of a decision link.
+func (p *sponsorPortal) decide(params url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/sponsor/decide", strings.NewReader(params.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleSponsorDecision(w, r, p.store, p.client, p.signer, p.cfg.Duration, p.cfg.Limits)
+	return w
+}
+
+// withdraw posts the withdrawal of the request of a cache ID.
+func (p *sponsorPortal) withdraw(cacheId string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/sponsor/withdraw/"+cacheId, nil)
+	r = withURLParam(r, "cacheId", cacheId)
+	w := httptest.NewRecorder()
+	handleSponsorWithdraw(w, r, p.store)
+	return w
+}
+
+// withURLParam adds a chi URL parameter to a request, as the router does.
+func withURLParam(r *http.Request, key, value string) *http.Request {
+	routeContext := chi.NewRouteContext()
+	routeContext.URLParams.Add(key, value)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, routeContext))
+}
+
+// rewriteRequest replaces a closed request with a copy, e.g. to backdate it.
+func (p *sponsorPortal) rewriteRequest(t *testing.T, request *db.SponsorRequest) {
+	t.Helper()
+	if err := p.store.WriteSponsorRequest(*request); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.store.WithdrawSponsorRequest(request.CacheID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSponsorPendingRequestIsNotReplaced(t *testing.T) {
+	p := newSponsorPortal(t)
+	cacheId := cache.AddToCache("aa:bb:cc:dd:ee:10", "11:22:33:44:55:66")
+
+	if w := p.ask(cacheId, "Ada", "alice@example.com"); w.Code != http.StatusAccepted {
+		t.Fatalf("first request: got %d %s", w.Code, w.Body)
+	}
+	link := p.approveLink(t)
+
+	w := p.ask(cacheId, "Mallory", "bob@example.com")
+	if w.Code != http.StatusConflict || errorCode(w) != ErrCodeSponsorRequestPending {
+		t.Fatalf("replacing a pending request: got %d %s", w.Code, w.Body)
+	}
+	if request, _ := p.store.GetSponsorRequest(cacheId); request.Name != "Ada" || request.Sponsor != "alice@example.com" {
+		t.Errorf("stored request: got %+v, want the first request", request)
+	}
+
+	if w := p.decide(link); w.Code != http.StatusOK {
+		t.Fatalf("approve: got %d %s", w.Code, w.Body)
+	}
+	if session, err := p.store.GetSession(cacheId); err != nil || session.Name != "Ada" {
+		t.Errorf("session: got %+v, %v", session, err)
+	}
+}
+
+func TestSponsorLinkIsBoundToRequest(t *testing.T) {
+	p := newSponsorPortal(t)
+	cacheId := cache.AddToCache("aa:bb:cc:dd:ee:11", "11:22:33:44:55:66")
+
+	if w := p.ask(cacheId, "Ada", "alice@example.com"); w.Code != http.StatusAccepted {
+		t.Fatalf("first request: got %d %s", w.Code, w.Body)
+	}
+	oldLink := p.approveLink(t)
+
+	if w := p.withdraw(cacheId); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"withdrawn"`) {
+		t.Fatalf("withdraw: got %d %s", w.Code, w.Body)
+	}
+	if w := p.decide(oldLink); w.Code != http.StatusConflict {
+		t.Errorf("approve a withdrawn request: got %d %s", w.Code, w.Body)
+	}
+
+	// A new request under the same cache ID, made once the resend interval has passed
+	request, err := p.store.GetSponsorRequest(cacheId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.CreatedAt = request.CreatedAt.Add(-time.Minute)
+	p.rewriteRequest(t, request)
+
+	if w := p.ask(cacheId, "Mallory", "bob@example.com"); w.Code != http.StatusAccepted {
+		t.Fatalf("second request: got %d %s", w.Code, w.Body)
+	}
+	newLink := p.approveLink(t)
+
+	if w := p.decide(oldLink); w.Code != http.StatusBadRequest {
+		t.Errorf("old link for the new request: got %d %s", w.Code, w.Body)
+	}
+	if got := p.controller.authorizations(); len(got) != 0 {
+		t.Fatalf("authorizations after the old link: got %v, want none", got)
+	}
+
+	if w := p.decide(newLink); w.Code != http.StatusOK {
+		t.Errorf("new link: got %d %s", w.Code, w.Body)
+	}
+}
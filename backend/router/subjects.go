@@ -0,0 +1,148 @@
+package router
+
+import (
+	"backend/db"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Page sizes of GET /api/admin/audit.
+const (
+	defaultAuditPage = 50
+	maxAuditPage     = 500
+)
+
+// EraseSubjectRequest represents the JSON body of POST /api/admin/subjects/erase.
+type EraseSubjectRequest struct {
+	Email     string `json:"email"`     // Email address of the guest.
+	MAC       string `json:"mac"`       // MAC address of the guest's client.
+	Mode      string `json:"mode"`      // "delete" (default) or "anonymize".
+	Reference string `json:"reference"` // Reference of the request (e.g. a ticket number), recorded in the audit log.
+}
+
+// AuditListResponse represents the JSON body returned by GET /api/admin/audit.
+type AuditListResponse struct {
+	Entries []db.AuditEntry `json:"entries"` // Entries of the requested page, newest first.
+	Total   int             `json:"total"`   // Number of entries in the log.
+	Limit   int             `json:"limit"`   // Page size used.
+	Offset  int             `json:"offset"`  // Number of entries skipped.
+}
+
+// handleExportSubject handles GET /api/admin/subjects by returning every record stored
+// about the guest given by the `email` and/or `mac` query parameters (GDPR right of
+// access). The export is recorded in the audit log, with the optional `reference`
+// parameter.
+func handleExportSubject(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	query := r.URL.Query()
+	subject, ok := parseSubject(w, query.Get("email"), query.Get("mac"))
+	if !ok {
+		return
+	}
+
+	data, err := store.ExportSubject(subject, currentAdmin(r).Username, query.Get("reference"))
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="subject-export.json"`)
+	writeJSON(w, http.StatusOK, data)
+}
+
+// handleEraseSubject handles POST /api/admin/subjects/erase by deleting or anonymising
+// every record stored about a guest (GDPR right to erasure). The erasure is recorded in
+// the audit log under the signed-in account, without the guest's identifiers.
+//
+// Responds with the number of records erased per table.
+func handleEraseSubject(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	var req EraseSubjectRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	subject, ok := parseSubject(w, req.Email, req.MAC)
+	if !ok {
+		return
+	}
+
+	var anonymize bool
+	switch req.Mode {
+	case "", "delete":
+	case "anonymize":
+		anonymize = true
+	default:
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: "mode must be delete or anonymize."})
+		return
+	}
+
+	report, err := store.EraseSubject(subject, anonymize, currentAdmin(r).Username, req.Reference)
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleListAuditEntries handles GET /api/admin/audit by listing the audit log, newest
+// first, paginated by the `limit` and `offset` query parameters.
+func handleListAuditEntries(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	query := r.URL.Query()
+	limit, offset := defaultAuditPage, 0
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxAuditPage {
+			writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: fmt.Sprintf("limit must be between 1 and %d.", maxAuditPage)})
+			return
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		var err error
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: "offset must not be negative."})
+			return
+		}
+	}
+
+	entries, total, err := store.ListAuditEntries(limit, offset)
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, AuditListResponse{Entries: entries, Total: total, Limit: limit, Offset: offset})
+}
+
+// handleVerifyAuditLog handles GET /api/admin/audit/verify by checking the hash chain of
+// the audit log (see db.VerifyAuditLog).
+func handleVerifyAuditLog(w http.ResponseWriter, store db.Storage) {
+	result, err := store.VerifyAuditLog()
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// parseSubject builds the data subject of a request from an email address and a MAC
+// address, at least one of which must be given. It writes a 400 response and returns
+// false if the subject is empty or the MAC address is malformed.
+func parseSubject(w http.ResponseWriter, email string, mac string) (db.Subject, bool) {
+	subject := db.Subject{Email: strings.TrimSpace(email)}
+	if mac = strings.TrimSpace(mac); mac != "" {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: "mac must be a MAC address."})
+			return db.Subject{}, false
+		}
+		subject.MAC = strings.ToLower(hw.String())
+	}
+	if subject.Email == "" && subject.MAC == "" {
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: "An email address or a MAC address is required."})
+		return db.Subject{}, false
+	}
+	return subject, true
+}
@@ -0,0 +1,119 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"runtime/debug"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxBodyBytes is the largest JSON request body accepted by the API. Login requests are
+// well under a kilobyte; the limit leaves room for custom form fields and admin requests.
+const maxBodyBytes = 64 << 10
+
+// Length limits of the fields of a LoginRequest, in characters.
+const (
+	maxNameLength   = 100 // Guest name or account username.
+	maxEmailLength  = 254 // Email addresses of the guest and the host (RFC 5321).
+	maxSecretLength = 256 // Passwords and passphrases.
+)
+
+// decodeJSON decodes the JSON body of a request into v, reading at most maxBodyBytes.
+// It writes a 413 request_too_large error if the body is larger, or a 400
+// invalid_request error if it is not a single JSON value of the expected shape, and
+// returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	err := decoder.Decode(v)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("unexpected data after the JSON body")
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, APIError{
+			Code:    ErrCodeRequestTooLarge,
+			Message: fmt.Sprintf("The request body must not exceed %d KB.", maxBodyBytes>>10),
+		})
+		return false
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: "Invalid JSON body."})
+		return false
+	}
+	return true
+}
+
+// validateLogin normalises the fields of a login request and checks them against the
+// field rules. It writes a 400 error naming the offending field and returns false if a
+// field breaks a rule.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - req: Decoded request; the name, email and host addresses are trimmed in place.
+// - nameRequired: Whether the guest must enter a name (modes with the guest form).
+//
+// Behavior:
+// - Names must be at most maxNameLength characters long, without control characters.
+// - Email addresses, if given, must be a single plain address of at most maxEmailLength characters.
+// - Passwords and passphrases must be at most maxSecretLength characters long.
+// - Mode-specific checks (e.g. the email being required in email mode) are left to the handlers.
+func validateLogin(w http.ResponseWriter, req *LoginRequest, nameRequired bool) bool {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Email = strings.TrimSpace(req.Email)
+	req.Sponsor = strings.TrimSpace(req.Sponsor)
+
+	invalid := func(code, field, message string) bool {
+		writeError(w, http.StatusBadRequest, APIError{Code: code, Message: message, Field: field})
+		return false
+	}
+
+	switch {
+	case nameRequired && req.Name == "":
+		return invalid(ErrCodeInvalidField, "username", "Please enter your name.")
+	case utf8.RuneCountInString(req.Name) > maxNameLength:
+		return invalid(ErrCodeInvalidField, "username", fmt.Sprintf("Your name must be at most %d characters long.", maxNameLength))
+	case strings.IndexFunc(req.Name, unicode.IsControl) >= 0:
+		return invalid(ErrCodeInvalidField, "username", "Your name contains characters that are not allowed.")
+	}
+
+	if req.Email != "" {
+		address, err := mail.ParseAddress(req.Email)
+		if err != nil || address.Address != req.Email || len(req.Email) > maxEmailLength {
+			return invalid(ErrCodeInvalidEmail, "email", "Please enter a valid email address.")
+		}
+	}
+	if len(req.Sponsor) > maxEmailLength {
+		return invalid(ErrCodeInvalidSponsor, "sponsor", "Please enter the email address of the person you are visiting.")
+	}
+
+	for field, secret := range map[string]string{"password": req.Password, "passphrase": req.Passphrase} {
+		if utf8.RuneCountInString(secret) > maxSecretLength {
+			return invalid(ErrCodeInvalidField, field, fmt.Sprintf("The %s must be at most %d characters long.", field, maxSecretLength))
+		}
+	}
+	return true
+}
+
+// recoverJSON is a middleware that turns a panic in a handler into an internal_error
+// response, so a bug fails a single request with the usual JSON error instead of
+// dropping the connection. The panic and its stack trace are logged.
+func recoverJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				fmt.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, recovered, debug.Stack())
+				writeInternalError(w)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
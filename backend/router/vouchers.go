@@ -0,0 +1,194 @@
+package router
+
+import (
+	"backend/authorization"
+	"backend/db"
+	"backend/voucher"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxVoucherBatch is the largest number of vouchers that can be generated in one request.
+const maxVoucherBatch = 1000
+
+// VoucherLoginRequest represents the structure of the JSON body for the voucher login API.
+type VoucherLoginRequest struct {
+	CacheID string `json:"cacheId"` // Cache identifier
+	Code    string `json:"voucher"` // Voucher code as typed by the guest
+
+	ConsentFields // Terms of service acceptance and marketing opt-in
+}
+
+// CreateVouchersRequest represents the JSON body for generating a batch of vouchers.
+type CreateVouchersRequest struct {
+	Count     int        `json:"count"`     // Number of vouchers to generate.
+	Duration  int        `json:"duration"`  // Session duration in minutes (default: UNIFI_DURATION).
+	MaxUses   int        `json:"maxUses"`   // Number of uses per voucher (default: 1).
+	Quota     int        `json:"quota"`     // Data quota per session in MB (default: UNIFI_QUOTA_MB).
+	ExpiresAt *time.Time `json:"expiresAt"` // Optional time after which the vouchers can't be redeemed.
+	Note      string     `json:"note"`      // Optional note stored with and printed on each voucher.
+}
+
+// CreateVouchersResponse represents the JSON body returned after generating vouchers.
+type CreateVouchersResponse struct {
+	Batch    string       `json:"batch"`    // Identifier of the generated batch.
+	Vouchers []db.Voucher `json:"vouchers"` // The generated vouchers.
+}
+
+// handleVoucherLogin handles the POST /api/login/voucher requests to authorize a guest
+// with a voucher code.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - client: Shared UniFi controller client.
+// - limits: Default bandwidth and data quota restrictions; the voucher's quota takes precedence.
+// - consents: Terms of service and marketing consent policy.
+//
+// Behavior:
+// - Rejects logins that did not accept the current terms of service before checking the voucher.
+// - Consumes one use of the voucher before calling the controller, and records the consent.
+// - Authorizes the guest for the voucher's duration.
+// - Gives the use back if the controller authorization fails, so the guest can retry.
+func handleVoucherLogin(w http.ResponseWriter, r *http.Request, store db.Storage, client *authorization.Client, limits authorization.Limits, consents *consentPolicy) {
+	var req VoucherLoginRequest
+
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	cacheInfo, ok := lookupCache(w, req.CacheID)
+	if !ok {
+		return
+	}
+
+	if !checkConsent(w, consents, req.ConsentFields) {
+		return
+	}
+
+	code := voucher.Normalize(req.Code)
+	v, err := store.RedeemVoucher(code)
+	if errors.Is(err, db.ErrVoucherInvalid) {
+		store.WriteFailedAttempt(req.CacheID, cacheInfo.ID, cacheInfo.AP, "", "", ErrCodeInvalidVoucher, fmt.Sprintf("voucher %q rejected", code))
+		writeError(w, http.StatusForbidden, APIError{
+			Code:    ErrCodeInvalidVoucher,
+			Message: "This voucher code is invalid, expired or has already been used.",
+		})
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+
+	if !recordConsent(w, store, consents.pending(r, req.CacheID, cacheInfo, req.ConsentFields, "")) {
+		if err := store.ReleaseVoucher(v.Code); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if v.Quota > 0 {
+		limits.Bytes = v.Quota
+	}
+
+	if !completeLogin(w, r, store, client, req.CacheID, cacheInfo, db.Session{Duration: v.Duration, Voucher: v.Code}, limits) {
+		if err := store.ReleaseVoucher(v.Code); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// handleCreateVouchers handles POST /api/admin/vouchers by generating a batch of vouchers.
+//
+// Parameters:
+// - w: HTTP response writer.
+// - r: HTTP request.
+// - store: Shared database.
+// - defaultDuration: Session duration used when the request does not specify one.
+func handleCreateVouchers(w http.ResponseWriter, r *http.Request, store db.Storage, defaultDuration int) {
+	req := CreateVouchersRequest{Duration: defaultDuration, MaxUses: 1}
+
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Count < 1 || req.Count > maxVoucherBatch || req.Duration < 1 || req.MaxUses < 1 || req.Quota < 0 {
+		writeError(w, http.StatusBadRequest, APIError{
+			Code:    ErrCodeInvalidRequest,
+			Message: fmt.Sprintf("count must be between 1 and %d; duration and maxUses must be positive; quota must not be negative.", maxVoucherBatch),
+		})
+		return
+	}
+
+	codes, err := voucher.Generate(req.Count)
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+
+	batch := uuid.New().String()
+	vouchers := make([]db.Voucher, len(codes))
+	for i, code := range codes {
+		vouchers[i] = db.Voucher{
+			Code:      code,
+			Batch:     batch,
+			Duration:  req.Duration,
+			MaxUses:   req.MaxUses,
+			Quota:     req.Quota,
+			ExpiresAt: req.ExpiresAt,
+			Note:      req.Note,
+			CreatedAt: time.Now().UTC(),
+		}
+	}
+
+	if err := store.CreateVouchers(vouchers); err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, CreateVouchersResponse{Batch: batch, Vouchers: vouchers})
+}
+
+// handleListVouchers handles GET /api/admin/vouchers, optionally filtered by `?batch=`.
+func handleListVouchers(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	vouchers, err := store.ListVouchers(r.URL.Query().Get("batch"))
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, vouchers)
+}
+
+// handleVoucherSheet handles GET /api/admin/vouchers/sheet by rendering the vouchers of
+// the `?batch=` batch as a printable HTML page.
+func handleVoucherSheet(w http.ResponseWriter, r *http.Request, store db.Storage) {
+	batch := r.URL.Query().Get("batch")
+	if batch == "" {
+		writeError(w, http.StatusBadRequest, APIError{Code: ErrCodeInvalidRequest, Message: "The batch parameter is required."})
+		return
+	}
+
+	vouchers, err := store.ListVouchers(batch)
+	if err != nil {
+		fmt.Println(err)
+		writeInternalError(w)
+		return
+	}
+	if len(vouchers) == 0 {
+		writeError(w, http.StatusNotFound, APIError{Code: ErrCodeNotFound, Message: "No vouchers belong to this batch."})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := voucher.RenderSheet(w, pageTitle(), vouchers); err != nil {
+		fmt.Println(err)
+	}
+}
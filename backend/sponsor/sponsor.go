@@ -0,0 +1,125 @@
+// Package sponsor signs the approve/deny links emailed to guest sponsors and renders
+// the pages a sponsor sees when following them.
+package sponsor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Decisions a sponsor can make on a guest request.
+const (
+	ActionApprove = "approve"
+	ActionDeny    = "deny"
+)
+
+//go:embed decision.html
+var decisionSource string
+
+// decisionTemplate renders both the confirmation page and the result page.
+var decisionTemplate = template.Must(template.New("decision").Parse(decisionSource))
+
+// DecisionPage holds the data shown on a sponsor page.
+type DecisionPage struct {
+	Title   string     // Portal name.
+	Heading string     // Page heading.
+	Message string     // Explanation shown below the heading.
+	Form    url.Values // Hidden form fields for the confirmation button, or nil on result pages.
+	Action  string     // Decision confirmed by the button (ActionApprove or ActionDeny).
+}
+
+// Signer creates and checks the HMAC signatures of sponsor links.
+type Signer struct {
+	secret []byte // HMAC key.
+}
+
+// NewSigner creates a Signer. If secret is empty, a random key is generated, which
+// invalidates links sent before the next restart.
+func NewSigner(secret string) (*Signer, error) {
+	if secret != "" {
+		return &Signer{secret: []byte(secret)}, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate sponsor secret: %v", err)
+	}
+	log.Println("Warning: SPONSOR_SECRET not set. Sponsor links will stop working after a restart.")
+	return &Signer{secret: key}, nil
+}
+
+// Link returns the query parameters of a signed link for a decision on a guest request.
+//
+// Parameters:
+//   - cacheId: The cache identifier of the guest request.
+//   - request: The details of the request the host decides on (see Fingerprint). They are
+//     signed but not part of the link, so the link stops working if the request is replaced.
+//   - action: The decision the link confirms (ActionApprove or ActionDeny).
+//   - expires: The time after which the link is no longer accepted.
+func (s *Signer) Link(cacheId, request, action string, expires time.Time) url.Values {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return url.Values{
+		"id":     {cacheId},
+		"action": {action},
+		"exp":    {exp},
+		"sig":    {s.sign(cacheId, request, action, exp)},
+	}
+}
+
+// Fingerprint joins the details of a guest request that a link is bound to: the guest's
+// device, name and email address, the host and the time the request was made.
+func Fingerprint(mac, ap, name, email, host string, createdAt time.Time) string {
+	return strings.Join([]string{mac, ap, name, email, host, createdAt.UTC().Format(time.RFC3339)}, "\n")
+}
+
+// Verify checks the signature and expiry of link parameters produced by Link, for the
+// request the link's cache identifier currently refers to.
+//
+// Parameters:
+//   - params: The link parameters.
+//   - request: The details of the stored request (see Fingerprint).
+//
+// Returns:
+//   - string: The cache identifier of the guest request.
+//   - string: The signed decision.
+//   - error: An error if the link is malformed, tampered with or expired.
+func (s *Signer) Verify(params url.Values, request string) (string, string, error) {
+	cacheId, action, exp := params.Get("id"), params.Get("action"), params.Get("exp")
+	if action != ActionApprove && action != ActionDeny {
+		return "", "", fmt.Errorf("invalid action")
+	}
+
+	expected := s.sign(cacheId, request, action, exp)
+	if !hmac.Equal([]byte(expected), []byte(params.Get("sig"))) {
+		return "", "", fmt.Errorf("invalid signature")
+	}
+
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", "", fmt.Errorf("link expired")
+	}
+	return cacheId, action, nil
+}
+
+// sign computes the hex-encoded HMAC-SHA256 of the link fields and the request details.
+func (s *Signer) sign(cacheId, request, action, exp string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(cacheId + "\n" + request + "\n" + action + "\n" + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RenderDecisionPage writes a sponsor page.
+func RenderDecisionPage(w io.Writer, page DecisionPage) error {
+	return decisionTemplate.Execute(w, page)
+}
@@ -0,0 +1,44 @@
+// Package terms loads the terms of service guests must accept before logging in. The
+// terms are a Markdown file on the server; each login records the version its guest
+// accepted (see db.Consent), so a change of terms can be told apart in the records.
+package terms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Document is a version of the terms of service.
+type Document struct {
+	Version  string `json:"version"`  // Version label guests accept.
+	Markdown string `json:"markdown"` // Text of the terms in Markdown.
+}
+
+// Load reads the terms of service from a Markdown file.
+//
+// Parameters:
+//   - path: Path of the Markdown file.
+//   - version: Version label of the terms. When empty, the version is derived from the
+//     content ("sha256:" and the first 12 hex digits of its hash), so every edit of the
+//     file is a new version guests must accept again.
+//
+// Returns an error if the file cannot be read or is empty.
+func Load(path string, version string) (*Document, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read terms of service: %v", err)
+	}
+	markdown := strings.TrimSpace(string(content))
+	if markdown == "" {
+		return nil, fmt.Errorf("terms of service file %s is empty", path)
+	}
+
+	if version = strings.TrimSpace(version); version == "" {
+		sum := sha256.Sum256([]byte(markdown))
+		version = "sha256:" + hex.EncodeToString(sum[:6])
+	}
+	return &Document{Version: version, Markdown: markdown}, nil
+}
@@ -0,0 +1,94 @@
+// Package voucher generates and formats the printed access codes used by the voucher
+// authentication mode, and renders printable voucher sheets.
+package voucher
+
+import (
+	"backend/db"
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// codeLength is the number of digits in a voucher code.
+const codeLength = 10
+
+//go:embed sheet.html
+var sheetSource string
+
+// sheetTemplate renders a printable page of voucher cards.
+var sheetTemplate = template.Must(template.New("sheet").Funcs(template.FuncMap{
+	"format": Format,
+	"date": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Local().Format("2006-01-02 15:04")
+	},
+	"hours": func(minutes int) string {
+		if minutes%60 == 0 {
+			return fmt.Sprintf("%d h", minutes/60)
+		}
+		return fmt.Sprintf("%d min", minutes)
+	},
+}).Parse(sheetSource))
+
+// Generate returns count random voucher codes drawn from a cryptographically secure source.
+// Codes are returned in normalised (digits only) form.
+func Generate(count int) ([]string, error) {
+	codes := make([]string, 0, count)
+	seen := make(map[string]bool, count)
+	limit := big.NewInt(10)
+
+	for len(codes) < count {
+		var code strings.Builder
+		for i := 0; i < codeLength; i++ {
+			digit, err := rand.Int(rand.Reader, limit)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate voucher code: %v", err)
+			}
+			code.WriteString(digit.String())
+		}
+		if !seen[code.String()] {
+			seen[code.String()] = true
+			codes = append(codes, code.String())
+		}
+	}
+	return codes, nil
+}
+
+// Normalize converts a code as typed by a guest (with optional spaces or dashes) into
+// the digits-only form stored in the database.
+func Normalize(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(code))
+}
+
+// Format inserts a dash in the middle of a normalised code for display, e.g. 12345-67890.
+func Format(code string) string {
+	if len(code) != codeLength {
+		return code
+	}
+	return code[:codeLength/2] + "-" + code[codeLength/2:]
+}
+
+// RenderSheet writes a printable HTML page with one card per voucher.
+//
+// Parameters:
+// - w: Writer receiving the HTML page.
+// - title: Title printed on each card (usually the portal name).
+// - vouchers: The vouchers to print.
+func RenderSheet(w io.Writer, title string, vouchers []db.Voucher) error {
+	return sheetTemplate.Execute(w, struct {
+		Title    string
+		Vouchers []db.Voucher
+	}{title, vouchers})
+}
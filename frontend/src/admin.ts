@@ -0,0 +1,603 @@
+// Structured error returned by the backend API.
+interface ApiError {
+  code: string;
+  message: string;
+  retryable: boolean;
+}
+
+// Admin account as returned by the admin API.
+interface Admin {
+  username: string;
+  role: string;
+  createdAt: string;
+  lastLoginAt?: string;
+}
+
+// Guest session as returned by the admin API.
+interface Session {
+  cacheId: string;
+  mac: string;
+  ap: string;
+  name: string;
+  email: string;
+  duration: number;
+  status: string;
+  active: boolean;
+  voucher?: string;
+  sponsor?: string;
+  createdAt: string;
+  expiresAt: string;
+  endedAt?: string;
+  endReason?: string;
+}
+
+// Page of sessions returned by GET /api/admin/sessions.
+interface SessionList {
+  sessions: Session[];
+  total: number;
+  limit: number;
+  offset: number;
+}
+
+// Aggregate counts returned by GET /api/admin/sessions/stats.
+interface SessionStats {
+  total: number;
+  active: number;
+  uniqueClients: number;
+  byAp: Record<string, number>;
+  failedAttempts: number;
+}
+
+// Printed access code returned by GET /api/admin/vouchers.
+interface Voucher {
+  code: string;
+  batch: string;
+  duration: number;
+  maxUses: number;
+  uses: number;
+  note: string;
+  createdAt: string;
+}
+
+// Current passphrase of the passphrase mode.
+interface Passphrase {
+  passphrase: string;
+  rotatedAt: string;
+}
+
+// Running configuration returned by GET /api/admin/settings.
+interface Settings {
+  pageTitle: string;
+  authMode: string;
+  controller: { url: string; site: string; type: string; disableTls: boolean };
+  guests: { duration: number; up: number; down: number; quota: number };
+  mode: Record<string, string>;
+  groupPolicies?: { group: string; duration: number; up: number; down: number; quota: number }[];
+  retention: { days: number; mode: string };
+}
+
+// Error thrown for failed admin API requests.
+class RequestError extends Error {
+  constructor(public status: number, message: string) {
+    super(message);
+  }
+}
+
+// Roles of admin accounts by privilege; each role can do everything the roles before it can.
+const ROLE_RANKS: Record<string, number> = { viewer: 1, "front-desk": 2, admin: 3 };
+
+// Interval between two refreshes of the guest lists in milliseconds.
+const REFRESH_INTERVAL = 15000;
+
+// Number of recent logins shown per page.
+const PAGE_SIZE = 25;
+
+document.addEventListener("DOMContentLoaded", () => {
+  const loginView = document.getElementById("login-view") as HTMLDivElement;
+  const loginForm = document.getElementById("login-form") as HTMLFormElement;
+  const usernameInput = document.getElementById("username") as HTMLInputElement;
+  const passwordInput = document.getElementById("password") as HTMLInputElement;
+  const loginError = document.getElementById("login-error") as HTMLParagraphElement;
+  const dashboard = document.getElementById("dashboard") as HTMLDivElement;
+  const currentUser = document.getElementById("current-user") as HTMLSpanElement;
+  const logoutButton = document.getElementById("logout-btn") as HTMLButtonElement;
+  const banner = document.getElementById("banner") as HTMLDivElement;
+  const activeGuests = document.getElementById("active-guests") as HTMLTableSectionElement;
+  const apCounts = document.getElementById("ap-counts") as HTMLTableSectionElement;
+  const recentLogins = document.getElementById("recent-logins") as HTMLTableSectionElement;
+  const searchInput = document.getElementById("search") as HTMLInputElement;
+  const prevButton = document.getElementById("prev-page") as HTMLButtonElement;
+  const nextButton = document.getElementById("next-page") as HTMLButtonElement;
+  const pageInfo = document.getElementById("page-info") as HTMLSpanElement;
+  const exportLink = document.getElementById("export-sessions") as HTMLAnchorElement;
+  const voucherForm = document.getElementById("voucher-form") as HTMLFormElement;
+  const voucherBatches = document.getElementById("voucher-batches") as HTMLTableSectionElement;
+  const passphraseCard = document.getElementById("passphrase-card") as HTMLDivElement;
+  const passphraseText = document.getElementById("passphrase") as HTMLElement;
+  const passphraseRotated = document.getElementById("passphrase-rotated") as HTMLParagraphElement;
+  const rotateButton = document.getElementById("rotate-btn") as HTMLButtonElement;
+  const settingsList = document.getElementById("settings") as HTMLDListElement;
+  const passwordForm = document.getElementById("password-form") as HTMLFormElement;
+  const accountForm = document.getElementById("account-form") as HTMLFormElement;
+  const accountsTable = document.getElementById("accounts") as HTMLTableSectionElement;
+
+  let me: Admin | undefined;
+  let offset = 0;
+  let refreshTimer: number | undefined;
+  let searchTimer: number | undefined;
+
+  // Sends a request to the admin API and decodes the JSON response, if any. The browser
+  // sends the session cookie.
+  const request = async <T>(path: string, init: RequestInit = {}): Promise<T> => {
+    const response = await fetch(`/api/admin${path}`, init);
+    if (!response.ok) {
+      let message = `Request failed (${response.status}).`;
+      try {
+        const body = (await response.json()) as { error?: ApiError };
+        message = body.error?.message ?? message;
+      } catch {
+        // Not a JSON error body
+      }
+      throw new RequestError(response.status, message);
+    }
+    if (response.status === 204) {
+      return undefined as T;
+    }
+    return (await response.json()) as T;
+  };
+
+  // Sends a request as the signed-in account. A 401 response shows the sign-in screen.
+  const api = async <T>(path: string, init: RequestInit = {}): Promise<T> => {
+    try {
+      return await request<T>(path, init);
+    } catch (err) {
+      if (err instanceof RequestError && err.status === 401) {
+        showLogin("Your session has ended. Please sign in again.");
+      }
+      throw err;
+    }
+  };
+
+  // Sends a JSON body to the admin API.
+  const send = <T>(method: string, path: string, body: unknown) =>
+    api<T>(path, { method, headers: { "Content-Type": "application/json" }, body: JSON.stringify(body) });
+
+  // Reports whether the signed-in account has at least the given role.
+  const allows = (role: string) => me !== undefined && (ROLE_RANKS[me.role] ?? 0) >= ROLE_RANKS[role];
+
+  // Shows an error above the dashboard, or hides it when message is empty.
+  const showBanner = (message: string) => {
+    banner.textContent = message;
+    banner.hidden = message === "";
+  };
+
+  // Runs an API call and reports its failure in the banner.
+  const report = async (task: () => Promise<void>) => {
+    try {
+      await task();
+      showBanner("");
+    } catch (err) {
+      if (!(err instanceof RequestError && err.status === 401)) {
+        showBanner(err instanceof Error ? err.message : String(err));
+      }
+    }
+  };
+
+  const formatTime = (value: string) => new Date(value).toLocaleString();
+
+  // Builds a table row of text cells, with an optional cell of action buttons.
+  const row = (cells: string[], actions: HTMLButtonElement[] = []) => {
+    const tr = document.createElement("tr");
+    cells.forEach((text) => {
+      const td = document.createElement("td");
+      td.textContent = text;
+      tr.appendChild(td);
+    });
+    if (actions.length > 0) {
+      const td = document.createElement("td");
+      td.className = "actions";
+      td.append(...actions);
+      tr.appendChild(td);
+    }
+    return tr;
+  };
+
+  // Fills a table body, or shows a placeholder row if there are no rows.
+  const fillTable = (body: HTMLTableSectionElement, rows: HTMLTableRowElement[], columns: number, empty: string) => {
+    if (rows.length === 0) {
+      const tr = document.createElement("tr");
+      const td = document.createElement("td");
+      td.colSpan = columns;
+      td.className = "muted";
+      td.textContent = empty;
+      tr.appendChild(td);
+      rows = [tr];
+    }
+    body.replaceChildren(...rows);
+  };
+
+  const button = (label: string, className: string, onClick: () => void) => {
+    const b = document.createElement("button");
+    b.type = "button";
+    b.className = className;
+    b.textContent = label;
+    b.addEventListener("click", onClick);
+    return b;
+  };
+
+  // Revokes or extends a session and refreshes the guest lists.
+  const sessionAction = (session: Session, action: "revoke" | "extend") => {
+    if (action === "revoke" && !confirm(`Revoke Wi-Fi access of ${session.name || session.mac}?`)) {
+      return;
+    }
+    report(async () => {
+      await api(`/sessions/${encodeURIComponent(session.cacheId)}/${action}`, { method: "POST" });
+      await loadGuests();
+    });
+  };
+
+  const loadGuests = async () => {
+    const today = new Date();
+    today.setHours(0, 0, 0, 0);
+    const since = encodeURIComponent(today.toISOString());
+
+    const [active, todayStats, activeStats] = await Promise.all([
+      api<SessionList>(`/sessions?active=true&limit=500`),
+      api<SessionStats>(`/sessions/stats?since=${since}`),
+      api<SessionStats>(`/sessions/stats?active=true`),
+    ]);
+
+    (document.getElementById("stat-active") as HTMLElement).textContent = String(activeStats.total);
+    (document.getElementById("stat-today") as HTMLElement).textContent = String(todayStats.total);
+    (document.getElementById("stat-clients") as HTMLElement).textContent = String(todayStats.uniqueClients);
+    (document.getElementById("stat-failed") as HTMLElement).textContent = String(todayStats.failedAttempts);
+
+    fillTable(
+      activeGuests,
+      active.sessions.map((s) =>
+        row(
+          [s.name, s.email, s.mac, s.ap, formatTime(s.createdAt), formatTime(s.expiresAt)],
+          allows("front-desk")
+            ? [
+                button("Extend", "secondary-btn", () => sessionAction(s, "extend")),
+                button("Revoke", "danger-btn", () => sessionAction(s, "revoke")),
+              ]
+            : [],
+        ),
+      ),
+      7,
+      "No guests are connected.",
+    );
+
+    fillTable(
+      apCounts,
+      Object.entries(activeStats.byAp)
+        .sort((a, b) => b[1] - a[1])
+        .map(([ap, count]) => row([ap || "Unknown", String(count)])),
+      2,
+      "No guests are connected.",
+    );
+
+    await loadRecentLogins();
+  };
+
+  const loadRecentLogins = async () => {
+    const query = new URLSearchParams({ limit: String(PAGE_SIZE), offset: String(offset) });
+    if (searchInput.value.trim() !== "") {
+      query.set("q", searchInput.value.trim());
+    }
+    const page = await api<SessionList>(`/sessions?${query}`);
+
+    // The export covers every page of the current search
+    const exportQuery = new URLSearchParams({ format: "csv" });
+    if (query.has("q")) {
+      exportQuery.set("q", query.get("q") ?? "");
+    }
+    exportLink.href = `/api/admin/sessions/export?${exportQuery}`;
+
+    fillTable(
+      recentLogins,
+      page.sessions.map((s) => row([s.name, s.email, s.mac, formatTime(s.createdAt), s.active ? "active" : (s.endReason ?? s.status)])),
+      5,
+      "No logins found.",
+    );
+
+    const last = Math.min(page.offset + page.sessions.length, page.total);
+    pageInfo.textContent = page.total === 0 ? "" : `${page.offset + 1}–${last} of ${page.total}`;
+    prevButton.disabled = page.offset === 0;
+    nextButton.disabled = last >= page.total;
+  };
+
+  const loadVouchers = async () => {
+    const vouchers = await api<Voucher[]>("/vouchers");
+
+    const batches = new Map<string, Voucher[]>();
+    vouchers.forEach((v) => batches.set(v.batch, [...(batches.get(v.batch) ?? []), v]));
+
+    fillTable(
+      voucherBatches,
+      [...batches.entries()].reverse().map(([batch, items]) =>
+        row(
+          [
+            batch.slice(0, 8),
+            items[0].note,
+            String(items.length),
+            `${items.reduce((sum, v) => sum + v.uses, 0)} / ${items.reduce((sum, v) => sum + v.maxUses, 0)}`,
+            `${items[0].duration} min`,
+            formatTime(items[0].createdAt),
+          ],
+          [button("Print", "secondary-btn", () => printBatch(batch))],
+        ),
+      ),
+      7,
+      "No vouchers have been generated yet.",
+    );
+  };
+
+  // Opens the printable sheet of a batch in a new window.
+  const printBatch = (batch: string) => {
+    if (!window.open(`/api/admin/vouchers/sheet?batch=${encodeURIComponent(batch)}`, "_blank")) {
+      showBanner("Allow pop-ups for this page to print vouchers.");
+    }
+  };
+
+  const loadSettings = async () => {
+    const settings = await api<Settings>("/settings");
+
+    const entries: [string, string][] = [
+      ["Portal name", settings.pageTitle],
+      ["Authentication mode", settings.authMode],
+      ["Controller", settings.controller.url],
+      ["Site", settings.controller.site],
+      ["Controller type", settings.controller.type],
+      ["TLS verification", settings.controller.disableTls ? "disabled" : "enabled"],
+      ["Session duration", `${settings.guests.duration} min`],
+      ["Upload limit", settings.guests.up > 0 ? `${settings.guests.up} kbps` : "unlimited"],
+      ["Download limit", settings.guests.down > 0 ? `${settings.guests.down} kbps` : "unlimited"],
+      ["Data quota", settings.guests.quota > 0 ? `${settings.guests.quota} MB` : "unlimited"],
+      [
+        "Data retention",
+        settings.retention.days > 0 ? `${settings.retention.days} days, then ${settings.retention.mode}` : "kept forever",
+      ],
+      ...Object.entries(settings.mode),
+      ...(settings.groupPolicies ?? []).map((p): [string, string] => [
+        `Group ${p.group}`,
+        `${p.duration || settings.guests.duration} min, up ${p.up || "∞"} / down ${p.down || "∞"} kbps, quota ${p.quota || "∞"} MB`,
+      ]),
+    ];
+    settingsList.replaceChildren(
+      ...entries.flatMap(([label, value]) => {
+        const dt = document.createElement("dt");
+        dt.textContent = label;
+        const dd = document.createElement("dd");
+        dd.textContent = value;
+        return [dt, dd];
+      }),
+    );
+
+    passphraseCard.hidden = settings.authMode !== "passphrase" || !allows("front-desk");
+    if (!passphraseCard.hidden) {
+      showPassphrase(await api<Passphrase>("/passphrase"));
+    }
+  };
+
+  const showPassphrase = (p: Passphrase) => {
+    passphraseText.textContent = p.passphrase;
+    passphraseRotated.textContent = `Last changed ${formatTime(p.rotatedAt)}`;
+  };
+
+  // Changes the role or password of an account, or deletes it, and refreshes the list.
+  const accountAction = (account: Admin, action: "role" | "password" | "delete") => {
+    let method = "PUT";
+    let body: Record<string, string> | undefined;
+    if (action === "role") {
+      const role = prompt(`New role of ${account.username} (viewer, front-desk or admin):`, account.role);
+      if (role === null || role === account.role) {
+        return;
+      }
+      body = { role: role.trim() };
+    } else if (action === "password") {
+      const password = prompt(`New password of ${account.username} (signs the account out everywhere):`);
+      if (password === null) {
+        return;
+      }
+      body = { password };
+    } else {
+      if (!confirm(`Delete the account ${account.username}?`)) {
+        return;
+      }
+      method = "DELETE";
+    }
+
+    report(async () => {
+      const path = `/accounts/${encodeURIComponent(account.username)}`;
+      await (body ? send(method, path, body) : api(path, { method }));
+      await loadAccounts();
+    });
+  };
+
+  const loadAccounts = async () => {
+    const accounts = await api<Admin[]>("/accounts");
+
+    fillTable(
+      accountsTable,
+      accounts.map((a) =>
+        row(
+          [a.username, a.role, formatTime(a.createdAt), a.lastLoginAt ? formatTime(a.lastLoginAt) : "never"],
+          [
+            button("Change Role", "secondary-btn", () => accountAction(a, "role")),
+            button("Reset Password", "secondary-btn", () => accountAction(a, "password")),
+            button("Delete", "danger-btn", () => accountAction(a, "delete")),
+          ],
+        ),
+      ),
+      5,
+      "No accounts have been created yet.",
+    );
+  };
+
+  const loaders: Record<string, () => Promise<void>> = {
+    guests: loadGuests,
+    vouchers: loadVouchers,
+    settings: loadSettings,
+    accounts: loadAccounts,
+  };
+  let currentTab = "guests";
+
+  const showTab = (tab: string) => {
+    currentTab = tab;
+    document.querySelectorAll<HTMLButtonElement>(".tab").forEach((b) => {
+      b.classList.toggle("active", b.dataset.tab === tab);
+    });
+    document.querySelectorAll<HTMLElement>(".tab-panel").forEach((panel) => {
+      panel.hidden = panel.id !== `tab-${tab}`;
+    });
+    report(loaders[tab]);
+  };
+
+  // Shows the dashboard for the signed-in account, hiding what its role may not use.
+  const signIn = (admin: Admin) => {
+    me = admin;
+    currentUser.textContent = `${admin.username} (${admin.role})`;
+    document.querySelectorAll<HTMLElement>("[data-role]").forEach((element) => {
+      element.hidden = !allows(element.dataset.role ?? "admin");
+    });
+    if (!allows(document.querySelector<HTMLButtonElement>(`.tab[data-tab="${currentTab}"]`)?.dataset.role ?? "viewer")) {
+      currentTab = "guests";
+    }
+
+    loginView.hidden = true;
+    dashboard.hidden = false;
+    showTab(currentTab);
+    refreshTimer = window.setInterval(() => {
+      if (currentTab === "guests" && !document.hidden) {
+        report(loadGuests);
+      }
+    }, REFRESH_INTERVAL);
+  };
+
+  // Shows the sign-in screen, with an optional message.
+  const showLogin = (message = "") => {
+    me = undefined;
+    window.clearInterval(refreshTimer);
+    dashboard.hidden = true;
+    loginView.hidden = false;
+    passwordInput.value = "";
+    loginError.textContent = message;
+    loginError.hidden = message === "";
+  };
+
+  loginForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    loginError.hidden = true;
+    try {
+      const admin = await request<Admin>("/login", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ username: usernameInput.value.trim(), password: passwordInput.value }),
+      });
+      passwordInput.value = "";
+      signIn(admin);
+    } catch (err) {
+      loginError.textContent = err instanceof RequestError ? err.message : "The portal could not be reached.";
+      loginError.hidden = false;
+    }
+  });
+
+  logoutButton.addEventListener("click", async () => {
+    try {
+      await request("/logout", { method: "POST" });
+    } finally {
+      showLogin();
+    }
+  });
+
+  document.querySelectorAll<HTMLButtonElement>(".tab").forEach((b) => {
+    b.addEventListener("click", () => showTab(b.dataset.tab ?? "guests"));
+  });
+
+  searchInput.addEventListener("input", () => {
+    window.clearTimeout(searchTimer);
+    searchTimer = window.setTimeout(() => {
+      offset = 0;
+      report(loadRecentLogins);
+    }, 300);
+  });
+  prevButton.addEventListener("click", () => {
+    offset = Math.max(0, offset - PAGE_SIZE);
+    report(loadRecentLogins);
+  });
+  nextButton.addEventListener("click", () => {
+    offset += PAGE_SIZE;
+    report(loadRecentLogins);
+  });
+
+  voucherForm.addEventListener("submit", (event) => {
+    event.preventDefault();
+    const value = (id: string) => (document.getElementById(id) as HTMLInputElement).value;
+
+    const body: Record<string, unknown> = {
+      count: Number(value("voucher-count")),
+      maxUses: Number(value("voucher-uses")),
+      note: value("voucher-note"),
+    };
+    if (value("voucher-duration") !== "") {
+      body.duration = Number(value("voucher-duration"));
+    }
+    if (value("voucher-quota") !== "") {
+      body.quota = Number(value("voucher-quota"));
+    }
+    if (value("voucher-expires") !== "") {
+      body.expiresAt = new Date(value("voucher-expires")).toISOString();
+    }
+
+    report(async () => {
+      await send("POST", "/vouchers", body);
+      voucherForm.reset();
+      await loadVouchers();
+    });
+  });
+
+  rotateButton.addEventListener("click", () => {
+    if (!confirm("Generate a new passphrase? Guests will need the new one to log in.")) {
+      return;
+    }
+    report(async () => {
+      showPassphrase(await api<Passphrase>("/passphrase/rotate", { method: "POST" }));
+    });
+  });
+
+  passwordForm.addEventListener("submit", (event) => {
+    event.preventDefault();
+    const value = (id: string) => (document.getElementById(id) as HTMLInputElement).value;
+
+    report(async () => {
+      await send("PUT", "/me/password", {
+        currentPassword: value("current-password"),
+        newPassword: value("new-password"),
+      });
+      passwordForm.reset();
+      showLogin("Your password was changed. Please sign in with the new password.");
+    });
+  });
+
+  accountForm.addEventListener("submit", (event) => {
+    event.preventDefault();
+    const value = (id: string) => (document.getElementById(id) as HTMLInputElement | HTMLSelectElement).value;
+
+    report(async () => {
+      await send("POST", "/accounts", {
+        username: value("account-username").trim(),
+        password: value("account-password"),
+        role: value("account-role"),
+      });
+      accountForm.reset();
+      await loadAccounts();
+    });
+  });
+
+  // Resume the session of an earlier sign-in, if its cookie is still valid
+  request<Admin>("/me")
+    .then(signIn)
+    .catch(() => showLogin());
+});
@@ -1,53 +1,637 @@
+// Structured error returned by the backend API.
+interface ApiError {
+  code: string;
+  message: string;
+  retryable: boolean;
+  field?: string;
+}
+
+// Endpoint and JSON body of a portal API request.
+interface PortalRequest {
+  url: string;
+  body: Record<string, unknown>;
+}
+
+// Response of the email mode after a verification code was sent.
+interface VerificationResponse {
+  status: string;
+  email: string;
+  expiresAt: string;
+  graceMinutes?: number;
+}
+
+// State of a sponsor mode request waiting for the host's approval.
+interface SponsorStatus {
+  status: "pending" | "approved" | "denied" | "expired";
+  sponsor: string;
+  expiresAt: string;
+}
+
+// Hosts offered to the guest in sponsor mode.
+interface SponsorHosts {
+  hosts: string[] | null;
+  domains: string[] | null;
+}
+
+// Terms of service guests accept before logging in, and whether marketing consent is offered.
+interface Terms {
+  required: boolean;
+  version?: string;
+  markdown?: string;
+  marketingConsent: boolean;
+}
+
+// Definition of a custom field of the guest form.
+interface FormField {
+  name: string;
+  label: string;
+  type: "text" | "textarea" | "email" | "tel" | "number" | "select" | "checkbox";
+  required?: boolean;
+  pattern?: string;
+  message?: string;
+  options?: string[];
+  placeholder?: string;
+  maxLength?: number;
+}
+
+// Provider details of the OIDC mode.
+interface OIDCInfo {
+  provider: string;
+}
+
+// Messages for the error codes the backend passes in the `error` query parameter after a
+// redirect flow (OIDC sign-in).
+const REDIRECT_ERRORS: Record<string, ApiError> = {
+  session_expired: {
+    code: "session_expired",
+    message: "Your login session has expired. Please reconnect to the Wi-Fi network and try again.",
+    retryable: false,
+  },
+  provider_unreachable: {
+    code: "provider_unreachable",
+    message: "The sign-in service is not reachable right now. Please try again in a moment.",
+    retryable: true,
+  },
+  sign_in_cancelled: {
+    code: "sign_in_cancelled",
+    message: "Sign-in was cancelled. Sign in to get online.",
+    retryable: true,
+  },
+  sign_in_failed: {
+    code: "sign_in_failed",
+    message: "We couldn't verify your sign-in. Please try again.",
+    retryable: true,
+  },
+  controller_unreachable: {
+    code: "controller_unreachable",
+    message: "The Wi-Fi controller is not responding right now. Please try again in a moment.",
+    retryable: true,
+  },
+  authorization_rejected: {
+    code: "authorization_rejected",
+    message: "Your device could not be authorized on the network. Please contact staff for assistance.",
+    retryable: false,
+  },
+  internal_error: {
+    code: "internal_error",
+    message: "Something went wrong. Please try again.",
+    retryable: true,
+  },
+  terms_not_accepted: {
+    code: "terms_not_accepted",
+    message: "Please accept the terms of use to get online.",
+    retryable: false,
+  },
+  terms_changed: {
+    code: "terms_changed",
+    message: "The terms of use have changed. Please read and accept the new version.",
+    retryable: false,
+  },
+};
+
+// Modes in which the guest's email address is known, so marketing consent can be offered.
+const EMAIL_MODES = ["form", "passphrase", "email", "sponsor", "oidc"];
+
+// Creates the input of a custom form field inside an input group.
+const renderField = (field: FormField): HTMLElement => {
+  const group = document.createElement("div");
+  group.className = "input-group";
+  const id = `field-${field.name}`;
+
+  if (field.type === "checkbox") {
+    const label = document.createElement("label");
+    label.className = "checkbox-label";
+    const input = document.createElement("input");
+    input.id = id;
+    input.type = "checkbox";
+    input.required = !!field.required;
+    label.append(input, field.label);
+    group.appendChild(label);
+    return group;
+  }
+
+  const label = document.createElement("label");
+  label.htmlFor = id;
+  label.textContent = field.required ? field.label : `${field.label} (Optional)`;
+  group.appendChild(label);
+
+  let input: HTMLInputElement | HTMLSelectElement | HTMLTextAreaElement;
+  if (field.type === "select") {
+    input = document.createElement("select");
+    const empty = document.createElement("option");
+    empty.value = "";
+    empty.textContent = field.placeholder || "Please choose";
+    input.appendChild(empty);
+    (field.options ?? []).forEach((value) => {
+      const option = document.createElement("option");
+      option.value = value;
+      option.textContent = value;
+      input.appendChild(option);
+    });
+  } else {
+    if (field.type === "textarea") {
+      input = document.createElement("textarea");
+      input.rows = 3;
+    } else {
+      input = document.createElement("input");
+      // Numbers are checked by the pattern below, like the backend does, rather than by the browser
+      input.type = field.type === "number" ? "text" : field.type;
+      if (field.type === "number") {
+        input.inputMode = "decimal";
+      }
+      if (field.pattern) {
+        input.pattern = field.pattern;
+      }
+    }
+    input.placeholder = field.placeholder ?? "";
+    if (field.maxLength) {
+      input.maxLength = field.maxLength;
+    }
+  }
+  input.id = id;
+  input.required = !!field.required;
+  if (field.message) {
+    input.title = field.message;
+  }
+  group.appendChild(input);
+  return group;
+};
+
+// Renders the Markdown of the terms of use into a container. Only headings, lists and
+// paragraphs are supported; text is inserted as plain text, never as HTML.
+const renderMarkdown = (container: HTMLElement, markdown: string) => {
+  container.replaceChildren();
+  for (const block of markdown.split(/\n\s*\n/)) {
+    const lines = block.trim().split("\n").map((line) => line.trim());
+    const heading = lines[0].match(/^(#{1,6})\s+(.*)$/);
+    if (heading && lines.length === 1) {
+      const element = document.createElement(heading[1].length <= 2 ? "h3" : "h4");
+      element.textContent = heading[2];
+      container.appendChild(element);
+    } else if (lines.every((line) => /^[-*+]\s+/.test(line))) {
+      const list = document.createElement("ul");
+      lines.forEach((line) => {
+        const item = document.createElement("li");
+        item.textContent = line.replace(/^[-*+]\s+/, "");
+        list.appendChild(item);
+      });
+      container.appendChild(list);
+    } else if (lines.every((line) => /^\d+[.)]\s+/.test(line))) {
+      const list = document.createElement("ol");
+      lines.forEach((line) => {
+        const item = document.createElement("li");
+        item.textContent = line.replace(/^\d+[.)]\s+/, "");
+        list.appendChild(item);
+      });
+      container.appendChild(list);
+    } else if (lines[0]) {
+      const paragraph = document.createElement("p");
+      paragraph.textContent = lines.join(" ");
+      container.appendChild(paragraph);
+    }
+  }
+};
+
+// Interval between two sponsor status checks in milliseconds.
+const SPONSOR_POLL_INTERVAL = 5000;
+
+const GENERIC_ERROR: ApiError = {
+  code: "network_error",
+  message: "We couldn't reach the portal. Please check your connection and try again.",
+  retryable: true,
+};
+
 document.addEventListener("DOMContentLoaded", () => {
   const form = document.getElementById("login-form") as HTMLFormElement;
   const usernameInput = document.getElementById("username") as HTMLInputElement;
+  const usernameLabel = document.querySelector("label[for=username]") as HTMLLabelElement;
+  const passwordInput = document.getElementById("password") as HTMLInputElement;
   const emailInput = document.getElementById("email") as HTMLInputElement;
+  const emailLabel = document.querySelector("label[for=email]") as HTMLLabelElement;
+  const voucherInput = document.getElementById("voucher") as HTMLInputElement;
+  const passphraseInput = document.getElementById("passphrase") as HTMLInputElement;
+  const sponsorInput = document.getElementById("sponsor") as HTMLInputElement;
+  const sponsorHostList = document.getElementById("sponsor-hosts") as HTMLDataListElement;
+  const sponsorDomains = document.getElementById("sponsor-domains") as HTMLElement;
+  const sponsorWait = document.getElementById("sponsor-wait") as HTMLDivElement;
+  const sponsorHint = document.getElementById("sponsor-hint") as HTMLParagraphElement;
+  const sponsorBackButton = document.getElementById("sponsor-back") as HTMLButtonElement;
+  const oidcButton = document.getElementById("oidc-btn") as HTMLButtonElement;
+  const submitButton = form?.querySelector("button[type=submit]") as HTMLButtonElement;
+  const verifyForm = document.getElementById("verify-form") as HTMLFormElement;
+  const verifyHint = document.getElementById("verify-hint") as HTMLParagraphElement;
+  const codeInput = document.getElementById("code") as HTMLInputElement;
+  const verifyButton = verifyForm?.querySelector("button[type=submit]") as HTMLButtonElement;
+  const resendButton = document.getElementById("resend-btn") as HTMLButtonElement;
+  const errorBox = document.getElementById("error-message") as HTMLDivElement;
+  const errorText = document.getElementById("error-text") as HTMLParagraphElement;
+  const retryButton = document.getElementById("retry-btn") as HTMLButtonElement;
+  const termsGroup = document.getElementById("terms-group") as HTMLDivElement;
+  const termsText = document.getElementById("terms-text") as HTMLDivElement;
+  const acceptTermsInput = document.getElementById("accept-terms") as HTMLInputElement;
+  const marketingGroup = document.getElementById("marketing-group") as HTMLDivElement;
+  const marketingInput = document.getElementById("marketing-consent") as HTMLInputElement;
+  const customFields = document.getElementById("custom-fields") as HTMLDivElement;
 
-  form?.addEventListener("submit", async (event) => {
-    event.preventDefault();
+  const authMode = window.authMode || "form";
+
+  // Show only the fields of the active mode; disabled fields are skipped by form validation
+  document.querySelectorAll<HTMLElement>("[data-mode]").forEach((group) => {
+    const active = (group.dataset.mode ?? "").split(" ").includes(authMode);
+    group.hidden = !active;
+    group.querySelectorAll("input").forEach((input) => {
+      input.disabled = !active;
+    });
+  });
+  if (authMode === "email") {
+    emailInput.required = true;
+    emailLabel.textContent = "Email";
+  }
+  // Credential modes ask for an account instead of the guest's name
+  const credentialMode = authMode === "radius" || authMode === "ldap";
+  if (credentialMode) {
+    usernameLabel.textContent = "Username";
+    usernameInput.placeholder = "Enter your username";
+    usernameInput.autocomplete = "username";
+  }
+  if (authMode === "sponsor") {
+    // Offer the configured hosts as suggestions and mention the domains that can be typed in
+    fetch("/api/sponsor/hosts")
+      .then((response) => response.json() as Promise<SponsorHosts>)
+      .then(({ hosts, domains }) => {
+        (hosts ?? []).forEach((host) => {
+          const option = document.createElement("option");
+          option.value = host;
+          sponsorHostList.appendChild(option);
+        });
+        if (domains?.length) {
+          sponsorDomains.textContent = `Any @${domains.join(" or @")} address`;
+          sponsorDomains.hidden = false;
+        }
+      })
+      .catch((error) => console.error("Failed to load hosts", error));
+  }
+
+  if (authMode === "oidc") {
+    fetch("/api/oidc")
+      .then((response) => response.json() as Promise<OIDCInfo>)
+      .then(({ provider }) => {
+        oidcButton.textContent = `Sign in with ${provider}`;
+      })
+      .catch((error) => console.error("Failed to load sign-in provider", error));
+  }
+
+  // Custom fields of the guest form, rendered once their definitions are loaded
+  let formFields: FormField[] = [];
+  if (!customFields.hidden) {
+    fetch("/api/fields")
+      .then((response) => response.json() as Promise<{ fields: FormField[] | null }>)
+      .then(({ fields }) => {
+        formFields = fields ?? [];
+        customFields.replaceChildren(...formFields.map(renderField));
+      })
+      .catch((error) => console.error("Failed to load form fields", error));
+  }
+
+  // Answers to the custom form fields, by field name
+  const fieldValues = () => {
+    const values: Record<string, string | boolean> = {};
+    formFields.forEach((field) => {
+      const input = document.getElementById(`field-${field.name}`) as HTMLInputElement | null;
+      if (input) {
+        values[field.name] = field.type === "checkbox" ? input.checked : input.value;
+      }
+    });
+    return values;
+  };
+
+  // Version of the terms of use shown to the guest, sent back with the acceptance
+  let termsVersion = "";
+
+  // Shows the current terms of use; the checkbox is cleared so a new version is accepted explicitly
+  const loadTerms = () => {
+    fetch("/api/terms")
+      .then((response) => response.json() as Promise<Terms>)
+      .then((terms) => {
+        termsVersion = terms.version ?? "";
+        renderMarkdown(termsText, terms.markdown ?? "");
+        acceptTermsInput.checked = false;
+        acceptTermsInput.disabled = !terms.required;
+        termsGroup.hidden = !terms.required;
+        const offerMarketing = terms.marketingConsent && EMAIL_MODES.includes(authMode);
+        marketingInput.disabled = !offerMarketing;
+        marketingGroup.hidden = !offerMarketing;
+      })
+      .catch((error) => console.error("Failed to load terms of use", error));
+  };
+  loadTerms();
+
+  // Consent fields sent with every login request
+  const consentFields = () => ({
+    acceptTerms: acceptTermsInput.checked,
+    termsVersion,
+    marketingConsent: !marketingInput.disabled && marketingInput.checked,
+  });
+
+  const showError = (error: ApiError) => {
+    errorText.textContent = error.message;
+    retryButton.hidden = !error.retryable;
+    errorBox.hidden = false;
+  };
+
+  const hideError = () => {
+    errorBox.hidden = true;
+  };
+
+  // Reads the structured error from a failed response, falling back to a generic message
+  const readError = async (response: Response): Promise<ApiError> => {
+    try {
+      const body = await response.json();
+      if (body?.error?.message) {
+        return body.error as ApiError;
+      }
+    } catch {
+      // Not a JSON error body
+    }
+    return { ...GENERIC_ERROR, code: `http_${response.status}` };
+  };
+
+  // Builds the endpoint and request body for the active mode, or null if a field is missing
+  const buildRequest = (cacheId: string): PortalRequest | null => {
+    if (authMode === "voucher") {
+      const voucher = voucherInput.value;
+      return voucher ? { url: "/api/login/voucher", body: { voucher, cacheId } } : null;
+    }
 
     const username = usernameInput.value;
     const email = emailInput.value;
+    const fields = fieldValues();
+    if (authMode === "passphrase") {
+      const passphrase = passphraseInput.value;
+      return username && passphrase
+        ? { url: "/api/login", body: { username, email, passphrase, fields, cacheId } }
+        : null;
+    }
+    if (credentialMode) {
+      const password = passwordInput.value;
+      return username && password
+        ? { url: "/api/login", body: { username, password, cacheId } }
+        : null;
+    }
+    if (authMode === "sponsor") {
+      const sponsor = sponsorInput.value;
+      return username && sponsor
+        ? { url: "/api/login", body: { username, email, sponsor, fields, cacheId } }
+        : null;
+    }
+    if (authMode === "email" && !email) {
+      return null;
+    }
+    return username ? { url: "/api/login", body: { username, email, fields, cacheId } } : null;
+  };
+
+  const clearForm = () => {
+    usernameInput.value = '';
+    emailInput.value = '';
+    voucherInput.value = '';
+    passphraseInput.value = '';
+    sponsorInput.value = '';
+    passwordInput.value = '';
+    codeInput.value = '';
+    customFields.querySelectorAll<HTMLInputElement>("input, select, textarea").forEach((input) => {
+      input.value = '';
+      input.checked = false;
+    });
+  };
+
+  // Switches the email mode to the code entry step
+  const showVerification = (verification: VerificationResponse) => {
+    let hint = `We sent a 6-digit code to ${verification.email}.`;
+    if (verification.graceMinutes) {
+      hint += ` You can use the internet for ${verification.graceMinutes} minutes to open your mailbox.`;
+    }
+    verifyHint.textContent = hint;
+    form.hidden = true;
+    verifyForm.hidden = false;
+    codeInput.focus();
+  };
+
+  // Report errors of redirect flows, which come back as a query parameter
+  const redirectError = new URLSearchParams(window.location.search).get("error");
+  if (redirectError) {
+    showError(REDIRECT_ERRORS[redirectError] ?? { ...GENERIC_ERROR, code: redirectError });
+  }
+
+  // Leaves the portal for the identity provider's sign-in page
+  const startSignIn = () => {
     const cacheId = window.cacheId;
+    if (!cacheId) {
+      alert("Please make sure you opened this page from the Wi-Fi network.");
+      return;
+    }
+    // The sign-in button is not a submit button, so the terms checkbox is checked here
+    if (!acceptTermsInput.reportValidity()) {
+      return;
+    }
+    const { acceptTerms, marketingConsent } = consentFields();
+    const params = new URLSearchParams({
+      cacheId,
+      acceptTerms: String(acceptTerms),
+      termsVersion,
+      marketingConsent: String(marketingConsent),
+    });
+    window.location.href = `/api/oidc/start?${params}`;
+  };
 
-    if (username && cacheId) {
-      // Prepare the request body
-      const requestBody = {
-        username,
-        email,
-        cacheId,
-      };
-
-      try {
-        const response = await fetch("/api/login", {
-          method: "POST",
-          headers: {
-            "Content-Type": "application/json",
-          },
-          body: JSON.stringify(requestBody),
+  let sponsorTimer: number | undefined;
+
+  const stopPolling = () => {
+    window.clearTimeout(sponsorTimer);
+    sponsorTimer = undefined;
+  };
+
+  // Checks whether the host has decided, and keeps checking while the request is pending
+  const pollSponsor = async () => {
+    try {
+      const response = await fetch(`/api/sponsor/status/${encodeURIComponent(window.cacheId)}`);
+      if (!response.ok) {
+        stopPolling();
+        showError(await readError(response));
+        return;
+      }
+
+      const sponsorStatus: SponsorStatus = await response.json();
+      if (sponsorStatus.status === "approved") {
+        stopPolling();
+        clearForm();
+        window.location.href = "/success";
+        return;
+      }
+      if (sponsorStatus.status !== "pending") {
+        stopPolling();
+        showError({
+          code: `sponsor_${sponsorStatus.status}`,
+          message: sponsorStatus.status === "denied"
+            ? "Your host declined the request. Please ask at the front desk."
+            : "Your host didn't answer in time. Please ask a different host or try again.",
+          retryable: false,
         });
+        return;
+      }
+    } catch (error) {
+      // Keep waiting through short network interruptions
+      console.error("Status check failed", error);
+    }
+    sponsorTimer = window.setTimeout(pollSponsor, SPONSOR_POLL_INTERVAL);
+  };
+
+  // Switches the sponsor mode to the waiting step
+  const showSponsorWait = (sponsorStatus: SponsorStatus) => {
+    sponsorHint.textContent = `We asked ${sponsorStatus.sponsor} to approve your access. ` +
+      "Please keep this page open; you will be connected as soon as they approve.";
+    form.hidden = true;
+    sponsorWait.hidden = false;
+    stopPolling();
+    sponsorTimer = window.setTimeout(pollSponsor, SPONSOR_POLL_INTERVAL);
+  };
+
+  let lastRequest: PortalRequest | null = null;
+
+  const send = async (request: PortalRequest) => {
+    lastRequest = request;
+    hideError();
+    submitButton.disabled = true;
+    verifyButton.disabled = true;
+    retryButton.disabled = true;
 
-        if (response.redirected) {
-          // If redirected, follow the new location
-          window.location.href = response.url;
-        } else if (response.ok) {
-          // Handle successful login if no redirect
-          console.log('Login successful!');
-          // Optionally, handle the success logic here
-        } else {
-          // Handle error if response is not successful (non-3xx, non-200)
-          console.error('Login failed', response.statusText);
-          // Optionally show an error message on the page
+    try {
+      const response = await fetch(request.url, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify(request.body),
+      });
+
+      if (response.redirected) {
+        // If redirected, follow the new location
+        clearForm();
+        window.location.href = response.url;
+      } else if (response.status === 202 && authMode === "sponsor") {
+        // The sponsor mode waits for the host's approval before authorizing
+        showSponsorWait(await response.json());
+      } else if (response.status === 202) {
+        // The email mode needs the one-time code before authorizing
+        showVerification(await response.json());
+      } else if (response.ok && authMode === "sponsor") {
+        // The host already approved this login
+        pollSponsor();
+      } else if (response.ok) {
+        // Handle successful login if no redirect
+        console.log('Login successful!');
+      } else {
+        // Show the error reported by the backend
+        const error = await readError(response);
+        console.error('Login failed', error.code);
+        if (error.code === "terms_changed") {
+          loadTerms();
+        }
+        if (error.field) {
+          // Custom fields are prefixed, the built-in inputs are named like their JSON fields
+          (document.getElementById(`field-${error.field}`) ?? document.getElementById(error.field))?.focus();
         }
-      } catch (error) {
-        console.error("Request failed", error);
+        showError(error);
       }
+    } catch (error) {
+      console.error("Request failed", error);
+      showError(GENERIC_ERROR);
+    } finally {
+      submitButton.disabled = false;
+      verifyButton.disabled = false;
+      retryButton.disabled = false;
+    }
+  };
 
-      // Optionally clear the form fields after submission
-      usernameInput.value = '';
-      emailInput.value = '';
-    } else {
-      alert("Please enter both a username and cacheId.");
+  form?.addEventListener("submit", (event) => {
+    event.preventDefault();
+
+    const cacheId = window.cacheId;
+    const request = cacheId ? buildRequest(cacheId) : null;
+    if (!request) {
+      alert("Please fill in the form and make sure you opened this page from the Wi-Fi network.");
+      return;
+    }
+    send({ ...request, body: { ...request.body, ...consentFields() } });
+  });
+
+  verifyForm?.addEventListener("submit", (event) => {
+    event.preventDefault();
+    send({ url: "/api/verify", body: { code: codeInput.value, cacheId: window.cacheId } });
+  });
+
+  resendButton?.addEventListener("click", () => {
+    // Go back to the first step so the guest can correct the address or ask for a new code
+    hideError();
+    codeInput.value = '';
+    verifyForm.hidden = true;
+    form.hidden = false;
+  });
+
+  sponsorBackButton?.addEventListener("click", async () => {
+    // Withdraw the request and go back to the first step so the guest can ask someone else
+    stopPolling();
+    hideError();
+    sponsorBackButton.disabled = true;
+    try {
+      const response = await fetch(`/api/sponsor/withdraw/${encodeURIComponent(window.cacheId)}`, { method: "POST" });
+      if (!response.ok) {
+        showError(await readError(response));
+        return;
+      }
+      const sponsorStatus: SponsorStatus = await response.json();
+      if (sponsorStatus.status === "approved") {
+        // The host approved while the guest was giving up
+        clearForm();
+        window.location.href = "/success";
+        return;
+      }
+      sponsorWait.hidden = true;
+      form.hidden = false;
+    } catch (error) {
+      console.error("Withdrawing the request failed", error);
+      showError(GENERIC_ERROR);
+    } finally {
+      sponsorBackButton.disabled = false;
+    }
+  });
+
+  oidcButton?.addEventListener("click", startSignIn);
+
+  retryButton?.addEventListener("click", () => {
+    if (authMode === "oidc") {
+      startSignIn();
+    } else if (lastRequest) {
+      send(lastRequest);
     }
   });
 });
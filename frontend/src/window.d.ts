@@ -1,5 +1,6 @@
 // window.d.ts
 interface Window {
     cacheId: string; // or whatever type 'cacheId' is
+    authMode: string; // guest authentication mode ("form", "voucher", "passphrase", "email", "sponsor", "oidc", "radius" or "ldap")
   }
   
\ No newline at end of file
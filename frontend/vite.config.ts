@@ -0,0 +1,14 @@
+import { resolve } from "node:path";
+import { defineConfig } from "vite";
+
+// The guest portal and the admin dashboard are built as two pages of the same app.
+export default defineConfig({
+  build: {
+    rollupOptions: {
+      input: {
+        main: resolve(__dirname, "index.html"),
+        admin: resolve(__dirname, "admin.html"),
+      },
+    },
+  },
+});